@@ -0,0 +1,42 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+// config-schema generates the JSON schema of the network config of veth and router
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spidernet-io/cni-plugins/pkg/config"
+)
+
+func main() {
+	output := flag.String("output", "docs/usage/schema", "the directory where the schema files are written")
+	flag.Parse()
+
+	if err := os.MkdirAll(*output, 0755); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	for name, conf := range map[string]interface{}{
+		"veth":   config.VethConf{},
+		"router": config.RouterConf{},
+	} {
+		data, err := json.MarshalIndent(config.JSONSchema(name, conf), "", "  ")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		file := filepath.Join(*output, name+".schema.json")
+		if err = os.WriteFile(file, append(data, '\n'), 0644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println("generated", file)
+	}
+}
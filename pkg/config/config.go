@@ -2,19 +2,24 @@ package config
 
 import (
 	"fmt"
+	"github.com/spidernet-io/cni-plugins/pkg/constant"
 	ty "github.com/spidernet-io/cni-plugins/pkg/types"
+	"golang.org/x/sys/unix"
 	"k8s.io/utils/pointer"
+	"math"
 	"net"
 	"regexp"
 	"strings"
+	"time"
 )
 
-func ValidateRPFilterConfig(config *ty.RPFilter) *ty.RPFilter {
+// ValidateRPFilterConfig gives default value to rp_filter, the value must be 0/1/2
+func ValidateRPFilterConfig(config *ty.RPFilter) (*ty.RPFilter, error) {
 	if config == nil {
 		return &ty.RPFilter{
 			Enable: pointer.Bool(true),
 			Value:  pointer.Int32(2),
-		}
+		}, nil
 	}
 
 	if config.Enable != nil && *config.Enable {
@@ -26,13 +31,13 @@ func ValidateRPFilterConfig(config *ty.RPFilter) *ty.RPFilter {
 				}
 			}
 			if !matched {
-				config.Value = pointer.Int32(2)
+				return nil, fmt.Errorf("invalid value %d, must be 0/1/2", *config.Value)
 			}
 		} else {
 			config.Value = pointer.Int32(2)
 		}
 	}
-	return config
+	return config, nil
 }
 
 func ValidateMigrateRouteConfig(given *ty.MigrateRoute) *ty.MigrateRoute {
@@ -59,7 +64,7 @@ func ValidateRoutes(overlaySubnet, serviceSubnet []string) (ovlSubnet, svcSubnet
 		return nil, nil, fmt.Errorf("the subnet of service clusterip must be given")
 	}
 
-	ovlSubnet, err = validateRoutes(overlaySubnet)
+	ovlSubnet, err = ValidateOverlaySubnets(overlaySubnet)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -71,6 +76,34 @@ func ValidateRoutes(overlaySubnet, serviceSubnet []string) (ovlSubnet, svcSubnet
 	return ovlSubnet, svcSubnet, nil
 }
 
+// ValidateOverlaySubnets is same as ValidateSubnets, but "auto" is allowed,
+// it means the overlay subnets are inferred from routes when calling plugin.
+func ValidateOverlaySubnets(subnets []string) ([]string, error) {
+	auto := false
+	given := make([]string, 0, len(subnets))
+	for _, subnet := range subnets {
+		if strings.TrimSpace(subnet) == constant.AutoSubnet {
+			auto = true
+			continue
+		}
+		given = append(given, subnet)
+	}
+
+	result, err := validateRoutes(given)
+	if err != nil {
+		return nil, err
+	}
+	if auto {
+		result = append(result, constant.AutoSubnet)
+	}
+	return result, nil
+}
+
+// ValidateSubnets trims and validates the given subnets, empty is allowed
+func ValidateSubnets(subnets []string) ([]string, error) {
+	return validateRoutes(subnets)
+}
+
 func validateRoutes(routes []string) ([]string, error) {
 	result := make([]string, len(routes))
 	for idx, route := range routes {
@@ -116,3 +149,114 @@ func ValidateIPConflict(config *ty.IPConflict) *ty.IPConflict {
 	return config
 
 }
+
+func ValidateKubernetes(config *ty.Kubernetes) (*ty.Kubernetes, error) {
+	if config == nil {
+		return nil, nil
+	}
+	if config.Timeout == "" {
+		config.Timeout = constant.DefaultKubernetesTimeout
+	}
+	if _, err := time.ParseDuration(config.Timeout); err != nil {
+		return nil, fmt.Errorf("invalid kubernetes timeout %s: %v, input like: 1s,500ms", config.Timeout, err)
+	}
+	return config, nil
+}
+
+// ValidateAutoDiscovery gives default value to auto discovery config, it requires the kubeconfig
+func ValidateAutoDiscovery(discovery *ty.AutoDiscovery, kubernetes *ty.Kubernetes) (*ty.AutoDiscovery, error) {
+	if discovery == nil || !discovery.Enabled {
+		return discovery, nil
+	}
+
+	if kubernetes == nil || kubernetes.Kubeconfig == "" {
+		return nil, fmt.Errorf("kubernetes.kubeconfig must be given when auto_discover is enabled")
+	}
+
+	if len(discovery.OverlaySources) == 0 {
+		discovery.OverlaySources = constant.DefaultOverlaySources
+	}
+	for _, source := range discovery.OverlaySources {
+		switch source {
+		case constant.OverlaySourceCalico, constant.OverlaySourceCilium:
+		default:
+			return nil, fmt.Errorf("unknown overlay source %s of auto_discover, available: %s, %s", source,
+				constant.OverlaySourceCalico, constant.OverlaySourceCilium)
+		}
+	}
+
+	if discovery.CacheFile == "" {
+		discovery.CacheFile = constant.DefaultDiscoveryCacheFile
+	}
+	if discovery.CacheTTL == "" {
+		discovery.CacheTTL = constant.DefaultDiscoveryCacheTTL
+	}
+	if _, err := time.ParseDuration(discovery.CacheTTL); err != nil {
+		return nil, fmt.Errorf("invalid cache_ttl %s of auto_discover: %v, input like: 10m", discovery.CacheTTL, err)
+	}
+	return discovery, nil
+}
+
+// ValidateReturnPath gives default values to the return path, the table must not be a reserved one of the kernel
+func ValidateReturnPath(config *ty.ReturnPath) (*ty.ReturnPath, error) {
+	if config == nil || !config.Enabled {
+		return config, nil
+	}
+
+	if config.Mark == nil {
+		config.Mark = pointer.Int(constant.DefaultReturnPathMark)
+	} else if *config.Mark <= 0 || *config.Mark > math.MaxUint32 {
+		return nil, fmt.Errorf("invalid mark %d, must be in [1, %d]", *config.Mark, uint32(math.MaxUint32))
+	}
+
+	if config.Table == nil {
+		config.Table = pointer.Int(constant.DefaultReturnPathTable)
+	} else if *config.Table <= 0 || *config.Table >= unix.RT_TABLE_COMPAT && *config.Table <= unix.RT_TABLE_LOCAL {
+		return nil, fmt.Errorf("invalid table %d, must be greater than 0 and not be reserved(252-255)", *config.Table)
+	}
+
+	if config.RulePriority == nil {
+		config.RulePriority = pointer.Int(constant.DefaultReturnPathPriority)
+	} else if *config.RulePriority <= 0 || *config.RulePriority >= 32766 {
+		return nil, fmt.Errorf("invalid rule_priority %d, must be in [1, 32765] to precede the rule of main table", *config.RulePriority)
+	}
+	return config, nil
+}
+
+// ValidateHijackRoutes gives default values to the hijack routes, the gateway and the source must be the same family
+// as the destination
+func ValidateHijackRoutes(routes []ty.HijackRoute) ([]ty.HijackRoute, error) {
+	for idx := range routes {
+		route := &routes[idx]
+		route.Dst = strings.TrimSpace(route.Dst)
+		_, dst, err := net.ParseCIDR(route.Dst)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dst %q: %v", route.Dst, err)
+		}
+		isV4 := dst.IP.To4() != nil
+
+		if route.Via == "" {
+			route.Via = constant.HijackViaOverlay
+		} else if len(route.Via) > unix.IFNAMSIZ-1 || strings.ContainsAny(route.Via, " /") {
+			return nil, fmt.Errorf("invalid via %q of %s, must be overlay, underlay or the name of an interface", route.Via, route.Dst)
+		}
+
+		for name, value := range map[string]string{"gateway": route.Gateway, "src": route.Src} {
+			if value == "" {
+				continue
+			}
+			ip := net.ParseIP(value)
+			if ip == nil || (ip.To4() != nil) != isV4 {
+				return nil, fmt.Errorf("invalid %s %q of %s, must be an ip of the same family", name, value, route.Dst)
+			}
+		}
+
+		if route.Metric != nil && (*route.Metric < 0 || *route.Metric > math.MaxUint32) {
+			return nil, fmt.Errorf("invalid metric %d of %s, must be in [0, %d]", *route.Metric, route.Dst, uint32(math.MaxUint32))
+		}
+		if route.Table != nil && (*route.Table <= 0 || *route.Table >= unix.RT_TABLE_COMPAT && *route.Table <= unix.RT_TABLE_LOCAL && *route.Table != unix.RT_TABLE_MAIN) {
+			return nil, fmt.Errorf("invalid table %d of %s, must be greater than 0 and not be reserved(252, 253, 255)", *route.Table, route.Dst)
+		}
+	}
+	return routes, nil
+}
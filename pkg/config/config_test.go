@@ -1,9 +1,18 @@
 package config
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/spidernet-io/cni-plugins/pkg/constant"
 	ty "github.com/spidernet-io/cni-plugins/pkg/types"
+	"golang.org/x/sys/unix"
 	"k8s.io/utils/pointer"
 )
 
@@ -45,6 +54,19 @@ var _ = Describe("config", func() {
 		})
 	})
 
+	Context("Test ValidateOverlaySubnets", func() {
+		It("auto is kept", func() {
+			subnets, err := ValidateOverlaySubnets([]string{"auto", " 10.244.0.0/16"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(subnets).To(Equal([]string{"10.244.0.0/16", "auto"}))
+		})
+
+		It("invalid cidr return err", func() {
+			_, err := ValidateOverlaySubnets([]string{"auto", "abcd"})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
 	Context("Test ValidateRPFilterConfig", func() {
 		It("no rp_filter config", func() {
 			var config *ty.RPFilter
@@ -52,7 +74,8 @@ var _ = Describe("config", func() {
 				Enable: pointer.Bool(true),
 				Value:  pointer.Int32(2),
 			}
-			got := ValidateRPFilterConfig(config)
+			got, err := ValidateRPFilterConfig(config)
+			Expect(err).NotTo(HaveOccurred())
 			Expect(got).To(Equal(want))
 		})
 
@@ -65,7 +88,8 @@ var _ = Describe("config", func() {
 				Enable: pointer.Bool(true),
 				Value:  pointer.Int32(2),
 			}
-			got := ValidateRPFilterConfig(config)
+			got, err := ValidateRPFilterConfig(config)
+			Expect(err).NotTo(HaveOccurred())
 			Expect(got).To(Equal(want))
 		})
 
@@ -78,21 +102,18 @@ var _ = Describe("config", func() {
 				Enable: nil,
 				Value:  pointer.Int32(2),
 			}
-			got := ValidateRPFilterConfig(config)
+			got, err := ValidateRPFilterConfig(config)
+			Expect(err).NotTo(HaveOccurred())
 			Expect(got).To(Equal(want))
 		})
 
-		It("value must be 0/1/2, if not we set it to 2", func() {
+		It("value must be 0/1/2, if not return err", func() {
 			var config = &ty.RPFilter{
 				Enable: pointer.Bool(true),
 				Value:  pointer.Int32(10),
 			}
-			var want = &ty.RPFilter{
-				Enable: pointer.Bool(true),
-				Value:  pointer.Int32(2),
-			}
-			got := ValidateRPFilterConfig(config)
-			Expect(got).To(Equal(want))
+			_, err := ValidateRPFilterConfig(config)
+			Expect(err).To(HaveOccurred())
 		})
 
 		It("correct rp_filter config", func() {
@@ -104,7 +125,8 @@ var _ = Describe("config", func() {
 				Enable: pointer.Bool(true),
 				Value:  pointer.Int32(1),
 			}
-			got := ValidateRPFilterConfig(config)
+			got, err := ValidateRPFilterConfig(config)
+			Expect(err).NotTo(HaveOccurred())
 			Expect(got).To(Equal(want))
 		})
 	})
@@ -145,4 +167,644 @@ var _ = Describe("config", func() {
 			Expect(err).To(BeNil())
 		})
 	})
+
+	Context("Test ValidateAllowedOverrides", func() {
+		It("known keys", func() {
+			err := ValidateAllowedOverrides([]string{constant.OverrideSkipCall, constant.OverrideMacPrefix})
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("unknown key return err", func() {
+			err := ValidateAllowedOverrides([]string{"overlay_interface"})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Test SplitCNIArgs", func() {
+		It("empty args", func() {
+			k8sArgs, overrides, err := SplitCNIArgs("")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sArgs).To(BeEmpty())
+			Expect(overrides).To(BeEmpty())
+		})
+		It("split overrides from k8s args", func() {
+			k8sArgs, overrides, err := SplitCNIArgs("IgnoreUnknown=1;K8S_POD_NAME=test;skip_call=true;K8S_POD_NAMESPACE=default")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sArgs).To(Equal("IgnoreUnknown=1;K8S_POD_NAME=test;K8S_POD_NAMESPACE=default"))
+			Expect(overrides).To(Equal(map[string]string{"skip_call": "true"}))
+		})
+		It("invalid pair return err", func() {
+			_, _, err := SplitCNIArgs("K8S_POD_NAME")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Test ParseOverrides", func() {
+		allowed := []string{constant.OverrideSkipCall, constant.OverrideAdditionalHijackSubnet,
+			constant.OverrideRPFilterValue, constant.OverrideMigrateRoute, constant.OverrideMacPrefix, constant.OverrideDryRun}
+
+		It("no overrides", func() {
+			got, err := ParseOverrides(nil, nil, nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got).To(Equal(&ty.Overrides{}))
+		})
+
+		It("overrides from args.cni", func() {
+			cniArgs := &ty.CNIArgs{Cni: map[string]interface{}{
+				"skip_call":                true,
+				"additional_hijack_subnet": []interface{}{"10.6.0.0/16", " fd00:10:6::/64"},
+				"rp_filter_value":          float64(1),
+				"migrate_route":            float64(0),
+				"mac_prefix":               "0a:1b",
+				"dry_run":                  true,
+				"ips":                      []interface{}{"10.6.1.10"},
+			}}
+			got, err := ParseOverrides(allowed, nil, cniArgs, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got).To(Equal(&ty.Overrides{
+				Skipped:                pointer.Bool(true),
+				AdditionalHijackSubnet: []string{"10.6.0.0/16", "fd00:10:6::/64"},
+				RPFilterValue:          pointer.Int32(1),
+				MigrateRoute:           (*ty.MigrateRoute)(pointer.Int32(0)),
+				MacPrefix:              pointer.String("0a:1b"),
+				DryRun:                 pointer.Bool(true),
+			}))
+		})
+
+		It("CNI_ARGS takes precedence over args.cni", func() {
+			cniArgs := &ty.CNIArgs{Cni: map[string]interface{}{"skip_call": true}}
+			got, err := ParseOverrides(allowed, nil, cniArgs, map[string]string{"skip_call": "false"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got.Skipped).To(Equal(pointer.Bool(false)))
+		})
+
+		It("args.cni takes precedence over annotations", func() {
+			annotations := map[string]string{"skip_call": "true", "mac_prefix": "0a:1b"}
+			cniArgs := &ty.CNIArgs{Cni: map[string]interface{}{"skip_call": false}}
+			got, err := ParseOverrides(allowed, annotations, cniArgs, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got.Skipped).To(Equal(pointer.Bool(false)))
+			Expect(got.MacPrefix).To(Equal(pointer.String("0a:1b")))
+		})
+
+		It("key not in allowed_overrides return err", func() {
+			_, err := ParseOverrides([]string{constant.OverrideMacPrefix}, nil, nil, map[string]string{"skip_call": "true"})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("invalid value return err", func() {
+			for key, value := range map[string]string{
+				"skip_call":                "yes please",
+				"additional_hijack_subnet": "10.6.0.0",
+				"rp_filter_value":          "3",
+				"migrate_route":            "2",
+				"mac_prefix":               "0a",
+				"dry_run":                  "maybe",
+			} {
+				_, err := ParseOverrides(allowed, nil, nil, map[string]string{key: value})
+				Expect(err).To(HaveOccurred(), key)
+			}
+		})
+	})
+
+	Context("Test ApplyOverrides", func() {
+		It("overrides of pod overwrite the network config", func() {
+			conf := &PluginConf{
+				AdditionalHijackSubnet: []string{"10.6.0.0/16"},
+				MigrateRoute:           (*ty.MigrateRoute)(pointer.Int32(-1)),
+			}
+			err := conf.ApplyOverrides(&ty.Overrides{
+				Skipped:                pointer.Bool(true),
+				AdditionalHijackSubnet: []string{"10.7.0.0/16"},
+				RPFilterValue:          pointer.Int32(0),
+				MigrateRoute:           (*ty.MigrateRoute)(pointer.Int32(1)),
+				MacPrefix:              pointer.String("0a:1b"),
+				DryRun:                 pointer.Bool(true),
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conf.Skipped).To(BeTrue())
+			Expect(conf.AdditionalHijackSubnet).To(Equal([]string{"10.6.0.0/16", "10.7.0.0/16"}))
+			Expect(*conf.RPFilter.Value).To(Equal(int32(0)))
+			Expect(*conf.MigrateRoute).To(Equal(ty.MigrateEnable))
+			Expect(conf.MacPrefix).To(Equal("0a:1b"))
+			Expect(conf.DryRun).To(BeTrue())
+		})
+
+		It("nothing overridden", func() {
+			conf := &PluginConf{MacPrefix: "0a:1b"}
+			Expect(conf.ApplyOverrides(&ty.Overrides{})).To(Succeed())
+			Expect(conf).To(Equal(&PluginConf{MacPrefix: "0a:1b"}))
+		})
+
+		It("rp_filter_value isn't allowed if the network doesn't set the rp_filter of host", func() {
+			conf := &PluginConf{RPFilter: &ty.RPFilter{Enable: pointer.Bool(false)}}
+			err := conf.ApplyOverrides(&ty.Overrides{RPFilterValue: pointer.Int32(0)})
+			Expect(err).To(HaveOccurred())
+			Expect(conf.RPFilter.Value).To(BeNil())
+		})
+	})
+
+	Context("Test ValidateKubernetes", func() {
+		It("no kubernetes config", func() {
+			got, err := ValidateKubernetes(nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got).To(BeNil())
+		})
+		It("give default timeout", func() {
+			got, err := ValidateKubernetes(&ty.Kubernetes{Kubeconfig: "/etc/cni/net.d/meta-plugins.kubeconfig"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got.Timeout).To(Equal(constant.DefaultKubernetesTimeout))
+		})
+		It("invalid timeout return err", func() {
+			_, err := ValidateKubernetes(&ty.Kubernetes{Timeout: "3"})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Test ValidateAutoDiscovery", func() {
+		kubernetes := &ty.Kubernetes{Kubeconfig: "/etc/cni/net.d/meta-plugins.kubeconfig"}
+		It("disabled", func() {
+			got, err := ValidateAutoDiscovery(&ty.AutoDiscovery{}, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got).To(Equal(&ty.AutoDiscovery{}))
+		})
+		It("kubeconfig must be given", func() {
+			_, err := ValidateAutoDiscovery(&ty.AutoDiscovery{Enabled: true}, nil)
+			Expect(err).To(HaveOccurred())
+		})
+		It("give default value", func() {
+			got, err := ValidateAutoDiscovery(&ty.AutoDiscovery{Enabled: true}, kubernetes)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got).To(Equal(&ty.AutoDiscovery{
+				Enabled:        true,
+				OverlaySources: constant.DefaultOverlaySources,
+				CacheFile:      constant.DefaultDiscoveryCacheFile,
+				CacheTTL:       constant.DefaultDiscoveryCacheTTL,
+			}))
+		})
+		It("unknown overlay source return err", func() {
+			_, err := ValidateAutoDiscovery(&ty.AutoDiscovery{Enabled: true, OverlaySources: []string{"flannel"}}, kubernetes)
+			Expect(err).To(HaveOccurred())
+		})
+		It("invalid cache_ttl return err", func() {
+			_, err := ValidateAutoDiscovery(&ty.AutoDiscovery{Enabled: true, CacheTTL: "10"}, kubernetes)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Test ValidateReturnPath", func() {
+		It("disabled", func() {
+			got, err := ValidateReturnPath(&ty.ReturnPath{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got).To(Equal(&ty.ReturnPath{}))
+		})
+		It("give default value", func() {
+			got, err := ValidateReturnPath(&ty.ReturnPath{Enabled: true})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*got.Mark).To(Equal(constant.DefaultReturnPathMark))
+			Expect(*got.Table).To(Equal(constant.DefaultReturnPathTable))
+			Expect(*got.RulePriority).To(Equal(constant.DefaultReturnPathPriority))
+		})
+		It("reserved table return err", func() {
+			_, err := ValidateReturnPath(&ty.ReturnPath{Enabled: true, Table: pointer.Int(254)})
+			Expect(err).To(HaveOccurred())
+		})
+		It("invalid mark or priority return err", func() {
+			_, err := ValidateReturnPath(&ty.ReturnPath{Enabled: true, Mark: pointer.Int(0)})
+			Expect(err).To(HaveOccurred())
+			_, err = ValidateReturnPath(&ty.ReturnPath{Enabled: true, RulePriority: pointer.Int(32766)})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Test ValidateHijackRoutes", func() {
+		It("give default value", func() {
+			got, err := ValidateHijackRoutes([]ty.HijackRoute{{Dst: " 10.7.0.0/16 "}, {Dst: "172.16.0.0/16", Via: "underlay", Gateway: "10.6.0.1", Metric: pointer.Int(10), Table: pointer.Int(254)}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got[0]).To(Equal(ty.HijackRoute{Dst: "10.7.0.0/16", Via: constant.HijackViaOverlay}))
+			Expect(got[1].Via).To(Equal(constant.HijackViaUnderlay))
+		})
+		It("invalid dst or via return err", func() {
+			_, err := ValidateHijackRoutes([]ty.HijackRoute{{Dst: "10.7.0.0"}})
+			Expect(err).To(MatchError(ContainSubstring("invalid dst")))
+			_, err = ValidateHijackRoutes([]ty.HijackRoute{{Dst: "10.7.0.0/16", Via: "a-very-long-interface"}})
+			Expect(err).To(MatchError(ContainSubstring("invalid via")))
+		})
+		It("gateway and src must be the same family as dst", func() {
+			_, err := ValidateHijackRoutes([]ty.HijackRoute{{Dst: "10.7.0.0/16", Gateway: "fd00::1"}})
+			Expect(err).To(MatchError(ContainSubstring("invalid gateway")))
+			_, err = ValidateHijackRoutes([]ty.HijackRoute{{Dst: "fd00:10:7::/64", Src: "10.6.1.10"}})
+			Expect(err).To(MatchError(ContainSubstring("invalid src")))
+		})
+		It("invalid metric or reserved table return err", func() {
+			_, err := ValidateHijackRoutes([]ty.HijackRoute{{Dst: "10.7.0.0/16", Metric: pointer.Int(-1)}})
+			Expect(err).To(MatchError(ContainSubstring("invalid metric")))
+			_, err = ValidateHijackRoutes([]ty.HijackRoute{{Dst: "10.7.0.0/16", Table: pointer.Int(255)}})
+			Expect(err).To(MatchError(ContainSubstring("invalid table")))
+			for _, table := range []int{unix.RT_TABLE_MAIN, 300, constant.DefaultReturnPathTable} {
+				routes, err := ValidateHijackRoutes([]ty.HijackRoute{{Dst: "10.7.0.0/16", Table: pointer.Int(table)}})
+				Expect(err).NotTo(HaveOccurred(), "table %d", table)
+				Expect(*routes[0].Table).To(Equal(table))
+			}
+		})
+	})
+
+	Context("Test MergeDropInConfig", func() {
+		var dir string
+		BeforeEach(func() {
+			dir = GinkgoT().TempDir()
+		})
+
+		writeDropIn := func(name, content string) {
+			Expect(os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)).To(Succeed())
+		}
+
+		netConf := func(extra string) []byte {
+			return []byte(fmt.Sprintf(`{"cniVersion":"0.3.1","name":"macvlan","type":"router","drop_in_dir":%q%s}`, dir, extra))
+		}
+
+		It("nothing to merge", func() {
+			stdin := netConf("")
+			merged, files, err := MergeDropInConfig("router", stdin)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(files).To(BeEmpty())
+			Expect(merged).To(Equal(stdin))
+		})
+
+		It("stdin takes precedence over the drop-in files in lexical order", func() {
+			writeDropIn("10-node.json", `{"host_rule_priority":900,"log_options":{"log_level":"info","log_file":"/tmp/a.log"},"service_hijack_subnet":["10.96.0.0/12"]}`)
+			writeDropIn("20-node.json", `{"host_rule_priority":800,"host_interfaces_to_exclude":["^eth1$"]}`)
+			writeDropIn("README", `not json`)
+
+			merged, files, err := MergeDropInConfig("router", netConf(`,"log_options":{"log_level":"debug"},"service_hijack_subnet":["10.233.0.0/18"]`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(files).To(Equal([]string{filepath.Join(dir, "10-node.json"), filepath.Join(dir, "20-node.json")}))
+
+			result := map[string]interface{}{}
+			Expect(json.Unmarshal(merged, &result)).To(Succeed())
+			Expect(result["host_rule_priority"]).To(BeEquivalentTo(800))
+			Expect(result["host_interfaces_to_exclude"]).To(Equal([]interface{}{"^eth1$"}))
+			Expect(result["log_options"]).To(Equal(map[string]interface{}{"log_level": "debug", "log_file": "/tmp/a.log"}))
+			Expect(result["service_hijack_subnet"]).To(Equal([]interface{}{"10.233.0.0/18"}))
+			Expect(result["type"]).To(Equal("router"))
+		})
+
+		It("drop-in can't give the keys of network config", func() {
+			writeDropIn("10-node.json", `{"type":"veth"}`)
+			_, _, err := MergeDropInConfig("router", netConf(""))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("invalid drop-in return err", func() {
+			writeDropIn("10-node.json", `{"host_rule_priority":`)
+			_, _, err := MergeDropInConfig("router", netConf(""))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("the drop-in is shared by both plugins", func() {
+			writeDropIn("10-node.json", `{"log_options":{"log_level":"info"},"host_rule_priority":900,"host_interfaces_to_exclude":["^eth1$"]}`)
+			stdin := func(plugin string) []byte {
+				return []byte(fmt.Sprintf(`{"cniVersion":"0.3.1","name":"macvlan","type":%q,"drop_in_dir":%q,
+					"service_hijack_subnet":["10.233.0.0/18"],"overlay_hijack_subnet":["10.244.0.0/16"]}`, plugin, dir))
+			}
+
+			merged, _, err := MergeDropInConfig("veth", stdin("veth"))
+			Expect(err).NotTo(HaveOccurred())
+			vethConf, err := ParseVethConfig(merged)
+			Expect(err).NotTo(HaveOccurred(), "the keys of router are dropped for veth")
+			Expect(vethConf.LogOptions.LogLevel).To(Equal("info"))
+			Expect(vethConf.HostInterfacesToExclude).To(Equal([]string{"^eth1$"}))
+
+			merged, _, err = MergeDropInConfig("router", stdin("router"))
+			Expect(err).NotTo(HaveOccurred())
+			routerConf, err := ParseRouterConfig(merged)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*routerConf.HostRulePriority).To(Equal(900))
+			Expect(routerConf.LogOptions.LogLevel).To(Equal("info"))
+
+			writeDropIn("20-node.json", `{"Host_Rule_Priority":900}`)
+			merged, _, err = MergeDropInConfig("veth", stdin("veth"))
+			Expect(err).NotTo(HaveOccurred())
+			_, err = ParseVethConfig(merged)
+			Expect(err).NotTo(HaveOccurred(), "the keys of router are dropped case-insensitively")
+
+			writeDropIn("20-node.json", `{"host_rule_priorty":900}`)
+			merged, _, err = MergeDropInConfig("veth", stdin("veth"))
+			Expect(err).NotTo(HaveOccurred())
+			_, err = ParseVethConfig(merged)
+			Expect(err).To(MatchError(ContainSubstring("host_rule_priorty: unknown field")), "the keys unknown to all plugins are still rejected")
+
+			_, _, err = MergeDropInConfig("macvlan", stdin("veth"))
+			Expect(err).To(MatchError("unknown plugin macvlan"))
+		})
+
+		It("empty drop_in_dir disables the drop-in", func() {
+			writeDropIn("10-node.json", `{"host_rule_priority":900}`)
+			stdin := []byte(`{"cniVersion":"0.3.1","name":"macvlan","type":"router","drop_in_dir":""}`)
+			merged, files, err := MergeDropInConfig("router", stdin)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(files).To(BeEmpty())
+			Expect(merged).To(Equal(stdin))
+		})
+	})
+
+	Context("Test ParseDelConfig", func() {
+		It("the invalid config is decoded leniently with the defaults", func() {
+			vethConf, err := ParseVethDelConfig([]byte(`{
+				"cniVersion": "0.3.1",
+				"name": "veth",
+				"type": "veth",
+				"foo": 1,
+				"return_path": {"enabled": true},
+				"log_options": {"log_file_path": "/tmp/a.log"}
+			}`))
+			Expect(err).To(MatchError(ContainSubstring("foo: unknown field")))
+			Expect(vethConf.ReturnPath.Enabled).To(BeTrue())
+			Expect(*vethConf.FlushConntrack).To(BeTrue())
+			Expect(vethConf.Timeout).To(Equal(constant.DefaultPluginTimeout))
+			Expect(vethConf.LogOptions).To(BeNil())
+
+			routerConf, err := ParseRouterDelConfig([]byte(`{"host_access": "bpf"`))
+			Expect(err).To(HaveOccurred())
+			Expect(routerConf.HostAccess).To(Equal(constant.HostAccessAuto))
+			Expect(*routerConf.FlushConntrack).To(BeTrue())
+		})
+
+		It("the valid config is parsed as ADD", func() {
+			conf, err := ParseRouterDelConfig([]byte(`{
+				"cniVersion": "0.3.1",
+				"name": "router",
+				"type": "router",
+				"service_hijack_subnet": ["10.233.0.0/18"],
+				"overlay_hijack_subnet": ["10.244.0.0/16"],
+				"host_access": "overlay",
+				"flush_conntrack": false
+			}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conf.HostAccess).To(Equal(constant.HostAccessOverlay))
+			Expect(*conf.FlushConntrack).To(BeFalse())
+			Expect(conf.LogOptions).NotTo(BeNil())
+		})
+	})
+
+	Context("Test ParseRouterConfig", func() {
+		It("router accepts its own fields", func() {
+			conf, err := ParseRouterConfig([]byte(`{
+				"cniVersion": "0.3.1",
+				"name": "router",
+				"type": "router",
+				"service_hijack_subnet": ["10.233.0.0/18"],
+				"overlay_hijack_subnet": ["10.244.0.0/16"],
+				"sriov": true
+			}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conf.Sriov).To(BeTrue())
+			Expect(*conf.HostRuleTable).To(Equal(500))
+			Expect(*conf.HostRulePriority).To(Equal(constant.DefaultHostRulePriority))
+			Expect(conf.HostAccess).To(Equal(constant.HostAccessAuto))
+			Expect(conf.DefaultOverlayInterface).To(Equal(constant.AutoOverlayInterface))
+			Expect(conf.Timeout).To(Equal(constant.DefaultPluginTimeout))
+			Expect(*conf.RouteProtocol).To(Equal(constant.DefaultRouteProtocol))
+			Expect(*conf.FlushConntrack).To(BeTrue())
+			Expect(*conf.RouteSrcHint).To(BeTrue())
+			Expect(conf.Isolation).To(Equal(constant.IsolationRule))
+
+			_, err = ParseVethConfig([]byte(`{
+				"cniVersion": "0.3.1",
+				"name": "veth",
+				"type": "veth",
+				"service_hijack_subnet": ["10.233.0.0/18"],
+				"overlay_hijack_subnet": ["10.244.0.0/16"],
+				"host_access": "veth"
+			}`))
+			Expect(err).To(MatchError(ContainSubstring("host_access: unknown field")))
+
+			_, err = ParseRouterConfig([]byte(`{
+				"cniVersion": "0.3.1",
+				"name": "router",
+				"type": "router",
+				"service_hijack_subnet": ["10.233.0.0/18"],
+				"overlay_hijack_subnet": ["10.244.0.0/16"],
+				"return_path": {"enabled": true}
+			}`))
+			Expect(err).To(MatchError(ContainSubstring("return_path: unknown field")))
+
+			vethConf, err := ParseVethConfig([]byte(`{
+				"cniVersion": "0.3.1",
+				"name": "veth",
+				"type": "veth",
+				"service_hijack_subnet": ["10.233.0.0/18"],
+				"overlay_hijack_subnet": ["10.244.0.0/16"],
+				"sriov": true,
+				"isolation": "vrf",
+				"route_src_hint": false
+			}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(vethConf.Sriov).To(BeTrue())
+			Expect(*vethConf.RouteSrcHint).To(BeFalse())
+			Expect(vethConf.Isolation).To(Equal(constant.IsolationVRF))
+
+			DeferCleanup(func(f func(string) (string, error)) { lookPath = f }, lookPath)
+			lookPath = func(file string) (string, error) { return "/usr/sbin/" + file, nil }
+			conf, err = ParseRouterConfig([]byte(`{
+				"cniVersion": "0.3.1",
+				"name": "router",
+				"type": "router",
+				"service_hijack_subnet": ["10.233.0.0/18"],
+				"overlay_hijack_subnet": ["10.244.0.0/16"],
+				"isolation": "fwmark"
+			}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conf.Isolation).To(Equal(constant.IsolationFwmark))
+
+			lookPath = func(file string) (string, error) { return "", exec.ErrNotFound }
+			_, err = ParseRouterConfig([]byte(`{
+				"cniVersion": "0.3.1",
+				"name": "router",
+				"type": "router",
+				"service_hijack_subnet": ["10.233.0.0/18"],
+				"overlay_hijack_subnet": ["10.244.0.0/16"],
+				"isolation": "fwmark"
+			}`))
+			Expect(err).To(MatchError(ContainSubstring("isolation: fwmark needs the nft command")))
+
+			_, err = ParseVethConfig([]byte(`{
+				"cniVersion": "0.3.1",
+				"name": "veth",
+				"type": "veth",
+				"service_hijack_subnet": ["10.233.0.0/18"],
+				"overlay_hijack_subnet": ["10.244.0.0/16"],
+				"overlay_interface": "auto"
+			}`))
+			Expect(err).To(MatchError(ContainSubstring("overlay_interface: auto is only supported by router")))
+		})
+
+		It("report all invalid fields", func() {
+			_, err := ParseRouterConfig([]byte(`{
+				"cniVersion": "0.3.1",
+				"name": "router",
+				"type": "router",
+				"overlay_hijack_subnet": ["abcd"],
+				"rp_filter": {"set_host": true, "value": 3},
+				"host_rule_table": -1,
+				"timeout": "-1s",
+				"route_protocol": 2,
+				"host_access": "bpf",
+				"isolation": "netns",
+				"log_options": {"log_level": "debug", "log_file_path": "/tmp/a.log"}
+			}`))
+			Expect(err).To(HaveOccurred())
+			for _, field := range []string{"overlay_hijack_subnet", "service_hijack_subnet", "rp_filter", "host_rule_table", "timeout", "route_protocol", "host_access", "isolation", "log_options.log_file_path"} {
+				Expect(err.Error()).To(ContainSubstring(field + ":"))
+			}
+		})
+
+		It("the keys are matched case-insensitively like the decoder", func() {
+			conf, err := ParseRouterConfig([]byte(`{
+				"cniVersion": "0.3.1",
+				"name": "router",
+				"type": "router",
+				"Service_Hijack_Subnet": ["10.233.0.0/18"],
+				"Overlay_Hijack_Subnet": ["10.244.0.0/16"],
+				"LOG_OPTIONS": {"Log_Level": "debug"}
+			}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conf.OverlayHijackSubnet).To(Equal([]string{"10.244.0.0/16"}))
+			Expect(conf.LogOptions.LogLevel).To(Equal("debug"))
+
+			_, err = ParseRouterConfig([]byte(`{
+				"cniVersion": "0.3.1",
+				"name": "router",
+				"type": "router",
+				"service_hijack_subnet": ["10.233.0.0/18"],
+				"Overlay_Hijack_Subnets": ["10.244.0.0/16"]
+			}`))
+			Expect(err).To(MatchError(ContainSubstring("Overlay_Hijack_Subnets: unknown field")))
+		})
+
+		It("args and runtimeConfig are accepted", func() {
+			_, err := ParseRouterConfig([]byte(`{
+				"cniVersion": "0.3.1",
+				"name": "router",
+				"type": "router",
+				"service_hijack_subnet": ["10.233.0.0/18"],
+				"overlay_hijack_subnet": ["10.244.0.0/16"],
+				"args": {"cni": {"skip_call": true}, "other": {}},
+				"runtimeConfig": {"portMappings": []}
+			}`))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("deviceID injected by Multus is accepted", func() {
+			conf, err := ParseRouterConfig([]byte(`{
+				"cniVersion": "0.3.1",
+				"name": "router",
+				"type": "router",
+				"service_hijack_subnet": ["10.233.0.0/18"],
+				"overlay_hijack_subnet": ["10.244.0.0/16"],
+				"deviceID": "0000:af:06.0"
+			}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conf.DeviceID).To(Equal("0000:af:06.0"))
+			Expect(conf.Sriov).To(BeFalse(), "it's detected by the plugins")
+		})
+	})
+
+	Context("Test JSONSchema", func() {
+		It("the published schema is up to date", func() {
+			for name, conf := range map[string]interface{}{"veth": VethConf{}, "router": RouterConf{}} {
+				published, err := os.ReadFile(filepath.Join("..", "..", "docs", "usage", "schema", name+".schema.json"))
+				Expect(err).NotTo(HaveOccurred())
+				generated, err := json.MarshalIndent(JSONSchema(name, conf), "", "  ")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(published)).To(Equal(string(generated)+"\n"), "run 'make config-schema' to update the schema")
+			}
+		})
+
+		It("unknown fields are not allowed", func() {
+			schema := JSONSchema("router", RouterConf{})
+			Expect(schema["additionalProperties"]).To(BeFalse())
+			Expect(schema["properties"]).To(HaveKey("host_rule_table"))
+			Expect(schema["properties"]).To(HaveKey("cniVersion"))
+			Expect(schema["properties"]).NotTo(HaveKey("Warnings"))
+			Expect(schema["properties"]).To(HaveKeyWithValue("host_access", HaveKeyWithValue("enum", ContainElement(constant.HostAccessVeth))))
+		})
+	})
+
+	Context("Test MigrateConfig", func() {
+		It("rename the deprecated fields", func() {
+			migrated, warnings, err := MigrateConfig([]byte(`{"type":"router","overlay_subnet":["10.244.0.0/16"],"custom_subnet":["10.6.0.0/16"],"mtu":1500}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(HaveLen(2))
+
+			result := map[string]interface{}{}
+			Expect(json.Unmarshal(migrated, &result)).To(Succeed())
+			Expect(result).To(Equal(map[string]interface{}{
+				"type":                     "router",
+				"config_version":           float64(constant.ConfigVersion),
+				"overlay_hijack_subnet":    []interface{}{"10.244.0.0/16"},
+				"additional_hijack_subnet": []interface{}{"10.6.0.0/16"},
+				"mtu":                      float64(1500),
+			}))
+		})
+
+		It("rename the deprecated nested fields", func() {
+			migrated, warnings, err := MigrateConfig([]byte(`{"type":"veth","rp_filter":{"enable":false,"value":0}}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(ConsistOf("rp_filter.enable: deprecated, use rp_filter.set_host instead"))
+
+			result := map[string]interface{}{}
+			Expect(json.Unmarshal(migrated, &result)).To(Succeed())
+			Expect(result).To(HaveKeyWithValue("rp_filter", map[string]interface{}{"set_host": false, "value": float64(0)}))
+
+			_, _, err = MigrateConfig([]byte(`{"rp_filter":{"enable":true,"set_host":true}}`))
+			Expect(err).To(HaveOccurred())
+			_, _, err = MigrateConfig([]byte(`{"rp_filter":true}`))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("reject the conflicting fields", func() {
+			_, _, err := MigrateConfig([]byte(`{"service_subnet":["10.233.0.0/18"],"service_hijack_subnet":["10.233.0.0/18"]}`))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("the deprecated fields are not allowed in current version", func() {
+			_, _, err := MigrateConfig([]byte(`{"config_version":1,"custom_subnet":["10.6.0.0/16"]}`))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("current version is returned as it is", func() {
+			stdin := []byte(`{"config_version":1,"additional_hijack_subnet":["10.6.0.0/16"]}`)
+			migrated, warnings, err := MigrateConfig(stdin)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(BeEmpty())
+			Expect(migrated).To(Equal(stdin))
+		})
+
+		It("unsupported version", func() {
+			_, _, err := MigrateConfig([]byte(`{"config_version":100}`))
+			Expect(err).To(HaveOccurred())
+			_, _, err = MigrateConfig([]byte(`{"config_version":"1"}`))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Test PrintMigratedConfig", func() {
+		It("print the migrated config", func() {
+			stdin := bytes.NewBufferString(`{"cniVersion":"0.3.1","name":"veth","type":"veth","overlay_subnet":["10.244.0.0/16"],"service_hijack_subnet":["10.233.0.0/18"]}`)
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+			Expect(PrintMigratedConfig("veth", stdin, stdout, stderr)).To(Succeed())
+			Expect(stderr.String()).To(ContainSubstring("overlay_subnet: deprecated, use overlay_hijack_subnet instead"))
+
+			conf, err := ParseVethConfig(stdout.Bytes())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conf.ConfigVersion).To(Equal(constant.ConfigVersion))
+			Expect(conf.Warnings).To(BeEmpty())
+		})
+
+		It("invalid config", func() {
+			stdin := bytes.NewBufferString(`{"cniVersion":"0.3.1","name":"router","type":"router","overlay_subnet":["10.244.0.0/16"]}`)
+			err := PrintMigratedConfig("router", stdin, &bytes.Buffer{}, &bytes.Buffer{})
+			Expect(err).To(MatchError(ContainSubstring("service_hijack_subnet")))
+		})
+	})
 })
@@ -0,0 +1,138 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/spidernet-io/cni-plugins/pkg/constant"
+)
+
+// pluginConfigs are the config types of the plugins by name, the drop-in files are shared by them
+var pluginConfigs = map[string]reflect.Type{
+	"veth":   reflect.TypeOf(VethConf{}),
+	"router": reflect.TypeOf(RouterConf{}),
+}
+
+// MergeDropInConfig merges the node-local drop-in config files with the network config of given plugin(veth or
+// router) from stdin. the files are `*.json` in the `drop_in_dir` of stdin, or constant.DefaultDropInDir if it's
+// not given. the precedence is: stdin > the drop-in file sorted last > ... > the drop-in file sorted first.
+// objects are merged recursively, other values (including lists) are replaced as a whole.
+// the files are shared by the plugins, so the keys which only the other plugins know are dropped from them, and
+// the keys unknown to all plugins are still reported by the strict decoding.
+// It returns the merged config and the files merged.
+func MergeDropInConfig(plugin string, stdin []byte) ([]byte, []string, error) {
+	otherKeys, err := otherPluginKeys(plugin)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	netConf, err := decodeObject(stdin)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse network configuration: %v", err)
+	}
+
+	dir := constant.DefaultDropInDir
+	if v, ok := netConf["drop_in_dir"]; ok {
+		if dir, ok = v.(string); !ok {
+			return nil, nil, fmt.Errorf("drop_in_dir must be string, but got %v", v)
+		}
+	}
+	if dir == "" {
+		return stdin, nil, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list drop-in config in %s: %v", dir, err)
+	}
+	if len(files) == 0 {
+		return stdin, nil, nil
+	}
+	sort.Strings(files)
+
+	merged := make(map[string]interface{})
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read drop-in config %s: %v", file, err)
+		}
+		dropIn, err := decodeObject(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse drop-in config %s: %v", file, err)
+		}
+		for _, key := range constant.DropInForbiddenKeys {
+			if _, ok := dropIn[key]; ok {
+				return nil, nil, fmt.Errorf("%q can't be given by drop-in config %s", key, file)
+			}
+		}
+		// the keys are matched case-insensitively, just like they are decoded
+		for key := range dropIn {
+			for other := range otherKeys {
+				if strings.EqualFold(key, other) {
+					delete(dropIn, key)
+					break
+				}
+			}
+		}
+		mergeObject(merged, dropIn)
+	}
+	mergeObject(merged, netConf)
+
+	result, err := json.Marshal(merged)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal the merged config: %v", err)
+	}
+	return result, files, nil
+}
+
+// otherPluginKeys returns the top-level keys of network config which are known by the other plugins but not the
+// given one, such as host_rule_priority of router for veth
+func otherPluginKeys(plugin string) (map[string]bool, error) {
+	own, ok := pluginConfigs[plugin]
+	if !ok {
+		return nil, fmt.Errorf("unknown plugin %s", plugin)
+	}
+	ownKeys := jsonFields(own)
+	keys := make(map[string]bool)
+	for name, t := range pluginConfigs {
+		if name == plugin {
+			continue
+		}
+		for key := range jsonFields(t) {
+			if _, ok := ownKeys[key]; !ok {
+				keys[key] = true
+			}
+		}
+	}
+	return keys, nil
+}
+
+// decodeObject decodes a json object, numbers are kept as they are
+func decodeObject(data []byte) (map[string]interface{}, error) {
+	object := make(map[string]interface{})
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&object); err != nil {
+		return nil, err
+	}
+	return object, nil
+}
+
+// mergeObject merges src into dst, the values of src take precedence
+func mergeObject(dst, src map[string]interface{}) {
+	for key, value := range src {
+		srcObject, srcIsObject := value.(map[string]interface{})
+		dstObject, dstIsObject := dst[key].(map[string]interface{})
+		if srcIsObject && dstIsObject {
+			mergeObject(dstObject, srcObject)
+			continue
+		}
+		dst[key] = value
+	}
+}
@@ -0,0 +1,161 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spidernet-io/cni-plugins/pkg/constant"
+)
+
+// deprecatedFields maps the deprecated fields of network config to the current ones,
+// the deprecated fields are only accepted when config_version is not given.
+// the nested fields are joined by dots, and only renamed within the same object.
+var deprecatedFields = map[string]string{
+	"overlay_subnet":   "overlay_hijack_subnet",
+	"service_subnet":   "service_hijack_subnet",
+	"custom_subnet":    "additional_hijack_subnet",
+	"rp_filter.enable": "rp_filter.set_host",
+}
+
+// MigrateConfig migrates the network config to the current config_version: the deprecated fields
+// are renamed to the current ones with warnings, and it fails if both of them are given.
+// the config of current version is returned as it is, and the deprecated fields in it are errors.
+func MigrateConfig(stdin []byte) ([]byte, []string, error) {
+	conf, err := decodeObject(stdin)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse network configuration: %v", err)
+	}
+
+	version, err := configVersion(conf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	oldFields := make([]string, 0, len(deprecatedFields))
+	for old := range deprecatedFields {
+		if object, key := fieldObject(conf, old); object != nil {
+			if _, ok := object[key]; ok {
+				oldFields = append(oldFields, old)
+			}
+		}
+	}
+	sort.Strings(oldFields)
+
+	if version == constant.ConfigVersion {
+		if len(oldFields) != 0 {
+			return nil, nil, fmt.Errorf("deprecated fields %v are not allowed in config_version %d, use %v instead",
+				oldFields, version, currentFields(oldFields))
+		}
+		return stdin, nil, nil
+	}
+
+	var warnings []string
+	for _, old := range oldFields {
+		current := deprecatedFields[old]
+		object, oldKey := fieldObject(conf, old)
+		_, currentKey := fieldObject(conf, current)
+		if _, ok := object[currentKey]; ok {
+			return nil, nil, fmt.Errorf("both the deprecated field %q and %q are given, remove %q", old, current, old)
+		}
+		object[currentKey] = object[oldKey]
+		delete(object, oldKey)
+		warnings = append(warnings, fmt.Sprintf("%s: deprecated, use %s instead", old, current))
+	}
+	conf["config_version"] = constant.ConfigVersion
+
+	result, err := json.Marshal(conf)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal the migrated config: %v", err)
+	}
+	return result, warnings, nil
+}
+
+// PrintMigratedConfig reads the network config of given plugin(veth or router) from stdin, and writes the config
+// migrated to the current config_version to stdout, which can replace the config in NetworkAttachmentDefinition.
+// it only renames the deprecated fields, the defaults are not filled in. the migrated config is validated
+// before it is written, and the warnings are written to stderr.
+func PrintMigratedConfig(plugin string, stdin io.Reader, stdout, stderr io.Writer) error {
+	data, err := io.ReadAll(stdin)
+	if err != nil {
+		return fmt.Errorf("failed to read network configuration: %v", err)
+	}
+
+	migrated, warnings, err := MigrateConfig(data)
+	if err != nil {
+		return err
+	}
+
+	switch plugin {
+	case "veth":
+		conf, err := ParseVethConfig(migrated)
+		if err != nil {
+			return err
+		}
+		warnings = append(warnings, conf.Warnings...)
+	case "router":
+		conf, err := ParseRouterConfig(migrated)
+		if err != nil {
+			return err
+		}
+		warnings = append(warnings, conf.Warnings...)
+	default:
+		return fmt.Errorf("unknown plugin %s", plugin)
+	}
+
+	for _, warning := range warnings {
+		fmt.Fprintln(stderr, "warning:", warning)
+	}
+
+	var out bytes.Buffer
+	if err = json.Indent(&out, migrated, "", "  "); err != nil {
+		return err
+	}
+	out.WriteByte('\n')
+	_, err = out.WriteTo(stdout)
+	return err
+}
+
+// fieldObject returns the object which holds the field of given dotted path in conf and the key of field in it,
+// the object is nil if any parent of the field is not an object.
+func fieldObject(conf map[string]interface{}, path string) (map[string]interface{}, string) {
+	keys := strings.Split(path, ".")
+	object := conf
+	for _, key := range keys[:len(keys)-1] {
+		child, ok := object[key].(map[string]interface{})
+		if !ok {
+			return nil, ""
+		}
+		object = child
+	}
+	return object, keys[len(keys)-1]
+}
+
+func configVersion(conf map[string]interface{}) (int, error) {
+	value, ok := conf["config_version"]
+	if !ok {
+		return 0, nil
+	}
+
+	number, ok := value.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("config_version must be integer, but got %v", value)
+	}
+	version, err := strconv.Atoi(number.String())
+	if err != nil || version < 0 || version > constant.ConfigVersion {
+		return 0, fmt.Errorf("unsupported config_version %v, the latest version is %d", value, constant.ConfigVersion)
+	}
+	return version, nil
+}
+
+func currentFields(oldFields []string) []string {
+	result := make([]string, 0, len(oldFields))
+	for _, old := range oldFields {
+		result = append(result, deprecatedFields[old])
+	}
+	return result
+}
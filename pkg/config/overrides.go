@@ -0,0 +1,210 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spidernet-io/cni-plugins/pkg/constant"
+	ty "github.com/spidernet-io/cni-plugins/pkg/types"
+	"k8s.io/utils/pointer"
+)
+
+// ValidateAllowedOverrides make sure all keys of allowed_overrides are known
+func ValidateAllowedOverrides(allowed []string) error {
+	for _, key := range allowed {
+		if !isOverrideKey(key) {
+			return fmt.Errorf("unknown key %q in allowed_overrides, available keys: %v", key, constant.OverrideKeys)
+		}
+	}
+	return nil
+}
+
+// SplitCNIArgs splits CNI_ARGS into the args which is used by ty.K8sArgs and
+// the overrides of the plugin. so the overrides don't break types.LoadArgs.
+// input like: "K8S_POD_NAME=test;skip_call=true", output: "K8S_POD_NAME=test", {"skip_call": "true"}
+func SplitCNIArgs(args string) (string, map[string]string, error) {
+	if args == "" {
+		return "", nil, nil
+	}
+
+	var k8sArgs []string
+	overrides := make(map[string]string)
+	for _, pair := range strings.Split(args, ";") {
+		kv := strings.Split(pair, "=")
+		if len(kv) != 2 {
+			return "", nil, fmt.Errorf("invalid CNI_ARGS pair %q", pair)
+		}
+		if isOverrideKey(kv[0]) {
+			overrides[kv[0]] = kv[1]
+			continue
+		}
+		k8sArgs = append(k8sArgs, pair)
+	}
+	return strings.Join(k8sArgs, ";"), overrides, nil
+}
+
+// ParseOverrides parses the per-pod overrides from the annotations of pod, the `args.cni` of
+// network config and CNI_ARGS. the precedence is: CNI_ARGS > `args.cni` > annotations.
+// Only the keys given by allowed can be overridden, otherwise return err.
+func ParseOverrides(allowed []string, annotations map[string]string, cniArgs *ty.CNIArgs, envArgs map[string]string) (*ty.Overrides, error) {
+	values := make(map[string]string)
+	for key, value := range annotations {
+		values[key] = value
+	}
+
+	if cniArgs != nil {
+		for key, value := range cniArgs.Cni {
+			if !isOverrideKey(key) {
+				// args.cni may carry the args of other plugins, ignore them
+				continue
+			}
+			str, err := overrideValueString(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value of args.cni %q: %v", key, err)
+			}
+			values[key] = str
+		}
+	}
+
+	for key, value := range envArgs {
+		values[key] = value
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	overrides := &ty.Overrides{}
+	for _, key := range keys {
+		if !isAllowed(allowed, key) {
+			return nil, fmt.Errorf("%q is not allowed to be overridden by pod, it must be listed in allowed_overrides", key)
+		}
+
+		value := strings.TrimSpace(values[key])
+		switch key {
+		case constant.OverrideSkipCall:
+			skipped, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s %q: %v", key, value, err)
+			}
+			overrides.Skipped = pointer.Bool(skipped)
+		case constant.OverrideAdditionalHijackSubnet:
+			if value == "" {
+				continue
+			}
+			subnets, err := validateRoutes(strings.Split(value, ","))
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s %q: %v", key, value, err)
+			}
+			overrides.AdditionalHijackSubnet = subnets
+		case constant.OverrideRPFilterValue:
+			rp, err := strconv.ParseInt(value, 10, 32)
+			if err != nil || rp < 0 || rp > 2 {
+				return nil, fmt.Errorf("invalid %s %q: value must be 0/1/2", key, value)
+			}
+			overrides.RPFilterValue = pointer.Int32(int32(rp))
+		case constant.OverrideMigrateRoute:
+			migrate, err := strconv.ParseInt(value, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s %q: %v", key, value, err)
+			}
+			given := ty.MigrateRoute(migrate)
+			if *ValidateMigrateRouteConfig(&given) != given {
+				return nil, fmt.Errorf("invalid %s %q: value must be -1/0/1", key, value)
+			}
+			overrides.MigrateRoute = &given
+		case constant.OverrideMacPrefix:
+			if err := ValidateOverwriteMacAddress(value); err != nil {
+				return nil, err
+			}
+			overrides.MacPrefix = pointer.String(value)
+		case constant.OverrideDryRun:
+			dryRun, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s %q: %v", key, value, err)
+			}
+			overrides.DryRun = pointer.Bool(dryRun)
+		}
+	}
+	return overrides, nil
+}
+
+// overrideValueString converts the json value of args.cni to the string format of CNI_ARGS,
+// so both of them can be parsed in the same way.
+func overrideValueString(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			str, ok := item.(string)
+			if !ok {
+				return "", fmt.Errorf("list item must be string, but got %v", item)
+			}
+			items = append(items, str)
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported type %T", value)
+	}
+}
+
+func isOverrideKey(key string) bool {
+	for _, k := range constant.OverrideKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+func isAllowed(allowed []string, key string) bool {
+	for _, k := range allowed {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// ApplyOverrides overwrites the network config with the overrides of pod. the rp_filter value of pod is also the one
+// of host, so it can't be overridden if the network doesn't set the rp_filter of host.
+func (c *PluginConf) ApplyOverrides(overrides *ty.Overrides) error {
+	if overrides.RPFilterValue != nil {
+		if c.RPFilter == nil {
+			c.RPFilter, _ = ValidateRPFilterConfig(nil)
+		}
+		if c.RPFilter.Enable == nil || !*c.RPFilter.Enable {
+			return fmt.Errorf("the override %s is not allowed, rp_filter.set_host of the network is false", constant.OverrideRPFilterValue)
+		}
+	}
+
+	if overrides.Skipped != nil {
+		c.Skipped = *overrides.Skipped
+	}
+	if len(overrides.AdditionalHijackSubnet) != 0 {
+		c.AdditionalHijackSubnet = append(c.AdditionalHijackSubnet, overrides.AdditionalHijackSubnet...)
+	}
+	if overrides.RPFilterValue != nil {
+		c.RPFilter.Value = overrides.RPFilterValue
+	}
+	if overrides.MigrateRoute != nil {
+		c.MigrateRoute = overrides.MigrateRoute
+	}
+	if overrides.MacPrefix != nil {
+		c.MacPrefix = *overrides.MacPrefix
+	}
+	if overrides.DryRun != nil {
+		c.DryRun = *overrides.DryRun
+	}
+	return nil
+}
@@ -0,0 +1,500 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/version"
+	"github.com/spidernet-io/cni-plugins/pkg/constant"
+	"github.com/spidernet-io/cni-plugins/pkg/logging"
+	ty "github.com/spidernet-io/cni-plugins/pkg/types"
+	"github.com/spidernet-io/spiderpool/pkg/logutils"
+	"k8s.io/utils/pointer"
+)
+
+// PluginConf is the network config shared by veth and router
+type PluginConf struct {
+	types.NetConf
+	// the version of this config, see constant.ConfigVersion
+	ConfigVersion int `json:"config_version,omitempty"`
+	// given by the container runtime if the plugin declares capabilities, not used for now
+	RuntimeConfig map[string]interface{} `json:"runtimeConfig,omitempty"`
+	// the PCI address of the device allocated by the device plugin, which is injected by Multus.
+	// the plugins detect the SR-IOV VF by it
+	DeviceID string `json:"deviceID,omitempty"`
+	// the chained interface is a SR-IOV VF, it's detected by deviceID or the pciID in prevResult if not set
+	Sriov bool `json:"sriov,omitempty"`
+	// should include: overlay Subnet , clusterip subnet
+	OverlayHijackSubnet    []string `json:"overlay_hijack_subnet,omitempty"`
+	ServiceHijackSubnet    []string `json:"service_hijack_subnet,omitempty"`
+	AdditionalHijackSubnet []string `json:"additional_hijack_subnet,omitempty"`
+	// the routes to the destinations through the given path, with the gateway, metric, table or source hint
+	HijackRoutes []ty.HijackRoute `json:"hijack_routes,omitempty"`
+	// the interface created by overlay cni in pod, default to eth0 for veth.
+	// router detects it if it's auto, which is the default of router
+	DefaultOverlayInterface string `json:"overlay_interface,omitempty"`
+	// RpFilter
+	RPFilter     *ty.RPFilter     `json:"rp_filter,omitempty"`
+	Skipped      bool             `json:"skip_call,omitempty"`
+	MigrateRoute *ty.MigrateRoute `json:"migrate_route,omitempty"`
+	LogOptions   *ty.LogOptions   `json:"log_options,omitempty"`
+	IPConflict   *ty.IPConflict   `json:"ip_conflict,omitempty"`
+	MacPrefix    string           `json:"mac_prefix,omitempty"`
+	OnlyOpMac    bool             `json:"only_op_mac,omitempty"`
+	// the keys which a pod can override by CNI_ARGS or args.cni
+	AllowedOverrides []string    `json:"allowed_overrides,omitempty"`
+	Args             *ty.CNIArgs `json:"args,omitempty"`
+	// look up the annotations of pod from kubernetes
+	Kubernetes *ty.Kubernetes `json:"kubernetes,omitempty"`
+	// discover the subnets which are not given from kubernetes
+	AutoDiscovery *ty.AutoDiscovery `json:"auto_discover,omitempty"`
+	// the directory of node-local drop-in config, which is merged with this config
+	DropInDir *string `json:"drop_in_dir,omitempty"`
+	// the interfaces whose ip is not treated as the ip of node
+	HostInterfacesToExclude []string `json:"host_interfaces_to_exclude,omitempty"`
+	// only log the network changes which would be made, and return the prevResult unchanged
+	DryRun bool `json:"dry_run,omitempty"`
+	// the deadline of an invocation, such as 30s. it fails with the code ErrTryAgainLater once exceeded
+	Timeout string `json:"timeout,omitempty"`
+	// the protocol which the routes and rules created by the plugins are tagged with, to tell them from others
+	RouteProtocol *int `json:"route_protocol,omitempty"`
+	// flush the conntrack entries of the pod ips on the node in ADD and DEL, default to true
+	FlushConntrack *bool `json:"flush_conntrack,omitempty"`
+	// set the preferred source of the hijack routes and the routes of node ips in pod by the interface which they go
+	// through, default to true
+	RouteSrcHint *bool `json:"route_src_hint,omitempty"`
+	// how the routes of the underlay attachment are isolated from the overlay: rule, vrf or fwmark, default to rule
+	Isolation string `json:"isolation,omitempty"`
+
+	// Warnings are the values coerced when validating the config, they should be logged by the plugin
+	Warnings []string `json:"-"`
+}
+
+// VethConf is the network config of veth
+type VethConf struct {
+	PluginConf
+	// send the replies of the connections from veth0 back through veth0
+	ReturnPath *ty.ReturnPath `json:"return_path,omitempty"`
+}
+
+// RouterConf is the network config of router
+type RouterConf struct {
+	PluginConf
+	HostRuleTable *int `json:"host_rule_table,omitempty"`
+	// the priority of the rule to host_rule_table on the node
+	HostRulePriority *int `json:"host_rule_priority,omitempty"`
+	// the path between the node and the chained interface: auto, overlay or veth, default to auto
+	HostAccess string `json:"host_access,omitempty"`
+}
+
+// ParseVethConfig decodes and validates the network config of veth.
+// all unknown fields and invalid values are reported in the returned error.
+func ParseVethConfig(stdin []byte) (*VethConf, error) {
+	migrated, warnings, err := MigrateConfig(stdin)
+	if err != nil {
+		return nil, fmt.Errorf("[veth] failed to migrate network configuration: %v", err)
+	}
+
+	conf := &VethConf{}
+	errs, err := decodeStrict(migrated, conf)
+	if err != nil {
+		return nil, fmt.Errorf("[veth] failed to parse network configuration: %v", err)
+	}
+
+	// Parse previous result. This will parse, validate, and place the
+	// previous result object into conf.PrevResult. If you need to modify
+	// or inspect the PrevResult you will need to convert it to a concrete
+	// versioned Result struct.
+	if err = version.ParsePrevResult(&conf.NetConf); err != nil {
+		return nil, fmt.Errorf("[veth] could not parse prevResult: %v", err)
+	}
+
+	conf.Warnings = warnings
+	errs = append(errs, conf.PluginConf.validate(constant.VethLogDefaultFilePath)...)
+	if conf.DefaultOverlayInterface == constant.AutoOverlayInterface {
+		errs = append(errs, fmt.Errorf("overlay_interface: %s is only supported by router", constant.AutoOverlayInterface))
+	}
+	if !conf.OnlyOpMac {
+		if conf.ReturnPath, err = ValidateReturnPath(conf.ReturnPath); err != nil {
+			errs = append(errs, fmt.Errorf("return_path: %v", err))
+		}
+	}
+	if len(errs) != 0 {
+		return nil, fmt.Errorf("[veth] invalid network configuration: %w", errors.Join(errs...))
+	}
+	return conf, nil
+}
+
+// ParseRouterConfig decodes and validates the network config of router.
+// all unknown fields and invalid values are reported in the returned error.
+func ParseRouterConfig(stdin []byte) (*RouterConf, error) {
+	migrated, warnings, err := MigrateConfig(stdin)
+	if err != nil {
+		return nil, fmt.Errorf("[router] failed to migrate network configuration: %v", err)
+	}
+
+	conf := &RouterConf{}
+	errs, err := decodeStrict(migrated, conf)
+	if err != nil {
+		return nil, fmt.Errorf("[router] failed to parse network configuration: %v", err)
+	}
+
+	if err = version.ParsePrevResult(&conf.NetConf); err != nil {
+		return nil, fmt.Errorf("[router] could not parse prevResult: %v", err)
+	}
+
+	conf.Warnings = warnings
+	// router detects the overlay interface by default
+	if conf.DefaultOverlayInterface == "" {
+		conf.DefaultOverlayInterface = constant.AutoOverlayInterface
+	}
+	errs = append(errs, conf.PluginConf.validate(constant.RouterLogDefaultFilePath)...)
+	if !conf.OnlyOpMac {
+		if conf.HostRuleTable == nil {
+			conf.HostRuleTable = pointer.Int(500)
+		} else if *conf.HostRuleTable <= 0 {
+			errs = append(errs, fmt.Errorf("host_rule_table: must be greater than 0, but got %d", *conf.HostRuleTable))
+		}
+
+		if conf.HostRulePriority == nil {
+			conf.HostRulePriority = pointer.Int(constant.DefaultHostRulePriority)
+		} else if *conf.HostRulePriority < 0 {
+			errs = append(errs, fmt.Errorf("host_rule_priority: must not be negative, but got %d", *conf.HostRulePriority))
+		}
+
+		switch conf.HostAccess {
+		case "":
+			conf.HostAccess = constant.HostAccessAuto
+		case constant.HostAccessAuto, constant.HostAccessOverlay, constant.HostAccessVeth:
+		default:
+			errs = append(errs, fmt.Errorf("host_access: unknown value %q, must be one of: %s, %s, %s", conf.HostAccess,
+				constant.HostAccessAuto, constant.HostAccessOverlay, constant.HostAccessVeth))
+		}
+	}
+
+	if len(errs) != 0 {
+		return nil, fmt.Errorf("[router] invalid network configuration: %w", errors.Join(errs...))
+	}
+	return conf, nil
+}
+
+// ParseVethDelConfig parses the network config of veth for DEL. DEL is best-effort, the pod must be torn down even
+// if its config is invalid, such as an old config with the fields unknown now. so the config is decoded leniently
+// with the defaults if it's invalid, and the returned config is never nil. the returned error reports the invalid config.
+func ParseVethDelConfig(stdin []byte) (*VethConf, error) {
+	conf, err := ParseVethConfig(stdin)
+	if err == nil {
+		return conf, nil
+	}
+
+	conf = &VethConf{}
+	decodeLax(stdin, conf, &conf.PluginConf)
+	return conf, err
+}
+
+// ParseRouterDelConfig parses the network config of router for DEL, see ParseVethDelConfig.
+func ParseRouterDelConfig(stdin []byte) (*RouterConf, error) {
+	conf, err := ParseRouterConfig(stdin)
+	if err == nil {
+		return conf, nil
+	}
+
+	conf = &RouterConf{}
+	decodeLax(stdin, conf, &conf.PluginConf)
+	switch conf.HostAccess {
+	case constant.HostAccessAuto, constant.HostAccessOverlay, constant.HostAccessVeth:
+	default:
+		conf.HostAccess = constant.HostAccessAuto
+	}
+	return conf, err
+}
+
+// decodeLax decodes the network config into conf ignoring the unknown fields and invalid values, and gives the
+// defaults used by DEL to the shared config. the log options are left to the plugins, which log with the defaults.
+func decodeLax(stdin []byte, conf interface{}, shared *PluginConf) {
+	if migrated, _, err := MigrateConfig(stdin); err == nil {
+		stdin = migrated
+	}
+	// the values of the wrong types are skipped by the decoder, others are still decoded
+	_ = json.Unmarshal(stdin, conf)
+	if err := version.ParsePrevResult(&shared.NetConf); err != nil {
+		shared.PrevResult = nil
+	}
+
+	if shared.FlushConntrack == nil {
+		shared.FlushConntrack = pointer.Bool(true)
+	}
+	if timeout, err := time.ParseDuration(shared.Timeout); err != nil || timeout <= 0 {
+		shared.Timeout = constant.DefaultPluginTimeout
+	}
+	shared.LogOptions = nil
+}
+
+// DefaultLogOptions returns the log options of the config without log_options, it's used to log the errors of
+// the config which can't be parsed
+func DefaultLogOptions(defaultLogFile string) *ty.LogOptions {
+	options := logging.InitLogOptions(nil)
+	options.LogFilePath = defaultLogFile
+	return options
+}
+
+// validate validates the shared config and gives default values to it,
+// it returns the errors of all invalid fields.
+func (c *PluginConf) validate(defaultLogFile string) []error {
+	var errs []error
+	var err error
+
+	if err = ValidateOverwriteMacAddress(c.MacPrefix); err != nil {
+		errs = append(errs, fmt.Errorf("mac_prefix: %v", err))
+	}
+
+	if err = ValidateAllowedOverrides(c.AllowedOverrides); err != nil {
+		errs = append(errs, fmt.Errorf("allowed_overrides: %v", err))
+	}
+
+	if c.Timeout == "" {
+		c.Timeout = constant.DefaultPluginTimeout
+	}
+	if timeout, err := time.ParseDuration(c.Timeout); err != nil || timeout <= 0 {
+		errs = append(errs, fmt.Errorf("timeout: invalid timeout %s, must be a positive duration like: 30s or 1m", c.Timeout))
+	}
+
+	if c.FlushConntrack == nil {
+		c.FlushConntrack = pointer.Bool(true)
+	}
+
+	if c.RouteSrcHint == nil {
+		c.RouteSrcHint = pointer.Bool(true)
+	}
+
+	if c.RouteProtocol == nil {
+		c.RouteProtocol = pointer.Int(constant.DefaultRouteProtocol)
+	} else if *c.RouteProtocol < constant.MinRouteProtocol || *c.RouteProtocol > 255 {
+		errs = append(errs, fmt.Errorf("route_protocol: must be in [%d, 255], but got %d", constant.MinRouteProtocol, *c.RouteProtocol))
+	}
+
+	if c.Kubernetes, err = ValidateKubernetes(c.Kubernetes); err != nil {
+		errs = append(errs, fmt.Errorf("kubernetes: %v", err))
+	}
+
+	if c.IPConflict != nil {
+		if c.IPConflict.Retry < 0 {
+			c.warn("ip_conflict.retries: %d is negative, use the default value 3", c.IPConflict.Retry)
+		}
+		c.IPConflict = ValidateIPConflict(c.IPConflict)
+		if _, err = time.ParseDuration(c.IPConflict.Interval); err != nil {
+			errs = append(errs, fmt.Errorf("ip_conflict.interval: invalid interval %s: %v, input like: 1s or 1m", c.IPConflict.Interval, err))
+		}
+	}
+
+	c.LogOptions = logging.InitLogOptions(c.LogOptions)
+	if logutils.ConvertLogLevel(c.LogOptions.LogLevel) == nil {
+		c.warn("log_options.log_level: unknown level %q, use %s", c.LogOptions.LogLevel, constant.LogInfoLevelStr)
+		c.LogOptions.LogLevel = constant.LogInfoLevelStr
+	}
+	if c.LogOptions.LogFilePath == "" {
+		c.LogOptions.LogFilePath = defaultLogFile
+	}
+
+	if c.HostInterfacesToExclude == nil {
+		c.HostInterfacesToExclude = constant.DefaultNodeInterfacesToExclude
+	}
+	for _, exclude := range c.HostInterfacesToExclude {
+		if _, err = regexp.Compile(exclude); err != nil {
+			errs = append(errs, fmt.Errorf("host_interfaces_to_exclude: invalid regexp %q: %v", exclude, err))
+		}
+	}
+
+	if c.DefaultOverlayInterface == "" {
+		c.DefaultOverlayInterface = constant.DefaultInterfaceName
+	}
+
+	if c.OnlyOpMac {
+		return errs
+	}
+
+	if c.AutoDiscovery, err = ValidateAutoDiscovery(c.AutoDiscovery, c.Kubernetes); err != nil {
+		errs = append(errs, fmt.Errorf("auto_discover: %v", err))
+	}
+	// the subnets which are not given are discovered in cmdAdd
+	discover := c.AutoDiscovery != nil && c.AutoDiscovery.Enabled
+
+	if len(c.OverlayHijackSubnet) == 0 && !discover {
+		errs = append(errs, fmt.Errorf("overlay_hijack_subnet: the subnet of overlay cni(such as calico or cilium) must be given"))
+	}
+	c.warnTrimmed("overlay_hijack_subnet", c.OverlayHijackSubnet)
+	if c.OverlayHijackSubnet, err = ValidateOverlaySubnets(c.OverlayHijackSubnet); err != nil {
+		errs = append(errs, fmt.Errorf("overlay_hijack_subnet: %v", err))
+	}
+
+	if len(c.ServiceHijackSubnet) == 0 && !discover {
+		errs = append(errs, fmt.Errorf("service_hijack_subnet: the subnet of service clusterip must be given"))
+	}
+	c.warnTrimmed("service_hijack_subnet", c.ServiceHijackSubnet)
+	if c.ServiceHijackSubnet, err = ValidateSubnets(c.ServiceHijackSubnet); err != nil {
+		errs = append(errs, fmt.Errorf("service_hijack_subnet: %v", err))
+	}
+
+	c.warnTrimmed("additional_hijack_subnet", c.AdditionalHijackSubnet)
+	if c.AdditionalHijackSubnet, err = ValidateSubnets(c.AdditionalHijackSubnet); err != nil {
+		errs = append(errs, fmt.Errorf("additional_hijack_subnet: %v", err))
+	}
+	if c.HijackRoutes, err = ValidateHijackRoutes(c.HijackRoutes); err != nil {
+		errs = append(errs, fmt.Errorf("hijack_routes: %v", err))
+	}
+
+	if c.RPFilter, err = ValidateRPFilterConfig(c.RPFilter); err != nil {
+		errs = append(errs, fmt.Errorf("rp_filter: %v", err))
+	}
+
+	switch c.Isolation {
+	case "":
+		c.Isolation = constant.IsolationRule
+	case constant.IsolationRule, constant.IsolationVRF:
+	case constant.IsolationFwmark:
+		if _, err = lookPath(constant.NftCommand); err != nil {
+			errs = append(errs, fmt.Errorf("isolation: %s needs the %s command on the node: %v", c.Isolation, constant.NftCommand, err))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("isolation: unknown value %q, must be one of: %s, %s, %s", c.Isolation,
+			constant.IsolationRule, constant.IsolationVRF, constant.IsolationFwmark))
+	}
+
+	given := c.MigrateRoute
+	c.MigrateRoute = ValidateMigrateRouteConfig(c.MigrateRoute)
+	if given != nil && *given != *c.MigrateRoute {
+		c.warn("migrate_route: unknown value %d, use %d", *given, *c.MigrateRoute)
+	}
+
+	return errs
+}
+
+func (c *PluginConf) warn(format string, args ...interface{}) {
+	c.Warnings = append(c.Warnings, fmt.Sprintf(format, args...))
+}
+
+func (c *PluginConf) warnTrimmed(field string, subnets []string) {
+	for _, subnet := range subnets {
+		if trimmed := strings.TrimSpace(subnet); trimmed != subnet {
+			c.warn("%s: the spaces of %q are trimmed", field, subnet)
+		}
+	}
+}
+
+// decodeStrict decodes the network config into conf, the unknown fields are returned as errors
+// so that they can be reported together with other invalid fields.
+// the returned error means the config can't be decoded at all.
+func decodeStrict(stdin []byte, conf interface{}) ([]error, error) {
+	raw := make(map[string]interface{})
+	if err := json.Unmarshal(stdin, &raw); err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(stdin, conf); err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for _, field := range unknownFields(raw, reflect.TypeOf(conf), "") {
+		errs = append(errs, fmt.Errorf("%s: unknown field", field))
+	}
+	return errs, nil
+}
+
+// the types whose content is defined by others, so any field is accepted
+// lookPath finds the commands which the network config depends on
+var lookPath = exec.LookPath
+
+var opaqueTypes = map[reflect.Type]bool{
+	reflect.TypeOf(types.IPAM{}): true,
+	reflect.TypeOf(types.DNS{}):  true,
+	reflect.TypeOf(ty.CNIArgs{}): true,
+}
+
+// unknownFields returns the fields of raw which are not defined by t, the nested objects are checked too
+func unknownFields(raw map[string]interface{}, t reflect.Type, prefix string) []string {
+	fields := jsonFields(t)
+
+	var unknown []string
+	for key, value := range raw {
+		field, ok := lookupField(fields, key)
+		if !ok {
+			unknown = append(unknown, prefix+key)
+			continue
+		}
+
+		ft := indirectType(field.Type)
+		switch {
+		case ft.Kind() == reflect.Struct && !opaqueTypes[ft]:
+			if object, ok := value.(map[string]interface{}); ok {
+				unknown = append(unknown, unknownFields(object, ft, prefix+key+".")...)
+			}
+		case ft.Kind() == reflect.Slice && indirectType(ft.Elem()).Kind() == reflect.Struct:
+			items, _ := value.([]interface{})
+			for idx, item := range items {
+				if object, ok := item.(map[string]interface{}); ok {
+					unknown = append(unknown, unknownFields(object, indirectType(ft.Elem()), fmt.Sprintf("%s%s[%d].", prefix, key, idx))...)
+				}
+			}
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// lookupField returns the field of the key as encoding/json matches it: the exact name is preferred,
+// otherwise the name is matched case-insensitively
+func lookupField(fields map[string]reflect.StructField, key string) (reflect.StructField, bool) {
+	if field, ok := fields[key]; ok {
+		return field, true
+	}
+	for name, field := range fields {
+		if strings.EqualFold(name, key) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// jsonFields returns the fields of struct t by their json name, the fields of embedded structs are included
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	t = indirectType(t)
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if field.Anonymous && name == "" && indirectType(field.Type).Kind() == reflect.Struct {
+			for k, v := range jsonFields(field.Type) {
+				fields[k] = v
+			}
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field
+	}
+	return fields
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t
+}
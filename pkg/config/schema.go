@@ -0,0 +1,90 @@
+package config
+
+import (
+	"reflect"
+
+	"github.com/spidernet-io/cni-plugins/pkg/constant"
+)
+
+// SchemaVersion is the version of JSON schema which the generated schema follows
+const SchemaVersion = "https://json-schema.org/draft/2020-12/schema"
+
+// the allowed values of the fields, the key is the path of field
+var schemaEnums = map[string][]interface{}{
+	"config_version":                {constant.ConfigVersion},
+	"migrate_route":                 {-1, 0, 1},
+	"rp_filter.value":               {0, 1, 2},
+	"auto_discover.overlay_sources": {constant.OverlaySourceCalico, constant.OverlaySourceCilium},
+	"isolation":                     {constant.IsolationRule, constant.IsolationVRF, constant.IsolationFwmark},
+	"host_access":                   {constant.HostAccessAuto, constant.HostAccessOverlay, constant.HostAccessVeth},
+	"hijack_routes[].via":           {constant.HijackViaOverlay, constant.HijackViaUnderlay},
+}
+
+// the patterns of the values which are allowed besides the enum, the key is the path of field
+var schemaPatterns = map[string]string{
+	// the name of an interface in pod
+	"hijack_routes[].via": "^[^ /]{1,15}$",
+}
+
+// JSONSchema generates the JSON schema of the network config from its Go type,
+// conf should be VethConf or RouterConf.
+func JSONSchema(title string, conf interface{}) map[string]interface{} {
+	schema := typeSchema(reflect.TypeOf(conf), "")
+	schema["$schema"] = SchemaVersion
+	schema["title"] = title
+	return schema
+}
+
+func typeSchema(t reflect.Type, path string) map[string]interface{} {
+	t = indirectType(t)
+	schema := make(map[string]interface{})
+
+	switch t.Kind() {
+	case reflect.String:
+		schema["type"] = "string"
+	case reflect.Bool:
+		schema["type"] = "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		schema["type"] = "integer"
+	case reflect.Float32, reflect.Float64:
+		schema["type"] = "number"
+	case reflect.Slice, reflect.Array:
+		schema["type"] = "array"
+		items := typeSchema(t.Elem(), path+"[]")
+		if enum, ok := schemaEnums[path]; ok {
+			items["enum"] = enum
+		}
+		schema["items"] = items
+		return schema
+	case reflect.Map:
+		schema["type"] = "object"
+	case reflect.Struct:
+		schema["type"] = "object"
+		if opaqueTypes[t] {
+			break
+		}
+		properties := make(map[string]interface{})
+		for name, field := range jsonFields(t) {
+			fieldPath := name
+			if path != "" {
+				fieldPath = path + "." + name
+			}
+			properties[name] = typeSchema(field.Type, fieldPath)
+		}
+		schema["properties"] = properties
+		schema["additionalProperties"] = false
+	}
+
+	if enum, ok := schemaEnums[path]; ok {
+		if pattern, ok := schemaPatterns[path]; ok {
+			schema["anyOf"] = []interface{}{
+				map[string]interface{}{"enum": enum},
+				map[string]interface{}{"pattern": pattern},
+			}
+		} else {
+			schema["enum"] = enum
+		}
+	}
+	return schema
+}
@@ -2,6 +2,9 @@ package constant
 
 var SysctlConfPath = "/proc/sys/net/ipv4/conf"
 
+// SysBusPCIPath is where the PCI devices are, the PF of a VF is found by it
+var SysBusPCIPath = "/sys/bus/pci/devices"
+
 // var disableIPv6SysctlTemplate = "net/ipv6/conf/%s/disable_ipv6"
 
 var DefaultInterfacesToExclude = []string{
@@ -11,6 +14,12 @@ var DefaultInterfacesToExclude = []string{
 }
 
 var OverlayRouteTable = 100
+
+// AutoOverlayInterface in overlay_interface means router detects the interface of overlay CNI in pod
+var AutoOverlayInterface = "auto"
+
+// AutoSubnet in overlay_hijack_subnet means the overlay subnets are inferred from routes
+var AutoSubnet = "auto"
 var DefaultInterfaceName = "eth0"
 var DefaultMacPrefix = "80:80"
 
@@ -33,3 +42,163 @@ const (
 	LogDefaultMaxAge         = 5   // days
 	LogDefaultMaxBackups     = 5
 )
+
+// The keys that a pod may override by CNI_ARGS or the `args.cni` of the network config,
+// only the keys listed in `allowed_overrides` of the network config are accepted.
+const (
+	OverrideSkipCall               = "skip_call"
+	OverrideAdditionalHijackSubnet = "additional_hijack_subnet"
+	OverrideRPFilterValue          = "rp_filter_value"
+	OverrideMigrateRoute           = "migrate_route"
+	OverrideMacPrefix              = "mac_prefix"
+	OverrideDryRun                 = "dry_run"
+)
+
+var OverrideKeys = []string{
+	OverrideSkipCall, OverrideAdditionalHijackSubnet, OverrideRPFilterValue,
+	OverrideMigrateRoute, OverrideMacPrefix, OverrideDryRun,
+}
+
+// The annotations of pod which override the network config, see OverrideKeys
+const (
+	AnnotationPrefix         = "meta-plugins.spidernet.io/"
+	AnnotationSkip           = AnnotationPrefix + "skip"
+	AnnotationHijackSubnets  = AnnotationPrefix + "hijack-subnets"
+	AnnotationRPFilter       = AnnotationPrefix + "rp-filter"
+	AnnotationMigrateRoute   = AnnotationPrefix + "migrate-route"
+	AnnotationMacPrefix      = AnnotationPrefix + "mac-prefix"
+	AnnotationDryRun         = AnnotationPrefix + "dry-run"
+	DefaultKubernetesTimeout = "3s"
+)
+
+// AnnotationOverrideKeys maps the annotations of pod to the keys of overrides
+var AnnotationOverrideKeys = map[string]string{
+	AnnotationSkip:          OverrideSkipCall,
+	AnnotationHijackSubnets: OverrideAdditionalHijackSubnet,
+	AnnotationRPFilter:      OverrideRPFilterValue,
+	AnnotationMigrateRoute:  OverrideMigrateRoute,
+	AnnotationMacPrefix:     OverrideMacPrefix,
+	AnnotationDryRun:        OverrideDryRun,
+}
+
+// The sources of overlay subnets for auto discovery
+const (
+	OverlaySourceCalico = "calico"
+	OverlaySourceCilium = "cilium"
+)
+
+var DefaultOverlaySources = []string{OverlaySourceCalico, OverlaySourceCilium}
+
+const (
+	DefaultDiscoveryCacheFile = "/var/run/meta-plugins/discovered-subnets.json"
+	DefaultDiscoveryCacheTTL  = "10m"
+)
+
+// The node-local drop-in config, it's merged with the network config from stdin
+const (
+	DefaultDropInDir        = "/etc/cni/meta-plugins.d"
+	DefaultHostRulePriority = 1000
+)
+
+// DefaultPluginTimeout is the default deadline of a plugin invocation
+const DefaultPluginTimeout = "30s"
+
+// DefaultRouteProtocol is the protocol of the routes and rules created by the plugins, like: ip route show proto 201.
+// 0-4 are reserved by the kernel.
+const (
+	DefaultRouteProtocol = 201
+	MinRouteProtocol     = 5
+)
+
+// The paths between the node and the chained interface of pod in router, see RouterConf.HostAccess
+const (
+	// veth if the overlay interface is a cilium lxc device, otherwise overlay
+	HostAccessAuto = "auto"
+	// through the overlay interface and its veth device on the node, such as cali*
+	HostAccessOverlay = "overlay"
+	// through a dedicated veth pair, cilium drops the packets from the underlay ip on its lxc device
+	HostAccessVeth = "veth"
+)
+
+// HostLockFile is locked by every invocation on the node while it changes the rules, routes and neighbors of host
+const HostLockFile = "/var/run/meta-plugins/host.lock"
+
+// ReleasedIPsDir records the pod ips released by DEL, ADD flushes the conntrack entries of an ip only if it's reused
+const ReleasedIPsDir = "/var/run/meta-plugins/released-ips"
+
+// DropInForbiddenKeys can't be given by the drop-in config, they belong to the network config
+var DropInForbiddenKeys = []string{"cniVersion", "name", "type", "prevResult", "runtimeConfig", "args", "drop_in_dir"}
+
+// DefaultNodeInterfacesToExclude is the interfaces whose ip is not treated as the ip of node
+var DefaultNodeInterfacesToExclude = []string{
+	"docker.*", "cbr.*", "dummy.*",
+	"virbr.*", "lxcbr.*", "veth.*", `^lo$`,
+	`^cali.*`, "flannel.*", "kube-ipvs.*",
+	"cni.*", "vx-submariner", "cilium*",
+}
+
+// ConfigVersion is the current version of network config, the config without config_version is
+// treated as the legacy version 0, whose deprecated fields are migrated with warnings.
+const ConfigVersion = 1
+
+// The defaults of the return path of veth, see types.ReturnPath
+const (
+	DefaultReturnPathMark     = 0x2000
+	DefaultReturnPathTable    = 1002
+	DefaultReturnPathPriority = 90
+)
+
+// The flavors of overlay CNI, they're told by the name of the veth peer of the overlay interface on the node,
+// or the bridge of Open vSwitch which the peer is attached to
+const (
+	OverlayFlavorCalico  = "calico"
+	OverlayFlavorCilium  = "cilium"
+	OverlayFlavorKubeOVN = "kube-ovn"
+	OverlayFlavorAntrea  = "antrea"
+	// the other CNIs which create veth pairs like veth*, such as flannel
+	OverlayFlavorVeth = "veth"
+)
+
+// The types of the chained interface in pod, which is created by the main CNI. They decide whether the mac-address
+// of it can be changed and whether it answers ARP, see networking.ChainedInterfaceType
+const (
+	// macvlan, and the others which are treated as it
+	ChainedTypeMacvlan = "macvlan"
+	// ipvlan in l2 mode, it shares the mac-address of the parent
+	ChainedTypeIPvlanL2 = "ipvlan-l2"
+	// ipvlan in l3 or l3s mode, it shares the mac-address of the parent and is NOARP
+	ChainedTypeIPvlanL3 = "ipvlan-l3"
+	// a VF of SR-IOV NIC
+	ChainedTypeSriov = "sriov"
+)
+
+// The ways to isolate the routes of the attachments in pod, see PluginConf.Isolation
+const (
+	// the rules like: ip rule add from <ip> lookup <table>, and the routes are moved to the table
+	IsolationRule = "rule"
+	// the underlay attachment is enslaved to a vrf device of the table with its routes
+	IsolationVRF = "vrf"
+	// the rules above, and the connections are marked by the interface they arrive on, so their replies lookup
+	// the table of the interface by: ip rule add fwmark <mark>/<mask> lookup <table>
+	IsolationFwmark = "fwmark"
+	// the prefix of the vrf device of an attachment, such as vrf-net1
+	VRFPrefix = "vrf-"
+)
+
+// The fwmark isolation, the mark of a table is <table> << 16 in the bits of FwmarkMask, such as 0x640000 for table 100,
+// the mask has the 16 bits of a table id, so the tables of netN above 255 don't collide
+const (
+	FwmarkMask         = 0xffff0000
+	FwmarkShift        = 16
+	FwmarkRulePriority = 95
+	// the nftables table in pod which has the chains of the marks
+	FwmarkNftTable = "meta-plugins"
+	// the nftables command, which must be installed on the node for the fwmark isolation
+	NftCommand = "nft"
+)
+
+// The paths of the hijack routes besides the name of an interface, see types.HijackRoute
+const (
+	HijackViaOverlay  = "overlay"
+	HijackViaUnderlay = "underlay"
+)
@@ -10,38 +10,37 @@ import (
 	"time"
 )
 
-func IPCheckingByARP(ifi *net.Interface, targetIP netip.Addr, retry int, interval time.Duration) error {
+// IPCheckingByARP sends the gratuitous arp of targetIP and returns error if any reply is received,
+// the checking is stopped once ctx is done.
+func IPCheckingByARP(ctx context.Context, ifi *net.Interface, targetIP netip.Addr, retry int, interval time.Duration) error {
 	client, err := arp.Dial(ifi)
 	if err != nil {
 		return err
 	}
+	defer client.Close()
 
-	ctx, cancel := context.WithCancel(context.Background())
+	checkingCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	var conflictingMac string
+	received := make(chan struct{})
 	// start a goroutine to receive arp response
 	go func() {
-		var packet *arp.Packet
+		defer close(received)
 		for {
-			select {
-			case <-ctx.Done():
+			packet, _, err := client.Read()
+			if err != nil {
+				cancel()
 				return
-			default:
-				packet, _, err = client.Read()
-				if err != nil {
+			}
+
+			if packet.Operation == arp.OperationReply {
+				// found reply and simple check if the reply packet is we want.
+				if packet.SenderIP.Compare(targetIP) == 0 {
+					conflictingMac = packet.SenderHardwareAddr.String()
 					cancel()
 					return
 				}
-
-				if packet.Operation == arp.OperationReply {
-					// found reply and simple check if the reply packet is we want.
-					if packet.SenderIP.Compare(targetIP) == 0 {
-						conflictingMac = packet.SenderHardwareAddr.String()
-						cancel()
-						return
-					}
-				}
 			}
 		}
 	}()
@@ -51,7 +50,6 @@ func IPCheckingByARP(ifi *net.Interface, targetIP netip.Addr, retry int, interva
 	// we set source ip to 0.0.0.0
 	packet, err := arp.NewPacket(arp.OperationRequest, ifi.HardwareAddr, netip.MustParseAddr("0.0.0.0"), ethernet.Broadcast, targetIP)
 	if err != nil {
-		cancel()
 		return err
 	}
 
@@ -61,7 +59,7 @@ func IPCheckingByARP(ifi *net.Interface, targetIP netip.Addr, retry int, interva
 	stop := false
 	for i := 0; i < retry && !stop; i++ {
 		select {
-		case <-ctx.Done():
+		case <-checkingCtx.Done():
 			stop = true
 		case <-ticker.C:
 			err = client.WriteTo(packet, ethernet.Broadcast)
@@ -71,9 +69,10 @@ func IPCheckingByARP(ifi *net.Interface, targetIP netip.Addr, retry int, interva
 		}
 	}
 
-	if err != nil {
-		return fmt.Errorf("failed to checking ip %s if it's conflicting: %v", targetIP.String(), err)
-	}
+	// unblock client.Read() and wait for the receiving goroutine
+	cancel()
+	_ = client.SetReadDeadline(time.Now())
+	<-received
 
 	if conflictingMac != "" {
 		// found ip conflicting
@@ -81,5 +80,12 @@ func IPCheckingByARP(ifi *net.Interface, targetIP netip.Addr, retry int, interva
 			targetIP.String(), targetIP.String(), conflictingMac)
 	}
 
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to checking ip %s if it's conflicting: %w", targetIP.String(), err)
+	}
+
 	return nil
 }
@@ -1,6 +1,7 @@
 package ipchecking
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/mdlayher/ndp"
@@ -13,7 +14,9 @@ import (
 
 var errRetry = errors.New("retry")
 
-func IPCheckingByNDP(ifi *net.Interface, target netip.Addr, retry int, interval time.Duration) error {
+// IPCheckingByNDP sends the neighbor solicitation of target and returns error if any other one replies,
+// the checking is stopped once ctx is done.
+func IPCheckingByNDP(ctx context.Context, ifi *net.Interface, target netip.Addr, retry int, interval time.Duration) error {
 	client, _, err := ndp.Listen(ifi, ndp.LinkLocal)
 	if err != nil {
 		return err
@@ -31,7 +34,7 @@ func IPCheckingByNDP(ifi *net.Interface, target netip.Addr, retry int, interval
 	}
 
 	var replyMac string
-	replyMac, err = sendReceiveLoop(retry, interval, client, m, target)
+	replyMac, err = sendReceiveLoop(ctx, retry, interval, client, m, target)
 	switch err {
 	case constant.NDPFoundReply:
 		if replyMac != ifi.HardwareAddr.String() {
@@ -41,17 +44,20 @@ func IPCheckingByNDP(ifi *net.Interface, target netip.Addr, retry int, interval
 	case constant.NDPRetryError:
 		return constant.NDPRetryError
 	default:
-		return fmt.Errorf("failed to checking ip conflicting: %v", err)
+		return fmt.Errorf("failed to checking ip conflicting: %w", err)
 	}
 
 	return nil
 }
 
-func sendReceiveLoop(retry int, interval time.Duration, client *ndp.Conn, msg ndp.Message, dst netip.Addr) (string, error) {
+func sendReceiveLoop(ctx context.Context, retry int, interval time.Duration, client *ndp.Conn, msg ndp.Message, dst netip.Addr) (string, error) {
 	var hwAddr string
 	var err error
 	for i := 0; i < retry; i++ {
-		hwAddr, err = sendReceive(client, msg, dst, interval)
+		if err = ctx.Err(); err != nil {
+			return "", err
+		}
+		hwAddr, err = sendReceive(ctx, client, msg, dst, interval)
 		switch err {
 		case errRetry:
 			continue
@@ -65,22 +71,27 @@ func sendReceiveLoop(retry int, interval time.Duration, client *ndp.Conn, msg nd
 	return "", constant.NDPRetryError
 }
 
-func sendReceive(client *ndp.Conn, m ndp.Message, target netip.Addr, interval time.Duration) (string, error) {
+func sendReceive(ctx context.Context, client *ndp.Conn, m ndp.Message, target netip.Addr, interval time.Duration) (string, error) {
 	// Always multicast the message to the target's solicited-node multicast
 	// group as if we have no knowledge of its MAC address.
 	snm, err := ndp.SolicitedNodeMulticast(target)
 	if err != nil {
-		return "", fmt.Errorf("failed to determine solicited-node multicast address: %v", err)
+		return "", fmt.Errorf("failed to determine solicited-node multicast address: %w", err)
 	}
 
 	// we send a gratuitous neighbor solicitation to checking if ip is conflict
 	err = client.WriteTo(m, nil, snm)
 	if err != nil {
-		return "", fmt.Errorf("failed to send message: %v", err)
+		return "", fmt.Errorf("failed to send message: %w", err)
 	}
 
-	if err := client.SetReadDeadline(time.Now().Add(interval)); err != nil {
-		return "", fmt.Errorf("failed to set deadline: %v", err)
+	// never wait for the reply past the deadline of ctx
+	readDeadline := time.Now().Add(interval)
+	if deadline, ok := ctx.Deadline(); ok && deadline.Before(readDeadline) {
+		readDeadline = deadline
+	}
+	if err := client.SetReadDeadline(readDeadline); err != nil {
+		return "", fmt.Errorf("failed to set deadline: %w", err)
 	}
 
 	msg, _, _, err := client.ReadFrom()
@@ -99,8 +110,11 @@ func sendReceive(client *ndp.Conn, m ndp.Message, target netip.Addr, interval ti
 
 	// Was the error caused by a read timeout, and should the loop continue?
 	if neterr, ok := err.(net.Error); ok && neterr.Timeout() {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
 		return "", errRetry
 	}
 
-	return "", fmt.Errorf("failed to read message: %v", err)
+	return "", fmt.Errorf("failed to read message: %w", err)
 }
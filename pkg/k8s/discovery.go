@@ -0,0 +1,317 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spidernet-io/cni-plugins/pkg/config"
+	"github.com/spidernet-io/cni-plugins/pkg/constant"
+	ty "github.com/spidernet-io/cni-plugins/pkg/types"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	CalicoIPPoolListGVK      = schema.GroupVersionKind{Group: "crd.projectcalico.org", Version: "v1", Kind: "IPPoolList"}
+	CiliumNodeListGVK        = schema.GroupVersionKind{Group: "cilium.io", Version: "v2", Kind: "CiliumNodeList"}
+	kubeadmConfigMap         = client.ObjectKey{Namespace: "kube-system", Name: "kubeadm-config"}
+	serviceClusterIPRangeArg = "--service-cluster-ip-range="
+)
+
+// DiscoverHijackSubnets fills the overlay and service subnets which are not given by the network config with the ones
+// discovered from kubernetes, if auto_discover is enabled
+func DiscoverHijackSubnets(ctx context.Context, logger *zap.Logger, c *Client, conf *config.PluginConf) error {
+	if conf.AutoDiscovery == nil || !conf.AutoDiscovery.Enabled {
+		return nil
+	}
+	if len(conf.OverlayHijackSubnet) != 0 && len(conf.ServiceHijackSubnet) != 0 {
+		return nil
+	}
+
+	discovered, err := LookupHijackSubnets(ctx, logger, c, conf.AutoDiscovery, conf.DryRun)
+	if err != nil {
+		return fmt.Errorf("failed to discover hijack subnets: %w", err)
+	}
+
+	if len(conf.OverlayHijackSubnet) == 0 {
+		if conf.OverlayHijackSubnet, err = config.ValidateOverlaySubnets(discovered.OverlaySubnet); err != nil {
+			return fmt.Errorf("invalid discovered overlay subnets: %w", err)
+		}
+		if len(conf.OverlayHijackSubnet) == 0 {
+			return fmt.Errorf("no overlay subnet is discovered from %v, overlay_hijack_subnet must be given", conf.AutoDiscovery.OverlaySources)
+		}
+	}
+	if len(conf.ServiceHijackSubnet) == 0 {
+		if conf.ServiceHijackSubnet, err = config.ValidateSubnets(discovered.ServiceSubnet); err != nil {
+			return fmt.Errorf("invalid discovered service subnets: %w", err)
+		}
+		if len(conf.ServiceHijackSubnet) == 0 {
+			return fmt.Errorf("no service subnet is discovered, service_hijack_subnet must be given")
+		}
+	}
+
+	logger.Info("Use the discovered hijack subnets", zap.Strings("overlay_hijack_subnet", conf.OverlayHijackSubnet),
+		zap.Strings("service_hijack_subnet", conf.ServiceHijackSubnet))
+	return nil
+}
+
+// LookupHijackSubnets returns the overlay and service subnets discovered from kubernetes.
+// they are read from the cache on the node firstly, and discovered from kubernetes again if
+// the cache is missing or expired. the expired cache is still used if the discovery fails.
+// the discovery is bounded by the timeout of kubernetes and the deadline of ctx, which is the one of invocation.
+// the cache is never written if readOnly, such as in dry-run mode.
+func LookupHijackSubnets(ctx context.Context, logger *zap.Logger, c *Client, discovery *ty.AutoDiscovery, readOnly bool) (*ty.DiscoveredSubnets, error) {
+	ttl, err := time.ParseDuration(discovery.CacheTTL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cache_ttl %s: %v", discovery.CacheTTL, err)
+	}
+
+	cached, err := readDiscoveryCache(discovery.CacheFile)
+	if err != nil {
+		logger.Warn("failed to read the cache of discovered subnets, ignore it", zap.String("cache", discovery.CacheFile), zap.Error(err))
+	}
+	// the cache may be written by another network config, whose subnets are discovered from other sources
+	if cached != nil && !sameDiscoveryInputs(cached, c.Kubeconfig(), discovery) {
+		logger.Debug("The cache of discovered subnets is discovered by other inputs, ignore it", zap.Any("subnets", cached))
+		cached = nil
+	}
+	if cached != nil && time.Since(cached.Timestamp) < ttl {
+		logger.Debug("Use the cache of discovered subnets", zap.Any("subnets", cached))
+		return cached, nil
+	}
+
+	subnets, err := discoverSubnets(ctx, c, discovery)
+	if err != nil {
+		if cached != nil {
+			logger.Warn("failed to discover subnets from kubernetes, use the expired cache", zap.Any("subnets", cached), zap.Error(err))
+			return cached, nil
+		}
+		return nil, err
+	}
+	logger.Info("Succeed to discover subnets from kubernetes", zap.Any("subnets", subnets))
+
+	subnets.OverlaySources = sortedCopy(discovery.OverlaySources)
+	subnets.Kubeconfig = c.Kubeconfig()
+
+	if readOnly {
+		return subnets, nil
+	}
+	if err = writeDiscoveryCache(discovery.CacheFile, subnets); err != nil {
+		logger.Warn("failed to write the cache of discovered subnets", zap.String("cache", discovery.CacheFile), zap.Error(err))
+	}
+	return subnets, nil
+}
+
+func discoverSubnets(ctx context.Context, c *Client, discovery *ty.AutoDiscovery) (*ty.DiscoveredSubnets, error) {
+	kc, timeout, err := c.get()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return DiscoverSubnets(ctx, kc, discovery.OverlaySources)
+}
+
+// DiscoverSubnets discovers the overlay subnets from the given sources and the service subnets of the cluster
+func DiscoverSubnets(ctx context.Context, c client.Client, overlaySources []string) (*ty.DiscoveredSubnets, error) {
+	overlaySubnet, err := DiscoverOverlaySubnets(ctx, c, overlaySources)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceSubnet, err := DiscoverServiceSubnets(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ty.DiscoveredSubnets{
+		OverlaySubnet: overlaySubnet,
+		ServiceSubnet: serviceSubnet,
+		Timestamp:     time.Now(),
+	}, nil
+}
+
+// DiscoverOverlaySubnets returns the subnets of calico IPPool or cilium CiliumNode.
+// the source is ignored if its CRD is not installed.
+func DiscoverOverlaySubnets(ctx context.Context, c client.Client, sources []string) ([]string, error) {
+	var subnets []string
+	for _, source := range sources {
+		var gvk schema.GroupVersionKind
+		var fields [][]string
+		switch source {
+		case constant.OverlaySourceCalico:
+			gvk, fields = CalicoIPPoolListGVK, [][]string{{"spec", "cidr"}}
+		case constant.OverlaySourceCilium:
+			gvk, fields = CiliumNodeListGVK, [][]string{{"spec", "ipam", "podCIDRs"}}
+		default:
+			return nil, fmt.Errorf("unknown overlay source %s", source)
+		}
+
+		items, err := listUnstructured(ctx, c, gvk)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range items {
+			// the disabled calico IPPool don't allocate ip
+			if disabled, _, _ := unstructured.NestedBool(item.Object, "spec", "disabled"); disabled {
+				continue
+			}
+			for _, field := range fields {
+				if value, found, _ := unstructured.NestedString(item.Object, field...); found {
+					subnets = append(subnets, value)
+				}
+				if values, found, _ := unstructured.NestedStringSlice(item.Object, field...); found {
+					subnets = append(subnets, values...)
+				}
+			}
+		}
+	}
+	return uniqueSubnets(subnets), nil
+}
+
+// DiscoverServiceSubnets returns the service subnets from the kubeadm ClusterConfiguration,
+// or the '--service-cluster-ip-range' of kube-apiserver/kube-controller-manager.
+func DiscoverServiceSubnets(ctx context.Context, c client.Client) ([]string, error) {
+	cm := &corev1.ConfigMap{}
+	err := c.Get(ctx, kubeadmConfigMap, cm)
+	if err == nil {
+		clusterConfig := struct {
+			Networking struct {
+				ServiceSubnet string `json:"serviceSubnet"`
+			} `json:"networking"`
+		}{}
+		if err = yaml.Unmarshal([]byte(cm.Data["ClusterConfiguration"]), &clusterConfig); err != nil {
+			return nil, fmt.Errorf("failed to parse ClusterConfiguration of %s: %v", kubeadmConfigMap, err)
+		}
+		if clusterConfig.Networking.ServiceSubnet != "" {
+			return uniqueSubnets(strings.Split(clusterConfig.Networking.ServiceSubnet, ",")), nil
+		}
+	} else if client.IgnoreNotFound(err) != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", kubeadmConfigMap, err)
+	}
+
+	for _, component := range []string{"kube-apiserver", "kube-controller-manager"} {
+		pods := &corev1.PodList{}
+		if err = c.List(ctx, pods, client.InNamespace("kube-system"), client.MatchingLabels{"component": component}); err != nil {
+			return nil, fmt.Errorf("failed to list pods of %s: %w", component, err)
+		}
+		for _, pod := range pods.Items {
+			for _, container := range pod.Spec.Containers {
+				for _, arg := range append(container.Command, container.Args...) {
+					if strings.HasPrefix(arg, serviceClusterIPRangeArg) {
+						return uniqueSubnets(strings.Split(strings.TrimPrefix(arg, serviceClusterIPRangeArg), ",")), nil
+					}
+				}
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("failed to discover the service subnet from kubeadm-config or the args of kube-apiserver/kube-controller-manager")
+}
+
+// listUnstructured lists the objects of given kind, returns nothing if the CRD is not installed
+func listUnstructured(ctx context.Context, c client.Client, gvk schema.GroupVersionKind) ([]unstructured.Unstructured, error) {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(gvk)
+	if err := c.List(ctx, list); err != nil {
+		if meta.IsNoMatchError(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list %s: %w", gvk.Kind, err)
+	}
+	return list.Items, nil
+}
+
+func uniqueSubnets(subnets []string) []string {
+	result := make([]string, 0, len(subnets))
+	for _, subnet := range subnets {
+		subnet = strings.TrimSpace(subnet)
+		if subnet == "" {
+			continue
+		}
+		found := false
+		for _, s := range result {
+			if s == subnet {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, subnet)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// sameDiscoveryInputs returns whether the cache is discovered by the same kubeconfig and overlay sources,
+// the service subnets are always discovered in the same way
+func sameDiscoveryInputs(cached *ty.DiscoveredSubnets, kubeconfig string, discovery *ty.AutoDiscovery) bool {
+	if cached.Kubeconfig != kubeconfig {
+		return false
+	}
+	return strings.Join(sortedCopy(cached.OverlaySources), ",") == strings.Join(sortedCopy(discovery.OverlaySources), ",")
+}
+
+func sortedCopy(items []string) []string {
+	sorted := append([]string{}, items...)
+	sort.Strings(sorted)
+	return sorted
+}
+
+func readDiscoveryCache(file string) (*ty.DiscoveredSubnets, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	subnets := &ty.DiscoveredSubnets{}
+	if err = json.Unmarshal(data, subnets); err != nil {
+		return nil, err
+	}
+	return subnets, nil
+}
+
+// writeDiscoveryCache writes the cache by renaming a temp file, so that concurrent plugin calls
+// never read a partial file.
+func writeDiscoveryCache(file string, subnets *ty.DiscoveredSubnets) error {
+	data, err := json.Marshal(subnets)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
@@ -0,0 +1,279 @@
+package k8s_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spidernet-io/cni-plugins/pkg/config"
+	"github.com/spidernet-io/cni-plugins/pkg/constant"
+	"github.com/spidernet-io/cni-plugins/pkg/k8s"
+	"github.com/spidernet-io/cni-plugins/pkg/schema"
+	ty "github.com/spidernet-io/cni-plugins/pkg/types"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sschema "k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// crdScheme registers the given CRDs as unstructured, just like they are installed
+func crdScheme(listGVKs ...k8sschema.GroupVersionKind) *runtime.Scheme {
+	s := runtime.NewScheme()
+	Expect(corev1.AddToScheme(s)).NotTo(HaveOccurred())
+	for _, gvk := range listGVKs {
+		s.AddKnownTypeWithName(gvk.GroupVersion().WithKind(gvk.Kind[:len(gvk.Kind)-len("List")]), &unstructured.Unstructured{})
+		s.AddKnownTypeWithName(gvk, &unstructured.UnstructuredList{})
+	}
+	return s
+}
+
+func crdObject(listGVK k8sschema.GroupVersionKind, name string, spec map[string]interface{}) client.Object {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	obj.SetGroupVersionKind(listGVK.GroupVersion().WithKind(listGVK.Kind[:len(listGVK.Kind)-len("List")]))
+	obj.SetName(name)
+	return obj
+}
+
+var _ = Describe("K8s", func() {
+	Context("Test PodOverrides", func() {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "default",
+				Name:      "test",
+				Annotations: map[string]string{
+					constant.AnnotationSkip:          "true",
+					constant.AnnotationHijackSubnets: "10.6.0.0/16,fd00:10:6::/64",
+					constant.AnnotationRPFilter:      "1",
+					"kubernetes.io/description":      "not overrides",
+				},
+			},
+		}
+
+		It("get overrides from annotations of pod", func() {
+			c := fake.NewClientBuilder().WithScheme(schema.Scheme).WithObjects(pod).Build()
+			got, err := k8s.PodOverrides(context.TODO(), c, "default", "test")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got).To(Equal(map[string]string{
+				constant.OverrideSkipCall:               "true",
+				constant.OverrideAdditionalHijackSubnet: "10.6.0.0/16,fd00:10:6::/64",
+				constant.OverrideRPFilterValue:          "1",
+			}))
+		})
+
+		It("pod without annotations", func() {
+			c := fake.NewClientBuilder().WithScheme(schema.Scheme).WithObjects(&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "plain"},
+			}).Build()
+			got, err := k8s.PodOverrides(context.TODO(), c, "default", "plain")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got).To(BeEmpty())
+		})
+
+		It("pod not found return err", func() {
+			c := fake.NewClientBuilder().WithScheme(schema.Scheme).Build()
+			_, err := k8s.PodOverrides(context.TODO(), c, "default", "test")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Test LookupPodOverrides", func() {
+		It("disabled if no kubeconfig given", func() {
+			got, err := k8s.LookupPodOverrides(context.TODO(), zap.NewNop(), k8s.NewInvocationClient(&ty.Kubernetes{}), "default", "test")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got).To(BeNil())
+		})
+
+		It("no pod given by CNI_ARGS", func() {
+			got, err := k8s.LookupPodOverrides(context.TODO(), zap.NewNop(), k8s.NewInvocationClient(&ty.Kubernetes{Kubeconfig: "/not/exist", Timeout: "1s"}), "", "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got).To(BeNil())
+		})
+
+		It("failed to load kubeconfig return err", func() {
+			_, err := k8s.LookupPodOverrides(context.TODO(), zap.NewNop(), k8s.NewInvocationClient(&ty.Kubernetes{Kubeconfig: "/not/exist", Timeout: "1s"}), "default", "test")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("fail open", func() {
+			got, err := k8s.LookupPodOverrides(context.TODO(), zap.NewNop(), k8s.NewInvocationClient(&ty.Kubernetes{Kubeconfig: "/not/exist", Timeout: "1s", FailOpen: true}), "default", "test")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got).To(BeNil())
+		})
+
+		It("not fail open if the invocation is timed out", func() {
+			ctx, cancel := context.WithCancel(context.TODO())
+			cancel()
+			_, err := k8s.LookupPodOverrides(ctx, zap.NewNop(), k8s.NewInvocationClient(&ty.Kubernetes{Kubeconfig: "/not/exist", Timeout: "1s", FailOpen: true}), "default", "test")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Test DiscoverOverlaySubnets", func() {
+		It("discover from calico and cilium", func() {
+			c := fake.NewClientBuilder().WithScheme(crdScheme(k8s.CalicoIPPoolListGVK, k8s.CiliumNodeListGVK)).WithObjects(
+				crdObject(k8s.CalicoIPPoolListGVK, "default-ipv4-ippool", map[string]interface{}{"cidr": "10.244.0.0/16"}),
+				crdObject(k8s.CalicoIPPoolListGVK, "default-ipv6-ippool", map[string]interface{}{"cidr": "fd00:10:244::/64"}),
+				crdObject(k8s.CalicoIPPoolListGVK, "disabled-ippool", map[string]interface{}{"cidr": "10.245.0.0/16", "disabled": true}),
+				crdObject(k8s.CiliumNodeListGVK, "node1", map[string]interface{}{"ipam": map[string]interface{}{"podCIDRs": []interface{}{"10.0.1.0/24"}}}),
+				crdObject(k8s.CiliumNodeListGVK, "node2", map[string]interface{}{"ipam": map[string]interface{}{"podCIDRs": []interface{}{"10.0.2.0/24"}}}),
+			).Build()
+			got, err := k8s.DiscoverOverlaySubnets(context.TODO(), c, constant.DefaultOverlaySources)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got).To(Equal([]string{"10.0.1.0/24", "10.0.2.0/24", "10.244.0.0/16", "fd00:10:244::/64"}))
+		})
+
+		It("ignore the CRD which is not installed", func() {
+			c := fake.NewClientBuilder().WithScheme(crdScheme()).Build()
+			got, err := k8s.DiscoverOverlaySubnets(context.TODO(), c, constant.DefaultOverlaySources)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got).To(BeEmpty())
+		})
+
+		It("unknown source return err", func() {
+			c := fake.NewClientBuilder().WithScheme(crdScheme()).Build()
+			_, err := k8s.DiscoverOverlaySubnets(context.TODO(), c, []string{"flannel"})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Test DiscoverServiceSubnets", func() {
+		It("discover from kubeadm-config", func() {
+			c := fake.NewClientBuilder().WithScheme(schema.Scheme).WithObjects(&corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "kubeadm-config"},
+				Data: map[string]string{"ClusterConfiguration": `apiVersion: kubeadm.k8s.io/v1beta3
+kind: ClusterConfiguration
+networking:
+  dnsDomain: cluster.local
+  podSubnet: 10.244.0.0/16,fd00:10:244::/56
+  serviceSubnet: 10.96.0.0/12,fd00:10:96::/112
+`},
+			}).Build()
+			got, err := k8s.DiscoverServiceSubnets(context.TODO(), c)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got).To(Equal([]string{"10.96.0.0/12", "fd00:10:96::/112"}))
+		})
+
+		It("discover from the args of kube-apiserver", func() {
+			c := fake.NewClientBuilder().WithScheme(schema.Scheme).WithObjects(&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "kube-apiserver-master", Labels: map[string]string{"component": "kube-apiserver"}},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{
+					Name:    "kube-apiserver",
+					Command: []string{"kube-apiserver", "--secure-port=6443", "--service-cluster-ip-range=10.233.0.0/18"},
+				}}},
+			}).Build()
+			got, err := k8s.DiscoverServiceSubnets(context.TODO(), c)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got).To(Equal([]string{"10.233.0.0/18"}))
+		})
+
+		It("nothing found return err", func() {
+			c := fake.NewClientBuilder().WithScheme(schema.Scheme).Build()
+			_, err := k8s.DiscoverServiceSubnets(context.TODO(), c)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Test LookupHijackSubnets", func() {
+		var cacheFile string
+		BeforeEach(func() {
+			cacheFile = filepath.Join(GinkgoT().TempDir(), "discovered-subnets.json")
+		})
+
+		It("use the valid cache", func() {
+			Expect(os.WriteFile(cacheFile, []byte(`{"overlay_subnet":["10.244.0.0/16"],"service_subnet":["10.96.0.0/12"],`+
+				`"overlay_sources":["calico","cilium"],"kubeconfig":"/not/exist","timestamp":"`+time.Now().Format(time.RFC3339)+`"}`), 0644)).NotTo(HaveOccurred())
+			got, err := k8s.LookupHijackSubnets(context.TODO(), zap.NewNop(), k8s.NewInvocationClient(&ty.Kubernetes{Kubeconfig: "/not/exist", Timeout: "1s"}),
+				&ty.AutoDiscovery{CacheFile: cacheFile, CacheTTL: "10m", OverlaySources: []string{"cilium", "calico"}}, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got.OverlaySubnet).To(Equal([]string{"10.244.0.0/16"}))
+			Expect(got.ServiceSubnet).To(Equal([]string{"10.96.0.0/12"}))
+		})
+
+		It("use the expired cache if failed to discover", func() {
+			Expect(os.WriteFile(cacheFile, []byte(`{"overlay_subnet":["10.244.0.0/16"],"service_subnet":["10.96.0.0/12"],`+
+				`"overlay_sources":["calico"],"kubeconfig":"/not/exist","timestamp":"2023-01-01T00:00:00Z"}`), 0644)).NotTo(HaveOccurred())
+			got, err := k8s.LookupHijackSubnets(context.TODO(), zap.NewNop(), k8s.NewInvocationClient(&ty.Kubernetes{Kubeconfig: "/not/exist", Timeout: "1s"}),
+				&ty.AutoDiscovery{CacheFile: cacheFile, CacheTTL: "10m", OverlaySources: []string{"calico"}}, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got.OverlaySubnet).To(Equal([]string{"10.244.0.0/16"}))
+		})
+
+		It("the cache discovered by other inputs is never used", func() {
+			Expect(os.WriteFile(cacheFile, []byte(`{"overlay_subnet":["10.244.0.0/16"],"service_subnet":["10.96.0.0/12"],`+
+				`"overlay_sources":["calico"],"kubeconfig":"/not/exist","timestamp":"`+time.Now().Format(time.RFC3339)+`"}`), 0644)).NotTo(HaveOccurred())
+			_, err := k8s.LookupHijackSubnets(context.TODO(), zap.NewNop(), k8s.NewInvocationClient(&ty.Kubernetes{Kubeconfig: "/not/exist", Timeout: "1s"}),
+				&ty.AutoDiscovery{CacheFile: cacheFile, CacheTTL: "10m", OverlaySources: []string{"cilium"}}, false)
+			Expect(err).To(HaveOccurred(), "neither as the valid cache nor as the expired one")
+
+			_, err = k8s.LookupHijackSubnets(context.TODO(), zap.NewNop(), k8s.NewInvocationClient(&ty.Kubernetes{Kubeconfig: "/other/kubeconfig", Timeout: "1s"}),
+				&ty.AutoDiscovery{CacheFile: cacheFile, CacheTTL: "10m", OverlaySources: []string{"calico"}}, false)
+			Expect(err).To(HaveOccurred())
+
+			Expect(os.WriteFile(cacheFile, []byte(`{"overlay_subnet":["10.244.0.0/16"],"service_subnet":["10.96.0.0/12"],"timestamp":"`+
+				time.Now().Format(time.RFC3339)+`"}`), 0644)).NotTo(HaveOccurred())
+			_, err = k8s.LookupHijackSubnets(context.TODO(), zap.NewNop(), k8s.NewInvocationClient(&ty.Kubernetes{Kubeconfig: "/not/exist", Timeout: "1s"}),
+				&ty.AutoDiscovery{CacheFile: cacheFile, CacheTTL: "10m", OverlaySources: []string{"calico"}}, false)
+			Expect(err).To(HaveOccurred(), "the cache written before the inputs are recorded")
+		})
+
+		It("no cache and failed to discover return err", func() {
+			_, err := k8s.LookupHijackSubnets(context.TODO(), zap.NewNop(), k8s.NewInvocationClient(&ty.Kubernetes{Kubeconfig: "/not/exist", Timeout: "1s"}),
+				&ty.AutoDiscovery{CacheFile: cacheFile, CacheTTL: "10m"}, false)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Test DiscoverHijackSubnets", func() {
+		var cacheFile string
+		var kc *k8s.Client
+		BeforeEach(func() {
+			cacheFile = filepath.Join(GinkgoT().TempDir(), "discovered-subnets.json")
+			kc = k8s.NewInvocationClient(&ty.Kubernetes{Kubeconfig: "/not/exist", Timeout: "1s"})
+		})
+
+		writeCache := func(overlay, service string) {
+			Expect(os.WriteFile(cacheFile, []byte(`{"overlay_subnet":[`+overlay+`],"service_subnet":[`+service+`],`+
+				`"overlay_sources":["calico"],"kubeconfig":"/not/exist","timestamp":"`+time.Now().Format(time.RFC3339)+`"}`), 0644)).NotTo(HaveOccurred())
+		}
+
+		It("fill the subnets which are not given", func() {
+			writeCache(`" 10.244.0.0/16"`, `"10.96.0.0/12"`)
+			conf := &config.PluginConf{
+				ServiceHijackSubnet: []string{"10.233.0.0/18"},
+				AutoDiscovery:       &ty.AutoDiscovery{Enabled: true, CacheFile: cacheFile, CacheTTL: "10m", OverlaySources: []string{"calico"}},
+			}
+			Expect(k8s.DiscoverHijackSubnets(context.TODO(), zap.NewNop(), kc, conf)).To(Succeed())
+			Expect(conf.OverlayHijackSubnet).To(Equal([]string{"10.244.0.0/16"}))
+			Expect(conf.ServiceHijackSubnet).To(Equal([]string{"10.233.0.0/18"}))
+		})
+
+		It("nothing discovered return err", func() {
+			writeCache(``, `"10.96.0.0/12"`)
+			conf := &config.PluginConf{
+				AutoDiscovery: &ty.AutoDiscovery{Enabled: true, CacheFile: cacheFile, CacheTTL: "10m", OverlaySources: []string{"calico"}},
+			}
+			Expect(k8s.DiscoverHijackSubnets(context.TODO(), zap.NewNop(), kc, conf)).To(MatchError(ContainSubstring("no overlay subnet is discovered")))
+		})
+
+		It("invalid discovered subnets return err", func() {
+			writeCache(`"10.244.0.0/16"`, `"10.96.0.0"`)
+			conf := &config.PluginConf{
+				AutoDiscovery: &ty.AutoDiscovery{Enabled: true, CacheFile: cacheFile, CacheTTL: "10m", OverlaySources: []string{"calico"}},
+			}
+			Expect(k8s.DiscoverHijackSubnets(context.TODO(), zap.NewNop(), kc, conf)).To(MatchError(ContainSubstring("invalid discovered service subnets")))
+		})
+
+		It("disabled", func() {
+			conf := &config.PluginConf{}
+			Expect(k8s.DiscoverHijackSubnets(context.TODO(), zap.NewNop(), kc, conf)).To(Succeed())
+			Expect(conf.OverlayHijackSubnet).To(BeEmpty())
+		})
+	})
+})
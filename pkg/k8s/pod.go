@@ -0,0 +1,125 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/spidernet-io/cni-plugins/pkg/constant"
+	"github.com/spidernet-io/cni-plugins/pkg/schema"
+	ty "github.com/spidernet-io/cni-plugins/pkg/types"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// NewClient returns the kubernetes client from given kubeconfig
+func NewClient(kubeconfig string, timeout time.Duration) (client.Client, error) {
+	cfg, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig %s: %v", kubeconfig, err)
+	}
+	cfg.Timeout = timeout
+
+	c, err := client.New(cfg, client.Options{Scheme: schema.Scheme})
+	if err != nil {
+		return nil, fmt.Errorf("failed to new kubernetes client: %v", err)
+	}
+	return c, nil
+}
+
+// Client is the kubernetes client of an invocation, it's created on the first lookup and shared by the others
+// of the invocation, such as the lookups of the overrides of pod and the hijack subnets
+type Client struct {
+	config *ty.Kubernetes
+
+	once    sync.Once
+	client  client.Client
+	timeout time.Duration
+	err     error
+}
+
+// NewInvocationClient returns the kubernetes client of an invocation from the kubernetes config of network
+func NewInvocationClient(config *ty.Kubernetes) *Client {
+	return &Client{config: config}
+}
+
+// Kubeconfig returns the kubeconfig of the client, empty if it isn't given
+func (c *Client) Kubeconfig() string {
+	if c.config == nil {
+		return ""
+	}
+	return c.config.Kubeconfig
+}
+
+// get returns the kubernetes client and the timeout of a lookup
+func (c *Client) get() (client.Client, time.Duration, error) {
+	c.once.Do(func() {
+		if c.Kubeconfig() == "" {
+			c.err = fmt.Errorf("kubernetes.kubeconfig must be given")
+			return
+		}
+		if c.timeout, c.err = time.ParseDuration(c.config.Timeout); c.err != nil {
+			c.err = fmt.Errorf("invalid timeout %s: %v", c.config.Timeout, c.err)
+			return
+		}
+		c.client, c.err = NewClient(c.config.Kubeconfig, c.timeout)
+	})
+	return c.client, c.timeout, c.err
+}
+
+// PodOverrides returns the overrides from the annotations of given pod,
+// the key of result is the key of overrides, such as: skip_call.
+func PodOverrides(ctx context.Context, c client.Client, namespace, name string) (map[string]string, error) {
+	pod := &corev1.Pod{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, pod); err != nil {
+		return nil, fmt.Errorf("failed to get pod %s/%s: %w", namespace, name, err)
+	}
+
+	overrides := make(map[string]string)
+	for annotation, key := range constant.AnnotationOverrideKeys {
+		if value, ok := pod.Annotations[annotation]; ok {
+			overrides[key] = value
+		}
+	}
+	return overrides, nil
+}
+
+// LookupPodOverrides looks up the pod from kubernetes and returns the overrides from its annotations.
+// the lookup is bounded by the timeout of kubernetes and the deadline of ctx, which is the one of invocation.
+// if fail_open is true, the failure is ignored and return nothing, unless the invocation is timed out.
+func LookupPodOverrides(ctx context.Context, logger *zap.Logger, c *Client, namespace, name string) (map[string]string, error) {
+	if c.Kubeconfig() == "" {
+		return nil, nil
+	}
+
+	if namespace == "" || name == "" {
+		logger.Debug("No pod given by CNI_ARGS, ignore looking up the annotations of pod")
+		return nil, nil
+	}
+
+	overrides, err := lookupPodOverrides(ctx, c, namespace, name)
+	if err != nil {
+		if c.config.FailOpen && ctx.Err() == nil {
+			logger.Warn("failed to look up the annotations of pod, fail open and go on", zap.Error(err))
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	logger.Debug("Succeed to look up the annotations of pod", zap.Any("overrides", overrides))
+	return overrides, nil
+}
+
+func lookupPodOverrides(ctx context.Context, c *Client, namespace, name string) (map[string]string, error) {
+	kc, timeout, err := c.get()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return PodOverrides(ctx, kc, namespace, name)
+}
@@ -0,0 +1,57 @@
+// Package lock serializes the changes of host network made by the concurrent plugin invocations on a node.
+package lock
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// the interval of retrying to acquire a lock held by another invocation
+var retryInterval = 10 * time.Millisecond
+
+// FileLock is the exclusive flock of a file, it's shared by all the processes on the node which lock the same file
+type FileLock struct {
+	file *os.File
+}
+
+// Lock acquires the exclusive lock of the given file, the file is created if it doesn't exist.
+// it waits until the lock is released by others or ctx is done, and returns how long it waited.
+func Lock(ctx context.Context, path string) (*FileLock, time.Duration, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, 0, fmt.Errorf("failed to create the directory of lock file %s: %v", path, err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open lock file %s: %v", path, err)
+	}
+
+	startTime := time.Now()
+	for {
+		err = unix.Flock(int(file.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+		if err == nil {
+			return &FileLock{file: file}, time.Since(startTime), nil
+		}
+		if err != unix.EWOULDBLOCK && err != unix.EINTR {
+			file.Close()
+			return nil, time.Since(startTime), fmt.Errorf("failed to lock %s: %v", path, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			file.Close()
+			return nil, time.Since(startTime), fmt.Errorf("failed to lock %s: %w", path, ctx.Err())
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+// Unlock releases the lock
+func (l *FileLock) Unlock() error {
+	defer l.file.Close()
+	return unix.Flock(int(l.file.Fd()), unix.LOCK_UN)
+}
@@ -0,0 +1,52 @@
+package lock
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Lock", func() {
+	var path string
+
+	BeforeEach(func() {
+		path = filepath.Join(GinkgoT().TempDir(), "meta-plugins", "host.lock")
+	})
+
+	It("create the lock file and lock it", func() {
+		l, wait, err := Lock(context.Background(), path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(wait).To(BeNumerically("<", time.Second))
+		Expect(path).To(BeAnExistingFile())
+		Expect(l.Unlock()).To(Succeed())
+	})
+
+	It("wait for the lock held by others", func() {
+		held, _, err := Lock(context.Background(), path)
+		Expect(err).NotTo(HaveOccurred())
+		go func() {
+			defer GinkgoRecover()
+			time.Sleep(100 * time.Millisecond)
+			Expect(held.Unlock()).To(Succeed())
+		}()
+
+		l, wait, err := Lock(context.Background(), path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(wait).To(BeNumerically(">=", 100*time.Millisecond))
+		Expect(l.Unlock()).To(Succeed())
+	})
+
+	It("stop waiting once the context is done", func() {
+		held, _, err := Lock(context.Background(), path)
+		Expect(err).NotTo(HaveOccurred())
+		defer held.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, _, err = Lock(ctx, path)
+		Expect(err).To(MatchError(context.DeadlineExceeded))
+	})
+})
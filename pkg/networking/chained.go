@@ -0,0 +1,72 @@
+package networking
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/spidernet-io/cni-plugins/pkg/constant"
+	"github.com/spidernet-io/cni-plugins/pkg/nl"
+	"github.com/vishvananda/netlink"
+)
+
+// the PCI address of a device, such as 0000:af:06.0
+var pciAddressRegexp = regexp.MustCompile(`^[0-9a-fA-F]{4}:[0-9a-fA-F]{2}:[0-9a-fA-F]{2}\.[0-7]$`)
+
+// SriovDevice returns the PCI address of the SR-IOV VF which is the chained interface, or empty if it's not a VF.
+// the address is the deviceID which Multus injects into the config for the resource of the SR-IOV device plugin,
+// or the pciID of the interface in prevResult, which is reported by the CNIs of the CNI spec 1.1.
+func SriovDevice(deviceID string, rawPrevResult map[string]interface{}, ifName string) string {
+	if pciAddressRegexp.MatchString(deviceID) {
+		return deviceID
+	}
+
+	interfaces, _ := rawPrevResult["interfaces"].([]interface{})
+	for _, i := range interfaces {
+		iface, ok := i.(map[string]interface{})
+		if !ok || iface["name"] != ifName {
+			continue
+		}
+		// the interface on the node has no sandbox
+		if sandbox, _ := iface["sandbox"].(string); sandbox == "" {
+			continue
+		}
+		if pciID, _ := iface["pciID"].(string); pciAddressRegexp.MatchString(pciID) {
+			return pciID
+		}
+	}
+	return ""
+}
+
+// ChainedInterfaceType returns the type of the chained interface in pod, see constant.ChainedTypeMacvlan.
+// a SR-IOV VF can't be told by the link, so it's given by sriov. the links other than ipvlan are treated as macvlan.
+func ChainedInterfaceType(h nl.Netlink, name string, sriov bool) (string, error) {
+	link, err := h.LinkByName(name)
+	if err != nil {
+		return "", err
+	}
+	if sriov {
+		return constant.ChainedTypeSriov, nil
+	}
+	if ipvlan, ok := link.(*netlink.IPVlan); ok {
+		if ipvlan.Mode == netlink.IPVLAN_MODE_L2 {
+			return constant.ChainedTypeIPvlanL2, nil
+		}
+		return constant.ChainedTypeIPvlanL3, nil
+	}
+	return constant.ChainedTypeMacvlan, nil
+}
+
+// SriovPF returns the name of the PF on the node which the VF of the PCI address belongs to.
+// equivalent to: ls /sys/bus/pci/devices/<pciID>/physfn/net
+func SriovPF(pciID string) (string, error) {
+	entries, err := os.ReadDir(filepath.Join(constant.SysBusPCIPath, pciID, "physfn", "net"))
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 0 {
+		return "", fmt.Errorf("the PF of %s has no network device", pciID)
+	}
+	return entries[0].Name(), nil
+}
@@ -0,0 +1,154 @@
+package networking
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spidernet-io/cni-plugins/pkg/constant"
+	"github.com/spidernet-io/cni-plugins/pkg/nl"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+var _ = Describe("Chained interface", func() {
+	Context("Test SriovDevice", func() {
+		It("the deviceID injected by Multus", func() {
+			Expect(SriovDevice("0000:af:06.0", nil, "net1")).To(Equal("0000:af:06.0"))
+			Expect(SriovDevice("vf-resource", nil, "net1")).To(BeEmpty())
+		})
+
+		It("the pciID of the interface in prevResult", func() {
+			prevResult := map[string]interface{}{
+				"interfaces": []interface{}{
+					map[string]interface{}{"name": "net1", "pciID": "0000:af:06.1"},
+					map[string]interface{}{"name": "net1", "sandbox": "/var/run/netns/test", "pciID": "0000:af:06.2"},
+				},
+			}
+			Expect(SriovDevice("", prevResult, "net1")).To(Equal("0000:af:06.2"))
+			Expect(SriovDevice("", prevResult, "net2")).To(BeEmpty())
+		})
+	})
+
+	Context("Test SriovPF", func() {
+		It("the network device of physfn", func() {
+			sysBusPCI := GinkgoT().TempDir()
+			origin := constant.SysBusPCIPath
+			constant.SysBusPCIPath = sysBusPCI
+			DeferCleanup(func() { constant.SysBusPCIPath = origin })
+
+			// the physfn of the VF is the link to the device of PF
+			Expect(os.MkdirAll(filepath.Join(sysBusPCI, "0000:af:00.0", "net", "ens1f0"), 0755)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(sysBusPCI, "0000:af:06.0"), 0755)).To(Succeed())
+			Expect(os.Symlink("../0000:af:00.0", filepath.Join(sysBusPCI, "0000:af:06.0", "physfn"))).To(Succeed())
+			Expect(SriovPF("0000:af:06.0")).To(Equal("ens1f0"))
+
+			// the PF is bound to a driver without network device
+			Expect(os.RemoveAll(filepath.Join(sysBusPCI, "0000:af:00.0", "net", "ens1f0"))).To(Succeed())
+			_, err := SriovPF("0000:af:06.0")
+			Expect(err).To(HaveOccurred())
+
+			_, err = SriovPF("0000:af:06.1")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Test ChainedInterfaceType with fake netlink", func() {
+		It("the type of link", func() {
+			h := nl.NewFake()
+			h.Links = append(h.Links,
+				&netlink.Macvlan{LinkAttrs: netlink.LinkAttrs{Name: "net1", Index: 11}, Mode: netlink.MACVLAN_MODE_BRIDGE},
+				&netlink.IPVlan{LinkAttrs: netlink.LinkAttrs{Name: "net2", Index: 12}, Mode: netlink.IPVLAN_MODE_L2},
+				&netlink.IPVlan{LinkAttrs: netlink.LinkAttrs{Name: "net3", Index: 13}, Mode: netlink.IPVLAN_MODE_L3},
+				&netlink.IPVlan{LinkAttrs: netlink.LinkAttrs{Name: "net4", Index: 14}, Mode: netlink.IPVLAN_MODE_L3S},
+			)
+			h.AddLink(netlink.LinkAttrs{Name: "net5"})
+
+			for name, expected := range map[string]string{
+				"net1": constant.ChainedTypeMacvlan,
+				"net2": constant.ChainedTypeIPvlanL2,
+				"net3": constant.ChainedTypeIPvlanL3,
+				"net4": constant.ChainedTypeIPvlanL3,
+				"net5": constant.ChainedTypeMacvlan,
+			} {
+				chainedType, err := ChainedInterfaceType(h, name, false)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(chainedType).To(Equal(expected), name)
+			}
+
+			chainedType, err := ChainedInterfaceType(h, "net5", true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(chainedType).To(Equal(constant.ChainedTypeSriov))
+
+			_, err = ChainedInterfaceType(h, "net6", false)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Test ChainedInterfaceType with network namespace", func() {
+		var podNS ns.NetNS
+
+		BeforeEach(func() {
+			if os.Geteuid() != 0 {
+				Skip("creating network namespace requires root")
+			}
+			var err error
+			podNS, err = testutils.NewNS()
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(func() {
+				Expect(podNS.Close()).To(Succeed())
+				Expect(testutils.UnmountNS(podNS)).To(Succeed())
+			})
+
+			// the parent of the chained interface, which plays the nic of node
+			err = podNS.Do(func(_ ns.NetNS) error {
+				return netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "ens192"}, PeerName: "ens224"})
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		// addChained creates the chained interface net1 on ens192, it's skipped if the kernel doesn't support the type
+		addChained := func(newLink func(attrs netlink.LinkAttrs) netlink.Link) {
+			err := podNS.Do(func(_ ns.NetNS) error {
+				parent, err := netlink.LinkByName("ens192")
+				if err != nil {
+					return err
+				}
+				return netlink.LinkAdd(newLink(netlink.LinkAttrs{Name: "net1", ParentIndex: parent.Attrs().Index}))
+			})
+			if errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.ENOTSUP) {
+				Skip("the kernel doesn't support the type of chained interface: " + err.Error())
+			}
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		DescribeTable("the main CNI",
+			func(newLink func(attrs netlink.LinkAttrs) netlink.Link, sriov bool, expected string) {
+				addChained(newLink)
+				chainedType, err := ChainedInterfaceType(nl.NewAt(podNS), "net1", sriov)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(chainedType).To(Equal(expected))
+			},
+			Entry("macvlan", func(attrs netlink.LinkAttrs) netlink.Link {
+				return &netlink.Macvlan{LinkAttrs: attrs, Mode: netlink.MACVLAN_MODE_BRIDGE}
+			}, false, constant.ChainedTypeMacvlan),
+			Entry("ipvlan l2", func(attrs netlink.LinkAttrs) netlink.Link {
+				return &netlink.IPVlan{LinkAttrs: attrs, Mode: netlink.IPVLAN_MODE_L2}
+			}, false, constant.ChainedTypeIPvlanL2),
+			Entry("ipvlan l3", func(attrs netlink.LinkAttrs) netlink.Link {
+				return &netlink.IPVlan{LinkAttrs: attrs, Mode: netlink.IPVLAN_MODE_L3}
+			}, false, constant.ChainedTypeIPvlanL3),
+			Entry("ipvlan l3s", func(attrs netlink.LinkAttrs) netlink.Link {
+				return &netlink.IPVlan{LinkAttrs: attrs, Mode: netlink.IPVLAN_MODE_L3S}
+			}, false, constant.ChainedTypeIPvlanL3),
+			// a VF can't be created without the NIC, it's told by sriov whatever the link is
+			Entry("sriov", func(attrs netlink.LinkAttrs) netlink.Link {
+				return &netlink.Macvlan{LinkAttrs: attrs, Mode: netlink.MACVLAN_MODE_BRIDGE}
+			}, true, constant.ChainedTypeSriov),
+		)
+	})
+})
@@ -0,0 +1,135 @@
+package networking
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	types100 "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/spidernet-io/cni-plugins/pkg/constant"
+	"github.com/vishvananda/netlink"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+// FlushConntrack deletes the conntrack entries of the given ips in the current network namespace, and returns
+// the number of deleted entries. the entries left by the previous pod of an ip break the connections of the new pod.
+// if ctx has a deadline, the flush never blocks past it.
+func FlushConntrack(ctx context.Context, ips []net.IP) (uint, error) {
+	h, err := netlink.NewHandle(unix.NETLINK_NETFILTER)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create netlink handle: %w", err)
+	}
+	defer h.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout := time.Until(deadline)
+		if timeout < time.Microsecond {
+			return 0, context.DeadlineExceeded
+		}
+		if err = h.SetSocketTimeout(timeout); err != nil {
+			return 0, fmt.Errorf("failed to set the timeout of netlink socket: %w", err)
+		}
+	}
+
+	var deleted uint
+	for _, family := range []netlink.InetFamily{unix.AF_INET, unix.AF_INET6} {
+		var familyIPs []net.IP
+		for _, ip := range ips {
+			if (ip.To4() != nil) == (family == unix.AF_INET) {
+				familyIPs = append(familyIPs, ip)
+			}
+		}
+		if len(familyIPs) == 0 {
+			continue
+		}
+
+		n, err := h.ConntrackDeleteFilter(netlink.ConntrackTable, family, &conntrackFilter{ips: familyIPs})
+		deleted += n
+		if err != nil {
+			return deleted, fmt.Errorf("failed to delete the conntrack entries of %v: %w", familyIPs, err)
+		}
+	}
+	return deleted, nil
+}
+
+// conntrackFilter matches the entries whose either direction is from or to any of ips
+type conntrackFilter struct {
+	ips []net.IP
+}
+
+func (f *conntrackFilter) MatchConntrackFlow(flow *netlink.ConntrackFlow) bool {
+	for _, ip := range f.ips {
+		if flow.Forward.SrcIP.Equal(ip) || flow.Forward.DstIP.Equal(ip) ||
+			flow.Reverse.SrcIP.Equal(ip) || flow.Reverse.DstIP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// releasedIPsDir records the ips released by DEL, there is an empty file named by each ip. the files are created and
+// removed atomically, so the concurrent invocations need no lock
+var releasedIPsDir = constant.ReleasedIPsDir
+
+// CleanReleasedConntrack flushes the conntrack entries of the pod ips released by DEL, and records the ips, so that the
+// ADD which reuses any of them flushes the entries again. the failure is only logged because the entries are not
+// required to be cleaned by the pod.
+func CleanReleasedConntrack(ctx context.Context, logger *zap.Logger, ipconfigs []*types100.IPConfig) {
+	ips := configIPs(ipconfigs)
+	if len(ips) == 0 {
+		return
+	}
+	cleanConntrack(ctx, logger, ips)
+
+	if err := os.MkdirAll(releasedIPsDir, 0755); err != nil {
+		logger.Warn("failed to record the released ips, their conntrack entries won't be flushed when they're reused", zap.Any("ips", ips), zap.Error(err))
+		return
+	}
+	for _, ip := range ips {
+		if err := os.WriteFile(filepath.Join(releasedIPsDir, ip.String()), nil, 0644); err != nil {
+			logger.Warn("failed to record the released ip, its conntrack entries won't be flushed when it's reused", zap.String("ip", ip.String()), zap.Error(err))
+		}
+	}
+}
+
+// CleanReusedConntrack flushes the conntrack entries of the pod ips which are released by a DEL before, the entries
+// may be created after the DEL, such as by the connections to the ip in the meantime. the ips never released are
+// skipped, so the ADD of a new ip doesn't scan the conntrack table. the record of the reused ips is removed.
+func CleanReusedConntrack(ctx context.Context, logger *zap.Logger, ipconfigs []*types100.IPConfig) {
+	var reused []net.IP
+	for _, ip := range configIPs(ipconfigs) {
+		err := os.Remove(filepath.Join(releasedIPsDir, ip.String()))
+		if err == nil {
+			reused = append(reused, ip)
+		} else if !os.IsNotExist(err) {
+			logger.Warn("failed to remove the record of released ip", zap.String("ip", ip.String()), zap.Error(err))
+		}
+	}
+	if len(reused) == 0 {
+		return
+	}
+	cleanConntrack(ctx, logger, reused)
+}
+
+// cleanConntrack flushes the conntrack entries of the ips on the node, the failure is only logged
+func cleanConntrack(ctx context.Context, logger *zap.Logger, ips []net.IP) {
+	deleted, err := FlushConntrack(ctx, ips)
+	if err != nil {
+		logger.Warn("failed to flush the conntrack entries of pod, the connections to the ips may be broken", zap.Any("ips", ips), zap.Error(err))
+		return
+	}
+	if deleted != 0 {
+		logger.Info("Flushed the stale conntrack entries of pod", zap.Any("ips", ips), zap.Uint("count", deleted))
+	}
+}
+
+func configIPs(ipconfigs []*types100.IPConfig) []net.IP {
+	var ips []net.IP
+	for _, ipconfig := range ipconfigs {
+		ips = append(ips, ipconfig.Address.IP)
+	}
+	return ips
+}
@@ -0,0 +1,290 @@
+package networking
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/spidernet-io/cni-plugins/pkg/nl"
+	"github.com/vishvananda/netlink"
+	"go.uber.org/zap"
+)
+
+// The functions below are the same as the ones of spiderpool, but operate on the given Netlink,
+// so a pod netns is entered once for the handle instead of once per call.
+
+// IPAddressByName returns the unicast ip addresses of the given interface, filter by ipFamily
+func IPAddressByName(h nl.Netlink, iface string, ipFamily int) ([]netlink.Addr, error) {
+	link, err := h.LinkByName(iface)
+	if err != nil {
+		return nil, err
+	}
+	return unicastAddrs(h, link, ipFamily)
+}
+
+// GetAllIPAddress returns the unicast ip addresses of all interfaces, filter by ipFamily,
+// skipping any interfaces whose name matches any of the exclusion list regexes
+func GetAllIPAddress(h nl.Netlink, ipFamily int, excludeInterfaces []string) ([]netlink.Addr, error) {
+	var excludeRegexp *regexp.Regexp
+	if excludeInterfaces != nil {
+		var err error
+		if excludeRegexp, err = regexp.Compile("(" + strings.Join(excludeInterfaces, ")|(") + ")"); err != nil {
+			return nil, err
+		}
+	}
+
+	links, err := h.LinkList()
+	if err != nil {
+		return nil, err
+	}
+
+	var allIPAddress []netlink.Addr
+	for _, link := range links {
+		if excludeRegexp != nil && excludeRegexp.MatchString(link.Attrs().Name) {
+			continue
+		}
+		addrs, err := unicastAddrs(h, link, ipFamily)
+		if err != nil {
+			return nil, err
+		}
+		allIPAddress = append(allIPAddress, addrs...)
+	}
+	return allIPAddress, nil
+}
+
+func unicastAddrs(h nl.Netlink, link netlink.Link, ipFamily int) ([]netlink.Addr, error) {
+	addrs, err := h.AddrList(link, ipFamily)
+	if err != nil {
+		return nil, err
+	}
+
+	var ipAddress []netlink.Addr
+	for _, addr := range addrs {
+		if addr.IP.IsMulticast() || addr.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if addr.IP.To4() != nil && (ipFamily == netlink.FAMILY_V4 || ipFamily == netlink.FAMILY_ALL) {
+			ipAddress = append(ipAddress, addr)
+		}
+		if addr.IP.To4() == nil && (ipFamily == netlink.FAMILY_V6 || ipFamily == netlink.FAMILY_ALL) {
+			ipAddress = append(ipAddress, addr)
+		}
+	}
+	return ipAddress, nil
+}
+
+// AddRoute adds the route to dst via the given interface to ruleTable, the gateway of dst's family is used if it's not nil,
+// and so is the source of dst's family in srcIPs, see SrcHint. the existing route is ignored.
+func AddRoute(logger *zap.Logger, h nl.Netlink, ruleTable, ipFamily int, scope netlink.Scope, iface string, dst *net.IPNet, v4Gw, v6Gw net.IP, srcIPs []netlink.Addr) error {
+	link, err := h.LinkByName(iface)
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	route := &netlink.Route{
+		LinkIndex: link.Attrs().Index,
+		Scope:     scope,
+		Dst:       dst,
+		Table:     ruleTable,
+	}
+
+	switch ipFamily {
+	case netlink.FAMILY_V4:
+		if v4Gw != nil {
+			route.Gw = v4Gw
+		}
+	case netlink.FAMILY_V6:
+		if v6Gw != nil {
+			route.Gw = v6Gw
+		}
+	case netlink.FAMILY_ALL:
+		if dst != nil && dst.IP.To4() != nil && v4Gw != nil {
+			route.Gw = v4Gw
+		}
+		if dst != nil && dst.IP.To4() == nil && v6Gw != nil {
+			route.Gw = v6Gw
+		}
+	default:
+		return fmt.Errorf("unknown ipFamily %v", ipFamily)
+	}
+	if dst != nil {
+		route.Src = SrcHint(srcIPs, dst.IP)
+	}
+
+	if err = h.RouteAdd(route); err != nil && !os.IsExist(err) {
+		logger.Error("failed to RouteAdd", zap.String("route", route.String()), zap.Error(err))
+		return fmt.Errorf("failed to add route table(%v): %w", route.String(), err)
+	}
+	return nil
+}
+
+// AddStaticNeighborTable adds the permanent neighbor of dstIP to the link, the existing neighbor is ignored
+func AddStaticNeighborTable(h nl.Netlink, linkIndex int, dstIP net.IP, hwAddress net.HardwareAddr) error {
+	neigh := &netlink.Neigh{
+		LinkIndex:    linkIndex,
+		State:        netlink.NUD_PERMANENT,
+		Type:         netlink.NDA_LLADDR,
+		IP:           dstIP,
+		HardwareAddr: hwAddress,
+	}
+
+	if err := h.NeighAdd(neigh); err != nil && !os.IsExist(err) {
+		return fmt.Errorf("failed to add neigh table: %w ", err)
+	}
+	return nil
+}
+
+// the prefix of the host veths created by the plugins, followed by the first 11 characters of the container ID
+const hostVethPrefix = "veth"
+
+// HostVethName returns the name of the host veth of the pod, which is created by veth, or by router for host access
+func HostVethName(containerID string) string {
+	if len(containerID) > 11 {
+		containerID = containerID[:11]
+	}
+	return hostVethPrefix + containerID
+}
+
+// isHostVeth tells whether the link is a host veth named by HostVethName, the veths of others, such as the ones of
+// docker named by veth and 7 characters, are excluded by the length
+func isHostVeth(link netlink.Link) bool {
+	name := link.Attrs().Name
+	return link.Type() == "veth" && strings.HasPrefix(name, hostVethPrefix) && len(name) == len(hostVethPrefix)+11
+}
+
+// StaleNeighbors returns the permanent neighbors of the given ips left by the previous pods on the devices of the
+// plugins: the host veths named by HostVethName, and the host peer of the overlay interface of pod, whose index is
+// overlayPeer, or 0 if there is none. only the neighbors of these devices are dumped, instead of the whole neighbor
+// table of node, and the neighbors on the other devices are not managed by the plugins and never returned.
+func StaleNeighbors(h nl.Netlink, overlayPeer int, ips []netlink.Addr) ([]netlink.Neigh, error) {
+	if len(ips) == 0 {
+		return nil, nil
+	}
+	var v4, v6 bool
+	for _, ip := range ips {
+		if ip.IP.To4() != nil {
+			v4 = true
+		} else {
+			v6 = true
+		}
+	}
+	family := netlink.FAMILY_ALL
+	if !v6 {
+		family = netlink.FAMILY_V4
+	} else if !v4 {
+		family = netlink.FAMILY_V6
+	}
+
+	links, err := h.LinkList()
+	if err != nil {
+		return nil, err
+	}
+
+	var stale []netlink.Neigh
+	for _, link := range links {
+		if !isHostVeth(link) && (overlayPeer == 0 || link.Attrs().Index != overlayPeer) {
+			continue
+		}
+		neighs, err := h.NeighList(link.Attrs().Index, family)
+		if err != nil {
+			return nil, err
+		}
+		for _, neigh := range neighs {
+			if neigh.State&netlink.NUD_PERMANENT == 0 {
+				continue
+			}
+			for _, ip := range ips {
+				if neigh.IP.Equal(ip.IP) {
+					stale = append(stale, neigh)
+					break
+				}
+			}
+		}
+	}
+	return stale, nil
+}
+
+// LearnedNeighbors returns the neighbors of the given ips on the link which are learned by the kernel, the permanent
+// neighbors set by the administrator and the NOARP ones are never returned.
+func LearnedNeighbors(h nl.Netlink, linkName string, ips []netlink.Addr) ([]netlink.Neigh, error) {
+	link, err := h.LinkByName(linkName)
+	if err != nil {
+		return nil, err
+	}
+	neighs, err := h.NeighList(link.Attrs().Index, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, err
+	}
+
+	var learned []netlink.Neigh
+	for _, neigh := range neighs {
+		if neigh.State&(netlink.NUD_PERMANENT|netlink.NUD_NOARP) != 0 {
+			continue
+		}
+		for _, ip := range ips {
+			if neigh.IP.Equal(ip.IP) {
+				learned = append(learned, neigh)
+				break
+			}
+		}
+	}
+	return learned, nil
+}
+
+// SrcHint returns the first address of the same family as dst, it's the preferred source of the route to dst.
+// the link-local addresses are ignored, nil if there is none
+func SrcHint(addrs []netlink.Addr, dst net.IP) net.IP {
+	for _, addr := range addrs {
+		if addr.IPNet == nil || addr.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if (addr.IP.To4() != nil) == (dst.To4() != nil) {
+			return addr.IP
+		}
+	}
+	return nil
+}
+
+// Sysctl reads the sysctl if no value is given, or writes it, like sysctl.Sysctl. It's called in the network
+// namespace of the sysctl, and it only records the writes in dry-run mode, see plan.Plan.Sysctl
+type Sysctl func(name string, value ...string) (string, error)
+
+// SetupVeth creates the veth pair like ip.SetupVethWithName, but through the Netlink of pod and node: the device
+// name with the given mac-address in pod, and its peer on the node, which is set up and owns its routes.
+func SetupVeth(podNl, hostNl nl.Netlink, hostSysctl Sysctl, name, peerName string, mtu int, mac net.HardwareAddr) (hostVeth, podVeth netlink.Link, err error) {
+	hostNS, err := ns.GetCurrentNS()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open the network namespace of the node: %w", err)
+	}
+	defer hostNS.Close()
+
+	veth := &netlink.Veth{
+		LinkAttrs:     netlink.LinkAttrs{Name: name, MTU: mtu, HardwareAddr: mac},
+		PeerName:      peerName,
+		PeerNamespace: netlink.NsFd(int(hostNS.Fd())),
+	}
+	if err = podNl.LinkAdd(veth); err != nil {
+		if os.IsExist(err) {
+			return nil, nil, fmt.Errorf("container veth name provided (%v) already exists", name)
+		}
+		return nil, nil, fmt.Errorf("failed to make veth pair: %w", err)
+	}
+	// re-fetch the links to get their creation-time parameters, e.g. index and mac
+	if podVeth, err = podNl.LinkByName(name); err != nil {
+		_ = podNl.LinkDel(veth)
+		return nil, nil, err
+	}
+	if hostVeth, err = hostNl.LinkByName(peerName); err != nil {
+		return nil, nil, fmt.Errorf("failed to lookup %q on the node: %w", peerName, err)
+	}
+	if err = hostNl.LinkSetUp(hostVeth); err != nil {
+		return nil, nil, fmt.Errorf("failed to set %q up: %w", peerName, err)
+	}
+	// we want to own the routes for this interface
+	_, _ = hostSysctl(fmt.Sprintf("net/ipv6/conf/%s/accept_ra", peerName), "0")
+	return hostVeth, podVeth, nil
+}
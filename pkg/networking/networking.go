@@ -1,6 +1,7 @@
 package networking
 
 import (
+	"context"
 	"fmt"
 	types100 "github.com/containernetworking/cni/pkg/types/100"
 	"github.com/containernetworking/plugins/pkg/ns"
@@ -14,7 +15,8 @@ import (
 	"time"
 )
 
-func DoIPConflictChecking(logger *zap.Logger, netns ns.NetNS, iface string, ipconfigs []*types100.IPConfig, config *ty.IPConflict) error {
+// DoIPConflictChecking checks if the ips of the interface are used by others, it's stopped once ctx is done
+func DoIPConflictChecking(ctx context.Context, logger *zap.Logger, netns ns.NetNS, iface string, ipconfigs []*types100.IPConfig, config *ty.IPConflict) error {
 	logger.Debug("DoIPConflictChecking")
 
 	if len(ipconfigs) == 0 {
@@ -23,27 +25,27 @@ func DoIPConflictChecking(logger *zap.Logger, netns ns.NetNS, iface string, ipco
 
 	duration, err := time.ParseDuration(config.Interval)
 	if err != nil {
-		return fmt.Errorf("failed to parse interval %v: %v", config.Interval, err)
+		return fmt.Errorf("failed to parse interval %v: %w", config.Interval, err)
 	}
 
 	return netns.Do(func(netNS ns.NetNS) error {
 		ifi, err := net.InterfaceByName(iface)
 		if err != nil {
-			return fmt.Errorf("failed to get interface by name %s: %v", iface, err)
+			return fmt.Errorf("failed to get interface by name %s: %w", iface, err)
 		}
 
 		for idx, _ := range ipconfigs {
 			target := netip.MustParseAddr(ipconfigs[idx].Address.IP.String())
 			if target.Is4() {
 				logger.Debug("IPCheckingByARP", zap.String("address", target.String()))
-				err = ipchecking.IPCheckingByARP(ifi, target, config.Retry, duration)
+				err = ipchecking.IPCheckingByARP(ctx, ifi, target, config.Retry, duration)
 				if err != nil {
 					return err
 				}
 				logger.Debug("No IPv4 address conflicting", zap.String("address", target.String()))
 			} else {
 				logger.Debug("IPCheckingByNDP", zap.String("address", target.String()))
-				err = ipchecking.IPCheckingByNDP(ifi, target, config.Retry, duration)
+				err = ipchecking.IPCheckingByNDP(ctx, ifi, target, config.Retry, duration)
 				if err != nil {
 					return err
 				}
@@ -58,7 +60,9 @@ func durationStr(interval float64) string {
 	return fmt.Sprintf("%vs", interval)
 }
 
-func GetAllHostIPRouteForPod(ipFamily int, allPodIp []netlink.Addr) (finalNodeIpList []net.IP, e error) {
+// GetAllHostIPRouteForPod returns the ip addresses of the node which the pod may access,
+// the ip of the interfaces matching excludeInterfaces is ignored.
+func GetAllHostIPRouteForPod(ipFamily int, allPodIp []netlink.Addr, excludeInterfaces []string) (finalNodeIpList []net.IP, e error) {
 
 	finalNodeIpList = []net.IP{}
 
@@ -83,17 +87,10 @@ OUTER1:
 		finalNodeIpList = append(finalNodeIpList, t)
 	}
 
-	var DefaultNodeInterfacesToExclude = []string{
-		"docker.*", "cbr.*", "dummy.*",
-		"virbr.*", "lxcbr.*", "veth.*", `^lo$`,
-		`^cali.*`, "flannel.*", "kube-ipvs.*",
-		"cni.*", "vx-submariner", "cilium*",
-	}
-
 	// get additional host ip
-	additionalIp, err := networking.GetAllIPAddress(ipFamily, DefaultNodeInterfacesToExclude)
+	additionalIp, err := networking.GetAllIPAddress(ipFamily, excludeInterfaces)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get IPAddressOnNode: %v", err)
+		return nil, fmt.Errorf("failed to get IPAddressOnNode: %w", err)
 	}
 
 OUTER2:
@@ -0,0 +1,273 @@
+package networking
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+
+	types100 "github.com/containernetworking/cni/pkg/types/100"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spidernet-io/cni-plugins/pkg/nl"
+	"github.com/vishvananda/netlink"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	Expect(err).NotTo(HaveOccurred())
+	return ipNet
+}
+
+var _ = Describe("Networking", func() {
+	Context("Test overlaySubnetsFromRoutes", func() {
+		hostLinks := map[int]string{1: "lo", 2: "ens192", 3: "tunl0", 4: "cali12345678", 5: "cilium_host"}
+
+		It("calico ipip", func() {
+			podRoutes := []netlink.Route{
+				// default via 169.254.1.1 dev eth0
+				{Table: unix.RT_TABLE_MAIN, Gw: net.ParseIP("169.254.1.1")},
+				// 169.254.1.1 dev eth0 scope link
+				{Table: unix.RT_TABLE_MAIN, Dst: mustParseCIDR("169.254.1.1/32")},
+			}
+			hostRoutes := []netlink.Route{
+				{Table: unix.RT_TABLE_MAIN, LinkIndex: 2, Gw: net.ParseIP("172.18.0.1")},
+				{Table: unix.RT_TABLE_MAIN, LinkIndex: 2, Dst: mustParseCIDR("172.18.0.0/16"), Protocol: unix.RTPROT_KERNEL},
+				{Table: unix.RT_TABLE_MAIN, LinkIndex: 3, Dst: mustParseCIDR("10.244.1.0/26"), Protocol: unix.RTPROT_BIRD},
+				{Table: unix.RT_TABLE_MAIN, LinkIndex: 3, Dst: mustParseCIDR("10.244.2.0/26"), Protocol: unix.RTPROT_BIRD},
+				{Table: unix.RT_TABLE_MAIN, Dst: mustParseCIDR("10.244.0.0/26"), Type: unix.RTN_BLACKHOLE, Protocol: unix.RTPROT_BIRD},
+				{Table: unix.RT_TABLE_MAIN, LinkIndex: 4, Dst: mustParseCIDR("10.244.0.5/32"), Scope: netlink.SCOPE_LINK},
+			}
+			got := overlaySubnetsFromRoutes(podRoutes, hostRoutes, hostLinks)
+			Expect(got).To(Equal([]string{"10.244.1.0/26", "10.244.2.0/26", "10.244.0.0/26"}))
+		})
+
+		It("cilium", func() {
+			hostRoutes := []netlink.Route{
+				{Table: unix.RT_TABLE_MAIN, LinkIndex: 5, Dst: mustParseCIDR("10.0.0.0/24"), Gw: net.ParseIP("10.0.0.1")},
+				{Table: unix.RT_TABLE_MAIN, LinkIndex: 5, Dst: mustParseCIDR("10.0.0.1/32")},
+				{Table: 200, LinkIndex: 5, Dst: mustParseCIDR("10.0.1.0/24")},
+			}
+			got := overlaySubnetsFromRoutes(nil, hostRoutes, hostLinks)
+			Expect(got).To(Equal([]string{"10.0.0.0/24"}))
+		})
+
+		It("drop the covered and duplicate subnets", func() {
+			podRoutes := []netlink.Route{
+				{Table: unix.RT_TABLE_MAIN, Dst: mustParseCIDR("10.244.1.0/24")},
+				{Table: unix.RT_TABLE_MAIN, Dst: mustParseCIDR("10.244.0.0/16")},
+				{Table: unix.RT_TABLE_MAIN, Dst: mustParseCIDR("fe80::/64")},
+			}
+			hostRoutes := []netlink.Route{
+				{Table: unix.RT_TABLE_MAIN, LinkIndex: 3, Dst: mustParseCIDR("10.244.0.0/16"), Protocol: unix.RTPROT_BIRD},
+				{Table: unix.RT_TABLE_MAIN, LinkIndex: 3, Dst: mustParseCIDR("fd00:10:244::/64"), Protocol: unix.RTPROT_BIRD},
+			}
+			got := overlaySubnetsFromRoutes(podRoutes, hostRoutes, hostLinks)
+			Expect(got).To(Equal([]string{"10.244.0.0/16", "fd00:10:244::/64"}))
+		})
+	})
+
+	Context("Test ResolveAutoOverlaySubnets", func() {
+		It("nothing to do without auto", func() {
+			got, err := ResolveAutoOverlaySubnets(zap.NewNop(), nil, nil, "eth0", netlink.FAMILY_V4, []string{"10.244.0.0/16"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got).To(Equal([]string{"10.244.0.0/16"}))
+		})
+	})
+
+	Context("Test the functions with netlink", func() {
+		var pod *nl.Fake
+		var eth0 netlink.Link
+
+		BeforeEach(func() {
+			pod = nl.NewFake()
+			eth0 = pod.AddLink(netlink.LinkAttrs{Name: "eth0"})
+			pod.AddLink(netlink.LinkAttrs{Name: "net1"})
+			Expect(pod.AddAddr("lo", "127.0.0.1/8")).To(Succeed())
+			Expect(pod.AddAddr("eth0", "10.244.1.10/32")).To(Succeed())
+			Expect(pod.AddAddr("eth0", "fd00:10:244::10/128")).To(Succeed())
+			Expect(pod.AddAddr("eth0", "fe80::1/64")).To(Succeed())
+			Expect(pod.AddAddr("net1", "10.6.1.10/16")).To(Succeed())
+		})
+
+		It("IPAddressByName ignores the link-local addresses", func() {
+			addrs, err := IPAddressByName(pod, "eth0", netlink.FAMILY_ALL)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(addrs).To(HaveLen(2))
+
+			addrs, err = IPAddressByName(pod, "eth0", netlink.FAMILY_V6)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(addrs).To(HaveLen(1))
+			Expect(addrs[0].IP.String()).To(Equal("fd00:10:244::10"))
+
+			_, err = IPAddressByName(pod, "eth1", netlink.FAMILY_ALL)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("GetAllIPAddress skips the excluded interfaces", func() {
+			addrs, err := GetAllIPAddress(pod, netlink.FAMILY_V4, []string{`^lo$`})
+			Expect(err).NotTo(HaveOccurred())
+			var ips []string
+			for _, addr := range addrs {
+				ips = append(ips, addr.IP.String())
+			}
+			Expect(ips).To(ConsistOf("10.244.1.10", "10.6.1.10"))
+
+			_, err = GetAllIPAddress(pod, netlink.FAMILY_V4, []string{`(`})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("AddRoute uses the gateway of the same family and ignores the existing route", func() {
+			dst := mustParseCIDR("10.96.0.0/12")
+			v4Gw, v6Gw := net.ParseIP("169.254.1.1"), net.ParseIP("fe80::1")
+			Expect(AddRoute(zap.NewNop(), pod, 100, netlink.FAMILY_ALL, netlink.SCOPE_UNIVERSE, "eth0", dst, v4Gw, v6Gw, nil)).To(Succeed())
+			Expect(AddRoute(zap.NewNop(), pod, 100, netlink.FAMILY_ALL, netlink.SCOPE_UNIVERSE, "eth0", dst, v4Gw, v6Gw, nil)).To(Succeed())
+
+			routes := pod.RoutesInTable(100)
+			Expect(routes).To(HaveLen(1))
+			Expect(routes[0].LinkIndex).To(Equal(eth0.Attrs().Index))
+			Expect(routes[0].Gw).To(Equal(v4Gw))
+
+			Expect(AddRoute(zap.NewNop(), pod, 100, 3, netlink.SCOPE_UNIVERSE, "eth0", dst, v4Gw, v6Gw, nil)).NotTo(Succeed())
+		})
+
+		It("AddRoute sets the source of the same family", func() {
+			srcIPs := []netlink.Addr{
+				{IPNet: &net.IPNet{IP: net.ParseIP("fe80::10"), Mask: net.CIDRMask(64, 128)}},
+				{IPNet: &net.IPNet{IP: net.ParseIP("fd00:10:244::10"), Mask: net.CIDRMask(128, 128)}},
+				{IPNet: &net.IPNet{IP: net.ParseIP("10.244.1.10"), Mask: net.CIDRMask(32, 32)}},
+			}
+			Expect(AddRoute(zap.NewNop(), pod, 100, netlink.FAMILY_ALL, netlink.SCOPE_LINK, "eth0", mustParseCIDR("10.6.0.1/32"), nil, nil, srcIPs)).To(Succeed())
+			Expect(AddRoute(zap.NewNop(), pod, 100, netlink.FAMILY_ALL, netlink.SCOPE_LINK, "eth0", mustParseCIDR("fd00:10:6::1/128"), nil, nil, srcIPs)).To(Succeed())
+			Expect(pod.RoutesInTable(100)).To(ConsistOf(
+				HaveField("Src", Equal(net.ParseIP("10.244.1.10"))),
+				HaveField("Src", Equal(net.ParseIP("fd00:10:244::10"))),
+			))
+
+			Expect(SrcHint(srcIPs[:1], net.ParseIP("fd00:10:6::1"))).To(BeNil(), "the link-local address is not a hint")
+			Expect(SrcHint(nil, net.ParseIP("10.6.0.1"))).To(BeNil())
+		})
+
+		It("AddStaticNeighborTable ignores the existing neighbor", func() {
+			hw := net.HardwareAddr{0xee, 0xee, 0xee, 0xee, 0xee, 0xee}
+			Expect(AddStaticNeighborTable(pod, eth0.Attrs().Index, net.ParseIP("10.6.0.1"), hw)).To(Succeed())
+			Expect(AddStaticNeighborTable(pod, eth0.Attrs().Index, net.ParseIP("10.6.0.1"), hw)).To(Succeed())
+			Expect(pod.Neighs).To(HaveLen(1))
+			Expect(pod.Neighs[0].State).To(Equal(netlink.NUD_PERMANENT))
+
+			Expect(AddStaticNeighborTable(pod, 100, net.ParseIP("10.6.0.1"), hw)).NotTo(Succeed())
+		})
+
+		It("StaleNeighbors only returns the permanent neighbors on the host veths and the overlay peer", func() {
+			host := nl.NewFake()
+			ens192 := host.AddLink(netlink.LinkAttrs{Name: "ens192"})
+			vethOld := host.AddVeth(netlink.LinkAttrs{Name: "veth0123456789a"})
+			cali := host.AddVeth(netlink.LinkAttrs{Name: "cali12345678901"})
+			// the veth of docker and the peer of another overlay interface
+			docker := host.AddVeth(netlink.LinkAttrs{Name: "veth1a2b3c4"})
+			caliOther := host.AddVeth(netlink.LinkAttrs{Name: "cali98765432109"})
+			hw := net.HardwareAddr{0xee, 0xee, 0xee, 0xee, 0xee, 0xee}
+			host.Neighs = []netlink.Neigh{
+				{LinkIndex: ens192.Attrs().Index, State: netlink.NUD_PERMANENT, IP: net.ParseIP("10.6.1.10"), HardwareAddr: hw},
+				{LinkIndex: vethOld.Attrs().Index, State: netlink.NUD_PERMANENT, IP: net.ParseIP("10.6.1.10"), HardwareAddr: hw},
+				{LinkIndex: vethOld.Attrs().Index, State: netlink.NUD_PERMANENT, IP: net.ParseIP("10.6.1.11"), HardwareAddr: hw},
+				{LinkIndex: cali.Attrs().Index, State: netlink.NUD_REACHABLE, IP: net.ParseIP("10.6.1.10"), HardwareAddr: hw},
+				{LinkIndex: cali.Attrs().Index, State: netlink.NUD_PERMANENT, IP: net.ParseIP("fd00:10:6::10"), HardwareAddr: hw},
+				{LinkIndex: docker.Attrs().Index, State: netlink.NUD_PERMANENT, IP: net.ParseIP("10.6.1.10"), HardwareAddr: hw},
+				{LinkIndex: caliOther.Attrs().Index, State: netlink.NUD_PERMANENT, IP: net.ParseIP("10.6.1.10"), HardwareAddr: hw},
+			}
+			ips := []netlink.Addr{
+				{IPNet: &net.IPNet{IP: net.ParseIP("10.6.1.10"), Mask: net.CIDRMask(16, 32)}},
+				{IPNet: &net.IPNet{IP: net.ParseIP("fd00:10:6::10"), Mask: net.CIDRMask(64, 128)}},
+			}
+
+			stale, err := StaleNeighbors(host, cali.Attrs().Index, ips)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stale).To(ConsistOf(host.Neighs[1], host.Neighs[4]))
+
+			stale, err = StaleNeighbors(host, 0, ips)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stale).To(ConsistOf(host.Neighs[1]))
+
+			stale, err = StaleNeighbors(host, cali.Attrs().Index, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stale).To(BeEmpty())
+		})
+
+		It("HostVethName", func() {
+			Expect(HostVethName("0123456789abcdef")).To(Equal("veth0123456789a"))
+			Expect(HostVethName("0123")).To(Equal("veth0123"))
+		})
+
+		It("LearnedNeighbors only returns the neighbors learned on the link", func() {
+			host := nl.NewFake()
+			pf := host.AddLink(netlink.LinkAttrs{Name: "ens1f0"})
+			ens192 := host.AddLink(netlink.LinkAttrs{Name: "ens192"})
+			hw := net.HardwareAddr{0xee, 0xee, 0xee, 0xee, 0xee, 0xee}
+			host.Neighs = []netlink.Neigh{
+				{LinkIndex: pf.Attrs().Index, State: netlink.NUD_REACHABLE, IP: net.ParseIP("10.6.1.10"), HardwareAddr: hw},
+				{LinkIndex: pf.Attrs().Index, State: netlink.NUD_STALE, IP: net.ParseIP("fd00:10:6::10"), HardwareAddr: hw},
+				{LinkIndex: pf.Attrs().Index, State: netlink.NUD_PERMANENT, IP: net.ParseIP("10.6.1.11"), HardwareAddr: hw},
+				{LinkIndex: pf.Attrs().Index, State: netlink.NUD_REACHABLE, IP: net.ParseIP("10.6.1.12"), HardwareAddr: hw},
+				{LinkIndex: ens192.Attrs().Index, State: netlink.NUD_REACHABLE, IP: net.ParseIP("10.6.1.10"), HardwareAddr: hw},
+			}
+			ips := []netlink.Addr{
+				{IPNet: &net.IPNet{IP: net.ParseIP("10.6.1.10"), Mask: net.CIDRMask(16, 32)}},
+				{IPNet: &net.IPNet{IP: net.ParseIP("10.6.1.11"), Mask: net.CIDRMask(16, 32)}},
+				{IPNet: &net.IPNet{IP: net.ParseIP("fd00:10:6::10"), Mask: net.CIDRMask(64, 128)}},
+			}
+
+			learned, err := LearnedNeighbors(host, "ens1f0", ips)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(learned).To(ConsistOf(host.Neighs[0], host.Neighs[1]))
+
+			_, err = LearnedNeighbors(host, "ens1f1", ips)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Test the conntrack filter", func() {
+		It("match the entries of the ips in either direction", func() {
+			filter := &conntrackFilter{ips: []net.IP{net.ParseIP("10.6.1.10"), net.ParseIP("10.6.1.11")}}
+			flow := func(forwardSrc, forwardDst, reverseSrc, reverseDst string) *netlink.ConntrackFlow {
+				f := &netlink.ConntrackFlow{}
+				f.Forward.SrcIP, f.Forward.DstIP = net.ParseIP(forwardSrc), net.ParseIP(forwardDst)
+				f.Reverse.SrcIP, f.Reverse.DstIP = net.ParseIP(reverseSrc), net.ParseIP(reverseDst)
+				return f
+			}
+
+			Expect(filter.MatchConntrackFlow(flow("10.6.1.10", "10.96.0.1", "10.244.1.2", "10.6.1.10"))).To(BeTrue())
+			Expect(filter.MatchConntrackFlow(flow("10.6.1.2", "10.6.1.11", "10.6.1.11", "10.6.1.2"))).To(BeTrue())
+			// the service ip is translated to the pod ip in the reply direction
+			Expect(filter.MatchConntrackFlow(flow("10.6.1.2", "10.96.0.10", "10.6.1.11", "10.6.1.2"))).To(BeTrue())
+			Expect(filter.MatchConntrackFlow(flow("10.6.1.2", "10.6.1.3", "10.6.1.3", "10.6.1.2"))).To(BeFalse())
+		})
+	})
+
+	Context("Test the record of released ips", func() {
+		BeforeEach(func() {
+			DeferCleanup(func(dir string) { releasedIPsDir = dir }, releasedIPsDir)
+			releasedIPsDir = filepath.Join(GinkgoT().TempDir(), "released-ips")
+		})
+
+		ipconfigs := func(cidrs ...string) []*types100.IPConfig {
+			var configs []*types100.IPConfig
+			for _, cidr := range cidrs {
+				configs = append(configs, &types100.IPConfig{Address: *mustParseCIDR(cidr)})
+			}
+			return configs
+		}
+
+		It("only the released ips are flushed when they're reused, and only once", func() {
+			CleanReleasedConntrack(context.Background(), zap.NewNop(), ipconfigs("10.6.1.10/32", "fd00:10:6::10/128"))
+			Expect(filepath.Join(releasedIPsDir, "10.6.1.10")).To(BeAnExistingFile())
+			Expect(filepath.Join(releasedIPsDir, "fd00:10:6::10")).To(BeAnExistingFile())
+
+			CleanReusedConntrack(context.Background(), zap.NewNop(), ipconfigs("10.6.1.10/32", "10.6.1.11/32"))
+			Expect(filepath.Join(releasedIPsDir, "10.6.1.10")).NotTo(BeAnExistingFile())
+			Expect(filepath.Join(releasedIPsDir, "10.6.1.11")).NotTo(BeAnExistingFile())
+			Expect(filepath.Join(releasedIPsDir, "fd00:10:6::10")).To(BeAnExistingFile())
+		})
+	})
+})
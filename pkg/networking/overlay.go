@@ -0,0 +1,148 @@
+package networking
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+
+	"github.com/spidernet-io/cni-plugins/pkg/constant"
+	"github.com/spidernet-io/cni-plugins/pkg/nl"
+	"github.com/vishvananda/netlink"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+// the protocol of routes installed by calico felix
+var calicoFelixRouteProtocol = netlink.RouteProtocol(80)
+
+// the devices of overlay cni on the node, the routes through them point to overlay subnets
+var overlayHostDeviceRegexp = regexp.MustCompile(`^(tunl0|vxlan\.calico|vxlan-v6\.calico|wireguard\.cali|cilium_host|cilium_net|flannel\.\d+|flannel-v6\.\d+|cni0|antrea-gw0|ovn0|weave)$`)
+
+// ResolveAutoOverlaySubnets replaces "auto" in the given overlay subnets with the subnets inferred from routes.
+// the overlayInterface is the interface created by overlay cni in pod, it's ignored if empty.
+func ResolveAutoOverlaySubnets(logger *zap.Logger, podNl, hostNl nl.Netlink, overlayInterface string, ipfamily int, subnets []string) ([]string, error) {
+	found := false
+	result := make([]string, 0, len(subnets))
+	for _, subnet := range subnets {
+		if subnet == constant.AutoSubnet {
+			found = true
+			continue
+		}
+		result = append(result, subnet)
+	}
+	if !found {
+		return subnets, nil
+	}
+
+	inferred, err := InferOverlaySubnets(podNl, hostNl, overlayInterface, ipfamily)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Inferred overlay hijack subnets from routes", zap.String("overlayInterface", overlayInterface), zap.Strings("inferred", inferred))
+
+	for _, subnet := range inferred {
+		exist := false
+		for _, s := range result {
+			if s == subnet {
+				exist = true
+				break
+			}
+		}
+		if !exist {
+			result = append(result, subnet)
+		}
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no overlay subnet is inferred from routes, please give overlay_hijack_subnet explicitly")
+	}
+	return result, nil
+}
+
+// InferOverlaySubnets infers the subnets of overlay cni from the routes of overlayInterface in pod,
+// and the routes through overlay devices (such as tunl0, vxlan.calico, cilium_host) on the node.
+// It must be called before the routes of overlayInterface are migrated.
+func InferOverlaySubnets(podNl, hostNl nl.Netlink, overlayInterface string, ipfamily int) ([]string, error) {
+	var podRoutes []netlink.Route
+	if overlayInterface != "" {
+		link, err := podNl.LinkByName(overlayInterface)
+		if err == nil {
+			podRoutes, err = podNl.RouteList(link, ipfamily)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list routes of %s in pod: %w", overlayInterface, err)
+		}
+	}
+
+	hostRoutes, err := hostNl.RouteList(nil, ipfamily)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list routes on host: %w", err)
+	}
+
+	links, err := hostNl.LinkList()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list links on host: %w", err)
+	}
+	hostLinks := make(map[int]string, len(links))
+	for _, link := range links {
+		hostLinks[link.Attrs().Index] = link.Attrs().Name
+	}
+
+	return overlaySubnetsFromRoutes(podRoutes, hostRoutes, hostLinks), nil
+}
+
+// overlaySubnetsFromRoutes picks the overlay subnets from the given routes, the host routes and
+// default routes are ignored, and the subnet covered by another one is dropped.
+func overlaySubnetsFromRoutes(podRoutes, hostRoutes []netlink.Route, hostLinks map[int]string) []string {
+	var subnets []*net.IPNet
+	for _, route := range podRoutes {
+		if route.Table != unix.RT_TABLE_MAIN || !isSubnetRoute(route) {
+			continue
+		}
+		subnets = append(subnets, route.Dst)
+	}
+
+	for _, route := range hostRoutes {
+		if route.Table != unix.RT_TABLE_MAIN || !isSubnetRoute(route) {
+			continue
+		}
+		if route.Protocol == unix.RTPROT_BIRD || route.Protocol == calicoFelixRouteProtocol {
+			subnets = append(subnets, route.Dst)
+			continue
+		}
+		if route.Type != unix.RTN_BLACKHOLE && overlayHostDeviceRegexp.MatchString(hostLinks[route.LinkIndex]) {
+			subnets = append(subnets, route.Dst)
+		}
+	}
+
+	var result []string
+	for i, subnet := range subnets {
+		covered := false
+		for j, other := range subnets {
+			if i == j {
+				continue
+			}
+			ones, _ := subnet.Mask.Size()
+			otherOnes, _ := other.Mask.Size()
+			// drop the subnet if it's covered by a bigger one, or it's the duplicate of a previous one
+			if other.Contains(subnet.IP) && (otherOnes < ones || (otherOnes == ones && j < i)) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			result = append(result, subnet.String())
+		}
+	}
+	return result
+}
+
+// isSubnetRoute returns true if the route points to a subnet,
+// the default route, host route and link-local route are not
+func isSubnetRoute(route netlink.Route) bool {
+	if route.Dst == nil || route.Dst.IP.IsLinkLocalUnicast() || route.Dst.IP.IsMulticast() {
+		return false
+	}
+	ones, bits := route.Dst.Mask.Size()
+	return ones != 0 && ones != bits
+}
@@ -0,0 +1,166 @@
+package nl
+
+import (
+	"context"
+	"net"
+
+	"github.com/vishvananda/netlink"
+)
+
+// WithContext returns the Netlink which fails with the error of ctx once it's done,
+// so the remaining operations of an invocation are not made after its deadline.
+func WithContext(ctx context.Context, h Netlink) Netlink {
+	return &withContext{ctx: ctx, h: h}
+}
+
+type withContext struct {
+	ctx context.Context
+	h   Netlink
+}
+
+func (w *withContext) LinkByName(name string) (netlink.Link, error) {
+	if err := w.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return w.h.LinkByName(name)
+}
+
+func (w *withContext) LinkByIndex(index int) (netlink.Link, error) {
+	if err := w.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return w.h.LinkByIndex(index)
+}
+
+func (w *withContext) LinkList() ([]netlink.Link, error) {
+	if err := w.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return w.h.LinkList()
+}
+
+func (w *withContext) LinkSetHardwareAddr(link netlink.Link, hwaddr net.HardwareAddr) error {
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	return w.h.LinkSetHardwareAddr(link, hwaddr)
+}
+
+func (w *withContext) LinkAdd(link netlink.Link) error {
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	return w.h.LinkAdd(link)
+}
+
+func (w *withContext) LinkDel(link netlink.Link) error {
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	return w.h.LinkDel(link)
+}
+
+func (w *withContext) LinkSetUp(link netlink.Link) error {
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	return w.h.LinkSetUp(link)
+}
+
+func (w *withContext) LinkSetMasterByIndex(link netlink.Link, masterIndex int) error {
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	return w.h.LinkSetMasterByIndex(link, masterIndex)
+}
+
+func (w *withContext) VethPeerIndex(link netlink.Link) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.h.VethPeerIndex(link)
+}
+
+func (w *withContext) GetNetNsIdByFd(fd int) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.h.GetNetNsIdByFd(fd)
+}
+
+func (w *withContext) AddrList(link netlink.Link, family int) ([]netlink.Addr, error) {
+	if err := w.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return w.h.AddrList(link, family)
+}
+
+func (w *withContext) AddrAdd(link netlink.Link, addr *netlink.Addr) error {
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	return w.h.AddrAdd(link, addr)
+}
+
+func (w *withContext) RouteList(link netlink.Link, family int) ([]netlink.Route, error) {
+	if err := w.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return w.h.RouteList(link, family)
+}
+
+func (w *withContext) RouteAdd(route *netlink.Route) error {
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	return w.h.RouteAdd(route)
+}
+
+func (w *withContext) RouteDel(route *netlink.Route) error {
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	return w.h.RouteDel(route)
+}
+
+func (w *withContext) RuleList(family int) ([]netlink.Rule, error) {
+	if err := w.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return w.h.RuleList(family)
+}
+
+func (w *withContext) RuleAdd(rule *netlink.Rule) error {
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	return w.h.RuleAdd(rule)
+}
+
+func (w *withContext) RuleDel(rule *netlink.Rule) error {
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	return w.h.RuleDel(rule)
+}
+
+func (w *withContext) NeighList(linkIndex, family int) ([]netlink.Neigh, error) {
+	if err := w.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return w.h.NeighList(linkIndex, family)
+}
+
+func (w *withContext) NeighAdd(neigh *netlink.Neigh) error {
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	return w.h.NeighAdd(neigh)
+}
+
+func (w *withContext) NeighDel(neigh *netlink.Neigh) error {
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	return w.h.NeighDel(neigh)
+}
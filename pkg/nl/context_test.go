@@ -0,0 +1,28 @@
+package nl_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spidernet-io/cni-plugins/pkg/nl"
+	"github.com/vishvananda/netlink"
+)
+
+var _ = Describe("WithContext", func() {
+	It("operate until the context is done", func() {
+		fake := nl.NewFake()
+		ctx, cancel := context.WithCancel(context.Background())
+		h := nl.WithContext(ctx, fake)
+
+		link, err := h.LinkByName("lo")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(h.NeighAdd(&netlink.Neigh{LinkIndex: link.Attrs().Index})).To(Succeed())
+
+		cancel()
+		_, err = h.LinkByName("lo")
+		Expect(err).To(MatchError(context.Canceled))
+		Expect(h.RuleAdd(netlink.NewRule())).To(MatchError(context.Canceled))
+		Expect(fake.Rules).To(HaveLen(6), "nothing is changed after the context is done")
+	})
+})
@@ -0,0 +1,523 @@
+package nl
+
+import (
+	"errors"
+	"net"
+	"sort"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// the priorities of the default rules: from all lookup local/main/default
+const (
+	localRulePriority   = 0
+	mainRulePriority    = 32766
+	defaultRulePriority = 32767
+)
+
+// Fake is an in-memory Netlink which models a network namespace: the links, addresses, neighbors,
+// the routes of every table and the rules. Like the kernel, adding an existing route, rule or neighbor
+// returns EEXIST, and deleting a missing one returns an error.
+type Fake struct {
+	Links  []netlink.Link
+	Addrs  map[int][]netlink.Addr
+	Routes []netlink.Route
+	Rules  []netlink.Rule
+	Neighs []netlink.Neigh
+	// NsID is the netnsid which the namespace assigns to every other namespace, so the veth links
+	// with the zero NetNsID have their peers in the namespace of the fd given to GetNetNsIdByFd.
+	NsID int
+}
+
+var _ Netlink = &Fake{}
+
+// NewFake returns a Fake with the loopback interface and the default rules of ipv4 and ipv6
+func NewFake() *Fake {
+	f := &Fake{Addrs: map[int][]netlink.Addr{}}
+	f.AddLink(netlink.LinkAttrs{Name: "lo"})
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		for priority, table := range map[int]int{
+			localRulePriority:   unix.RT_TABLE_LOCAL,
+			mainRulePriority:    unix.RT_TABLE_MAIN,
+			defaultRulePriority: unix.RT_TABLE_DEFAULT,
+		} {
+			rule := netlink.NewRule()
+			rule.Family = family
+			rule.Priority = priority
+			rule.Table = table
+			f.Rules = append(f.Rules, *rule)
+		}
+	}
+	f.sortRules()
+	return f
+}
+
+// AddLink adds a dummy link with the given attrs, the index is allocated if it's not given
+func (f *Fake) AddLink(attrs netlink.LinkAttrs) netlink.Link {
+	link := &netlink.Dummy{LinkAttrs: f.linkAttrs(attrs)}
+	f.Links = append(f.Links, link)
+	return link
+}
+
+// AddVeth adds a veth link with the given attrs, like AddLink
+func (f *Fake) AddVeth(attrs netlink.LinkAttrs) netlink.Link {
+	link := &netlink.Veth{LinkAttrs: f.linkAttrs(attrs)}
+	f.Links = append(f.Links, link)
+	return link
+}
+
+func (f *Fake) linkAttrs(attrs netlink.LinkAttrs) netlink.LinkAttrs {
+	if attrs.Index == 0 {
+		attrs.Index = len(f.Links) + 1
+		for _, link := range f.Links {
+			if link.Attrs().Index >= attrs.Index {
+				attrs.Index = link.Attrs().Index + 1
+			}
+		}
+	}
+	if attrs.ParentIndex == 0 {
+		attrs.ParentIndex = -1
+	}
+	return attrs
+}
+
+// AddAddr adds the address such as "10.6.1.10/16" to the link, and the subnet route of it like the kernel
+func (f *Fake) AddAddr(name, cidr string) error {
+	link, err := f.LinkByName(name)
+	if err != nil {
+		return err
+	}
+	ip, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+	return f.AddrAdd(link, &netlink.Addr{IPNet: &net.IPNet{IP: ip, Mask: ipNet.Mask}})
+}
+
+// connectedRoute returns the subnet route of the address on the link, it's in the table of the vrf
+// which the link is enslaved to, like the kernel
+func (f *Fake) connectedRoute(link netlink.Link, addr netlink.Addr) *netlink.Route {
+	table := unix.RT_TABLE_MAIN
+	if master, err := f.LinkByIndex(link.Attrs().MasterIndex); err == nil {
+		if vrf, ok := master.(*netlink.Vrf); ok {
+			table = int(vrf.Table)
+		}
+	}
+	dst := &net.IPNet{IP: addr.IP.Mask(addr.Mask), Mask: addr.Mask}
+	return &netlink.Route{LinkIndex: link.Attrs().Index, Dst: dst, Src: addr.IP, Scope: netlink.SCOPE_LINK,
+		Protocol: unix.RTPROT_KERNEL, Table: table}
+}
+
+// RoutesInTable returns the routes of the given table
+func (f *Fake) RoutesInTable(table int) []netlink.Route {
+	var routes []netlink.Route
+	for _, route := range f.Routes {
+		if route.Table == table {
+			routes = append(routes, route)
+		}
+	}
+	return routes
+}
+
+func (f *Fake) LinkByName(name string) (netlink.Link, error) {
+	for _, link := range f.Links {
+		if link.Attrs().Name == name {
+			return link, nil
+		}
+	}
+	// the same message as netlink.LinkNotFoundError
+	return nil, errors.New("Link not found")
+}
+
+func (f *Fake) LinkByIndex(index int) (netlink.Link, error) {
+	for _, link := range f.Links {
+		if link.Attrs().Index == index {
+			return link, nil
+		}
+	}
+	return nil, errors.New("Link not found")
+}
+
+func (f *Fake) LinkList() ([]netlink.Link, error) {
+	return append([]netlink.Link{}, f.Links...), nil
+}
+
+func (f *Fake) LinkSetHardwareAddr(link netlink.Link, hwaddr net.HardwareAddr) error {
+	found, err := f.LinkByIndex(link.Attrs().Index)
+	if err != nil {
+		return err
+	}
+	found.Attrs().HardwareAddr = hwaddr
+	return nil
+}
+
+// LinkAdd adds the link, the index is allocated if it's not given
+func (f *Fake) LinkAdd(link netlink.Link) error {
+	if _, err := f.LinkByName(link.Attrs().Name); err == nil {
+		return unix.EEXIST
+	}
+	*link.Attrs() = f.linkAttrs(*link.Attrs())
+	f.Links = append(f.Links, link)
+	return nil
+}
+
+// LinkDel deletes the link with its addresses, routes and neighbors, like the kernel
+func (f *Fake) LinkDel(link netlink.Link) error {
+	index := link.Attrs().Index
+	if _, err := f.LinkByIndex(index); err != nil {
+		return err
+	}
+	var links []netlink.Link
+	for _, l := range f.Links {
+		if l.Attrs().Index != index {
+			links = append(links, l)
+		}
+	}
+	f.Links = links
+	delete(f.Addrs, index)
+	var routes []netlink.Route
+	for _, route := range f.Routes {
+		if route.LinkIndex != index {
+			routes = append(routes, route)
+		}
+	}
+	f.Routes = routes
+	var neighs []netlink.Neigh
+	for _, neigh := range f.Neighs {
+		if neigh.LinkIndex != index {
+			neighs = append(neighs, neigh)
+		}
+	}
+	f.Neighs = neighs
+	return nil
+}
+
+func (f *Fake) LinkSetUp(link netlink.Link) error {
+	found, err := f.LinkByIndex(link.Attrs().Index)
+	if err != nil {
+		return err
+	}
+	found.Attrs().Flags |= net.FlagUp
+	found.Attrs().OperState = netlink.OperUp
+	return nil
+}
+
+// LinkSetMasterByIndex sets the master of link. Like the kernel, enslaving the link to a vrf cycles it:
+// the routes via it are flushed, so are the ipv6 addresses, and the subnet routes of the ipv4 addresses
+// are added to the table of the vrf.
+func (f *Fake) LinkSetMasterByIndex(link netlink.Link, masterIndex int) error {
+	found, err := f.LinkByIndex(link.Attrs().Index)
+	if err != nil {
+		return err
+	}
+	master, err := f.LinkByIndex(masterIndex)
+	if err != nil {
+		return unix.ENODEV
+	}
+	found.Attrs().MasterIndex = masterIndex
+	if _, ok := master.(*netlink.Vrf); !ok {
+		return nil
+	}
+
+	index := found.Attrs().Index
+	routes := f.Routes[:0]
+	for _, route := range f.Routes {
+		if route.LinkIndex == index {
+			continue
+		}
+		if len(route.MultiPath) != 0 {
+			paths := make([]*netlink.NexthopInfo, 0, len(route.MultiPath))
+			for _, path := range route.MultiPath {
+				if path.LinkIndex != index {
+					paths = append(paths, path)
+				}
+			}
+			if len(paths) == 0 {
+				continue
+			}
+			route.MultiPath = paths
+		}
+		routes = append(routes, route)
+	}
+	f.Routes = routes
+
+	var addrs []netlink.Addr
+	for _, addr := range f.Addrs[index] {
+		if addr.IP.To4() == nil && !addr.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		addrs = append(addrs, addr)
+		if addr.IP.To4() != nil {
+			f.Routes = append(f.Routes, *f.connectedRoute(found, addr))
+		}
+	}
+	f.Addrs[index] = addrs
+	return nil
+}
+
+// VethPeerIndex returns the ParentIndex of the veth link, as the kernel reports it in IFLA_LINK as well
+func (f *Fake) VethPeerIndex(link netlink.Link) (int, error) {
+	found, err := f.LinkByIndex(link.Attrs().Index)
+	if err != nil {
+		return 0, err
+	}
+	if found.Type() != "veth" || found.Attrs().ParentIndex <= 0 {
+		return 0, errors.New("no peer_ifindex")
+	}
+	return found.Attrs().ParentIndex, nil
+}
+
+func (f *Fake) GetNetNsIdByFd(fd int) (int, error) {
+	return f.NsID, nil
+}
+
+func (f *Fake) AddrList(link netlink.Link, family int) ([]netlink.Addr, error) {
+	var addrs []netlink.Addr
+	for _, l := range f.Links {
+		if link != nil && l.Attrs().Index != link.Attrs().Index {
+			continue
+		}
+		for _, addr := range f.Addrs[l.Attrs().Index] {
+			if matchFamily(family, addr.IP) {
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+	return addrs, nil
+}
+
+// AddrAdd adds the address and the subnet route of it to the link, like the kernel
+func (f *Fake) AddrAdd(link netlink.Link, addr *netlink.Addr) error {
+	found, err := f.LinkByIndex(link.Attrs().Index)
+	if err != nil {
+		return unix.ENODEV
+	}
+	index := found.Attrs().Index
+	for _, existing := range f.Addrs[index] {
+		if existing.IP.Equal(addr.IP) {
+			return unix.EEXIST
+		}
+	}
+	f.Addrs[index] = append(f.Addrs[index], *addr)
+	return f.RouteAdd(f.connectedRoute(found, *addr))
+}
+
+func (f *Fake) RouteList(link netlink.Link, family int) ([]netlink.Route, error) {
+	var routes []netlink.Route
+	for _, route := range f.Routes {
+		if route.Table != unix.RT_TABLE_MAIN || !matchFamily(family, routeIP(&route)) {
+			continue
+		}
+		if link != nil && route.LinkIndex != link.Attrs().Index {
+			continue
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+func (f *Fake) RouteAdd(route *netlink.Route) error {
+	r := *route
+	if r.Table == 0 {
+		r.Table = unix.RT_TABLE_MAIN
+	}
+	if r.LinkIndex != 0 {
+		if _, err := f.LinkByIndex(r.LinkIndex); err != nil {
+			return unix.ENODEV
+		}
+	}
+	for _, existing := range f.Routes {
+		if existing.Table == r.Table && existing.Priority == r.Priority && ipNetEqual(existing.Dst, r.Dst) && sameFamily(&existing, &r) {
+			return unix.EEXIST
+		}
+	}
+	f.Routes = append(f.Routes, r)
+	return nil
+}
+
+func (f *Fake) RouteDel(route *netlink.Route) error {
+	table := route.Table
+	if table == 0 {
+		table = unix.RT_TABLE_MAIN
+	}
+	for i, existing := range f.Routes {
+		if existing.Table != table || !ipNetEqual(existing.Dst, route.Dst) || !sameFamily(&existing, route) {
+			continue
+		}
+		if len(existing.MultiPath) != 0 && len(route.MultiPath) == 0 {
+			// like ipv6, delete the matched nexthop of multipath route
+			for j, path := range existing.MultiPath {
+				if matchNexthop(route, path.LinkIndex, path.Gw) {
+					f.Routes[i].MultiPath = append(existing.MultiPath[:j:j], existing.MultiPath[j+1:]...)
+					if len(f.Routes[i].MultiPath) == 0 {
+						f.Routes = append(f.Routes[:i], f.Routes[i+1:]...)
+					}
+					return nil
+				}
+			}
+			continue
+		}
+		if !matchNexthop(route, existing.LinkIndex, existing.Gw) {
+			continue
+		}
+		f.Routes = append(f.Routes[:i], f.Routes[i+1:]...)
+		return nil
+	}
+	return unix.ESRCH
+}
+
+// matchNexthop returns true if the nexthop matches the link and gateway given by the route
+func matchNexthop(route *netlink.Route, linkIndex int, gw net.IP) bool {
+	if route.LinkIndex != 0 && linkIndex != route.LinkIndex {
+		return false
+	}
+	return route.Gw == nil || gw.Equal(route.Gw)
+}
+
+func (f *Fake) RuleList(family int) ([]netlink.Rule, error) {
+	var rules []netlink.Rule
+	for _, rule := range f.Rules {
+		if family == netlink.FAMILY_ALL || rule.Family == family {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+func (f *Fake) RuleAdd(rule *netlink.Rule) error {
+	r := *rule
+	if r.Family == 0 {
+		r.Family = netlink.FAMILY_V4
+		if (r.Src != nil && r.Src.IP.To4() == nil) || (r.Dst != nil && r.Dst.IP.To4() == nil) {
+			r.Family = netlink.FAMILY_V6
+		}
+	}
+	for _, existing := range f.Rules {
+		if rule.Priority >= 0 && existing.Priority != rule.Priority {
+			continue
+		}
+		// the kernel tells the rules by their protocols as well
+		if existing.Family == r.Family && existing.Table == r.Table && existing.Protocol == r.Protocol && ipNetEqual(existing.Src, r.Src) && ipNetEqual(existing.Dst, r.Dst) {
+			return unix.EEXIST
+		}
+	}
+	if r.Priority < 0 {
+		r.Priority = f.defaultRulePriority(r.Family)
+	}
+	f.Rules = append(f.Rules, r)
+	f.sortRules()
+	return nil
+}
+
+func (f *Fake) RuleDel(rule *netlink.Rule) error {
+	for i, existing := range f.Rules {
+		if rule.Family != 0 && existing.Family != rule.Family {
+			continue
+		}
+		if rule.Priority >= 0 && existing.Priority != rule.Priority {
+			continue
+		}
+		if rule.Table > 0 && existing.Table != rule.Table {
+			continue
+		}
+		if rule.Protocol != 0 && existing.Protocol != rule.Protocol {
+			continue
+		}
+		if (rule.Src != nil && !ipNetEqual(existing.Src, rule.Src)) || (rule.Dst != nil && !ipNetEqual(existing.Dst, rule.Dst)) {
+			continue
+		}
+		f.Rules = append(f.Rules[:i], f.Rules[i+1:]...)
+		return nil
+	}
+	return unix.ENOENT
+}
+
+func (f *Fake) NeighList(linkIndex, family int) ([]netlink.Neigh, error) {
+	var neighs []netlink.Neigh
+	for _, neigh := range f.Neighs {
+		if (linkIndex == 0 || neigh.LinkIndex == linkIndex) && matchFamily(family, neigh.IP) {
+			neighs = append(neighs, neigh)
+		}
+	}
+	return neighs, nil
+}
+
+func (f *Fake) NeighAdd(neigh *netlink.Neigh) error {
+	if _, err := f.LinkByIndex(neigh.LinkIndex); err != nil {
+		return unix.ENODEV
+	}
+	for _, existing := range f.Neighs {
+		if existing.LinkIndex == neigh.LinkIndex && existing.IP.Equal(neigh.IP) {
+			return unix.EEXIST
+		}
+	}
+	f.Neighs = append(f.Neighs, *neigh)
+	return nil
+}
+
+func (f *Fake) NeighDel(neigh *netlink.Neigh) error {
+	for i, existing := range f.Neighs {
+		if existing.LinkIndex == neigh.LinkIndex && existing.IP.Equal(neigh.IP) {
+			f.Neighs = append(f.Neighs[:i], f.Neighs[i+1:]...)
+			return nil
+		}
+	}
+	return unix.ENOENT
+}
+
+// defaultRulePriority returns the priority of the rule added without priority,
+// like the kernel, it's the priority of the second rule minus 1.
+func (f *Fake) defaultRulePriority(family int) int {
+	rules, _ := f.RuleList(family)
+	if len(rules) > 1 && rules[1].Priority > 0 {
+		return rules[1].Priority - 1
+	}
+	return 0
+}
+
+func (f *Fake) sortRules() {
+	sort.SliceStable(f.Rules, func(i, j int) bool {
+		return f.Rules[i].Priority < f.Rules[j].Priority
+	})
+}
+
+// routeIP returns an ip of the route to tell its family
+func routeIP(route *netlink.Route) net.IP {
+	if route.Dst != nil {
+		return route.Dst.IP
+	}
+	if route.Gw != nil {
+		return route.Gw
+	}
+	for _, path := range route.MultiPath {
+		if path.Gw != nil {
+			return path.Gw
+		}
+	}
+	if route.Family == netlink.FAMILY_V6 {
+		return net.IPv6zero
+	}
+	return net.IPv4zero
+}
+
+func sameFamily(a, b *netlink.Route) bool {
+	return (routeIP(a).To4() == nil) == (routeIP(b).To4() == nil)
+}
+
+func matchFamily(family int, ip net.IP) bool {
+	switch family {
+	case netlink.FAMILY_V4:
+		return ip.To4() != nil
+	case netlink.FAMILY_V6:
+		return ip.To4() == nil
+	}
+	return true
+}
+
+func ipNetEqual(a, b *net.IPNet) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.String() == b.String()
+}
@@ -0,0 +1,151 @@
+package nl_test
+
+import (
+	"net"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spidernet-io/cni-plugins/pkg/nl"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+var _ = Describe("Fake", func() {
+	var fake *nl.Fake
+	var eth0 netlink.Link
+
+	mustParseCIDR := func(s string) *net.IPNet {
+		_, ipNet, err := net.ParseCIDR(s)
+		Expect(err).NotTo(HaveOccurred())
+		return ipNet
+	}
+
+	BeforeEach(func() {
+		fake = nl.NewFake()
+		eth0 = fake.AddLink(netlink.LinkAttrs{Name: "eth0"})
+		Expect(fake.AddAddr("eth0", "10.6.1.10/16")).To(Succeed())
+	})
+
+	Context("Test links and addresses", func() {
+		It("allocate the index", func() {
+			Expect(eth0.Attrs().Index).To(Equal(2))
+			link, err := fake.LinkByIndex(2)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(link.Attrs().Name).To(Equal("eth0"))
+		})
+
+		It("link not found", func() {
+			_, err := fake.LinkByName("eth1")
+			Expect(err).To(MatchError("Link not found"))
+		})
+
+		It("add the subnet route with the address", func() {
+			addrs, _ := fake.AddrList(eth0, netlink.FAMILY_V6)
+			Expect(addrs).To(BeEmpty())
+			routes, _ := fake.RouteList(eth0, netlink.FAMILY_V4)
+			Expect(routes).To(HaveLen(1))
+			Expect(routes[0].Dst.String()).To(Equal("10.6.0.0/16"))
+		})
+
+		It("delete the link with its addresses and routes", func() {
+			Expect(fake.LinkDel(eth0)).To(Succeed())
+			_, err := fake.LinkByName("eth0")
+			Expect(err).To(MatchError("Link not found"))
+			Expect(fake.Addrs).NotTo(HaveKey(eth0.Attrs().Index))
+			Expect(fake.Routes).To(BeEmpty())
+			Expect(fake.LinkDel(eth0)).To(MatchError("Link not found"))
+		})
+	})
+
+	Context("Test vrf", func() {
+		It("enslaving the link to a vrf cycles it", func() {
+			vrf := &netlink.Vrf{LinkAttrs: netlink.LinkAttrs{Name: "vrf-eth0"}, Table: 100}
+			Expect(fake.LinkAdd(vrf)).To(Succeed())
+			Expect(fake.LinkAdd(&netlink.Vrf{LinkAttrs: netlink.LinkAttrs{Name: "vrf-eth0"}})).To(MatchError(unix.EEXIST))
+			Expect(fake.LinkSetUp(vrf)).To(Succeed())
+			Expect(vrf.Attrs().Flags & net.FlagUp).NotTo(BeZero())
+
+			Expect(fake.AddAddr("eth0", "fd00:10:6::10/64")).To(Succeed())
+			Expect(fake.RouteAdd(&netlink.Route{LinkIndex: eth0.Attrs().Index, Gw: net.ParseIP("10.6.0.1")})).To(Succeed())
+			Expect(fake.LinkSetMasterByIndex(eth0, vrf.Attrs().Index)).To(Succeed())
+			Expect(eth0.Attrs().MasterIndex).To(Equal(vrf.Attrs().Index))
+
+			routes, _ := fake.RouteList(nil, netlink.FAMILY_ALL)
+			Expect(routes).To(BeEmpty())
+			Expect(fake.RoutesInTable(100)).To(HaveLen(1))
+			Expect(fake.RoutesInTable(100)[0].Dst.String()).To(Equal("10.6.0.0/16"))
+			addrs, _ := fake.AddrList(eth0, netlink.FAMILY_V6)
+			Expect(addrs).To(BeEmpty())
+
+			// the address added to the slave has the subnet route in the table of vrf
+			Expect(fake.AddrAdd(eth0, &netlink.Addr{IPNet: mustParseCIDR("fd00:10:6::/64")})).To(Succeed())
+			Expect(fake.AddrAdd(eth0, &netlink.Addr{IPNet: mustParseCIDR("fd00:10:6::/64")})).To(MatchError(unix.EEXIST))
+			Expect(fake.RoutesInTable(100)).To(HaveLen(2))
+		})
+	})
+
+	Context("Test routes", func() {
+		It("route list only returns the routes of main table", func() {
+			Expect(fake.RouteAdd(&netlink.Route{LinkIndex: eth0.Attrs().Index, Gw: net.ParseIP("10.6.0.1"), Table: 100})).To(Succeed())
+			routes, _ := fake.RouteList(nil, netlink.FAMILY_ALL)
+			Expect(routes).To(HaveLen(1))
+			Expect(fake.RoutesInTable(100)).To(HaveLen(1))
+		})
+
+		It("add the existing route return EEXIST", func() {
+			route := &netlink.Route{LinkIndex: eth0.Attrs().Index, Dst: mustParseCIDR("10.7.0.0/16"), Gw: net.ParseIP("10.6.0.1")}
+			Expect(fake.RouteAdd(route)).To(Succeed())
+			Expect(fake.RouteAdd(route)).To(MatchError(unix.EEXIST))
+		})
+
+		It("del the missing route return ESRCH", func() {
+			Expect(fake.RouteDel(&netlink.Route{Dst: mustParseCIDR("10.7.0.0/16")})).To(MatchError(unix.ESRCH))
+			Expect(fake.RouteDel(&netlink.Route{Dst: mustParseCIDR("10.6.0.0/16"), LinkIndex: eth0.Attrs().Index})).To(Succeed())
+		})
+
+		It("the link of route must exist", func() {
+			Expect(fake.RouteAdd(&netlink.Route{LinkIndex: 10, Dst: mustParseCIDR("10.7.0.0/16")})).To(MatchError(unix.ENODEV))
+		})
+	})
+
+	Context("Test rules", func() {
+		It("the rule without priority is added before main table", func() {
+			rule := netlink.NewRule()
+			rule.Dst = mustParseCIDR("10.96.0.0/16")
+			rule.Table = 100
+			Expect(fake.RuleAdd(rule)).To(Succeed())
+
+			rule = netlink.NewRule()
+			rule.Dst = mustParseCIDR("10.244.0.0/16")
+			rule.Table = 100
+			Expect(fake.RuleAdd(rule)).To(Succeed())
+			Expect(fake.RuleAdd(rule)).To(MatchError(unix.EEXIST))
+
+			rules, _ := fake.RuleList(netlink.FAMILY_V4)
+			Expect(rules).To(HaveLen(5))
+			Expect(rules[1].Priority).To(Equal(32764))
+			Expect(rules[2].Priority).To(Equal(32765))
+		})
+
+		It("del the rule", func() {
+			rule := netlink.NewRule()
+			rule.Src = mustParseCIDR("10.6.1.10/32")
+			rule.Table = 100
+			Expect(fake.RuleDel(rule)).To(MatchError(unix.ENOENT))
+			Expect(fake.RuleAdd(rule)).To(Succeed())
+			Expect(fake.RuleDel(rule)).To(Succeed())
+		})
+	})
+
+	Context("Test neighbors", func() {
+		It("add and del neighbor", func() {
+			neigh := &netlink.Neigh{LinkIndex: eth0.Attrs().Index, IP: net.ParseIP("10.6.0.1"), HardwareAddr: net.HardwareAddr{0x0a, 0x1b, 0, 0, 0, 1}}
+			Expect(fake.NeighAdd(neigh)).To(Succeed())
+			Expect(fake.NeighAdd(neigh)).To(MatchError(unix.EEXIST))
+			neighs, _ := fake.NeighList(0, netlink.FAMILY_V4)
+			Expect(neighs).To(HaveLen(1))
+			Expect(fake.NeighDel(neigh)).To(Succeed())
+			Expect(fake.NeighDel(neigh)).To(MatchError(unix.ENOENT))
+		})
+	})
+})
@@ -0,0 +1,129 @@
+package nl
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/safchain/ethtool"
+	"github.com/vishvananda/netlink"
+	vnl "github.com/vishvananda/netlink/nl"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+// Handle is the Netlink bound to a network namespace by its netlink socket. The socket is opened in the
+// namespace once and reused by every operation, so unlike NewAt, the operations never switch the namespace
+// of the thread. It must be closed after use.
+type Handle struct {
+	*netlink.Handle
+	// the socket for the filtered dumps, the ones of netlink.Handle are not exported
+	route *vnl.SocketHandle
+	// the socket for the ethtool ioctls, it's opened in the namespace as well
+	ethtool *ethtool.Ethtool
+}
+
+var _ Netlink = &Handle{}
+
+// NewHandle returns the Handle of the current network namespace.
+// if ctx has a deadline, an operation of the handle never blocks past it.
+func NewHandle(ctx context.Context) (*Handle, error) {
+	h, err := netlink.NewHandle(unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create netlink handle: %w", err)
+	}
+	route, err := vnl.GetNetlinkSocketAt(netns.None(), netns.None(), unix.NETLINK_ROUTE)
+	if err != nil {
+		h.Close()
+		return nil, fmt.Errorf("failed to create netlink socket: %w", err)
+	}
+	e, err := ethtool.NewEthtool()
+	if err != nil {
+		h.Close()
+		route.Close()
+		return nil, fmt.Errorf("failed to create ethtool socket: %w", err)
+	}
+	return newHandle(ctx, h, route, e)
+}
+
+// NewHandleAt returns the Handle of the given network namespace.
+// if ctx has a deadline, an operation of the handle never blocks past it.
+func NewHandleAt(ctx context.Context, netNS ns.NetNS) (*Handle, error) {
+	nsHandle, err := netns.GetFromPath(netNS.Path())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open netns %s: %w", netNS.Path(), err)
+	}
+	// the sockets keep the namespace alive, the handle of namespace is not needed any more
+	defer nsHandle.Close()
+
+	h, err := netlink.NewHandleAt(nsHandle, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create netlink handle in netns %s: %w", netNS.Path(), err)
+	}
+	route, err := vnl.GetNetlinkSocketAt(nsHandle, netns.None(), unix.NETLINK_ROUTE)
+	if err != nil {
+		h.Close()
+		return nil, fmt.Errorf("failed to create netlink socket in netns %s: %w", netNS.Path(), err)
+	}
+	// the ioctl socket has to be created in the namespace, like the netlink sockets above
+	var e *ethtool.Ethtool
+	err = netNS.Do(func(_ ns.NetNS) error {
+		e, err = ethtool.NewEthtool()
+		return err
+	})
+	if err != nil {
+		h.Close()
+		route.Close()
+		return nil, fmt.Errorf("failed to create ethtool socket in netns %s: %w", netNS.Path(), err)
+	}
+	return newHandle(ctx, h, route, e)
+}
+
+func newHandle(ctx context.Context, h *netlink.Handle, route *vnl.NetlinkSocket, e *ethtool.Ethtool) (*Handle, error) {
+	handle := &Handle{Handle: h, route: &vnl.SocketHandle{Socket: route}, ethtool: e}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return handle, nil
+	}
+
+	timeout := time.Until(deadline)
+	if timeout < time.Microsecond {
+		handle.Close()
+		return nil, context.DeadlineExceeded
+	}
+	tv := unix.NsecToTimeval(timeout.Nanoseconds())
+	if err := h.SetSocketTimeout(timeout); err != nil {
+		handle.Close()
+		return nil, fmt.Errorf("failed to set the timeout of netlink socket: %w", err)
+	}
+	if err := route.SetSendTimeout(&tv); err != nil {
+		handle.Close()
+		return nil, fmt.Errorf("failed to set the timeout of netlink socket: %w", err)
+	}
+	if err := route.SetReceiveTimeout(&tv); err != nil {
+		handle.Close()
+		return nil, fmt.Errorf("failed to set the timeout of netlink socket: %w", err)
+	}
+	return handle, nil
+}
+
+// NeighList of a link only dumps the neighbors of the link from the kernel
+func (h *Handle) NeighList(linkIndex, family int) ([]netlink.Neigh, error) {
+	if linkIndex == 0 {
+		return h.Handle.NeighList(linkIndex, family)
+	}
+	return neighList(h.route, linkIndex, family)
+}
+
+// VethPeerIndex returns the peer_ifindex of the veth device from ethtool
+func (h *Handle) VethPeerIndex(link netlink.Link) (int, error) {
+	return vethPeerIndex(h.ethtool, link.Attrs().Name)
+}
+
+// Close closes the sockets of the handle
+func (h *Handle) Close() {
+	h.Handle.Close()
+	h.route.Close()
+	h.ethtool.Close()
+}
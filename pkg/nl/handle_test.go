@@ -0,0 +1,119 @@
+package nl_test
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+	"github.com/spidernet-io/cni-plugins/pkg/nl"
+	"github.com/vishvananda/netlink"
+)
+
+// addOperations does the netlink reads of a cmdAdd in the pod netns: list the addresses of all interfaces,
+// the addresses of the chained and overlay interfaces, the routes to migrate and the existing rules.
+// only the loopback is used, it's always there and the cost of the operations is the same.
+func addOperations(h nl.Netlink) error {
+	links, err := h.LinkList()
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		if _, err = h.AddrList(link, netlink.FAMILY_ALL); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < 3; i++ {
+		link, err := h.LinkByName("lo")
+		if err != nil {
+			return err
+		}
+		if _, err = h.AddrList(link, netlink.FAMILY_ALL); err != nil {
+			return err
+		}
+	}
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		if _, err = h.RouteList(nil, family); err != nil {
+			return err
+		}
+		if _, err = h.RuleList(family); err != nil {
+			return err
+		}
+	}
+	_, err = h.NeighList(0, netlink.FAMILY_ALL)
+	return err
+}
+
+func newBenchmarkNetNS(b *testing.B) ns.NetNS {
+	if os.Geteuid() != 0 {
+		b.Skip("creating network namespace requires root")
+	}
+	netNS, err := testutils.NewNS()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() {
+		_ = netNS.Close()
+		_ = testutils.UnmountNS(netNS)
+	})
+
+	err = netNS.Do(func(_ ns.NetNS) error {
+		link, err := netlink.LinkByName("lo")
+		if err != nil {
+			return err
+		}
+		if err = netlink.LinkSetUp(link); err != nil {
+			return err
+		}
+		for i := 0; i < 2; i++ {
+			addr := &netlink.Addr{IPNet: &net.IPNet{IP: net.IPv4(10, 6, byte(i), 10), Mask: net.CIDRMask(16, 32)}}
+			if err = netlink.AddrAdd(link, addr); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return netNS
+}
+
+// BenchmarkAddNetnsDo enters the pod netns for every operation, like the plugins did with netns.Do
+func BenchmarkAddNetnsDo(b *testing.B) {
+	netNS := newBenchmarkNetNS(b)
+	b.SetParallelism(8)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := addOperations(nl.NewAt(netNS)); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+// BenchmarkAddHandle creates a Handle of the pod netns for every ADD, and does all operations with it
+func BenchmarkAddHandle(b *testing.B) {
+	netNS := newBenchmarkNetNS(b)
+	b.SetParallelism(8)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			h, err := nl.NewHandleAt(context.Background(), netNS)
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			err = addOperations(h)
+			h.Close()
+			if err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
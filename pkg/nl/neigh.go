@@ -0,0 +1,39 @@
+package nl
+
+import (
+	"github.com/vishvananda/netlink"
+	vnl "github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// neighList dumps the neighbors of the given link. The kernel ignores the link index of the ndmsg and
+// sends the whole neighbor table, netlink.NeighList only filters them afterwards, so the dump is filtered
+// by the NDA_IFINDEX attribute instead, and only the neighbors of the link are copied from the kernel.
+// the dump is made on the given socket, or a socket of the current network namespace if it's nil.
+func neighList(socket *vnl.SocketHandle, linkIndex, family int) ([]netlink.Neigh, error) {
+	req := vnl.NewNetlinkRequest(unix.RTM_GETNEIGH, unix.NLM_F_DUMP)
+	if socket != nil {
+		req.Sockets = map[int]*vnl.SocketHandle{unix.NETLINK_ROUTE: socket}
+	}
+	req.AddData(&netlink.Ndmsg{Family: uint8(family), Index: uint32(linkIndex)})
+	req.AddData(vnl.NewRtAttr(netlink.NDA_IFINDEX, vnl.Uint32Attr(uint32(linkIndex))))
+
+	msgs, err := req.Execute(unix.NETLINK_ROUTE, unix.RTM_NEWNEIGH)
+	if err != nil {
+		return nil, err
+	}
+
+	var neighs []netlink.Neigh
+	for _, m := range msgs {
+		neigh, err := netlink.NeighDeserialize(m)
+		if err != nil {
+			return nil, err
+		}
+		// the kernels before 4.15 don't support the filter
+		if neigh.LinkIndex != linkIndex {
+			continue
+		}
+		neighs = append(neighs, *neigh)
+	}
+	return neighs, nil
+}
@@ -0,0 +1,84 @@
+package nl_test
+
+import (
+	"context"
+	"net"
+	"os"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spidernet-io/cni-plugins/pkg/nl"
+	"github.com/vishvananda/netlink"
+)
+
+var _ = Describe("NeighList", func() {
+	var netNS ns.NetNS
+	var veth0, veth1 netlink.Link
+
+	BeforeEach(func() {
+		if os.Geteuid() != 0 {
+			Skip("creating network namespace requires root")
+		}
+		var err error
+		netNS, err = testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(func() {
+			Expect(netNS.Close()).To(Succeed())
+			Expect(testutils.UnmountNS(netNS)).To(Succeed())
+		})
+
+		err = netNS.Do(func(_ ns.NetNS) error {
+			if err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "veth0"}, PeerName: "veth1"}); err != nil {
+				return err
+			}
+			for i, name := range []string{"veth0", "veth1"} {
+				link, err := netlink.LinkByName(name)
+				if err != nil {
+					return err
+				}
+				for _, ip := range []string{"10.6.0.10", "fd00:10:6::10"} {
+					neigh := &netlink.Neigh{
+						LinkIndex:    link.Attrs().Index,
+						State:        netlink.NUD_PERMANENT,
+						IP:           net.ParseIP(ip),
+						HardwareAddr: net.HardwareAddr{0x80, 0x80, 0, 0, 0, byte(i)},
+					}
+					if err := netlink.NeighAdd(neigh); err != nil {
+						return err
+					}
+				}
+			}
+			veth0, _ = netlink.LinkByName("veth0")
+			veth1, _ = netlink.LinkByName("veth1")
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("only return the neighbors of the link", func() {
+		handle, err := nl.NewHandleAt(context.Background(), netNS)
+		Expect(err).NotTo(HaveOccurred())
+		defer handle.Close()
+
+		for _, h := range []nl.Netlink{handle, nl.NewAt(netNS)} {
+			neighs, err := h.NeighList(veth0.Attrs().Index, netlink.FAMILY_V4)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(neighs).To(HaveLen(1))
+			Expect(neighs[0].IP.String()).To(Equal("10.6.0.10"))
+			Expect(neighs[0].LinkIndex).To(Equal(veth0.Attrs().Index))
+
+			neighs, err = h.NeighList(veth1.Attrs().Index, netlink.FAMILY_ALL)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(neighs).To(HaveLen(2))
+			for _, neigh := range neighs {
+				Expect(neigh.LinkIndex).To(Equal(veth1.Attrs().Index))
+			}
+
+			neighs, err = h.NeighList(0, netlink.FAMILY_V6)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(neighs).To(ContainElement(HaveField("IP", Equal(net.ParseIP("fd00:10:6::10")))))
+		}
+	})
+})
@@ -0,0 +1,252 @@
+// Package nl abstracts the netlink operations used by the plugins, so the networking logic
+// can be tested with the in-memory Fake instead of a real network namespace.
+package nl
+
+import (
+	"net"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/vishvananda/netlink"
+)
+
+// Netlink is the link, address, route, rule and neighbor operations of a network namespace,
+// the methods are the same as the functions of github.com/vishvananda/netlink.
+type Netlink interface {
+	LinkByName(name string) (netlink.Link, error)
+	LinkByIndex(index int) (netlink.Link, error)
+	LinkList() ([]netlink.Link, error)
+	LinkSetHardwareAddr(link netlink.Link, hwaddr net.HardwareAddr) error
+	LinkAdd(link netlink.Link) error
+	LinkDel(link netlink.Link) error
+	LinkSetUp(link netlink.Link) error
+	LinkSetMasterByIndex(link netlink.Link, masterIndex int) error
+	// VethPeerIndex returns the index of the veth peer of the link from ethtool, which is valid in the namespace of the peer
+	VethPeerIndex(link netlink.Link) (int, error)
+	// GetNetNsIdByFd returns the netnsid which the namespace assigns to the namespace of fd, or -1 if there is none
+	GetNetNsIdByFd(fd int) (int, error)
+
+	AddrList(link netlink.Link, family int) ([]netlink.Addr, error)
+	AddrAdd(link netlink.Link, addr *netlink.Addr) error
+
+	// RouteList only returns the routes of main table, like `ip route`
+	RouteList(link netlink.Link, family int) ([]netlink.Route, error)
+	RouteAdd(route *netlink.Route) error
+	RouteDel(route *netlink.Route) error
+
+	RuleList(family int) ([]netlink.Rule, error)
+	RuleAdd(rule *netlink.Rule) error
+	RuleDel(rule *netlink.Rule) error
+
+	// NeighList of a link only dumps the neighbors of the link from the kernel, rather than the whole table
+	NeighList(linkIndex, family int) ([]netlink.Neigh, error)
+	NeighAdd(neigh *netlink.Neigh) error
+	NeighDel(neigh *netlink.Neigh) error
+}
+
+// New returns the Netlink of the current network namespace
+func New() Netlink {
+	return current{}
+}
+
+// NewAt returns the Netlink of the given network namespace, it enters the namespace for every operation.
+// NewHandleAt is cheaper when there are many operations.
+func NewAt(netns ns.NetNS) Netlink {
+	return &namespaced{netns: netns}
+}
+
+// current calls the functions of netlink in the current network namespace
+type current struct{}
+
+func (current) LinkByName(name string) (netlink.Link, error) { return netlink.LinkByName(name) }
+func (current) LinkByIndex(index int) (netlink.Link, error)  { return netlink.LinkByIndex(index) }
+func (current) LinkList() ([]netlink.Link, error)            { return netlink.LinkList() }
+func (current) LinkSetHardwareAddr(link netlink.Link, hwaddr net.HardwareAddr) error {
+	return netlink.LinkSetHardwareAddr(link, hwaddr)
+}
+func (current) LinkAdd(link netlink.Link) error   { return netlink.LinkAdd(link) }
+func (current) LinkDel(link netlink.Link) error   { return netlink.LinkDel(link) }
+func (current) LinkSetUp(link netlink.Link) error { return netlink.LinkSetUp(link) }
+func (current) LinkSetMasterByIndex(link netlink.Link, masterIndex int) error {
+	return netlink.LinkSetMasterByIndex(link, masterIndex)
+}
+func (current) VethPeerIndex(link netlink.Link) (int, error) {
+	return vethPeerIndex(nil, link.Attrs().Name)
+}
+func (current) GetNetNsIdByFd(fd int) (int, error) { return netlink.GetNetNsIdByFd(fd) }
+func (current) AddrList(link netlink.Link, family int) ([]netlink.Addr, error) {
+	return netlink.AddrList(link, family)
+}
+func (current) AddrAdd(link netlink.Link, addr *netlink.Addr) error {
+	return netlink.AddrAdd(link, addr)
+}
+func (current) RouteList(link netlink.Link, family int) ([]netlink.Route, error) {
+	return netlink.RouteList(link, family)
+}
+func (current) RouteAdd(route *netlink.Route) error         { return netlink.RouteAdd(route) }
+func (current) RouteDel(route *netlink.Route) error         { return netlink.RouteDel(route) }
+func (current) RuleList(family int) ([]netlink.Rule, error) { return netlink.RuleList(family) }
+func (current) RuleAdd(rule *netlink.Rule) error            { return netlink.RuleAdd(rule) }
+func (current) RuleDel(rule *netlink.Rule) error            { return netlink.RuleDel(rule) }
+func (current) NeighAdd(neigh *netlink.Neigh) error         { return netlink.NeighAdd(neigh) }
+func (current) NeighDel(neigh *netlink.Neigh) error         { return netlink.NeighDel(neigh) }
+func (current) NeighList(linkIndex, family int) ([]netlink.Neigh, error) {
+	if linkIndex == 0 {
+		return netlink.NeighList(linkIndex, family)
+	}
+	return neighList(nil, linkIndex, family)
+}
+
+// namespaced calls the functions of netlink in the given network namespace
+type namespaced struct {
+	netns ns.NetNS
+}
+
+func (n *namespaced) do(f func(h current) error) error {
+	return n.netns.Do(func(_ ns.NetNS) error {
+		return f(current{})
+	})
+}
+
+func (n *namespaced) LinkByName(name string) (link netlink.Link, err error) {
+	err = n.do(func(h current) error {
+		link, err = h.LinkByName(name)
+		return err
+	})
+	return link, err
+}
+
+func (n *namespaced) LinkByIndex(index int) (link netlink.Link, err error) {
+	err = n.do(func(h current) error {
+		link, err = h.LinkByIndex(index)
+		return err
+	})
+	return link, err
+}
+
+func (n *namespaced) LinkList() (links []netlink.Link, err error) {
+	err = n.do(func(h current) error {
+		links, err = h.LinkList()
+		return err
+	})
+	return links, err
+}
+
+func (n *namespaced) LinkSetHardwareAddr(link netlink.Link, hwaddr net.HardwareAddr) error {
+	return n.do(func(h current) error {
+		return h.LinkSetHardwareAddr(link, hwaddr)
+	})
+}
+
+func (n *namespaced) LinkAdd(link netlink.Link) error {
+	return n.do(func(h current) error {
+		return h.LinkAdd(link)
+	})
+}
+
+func (n *namespaced) LinkDel(link netlink.Link) error {
+	return n.do(func(h current) error {
+		return h.LinkDel(link)
+	})
+}
+
+func (n *namespaced) LinkSetUp(link netlink.Link) error {
+	return n.do(func(h current) error {
+		return h.LinkSetUp(link)
+	})
+}
+
+func (n *namespaced) LinkSetMasterByIndex(link netlink.Link, masterIndex int) error {
+	return n.do(func(h current) error {
+		return h.LinkSetMasterByIndex(link, masterIndex)
+	})
+}
+
+func (n *namespaced) VethPeerIndex(link netlink.Link) (index int, err error) {
+	err = n.do(func(h current) error {
+		index, err = h.VethPeerIndex(link)
+		return err
+	})
+	return index, err
+}
+
+func (n *namespaced) GetNetNsIdByFd(fd int) (id int, err error) {
+	err = n.do(func(h current) error {
+		id, err = h.GetNetNsIdByFd(fd)
+		return err
+	})
+	return id, err
+}
+
+func (n *namespaced) AddrList(link netlink.Link, family int) (addrs []netlink.Addr, err error) {
+	err = n.do(func(h current) error {
+		addrs, err = h.AddrList(link, family)
+		return err
+	})
+	return addrs, err
+}
+
+func (n *namespaced) AddrAdd(link netlink.Link, addr *netlink.Addr) error {
+	return n.do(func(h current) error {
+		return h.AddrAdd(link, addr)
+	})
+}
+
+func (n *namespaced) RouteList(link netlink.Link, family int) (routes []netlink.Route, err error) {
+	err = n.do(func(h current) error {
+		routes, err = h.RouteList(link, family)
+		return err
+	})
+	return routes, err
+}
+
+func (n *namespaced) RouteAdd(route *netlink.Route) error {
+	return n.do(func(h current) error {
+		return h.RouteAdd(route)
+	})
+}
+
+func (n *namespaced) RouteDel(route *netlink.Route) error {
+	return n.do(func(h current) error {
+		return h.RouteDel(route)
+	})
+}
+
+func (n *namespaced) RuleList(family int) (rules []netlink.Rule, err error) {
+	err = n.do(func(h current) error {
+		rules, err = h.RuleList(family)
+		return err
+	})
+	return rules, err
+}
+
+func (n *namespaced) RuleAdd(rule *netlink.Rule) error {
+	return n.do(func(h current) error {
+		return h.RuleAdd(rule)
+	})
+}
+
+func (n *namespaced) RuleDel(rule *netlink.Rule) error {
+	return n.do(func(h current) error {
+		return h.RuleDel(rule)
+	})
+}
+
+func (n *namespaced) NeighList(linkIndex, family int) (neighs []netlink.Neigh, err error) {
+	err = n.do(func(h current) error {
+		neighs, err = h.NeighList(linkIndex, family)
+		return err
+	})
+	return neighs, err
+}
+
+func (n *namespaced) NeighAdd(neigh *netlink.Neigh) error {
+	return n.do(func(h current) error {
+		return h.NeighAdd(neigh)
+	})
+}
+
+func (n *namespaced) NeighDel(neigh *netlink.Neigh) error {
+	return n.do(func(h current) error {
+		return h.NeighDel(neigh)
+	})
+}
@@ -0,0 +1,13 @@
+package nl_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestNl(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Nl Suite")
+}
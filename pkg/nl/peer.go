@@ -0,0 +1,84 @@
+package nl
+
+import (
+	"fmt"
+
+	"github.com/safchain/ethtool"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// the ethtool statistic of veth driver which is the ifindex of the peer
+const ethtoolPeerIndex = "peer_ifindex"
+
+// vethPeerIndex returns the peer_ifindex of the veth device, like `ethtool -S <name>`, the device is looked up in the
+// network namespace where the socket of e is opened, or the current one if e is nil.
+// the index is valid in the network namespace of the peer.
+func vethPeerIndex(e *ethtool.Ethtool, name string) (int, error) {
+	if e == nil {
+		var err error
+		if e, err = ethtool.NewEthtool(); err != nil {
+			return 0, fmt.Errorf("failed to create the ethtool socket: %w", err)
+		}
+		defer e.Close()
+	}
+	stats, err := e.Stats(name)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get the ethtool statistics of %s: %w", name, err)
+	}
+	index, ok := stats[ethtoolPeerIndex]
+	if !ok || index == 0 {
+		return 0, fmt.Errorf("%s has no %s in the ethtool statistics", name, ethtoolPeerIndex)
+	}
+	return int(index), nil
+}
+
+// VethPeer returns the peer on the node of the veth device in pod. The peer is located by the netnsid which the pod
+// assigns to the namespace of it (IFLA_LINK_NETNSID), so a peer in another namespace, such as a nested one, is never
+// mistaken for the link of the node with the same index. The index of the peer is IFLA_LINK, or the peer_ifindex of
+// ethtool if the kernel doesn't report it.
+// podNl and hostNl are the Netlink of the pod and the current network namespace.
+func VethPeer(podNl, hostNl Netlink, name string) (netlink.Link, error) {
+	link, err := podNl.LinkByName(name)
+	if err != nil {
+		return nil, err
+	}
+	if link.Type() != "veth" {
+		return nil, fmt.Errorf("%s is a %s device without veth peer", name, link.Type())
+	}
+
+	// the kernel omits IFLA_LINK_NETNSID if the peer is in the same namespace
+	peerNsID := link.Attrs().NetNsID
+	if peerNsID < 0 {
+		return nil, fmt.Errorf("the veth peer of %s is in the pod rather than the node", name)
+	}
+	hostNS, err := netns.Get()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the network namespace of the node: %w", err)
+	}
+	defer hostNS.Close()
+	hostNsID, err := podNl.GetNetNsIdByFd(int(hostNS))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the netnsid of the node in pod: %w", err)
+	}
+	if peerNsID != hostNsID {
+		return nil, fmt.Errorf("the veth peer of %s is in the network namespace of netnsid %d rather than the node(netnsid %d)",
+			name, peerNsID, hostNsID)
+	}
+
+	peerIndex := link.Attrs().ParentIndex
+	if peerIndex <= 0 {
+		if peerIndex, err = podNl.VethPeerIndex(link); err != nil {
+			return nil, fmt.Errorf("failed to get the index of veth peer of %s: %w", name, err)
+		}
+	}
+	peer, err := hostNl.LinkByIndex(peerIndex)
+	if err != nil {
+		return nil, fmt.Errorf("the veth peer %d of %s is not found on the node: %w", peerIndex, name, err)
+	}
+	// the peer of the peer is the veth device in pod
+	if peer.Type() != "veth" || (peer.Attrs().ParentIndex > 0 && peer.Attrs().ParentIndex != link.Attrs().Index) {
+		return nil, fmt.Errorf("%s(index %d) on the node is not the veth peer of %s", peer.Attrs().Name, peerIndex, name)
+	}
+	return peer, nil
+}
@@ -0,0 +1,122 @@
+package nl_test
+
+import (
+	"context"
+	"os"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spidernet-io/cni-plugins/pkg/nl"
+	"github.com/vishvananda/netlink"
+)
+
+var _ = Describe("VethPeer", func() {
+	Context("with fake netlink", func() {
+		var pod, host *nl.Fake
+		var cali netlink.Link
+
+		BeforeEach(func() {
+			pod, host = nl.NewFake(), nl.NewFake()
+			cali = host.AddVeth(netlink.LinkAttrs{Name: "cali12345"})
+		})
+
+		It("the peer on the node", func() {
+			pod.AddVeth(netlink.LinkAttrs{Name: "eth0", ParentIndex: cali.Attrs().Index})
+			peer, err := nl.VethPeer(pod, host, "eth0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(peer.Attrs().Name).To(Equal("cali12345"))
+		})
+
+		It("the peer in another network namespace", func() {
+			pod.AddVeth(netlink.LinkAttrs{Name: "eth0", ParentIndex: cali.Attrs().Index, NetNsID: 1})
+			_, err := nl.VethPeer(pod, host, "eth0")
+			Expect(err).To(MatchError(ContainSubstring("in the network namespace of netnsid 1 rather than the node(netnsid 0)")))
+
+			pod.AddVeth(netlink.LinkAttrs{Name: "eth1", ParentIndex: 1, NetNsID: -1})
+			_, err = nl.VethPeer(pod, host, "eth1")
+			Expect(err).To(MatchError(ContainSubstring("in the pod rather than the node")))
+		})
+
+		It("the link of node with the same index is not the peer", func() {
+			eth0 := pod.AddVeth(netlink.LinkAttrs{Name: "eth0", ParentIndex: cali.Attrs().Index})
+			cali.Attrs().ParentIndex = eth0.Attrs().Index + 1
+			_, err := nl.VethPeer(pod, host, "eth0")
+			Expect(err).To(MatchError(ContainSubstring("is not the veth peer of eth0")))
+
+			pod.AddVeth(netlink.LinkAttrs{Name: "eth1", ParentIndex: 1})
+			_, err = nl.VethPeer(pod, host, "eth1")
+			Expect(err).To(MatchError(ContainSubstring("lo(index 1) on the node is not the veth peer of eth1")))
+		})
+
+		It("not a veth device", func() {
+			pod.AddLink(netlink.LinkAttrs{Name: "net1"})
+			_, err := nl.VethPeer(pod, host, "net1")
+			Expect(err).To(MatchError("net1 is a dummy device without veth peer"))
+		})
+	})
+
+	Context("with network namespaces", func() {
+		var podNS, hostNS ns.NetNS
+
+		BeforeEach(func() {
+			if os.Geteuid() != 0 {
+				Skip("creating network namespace requires root")
+			}
+			for _, netNS := range []*ns.NetNS{&podNS, &hostNS} {
+				var err error
+				*netNS, err = testutils.NewNS()
+				Expect(err).NotTo(HaveOccurred())
+				created := *netNS
+				DeferCleanup(func() {
+					Expect(created.Close()).To(Succeed())
+					Expect(testutils.UnmountNS(created)).To(Succeed())
+				})
+			}
+
+			// the peer of eth0 is in the namespace which plays the node
+			err := hostNS.Do(func(_ ns.NetNS) error {
+				if err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "veth12345"}, PeerName: "eth0"}); err != nil {
+					return err
+				}
+				eth0, err := netlink.LinkByName("eth0")
+				if err != nil {
+					return err
+				}
+				return netlink.LinkSetNsFd(eth0, int(podNS.Fd()))
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("find the peer by netnsid and ethtool", func() {
+			handle, err := nl.NewHandleAt(context.Background(), podNS)
+			Expect(err).NotTo(HaveOccurred())
+			defer handle.Close()
+
+			err = hostNS.Do(func(_ ns.NetNS) error {
+				defer GinkgoRecover()
+				veth, err := netlink.LinkByName("veth12345")
+				Expect(err).NotTo(HaveOccurred())
+
+				for _, podNl := range []nl.Netlink{handle, nl.NewAt(podNS)} {
+					peer, err := nl.VethPeer(podNl, nl.New(), "eth0")
+					Expect(err).NotTo(HaveOccurred())
+					Expect(peer.Attrs().Name).To(Equal("veth12345"))
+
+					eth0, err := podNl.LinkByName("eth0")
+					Expect(err).NotTo(HaveOccurred())
+					index, err := podNl.VethPeerIndex(eth0)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(index).To(Equal(veth.Attrs().Index))
+				}
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			// the peer is not in the current namespace
+			_, err = nl.VethPeer(nl.NewAt(podNS), nl.New(), "eth0")
+			Expect(err).To(MatchError(ContainSubstring("rather than the node")))
+		})
+	})
+})
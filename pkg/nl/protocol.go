@@ -0,0 +1,28 @@
+package nl
+
+import (
+	"github.com/vishvananda/netlink"
+)
+
+// WithProtocol returns the Netlink which tags the added routes and rules with the given protocol,
+// so they can be told from the ones of others, like: ip route show proto <protocol>.
+func WithProtocol(h Netlink, protocol int) Netlink {
+	return &withProtocol{Netlink: h, protocol: protocol}
+}
+
+type withProtocol struct {
+	Netlink
+	protocol int
+}
+
+func (w *withProtocol) RouteAdd(route *netlink.Route) error {
+	r := *route
+	r.Protocol = netlink.RouteProtocol(w.protocol)
+	return w.Netlink.RouteAdd(&r)
+}
+
+func (w *withProtocol) RuleAdd(rule *netlink.Rule) error {
+	r := *rule
+	r.Protocol = uint8(w.protocol)
+	return w.Netlink.RuleAdd(&r)
+}
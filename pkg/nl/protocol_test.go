@@ -0,0 +1,49 @@
+package nl_test
+
+import (
+	"net"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spidernet-io/cni-plugins/pkg/nl"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+var _ = Describe("WithProtocol", func() {
+	It("tag the added routes and rules", func() {
+		fake := nl.NewFake()
+		h := nl.WithProtocol(fake, 201)
+		link, err := h.LinkByName("lo")
+		Expect(err).NotTo(HaveOccurred())
+
+		_, dst, _ := net.ParseCIDR("10.6.0.0/16")
+		route := &netlink.Route{LinkIndex: link.Attrs().Index, Dst: dst, Protocol: unix.RTPROT_BOOT, Table: 100}
+		Expect(h.RouteAdd(route)).To(Succeed())
+		Expect(route.Protocol).To(Equal(netlink.RouteProtocol(unix.RTPROT_BOOT)), "the given route is not changed")
+
+		rule := netlink.NewRule()
+		rule.Dst = dst
+		rule.Table = 100
+		Expect(h.RuleAdd(rule)).To(Succeed())
+
+		Expect(fake.RoutesInTable(100)).To(ConsistOf(HaveField("Protocol", netlink.RouteProtocol(201))))
+		rules, err := h.RuleList(netlink.FAMILY_V4)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rules).To(ContainElement(And(HaveField("Table", 100), HaveField("Protocol", uint8(201)))))
+	})
+
+	It("the rules of different protocols are different rules", func() {
+		fake := nl.NewFake()
+		rule := netlink.NewRule()
+		rule.Table = 500
+		rule.Priority = 1000
+		Expect(fake.RuleAdd(rule)).To(Succeed())
+		Expect(nl.WithProtocol(fake, 201).RuleAdd(rule)).To(Succeed(), "just like the kernel, it's not EEXIST")
+
+		rules, err := fake.RuleList(netlink.FAMILY_V4)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rules).To(ContainElements(And(HaveField("Table", 500), HaveField("Protocol", uint8(0))),
+			And(HaveField("Table", 500), HaveField("Protocol", uint8(201)))))
+	})
+})
@@ -0,0 +1,70 @@
+package plan
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/containernetworking/plugins/pkg/utils/sysctl"
+	"github.com/coreos/go-iptables/iptables"
+)
+
+// Sysctl returns the sysctl of the network namespace netns which records the writes rather than making them,
+// the reads get the current values. Like sysctl.Sysctl, it's called in the network namespace.
+func (p *Plan) Sysctl(netns string) func(name string, value ...string) (string, error) {
+	return func(name string, value ...string) (string, error) {
+		if len(value) == 0 {
+			return sysctl.Sysctl(name)
+		}
+		p.AddSysctl(netns, name, value[0])
+		return value[0], nil
+	}
+}
+
+// Nftables returns the runner of the nftables script in the network namespace netns which records every command of
+// the script rather than running it
+func (p *Plan) Nftables(netns string) func(ctx context.Context, script string) error {
+	return func(_ context.Context, script string) error {
+		for _, command := range strings.Split(script, "\n") {
+			if command = strings.TrimSpace(command); command != "" {
+				p.Add(netns, KindNftables, "nft %s", command)
+			}
+		}
+		return nil
+	}
+}
+
+// Iptables returns the iptables of the protocol in the network namespace netns which records the appended rules
+// rather than appending them
+func (p *Plan) Iptables(netns string, protocol iptables.Protocol) *Iptables {
+	command := "iptables"
+	if protocol == iptables.ProtocolIPv6 {
+		command = "ip6tables"
+	}
+	return &Iptables{plan: p, netns: netns, command: command}
+}
+
+// Iptables records the changes of iptables, see Plan.Iptables
+type Iptables struct {
+	plan    *Plan
+	netns   string
+	command string
+}
+
+// AppendUnique records the rule, it's appended if it doesn't exist
+func (i *Iptables) AppendUnique(table, chain string, rulespec ...string) error {
+	i.plan.Add(i.netns, KindIptables, "%s -t %s -A %s %s", i.command, table, chain, iptablesSpecString(rulespec))
+	return nil
+}
+
+// iptablesSpecString quotes the args with spaces, like the shell
+func iptablesSpecString(spec []string) string {
+	args := make([]string, 0, len(spec))
+	for _, arg := range spec {
+		if strings.Contains(arg, " ") {
+			arg = fmt.Sprintf("%q", arg)
+		}
+		args = append(args, arg)
+	}
+	return strings.Join(args, " ")
+}
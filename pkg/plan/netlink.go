@@ -0,0 +1,260 @@
+package plan
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/spidernet-io/cni-plugins/pkg/nl"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// Netlink returns the Netlink of the network namespace netns which records the changes to the plan rather than making
+// them, so the plugin runs the same code in dry-run mode. The reads are passed to h, and the links added by the
+// recorded changes are seen by the later reads, like the veth pair whose peer is added to the other namespace.
+// The changes of the existing links are only recorded.
+func (p *Plan) Netlink(netns string, h nl.Netlink) nl.Netlink {
+	if p.recorders == nil {
+		p.recorders = map[string]*recorder{}
+	}
+	r := &recorder{Netlink: h, plan: p, netns: netns, nsID: -1}
+	p.recorders[netns] = r
+	return r
+}
+
+// peerNetns returns the namespace where the peer of a veth added with PeerNamespace is, the plugins only create
+// the veth pairs between the pod and the node
+func peerNetns(netns string) string {
+	if netns == PodNetns {
+		return HostNetns
+	}
+	return PodNetns
+}
+
+// recorder records the changes of a network namespace, see Plan.Netlink
+type recorder struct {
+	nl.Netlink
+	plan  *Plan
+	netns string
+	// the links added by the recorded changes
+	links []netlink.Link
+	// the netnsid allocated to the peer namespace, if the namespace has none before
+	nsID int
+}
+
+func (r *recorder) LinkByName(name string) (netlink.Link, error) {
+	for _, link := range r.links {
+		if link.Attrs().Name == name {
+			return link, nil
+		}
+	}
+	return r.Netlink.LinkByName(name)
+}
+
+func (r *recorder) LinkByIndex(index int) (netlink.Link, error) {
+	for _, link := range r.links {
+		if link.Attrs().Index == index {
+			return link, nil
+		}
+	}
+	return r.Netlink.LinkByIndex(index)
+}
+
+func (r *recorder) LinkList() ([]netlink.Link, error) {
+	links, err := r.Netlink.LinkList()
+	if err != nil {
+		return nil, err
+	}
+	return append(links, r.links...), nil
+}
+
+// added returns the link if it's added by the recorded changes
+func (r *recorder) added(link netlink.Link) netlink.Link {
+	for _, l := range r.links {
+		if l.Attrs().Index == link.Attrs().Index {
+			return l
+		}
+	}
+	return nil
+}
+
+// linkName returns the name of the link of index, or empty for the index 0
+func (r *recorder) linkName(index int) string {
+	if index == 0 {
+		return ""
+	}
+	link, err := r.LinkByIndex(index)
+	if err != nil {
+		return fmt.Sprintf("if%d", index)
+	}
+	return link.Attrs().Name
+}
+
+// nextIndex returns an index which is unused by the links of the namespace
+func (r *recorder) nextIndex() int {
+	index := 1
+	links, _ := r.LinkList()
+	for _, link := range links {
+		if link.Attrs().Index >= index {
+			index = link.Attrs().Index + 1
+		}
+	}
+	return index
+}
+
+func (r *recorder) LinkSetHardwareAddr(link netlink.Link, hwaddr net.HardwareAddr) error {
+	r.plan.Add(r.netns, KindMac, "ip link set %s address %s", link.Attrs().Name, hwaddr)
+	if l := r.added(link); l != nil {
+		l.Attrs().HardwareAddr = hwaddr
+	}
+	return nil
+}
+
+// LinkAdd records the link, and the index of it is allocated. The peer of a veth is added to the other namespace
+// if PeerNamespace is given.
+func (r *recorder) LinkAdd(link netlink.Link) error {
+	if _, err := r.LinkByName(link.Attrs().Name); err == nil {
+		return unix.EEXIST
+	}
+
+	attrs := link.Attrs()
+	attrs.Index = r.nextIndex()
+	attrs.ParentIndex = -1
+	attrs.NetNsID = -1
+	switch l := link.(type) {
+	case *netlink.Veth:
+		command := fmt.Sprintf("ip link add %s", attrs.Name)
+		if attrs.MTU > 0 {
+			command += fmt.Sprintf(" mtu %d", attrs.MTU)
+		}
+		if attrs.HardwareAddr != nil {
+			command += fmt.Sprintf(" address %s", attrs.HardwareAddr)
+		}
+		command += fmt.Sprintf(" type veth peer name %s", l.PeerName)
+
+		// the peer isn't tracked if the other namespace isn't recorded
+		peerRecorder := r
+		if l.PeerNamespace != nil {
+			command += " netns " + peerNetns(r.netns)
+			peerRecorder = r.plan.recorders[peerNetns(r.netns)]
+			if fd, ok := l.PeerNamespace.(netlink.NsFd); ok {
+				attrs.NetNsID, _ = r.GetNetNsIdByFd(int(fd))
+			}
+		}
+		r.plan.Add(r.netns, KindLink, "%s", command)
+
+		r.links = append(r.links, link)
+		if peerRecorder != nil {
+			peer := &netlink.Veth{LinkAttrs: netlink.NewLinkAttrs(), PeerName: attrs.Name}
+			peer.Name = l.PeerName
+			peer.MTU = attrs.MTU
+			peer.HardwareAddr = l.PeerHardwareAddr
+			peer.Index = peerRecorder.nextIndex()
+			peer.ParentIndex = attrs.Index
+			attrs.ParentIndex = peer.Index
+			peerRecorder.links = append(peerRecorder.links, peer)
+		}
+		return nil
+	case *netlink.Vrf:
+		r.plan.Add(r.netns, KindLink, "ip link add %s type vrf table %d", attrs.Name, l.Table)
+	default:
+		r.plan.Add(r.netns, KindLink, "ip link add %s type %s", attrs.Name, link.Type())
+	}
+	r.links = append(r.links, link)
+	return nil
+}
+
+func (r *recorder) LinkDel(link netlink.Link) error {
+	r.plan.Add(r.netns, KindLink, "ip link del %s", link.Attrs().Name)
+	for i, l := range r.links {
+		if l.Attrs().Index == link.Attrs().Index {
+			r.links = append(r.links[:i], r.links[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (r *recorder) LinkSetUp(link netlink.Link) error {
+	r.plan.Add(r.netns, KindLink, "ip link set %s up", link.Attrs().Name)
+	if l := r.added(link); l != nil {
+		l.Attrs().Flags |= net.FlagUp
+		l.Attrs().OperState = netlink.OperUp
+	}
+	return nil
+}
+
+func (r *recorder) LinkSetMasterByIndex(link netlink.Link, masterIndex int) error {
+	r.plan.Add(r.netns, KindLink, "ip link set %s master %s", link.Attrs().Name, r.linkName(masterIndex))
+	if l := r.added(link); l != nil {
+		l.Attrs().MasterIndex = masterIndex
+	}
+	return nil
+}
+
+func (r *recorder) VethPeerIndex(link netlink.Link) (int, error) {
+	if l := r.added(link); l != nil {
+		return l.Attrs().ParentIndex, nil
+	}
+	return r.Netlink.VethPeerIndex(link)
+}
+
+// GetNetNsIdByFd returns the netnsid of the peer namespace, which the kernel allocates when the first veth peer is
+// added to it, so the namespace of fd is taken as the peer namespace if it has no netnsid before
+func (r *recorder) GetNetNsIdByFd(fd int) (int, error) {
+	nsID, err := r.Netlink.GetNetNsIdByFd(fd)
+	if err != nil {
+		return nsID, err
+	}
+	if nsID < 0 {
+		if r.nsID < 0 {
+			// the kernel allocates the lowest unused one
+			r.nsID = 0
+		}
+		nsID = r.nsID
+	}
+	return nsID, nil
+}
+
+func (r *recorder) AddrAdd(link netlink.Link, addr *netlink.Addr) error {
+	command := fmt.Sprintf("ip addr add %s dev %s", addr.IPNet, link.Attrs().Name)
+	if addr.Flags&unix.IFA_F_NODAD != 0 {
+		command += " nodad"
+	}
+	r.plan.Add(r.netns, KindAddr, "%s", command)
+	return nil
+}
+
+func (r *recorder) RouteAdd(route *netlink.Route) error {
+	r.plan.AddRoute(r.netns, "add", route, r.linkName(route.LinkIndex))
+	return nil
+}
+
+func (r *recorder) RouteDel(route *netlink.Route) error {
+	r.plan.AddRoute(r.netns, "del", route, r.linkName(route.LinkIndex))
+	return nil
+}
+
+func (r *recorder) RuleAdd(rule *netlink.Rule) error {
+	r.plan.AddRule(r.netns, "add", rule)
+	return nil
+}
+
+func (r *recorder) RuleDel(rule *netlink.Rule) error {
+	r.plan.AddRule(r.netns, "del", rule)
+	return nil
+}
+
+func (r *recorder) NeighAdd(neigh *netlink.Neigh) error {
+	mac := ""
+	if neigh.HardwareAddr != nil {
+		mac = neigh.HardwareAddr.String()
+	}
+	r.plan.AddNeighbor(r.netns, "add", neigh.IP, mac, r.linkName(neigh.LinkIndex))
+	return nil
+}
+
+func (r *recorder) NeighDel(neigh *netlink.Neigh) error {
+	r.plan.AddNeighbor(r.netns, "del", neigh.IP, "", r.linkName(neigh.LinkIndex))
+	return nil
+}
@@ -0,0 +1,142 @@
+// Package plan records the network changes which a plugin would make in dry-run mode
+package plan
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+// The network namespaces where the changes are made
+const (
+	PodNetns  = "pod"
+	HostNetns = "host"
+)
+
+// The kinds of changes
+const (
+	KindLink     = "link"
+	KindMac      = "mac"
+	KindAddr     = "addr"
+	KindRoute    = "route"
+	KindRule     = "rule"
+	KindNeighbor = "neighbor"
+	KindSysctl   = "sysctl"
+	KindIptables = "iptables"
+	KindNftables = "nftables"
+)
+
+// Change is a network change, Command is the equivalent iproute2, sysctl or iptables command
+type Change struct {
+	Netns   string `json:"netns"`
+	Kind    string `json:"kind"`
+	Command string `json:"command"`
+}
+
+// Plan is the ordered changes which the plugin would make
+type Plan struct {
+	Changes []Change `json:"changes"`
+	// the recorders of the network namespaces, see Netlink
+	recorders map[string]*recorder
+}
+
+// Add records a change
+func (p *Plan) Add(netns, kind, format string, args ...interface{}) {
+	p.Changes = append(p.Changes, Change{Netns: netns, Kind: kind, Command: fmt.Sprintf(format, args...)})
+}
+
+// AddRoute records a route change, action is add or del, dev is the name of route.LinkIndex
+func (p *Plan) AddRoute(netns, action string, route *netlink.Route, dev string) {
+	p.Add(netns, KindRoute, "ip route %s %s", action, RouteString(route, dev))
+}
+
+// AddRule records a rule change, action is add or del
+func (p *Plan) AddRule(netns, action string, rule *netlink.Rule) {
+	p.Add(netns, KindRule, "ip rule %s %s", action, RuleString(rule))
+}
+
+// AddNeighbor records a neighbor change, action is add or del
+func (p *Plan) AddNeighbor(netns, action string, ip net.IP, mac, dev string) {
+	if mac == "" {
+		p.Add(netns, KindNeighbor, "ip neigh %s %s dev %s", action, ip, dev)
+		return
+	}
+	p.Add(netns, KindNeighbor, "ip neigh %s %s dev %s lladdr %s nud permanent", action, ip, dev, mac)
+}
+
+// AddSysctl records a sysctl change, name is like: /net/ipv4/conf/all/rp_filter
+func (p *Plan) AddSysctl(netns, name, value string) {
+	p.Add(netns, KindSysctl, "sysctl -w %s=%s", strings.ReplaceAll(strings.TrimPrefix(name, "/"), "/", "."), value)
+}
+
+// Log logs the plan as a structured field
+func (p *Plan) Log(logger *zap.Logger) {
+	logger.Info("Dry run, the network changes are planned but not applied", zap.Int("count", len(p.Changes)), zap.Any("plan", p.Changes))
+}
+
+// RouteString formats the route like the args of `ip route`
+func RouteString(route *netlink.Route, dev string) string {
+	var b strings.Builder
+	if route.Dst == nil {
+		b.WriteString("default")
+	} else {
+		b.WriteString(route.Dst.String())
+	}
+	if route.Gw != nil {
+		fmt.Fprintf(&b, " via %s", route.Gw)
+	}
+	if dev != "" {
+		fmt.Fprintf(&b, " dev %s", dev)
+	}
+	if route.Src != nil {
+		fmt.Fprintf(&b, " src %s", route.Src)
+	}
+	if route.Scope == netlink.SCOPE_LINK {
+		b.WriteString(" scope link")
+	}
+	if route.Priority > 0 {
+		fmt.Fprintf(&b, " metric %d", route.Priority)
+	}
+	if route.Table != 0 && route.Table != unix.RT_TABLE_MAIN {
+		fmt.Fprintf(&b, " table %d", route.Table)
+	}
+	if route.Protocol != 0 {
+		fmt.Fprintf(&b, " proto %d", route.Protocol)
+	}
+	return b.String()
+}
+
+// RuleString formats the rule like the args of `ip rule`
+func RuleString(rule *netlink.Rule) string {
+	var b strings.Builder
+	if rule.Priority >= 0 {
+		fmt.Fprintf(&b, "priority %d ", rule.Priority)
+	}
+	if rule.Src != nil {
+		fmt.Fprintf(&b, "from %s ", rule.Src)
+	} else {
+		b.WriteString("from all ")
+	}
+	if rule.Dst != nil {
+		fmt.Fprintf(&b, "to %s ", rule.Dst)
+	}
+	if rule.IifName != "" {
+		fmt.Fprintf(&b, "iif %s ", rule.IifName)
+	}
+	if rule.Mark > 0 {
+		fmt.Fprintf(&b, "fwmark %#x", rule.Mark)
+		if rule.Mask > 0 {
+			fmt.Fprintf(&b, "/%#x", rule.Mask)
+		}
+		b.WriteString(" ")
+	}
+	fmt.Fprintf(&b, "lookup %d", rule.Table)
+	if rule.Protocol != 0 {
+		fmt.Fprintf(&b, " protocol %d", rule.Protocol)
+	}
+	return b.String()
+}
@@ -0,0 +1,179 @@
+package plan_test
+
+import (
+	"context"
+	"net"
+
+	"github.com/coreos/go-iptables/iptables"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spidernet-io/cni-plugins/pkg/nl"
+	"github.com/spidernet-io/cni-plugins/pkg/plan"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+var _ = Describe("Plan", func() {
+	_, subnet, _ := net.ParseCIDR("10.6.0.0/16")
+	_, host, _ := net.ParseCIDR("10.6.1.10/32")
+
+	Context("Test RouteString", func() {
+		It("default route", func() {
+			route := &netlink.Route{Gw: net.ParseIP("169.254.1.1"), Table: 100}
+			Expect(plan.RouteString(route, "eth0")).To(Equal("default via 169.254.1.1 dev eth0 table 100"))
+		})
+		It("link route in main table", func() {
+			route := &netlink.Route{Dst: host, Scope: netlink.SCOPE_LINK, Table: 254}
+			Expect(plan.RouteString(route, "veth0")).To(Equal("10.6.1.10/32 dev veth0 scope link"))
+		})
+	})
+
+	Context("Test RuleString", func() {
+		It("to rule", func() {
+			rule := netlink.NewRule()
+			rule.Dst = subnet
+			rule.Table = 100
+			Expect(plan.RuleString(rule)).To(Equal("from all to 10.6.0.0/16 lookup 100"))
+		})
+		It("from rule with priority", func() {
+			rule := netlink.NewRule()
+			rule.Src = host
+			rule.Priority = 1000
+			rule.Table = 500
+			Expect(plan.RuleString(rule)).To(Equal("priority 1000 from 10.6.1.10/32 lookup 500"))
+		})
+		It("fwmark rule", func() {
+			rule := netlink.NewRule()
+			rule.Mark = 0x2000
+			rule.Mask = 0x2000
+			rule.Priority = 90
+			rule.Table = 1002
+			Expect(plan.RuleString(rule)).To(Equal("priority 90 from all fwmark 0x2000/0x2000 lookup 1002"))
+		})
+		It("iif rule", func() {
+			rule := netlink.NewRule()
+			rule.Src = host
+			rule.IifName = "veth123"
+			rule.Priority = 90
+			rule.Table = 254
+			Expect(plan.RuleString(rule)).To(Equal("priority 90 from 10.6.1.10/32 iif veth123 lookup 254"))
+		})
+	})
+
+	Context("Test Plan", func() {
+		It("record changes in order", func() {
+			p := &plan.Plan{}
+			p.AddSysctl(plan.PodNetns, "/net/ipv4/conf/all/rp_filter", "0")
+			p.AddNeighbor(plan.HostNetns, "add", net.ParseIP("10.6.1.10"), "0a:1b:0a:06:01:0a", "veth123")
+			p.AddNeighbor(plan.HostNetns, "del", net.ParseIP("10.6.1.11"), "", "veth123")
+			Expect(p.Changes).To(Equal([]plan.Change{
+				{Netns: plan.PodNetns, Kind: plan.KindSysctl, Command: "sysctl -w net.ipv4.conf.all.rp_filter=0"},
+				{Netns: plan.HostNetns, Kind: plan.KindNeighbor, Command: "ip neigh add 10.6.1.10 dev veth123 lladdr 0a:1b:0a:06:01:0a nud permanent"},
+				{Netns: plan.HostNetns, Kind: plan.KindNeighbor, Command: "ip neigh del 10.6.1.11 dev veth123"},
+			}))
+		})
+
+	})
+
+	Context("Test Netlink", func() {
+		var podFake, hostFake *nl.Fake
+		BeforeEach(func() {
+			podFake, hostFake = nl.NewFake(), nl.NewFake()
+			podFake.AddVeth(netlink.LinkAttrs{Name: "eth0"})
+			hostFake.AddLink(netlink.LinkAttrs{Name: "eth0"})
+		})
+
+		It("record the changes rather than making them", func() {
+			p := &plan.Plan{}
+			podNl := nl.WithProtocol(p.Netlink(plan.PodNetns, podFake), 201)
+			eth0, err := podNl.LinkByName("eth0")
+			Expect(err).NotTo(HaveOccurred())
+
+			rule := netlink.NewRule()
+			rule.Dst = subnet
+			rule.Table = 100
+			Expect(podNl.RuleAdd(rule)).To(Succeed())
+			Expect(podNl.RouteAdd(&netlink.Route{LinkIndex: eth0.Attrs().Index, Dst: subnet, Table: 100})).To(Succeed())
+			Expect(podNl.RouteDel(&netlink.Route{LinkIndex: eth0.Attrs().Index, Dst: subnet})).To(Succeed())
+			Expect(podNl.AddrAdd(eth0, &netlink.Addr{IPNet: host, Flags: unix.IFA_F_NODAD})).To(Succeed())
+			Expect(podNl.NeighAdd(&netlink.Neigh{LinkIndex: eth0.Attrs().Index, IP: host.IP, HardwareAddr: net.HardwareAddr{0x0a, 0x1b, 0x0a, 0x06, 0x01, 0x0a}})).To(Succeed())
+			Expect(p.Changes).To(Equal([]plan.Change{
+				{Netns: plan.PodNetns, Kind: plan.KindRule, Command: "ip rule add from all to 10.6.0.0/16 lookup 100 protocol 201"},
+				{Netns: plan.PodNetns, Kind: plan.KindRoute, Command: "ip route add 10.6.0.0/16 dev eth0 table 100 proto 201"},
+				{Netns: plan.PodNetns, Kind: plan.KindRoute, Command: "ip route del 10.6.0.0/16 dev eth0"},
+				{Netns: plan.PodNetns, Kind: plan.KindAddr, Command: "ip addr add 10.6.1.10/32 dev eth0 nodad"},
+				{Netns: plan.PodNetns, Kind: plan.KindNeighbor, Command: "ip neigh add 10.6.1.10 dev eth0 lladdr 0a:1b:0a:06:01:0a nud permanent"},
+			}))
+			Expect(rule.Protocol).To(BeZero())
+			Expect(podFake.Rules).To(Equal(nl.NewFake().Rules))
+			Expect(podFake.Routes).To(BeEmpty())
+			Expect(podFake.Neighs).To(BeEmpty())
+		})
+
+		It("the added links are seen by the later reads", func() {
+			p := &plan.Plan{}
+			podNl, hostNl := p.Netlink(plan.PodNetns, podFake), p.Netlink(plan.HostNetns, hostFake)
+			Expect(podNl.LinkAdd(&netlink.Veth{
+				LinkAttrs:     netlink.LinkAttrs{Name: "veth0", MTU: 1500},
+				PeerName:      "vethtesttestte",
+				PeerNamespace: netlink.NsFd(0),
+			})).To(Succeed())
+			Expect(podNl.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "veth0"}})).To(MatchError(unix.EEXIST))
+			Expect(podNl.LinkAdd(&netlink.Vrf{LinkAttrs: netlink.LinkAttrs{Name: "vrf-net1"}, Table: 100})).To(Succeed())
+
+			veth0, err := podNl.LinkByName("veth0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(podNl.LinkSetUp(veth0)).To(Succeed())
+			Expect(veth0.Attrs().Flags & net.FlagUp).NotTo(BeZero())
+			vrf, err := podNl.LinkByName("vrf-net1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(vrf.Attrs().Index).NotTo(Equal(veth0.Attrs().Index))
+			eth0, err := podNl.LinkByName("eth0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(podNl.LinkSetMasterByIndex(eth0, vrf.Attrs().Index)).To(Succeed())
+			Expect(eth0.Attrs().MasterIndex).To(BeZero(), "the existing links are unchanged")
+
+			peer, err := nl.VethPeer(podNl, hostNl, "veth0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(peer.Attrs().Name).To(Equal("vethtesttestte"))
+			Expect(hostNl.LinkSetHardwareAddr(peer, net.HardwareAddr{0x0a, 0x1b, 0x0a, 0x06, 0x01, 0x0a})).To(Succeed())
+			Expect(hostNl.RouteAdd(&netlink.Route{LinkIndex: peer.Attrs().Index, Dst: host, Scope: netlink.SCOPE_LINK})).To(Succeed())
+			links, err := hostNl.LinkList()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(links).To(HaveLen(3))
+
+			Expect(p.Changes).To(Equal([]plan.Change{
+				{Netns: plan.PodNetns, Kind: plan.KindLink, Command: "ip link add veth0 mtu 1500 type veth peer name vethtesttestte netns host"},
+				{Netns: plan.PodNetns, Kind: plan.KindLink, Command: "ip link add vrf-net1 type vrf table 100"},
+				{Netns: plan.PodNetns, Kind: plan.KindLink, Command: "ip link set veth0 up"},
+				{Netns: plan.PodNetns, Kind: plan.KindLink, Command: "ip link set eth0 master vrf-net1"},
+				{Netns: plan.HostNetns, Kind: plan.KindMac, Command: "ip link set vethtesttestte address 0a:1b:0a:06:01:0a"},
+				{Netns: plan.HostNetns, Kind: plan.KindRoute, Command: "ip route add 10.6.1.10/32 dev vethtesttestte scope link"},
+			}))
+			Expect(podFake.Links).To(HaveLen(2))
+			Expect(hostFake.Links).To(HaveLen(2))
+		})
+	})
+
+	Context("Test the commands", func() {
+		It("record the writes of sysctl", func() {
+			p := &plan.Plan{}
+			sysctl := p.Sysctl(plan.HostNetns)
+			_, err := sysctl("net/ipv4/conf/all/rp_filter")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sysctl("net/ipv4/conf/all/rp_filter", "0")).To(Equal("0"))
+			Expect(p.Changes).To(Equal([]plan.Change{{Netns: plan.HostNetns, Kind: plan.KindSysctl, Command: "sysctl -w net.ipv4.conf.all.rp_filter=0"}}))
+		})
+
+		It("record the commands of nftables and iptables", func() {
+			p := &plan.Plan{}
+			Expect(p.Nftables(plan.PodNetns)(context.Background(), "add table inet test\nflush chain inet test output\n")).To(Succeed())
+			Expect(p.Iptables(plan.PodNetns, iptables.ProtocolIPv6).AppendUnique("mangle", "OUTPUT", "-m", "comment", "--comment", "a b")).To(Succeed())
+			Expect(p.Changes).To(Equal([]plan.Change{
+				{Netns: plan.PodNetns, Kind: plan.KindNftables, Command: "nft add table inet test"},
+				{Netns: plan.PodNetns, Kind: plan.KindNftables, Command: "nft flush chain inet test output"},
+				{Netns: plan.PodNetns, Kind: plan.KindIptables, Command: `ip6tables -t mangle -A OUTPUT -m comment --comment "a b"`},
+			}))
+		})
+	})
+})
@@ -2,7 +2,10 @@ package schema
 
 import (
 	"github.com/spidernet-io/spiderpool/pkg/constant"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"sigs.k8s.io/controller-runtime/pkg/scheme"
 )
 
@@ -19,7 +22,7 @@ var (
 
 var (
 	// SpiderPoolGroupVersion is group version used to register these objects
-	SpiderPoolGroupVersion = schema.GroupVersion{Group: constant.SpiderpoolAPIGroup, Version: constant.SpiderpoolAPIVersionV1}
+	SpiderPoolGroupVersion = schema.GroupVersion{Group: constant.SpiderpoolAPIGroup, Version: constant.SpiderpoolAPIVersion}
 
 	// SpiderPoolSchemeBuilder is used to add go types to the GroupVersionKind scheme
 	SpiderPoolSchemeBuilder = &scheme.Builder{GroupVersion: SpiderPoolGroupVersion}
@@ -27,3 +30,10 @@ var (
 	// SpiderPoolAddToScheme adds the types in this group-version to the given scheme.
 	SpiderPoolAddToScheme = SpiderPoolSchemeBuilder.AddToScheme
 )
+
+// Scheme is used by the kubernetes client of plugins
+var Scheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(corev1.AddToScheme(Scheme))
+}
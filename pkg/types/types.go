@@ -3,6 +3,7 @@ package types
 import (
 	"github.com/containernetworking/cni/pkg/types"
 	"net"
+	"time"
 )
 
 type MigrateRoute int32
@@ -52,8 +53,88 @@ type Route struct {
 	CustomSubnet  []string `json:"custom_subnet,omitempty"`
 }
 
+// CNIArgs is the `args` convention of the network config, see:
+// https://github.com/containernetworking/cni/blob/main/CONVENTIONS.md#args-in-network-config
+type CNIArgs struct {
+	Cni map[string]interface{} `json:"cni,omitempty"`
+}
+
+// Overrides is the per-pod values which overwrite the network config,
+// they come from CNI_ARGS or the `args.cni` of the network config.
+// nil means not overridden.
+type Overrides struct {
+	Skipped                *bool
+	AdditionalHijackSubnet []string
+	RPFilterValue          *int32
+	MigrateRoute           *MigrateRoute
+	MacPrefix              *string
+	DryRun                 *bool
+}
+
+// Kubernetes is the config of looking up pod from kubernetes
+type Kubernetes struct {
+	// the path of kubeconfig, empty means disable the lookup
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+	// the timeout of the lookup, default to 3s
+	Timeout string `json:"timeout,omitempty"`
+	// ignore the failure of lookup and go on
+	FailOpen bool `json:"fail_open,omitempty"`
+}
+
+// AutoDiscovery is the config of discovering the service and overlay subnets from kubernetes,
+// the discovered subnets are used when service_hijack_subnet or overlay_hijack_subnet is empty.
+type AutoDiscovery struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// where the overlay subnets come from, available: calico, cilium.
+	// default to calico and cilium
+	OverlaySources []string `json:"overlay_sources,omitempty"`
+	// the discovered subnets are cached on the node, default to /var/run/meta-plugins/discovered-subnets.json
+	CacheFile string `json:"cache_file,omitempty"`
+	// how long the cache is valid, default to 10m
+	CacheTTL string `json:"cache_ttl,omitempty"`
+}
+
+// DiscoveredSubnets is the subnets discovered from kubernetes
+type DiscoveredSubnets struct {
+	OverlaySubnet []string `json:"overlay_subnet,omitempty"`
+	ServiceSubnet []string `json:"service_subnet,omitempty"`
+	// the inputs of the discovery, the cache is only used by the config with the same inputs
+	OverlaySources []string  `json:"overlay_sources,omitempty"`
+	Kubeconfig     string    `json:"kubeconfig,omitempty"`
+	Timestamp      time.Time `json:"timestamp"`
+}
+
 type IPConflict struct {
 	Enabled  bool   `json:"enabled,omitempty"`
 	Interval string `json:"interval,omitempty"`
 	Retry    int    `json:"retries,omitempty"`
 }
+
+// ReturnPath is the config of sending the replies of the connections from veth0 back through veth0,
+// the connections are marked in pod, and the marked replies are routed by a dedicated table.
+type ReturnPath struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// the connmark of the connections from veth0, default to 0x2000
+	Mark *int `json:"mark,omitempty"`
+	// the route table of the marked replies in pod, default to 1002
+	Table *int `json:"table,omitempty"`
+	// the priority of the fwmark rule in pod and the rules of pod ips on the node, default to 90
+	RulePriority *int `json:"rule_priority,omitempty"`
+}
+
+// HijackRoute is a route of pod to the destination through the overlay, the underlay or an interface in pod,
+// it's the rich form of additional_hijack_subnet.
+type HijackRoute struct {
+	// the destination cidr
+	Dst string `json:"dst"`
+	// overlay, underlay or the name of an interface in pod, default to overlay
+	Via string `json:"via,omitempty"`
+	// default to the gateway of overlay or underlay, no gateway for an interface
+	Gateway string `json:"gateway,omitempty"`
+	Metric  *int   `json:"metric,omitempty"`
+	// default to the table of the routes of overlay or underlay, main for an interface.
+	// the destination lookup the table by a rule if it's not main
+	Table *int `json:"table,omitempty"`
+	// the source hint of the route
+	Src string `json:"src,omitempty"`
+}
@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+)
+
+// InvocationContext returns the context of a plugin invocation started at startTime,
+// it's done once the given timeout is exceeded.
+func InvocationContext(startTime time.Time, timeout string) (context.Context, context.CancelFunc, error) {
+	d, err := time.ParseDuration(timeout)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid timeout %s: %w", timeout, err)
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), startTime.Add(d))
+	return ctx, cancel, nil
+}
+
+// IsTimeout returns true if the invocation failed with err because of a deadline: the one of invocation, of a
+// socket or of a net operation. the other errors are not timeouts even if they are returned after the deadline.
+func IsTimeout(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// TimeoutError returns the CNI error with code ErrTryAgainLater for the invocation which is timed out,
+// so the runtime can tell it from the other failures and retry.
+func TimeoutError(timeout string, err error) error {
+	var cniErr *cnitypes.Error
+	if errors.As(err, &cniErr) && cniErr.Code == cnitypes.ErrTryAgainLater {
+		return err
+	}
+	return cnitypes.NewError(cnitypes.ErrTryAgainLater, fmt.Sprintf("timed out after %s", timeout), err.Error())
+}
@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"golang.org/x/sys/unix"
+)
+
+var _ = Describe("Deadline", func() {
+	It("InvocationContext is done after the timeout", func() {
+		ctx, cancel, err := InvocationContext(time.Now().Add(-time.Second), "1s")
+		Expect(err).NotTo(HaveOccurred())
+		defer cancel()
+		Expect(ctx.Err()).To(MatchError(context.DeadlineExceeded))
+
+		_, _, err = InvocationContext(time.Now(), "1")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("IsTimeout", func() {
+		Expect(IsTimeout(nil)).To(BeFalse())
+		Expect(IsTimeout(errors.New("link not found"))).To(BeFalse())
+		Expect(IsTimeout(fmt.Errorf("failed to list routes: %w", context.DeadlineExceeded))).To(BeTrue())
+		Expect(IsTimeout(fmt.Errorf("failed to read message: %w", os.ErrDeadlineExceeded))).To(BeTrue())
+		Expect(IsTimeout(fmt.Errorf("failed to list routes: %w", unix.EAGAIN))).To(BeTrue(), "the timeout of netlink socket")
+		Expect(IsTimeout(fmt.Errorf("failed to add rule: %w", unix.EPERM))).To(BeFalse(), "even if it's returned after the deadline")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		Expect(IsTimeout(ctx.Err())).To(BeFalse())
+	})
+
+	It("TimeoutError returns the error with code ErrTryAgainLater", func() {
+		err := TimeoutError("30s", context.DeadlineExceeded)
+		var cniErr *cnitypes.Error
+		Expect(errors.As(err, &cniErr)).To(BeTrue())
+		Expect(cniErr.Code).To(Equal(uint(cnitypes.ErrTryAgainLater)))
+		Expect(cniErr.Msg).To(Equal("timed out after 30s"))
+		Expect(cniErr.Details).To(Equal(context.DeadlineExceeded.Error()))
+
+		Expect(TimeoutError("30s", err)).To(BeIdenticalTo(err))
+	})
+})
@@ -0,0 +1,136 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/spidernet-io/cni-plugins/pkg/constant"
+	"github.com/spidernet-io/cni-plugins/pkg/nl"
+	"github.com/spidernet-io/cni-plugins/pkg/types"
+	"github.com/vishvananda/netlink"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+// nftCommand is the nftables command, the script of the marks is passed by stdin like `nft -f -`
+var nftCommand = constant.NftCommand
+
+// Nftables runs the nftables script in the current network namespace, it only records the commands of the script
+// in dry-run mode, see plan.Plan.Nftables
+type Nftables func(ctx context.Context, script string) error
+
+// RunNftables runs the nftables script by nftCommand
+func RunNftables(ctx context.Context, script string) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, nftCommand, "-f", "-")
+	cmd.Stdin = strings.NewReader(script)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// IngressMark tells the replies of the connections arriving on Interface lookup Table
+type IngressMark struct {
+	Interface string
+	Table     int
+}
+
+// FwmarkValue returns the mark of the connections whose replies lookup the table, see constant.FwmarkMask
+func FwmarkValue(table int) int {
+	return (table << constant.FwmarkShift) & constant.FwmarkMask
+}
+
+// fwmarkNftCommands returns the nftables commands which mark the connections by the interface they arrive on, and
+// mark the replies of them. Every interface has its own chains which are flushed first, so the commands are idempotent
+func fwmarkNftCommands(marks []IngressMark) []string {
+	commands := []string{fmt.Sprintf("add table inet %s", constant.FwmarkNftTable)}
+	keep := fmt.Sprintf("%#x", ^uint32(constant.FwmarkMask))
+	for _, mark := range marks {
+		value := fmt.Sprintf("%#x", FwmarkValue(mark.Table))
+		prerouting := fmt.Sprintf("inet %s %q", constant.FwmarkNftTable, "prerouting-"+mark.Interface)
+		output := fmt.Sprintf("inet %s %q", constant.FwmarkNftTable, "output-"+mark.Interface)
+		commands = append(commands,
+			fmt.Sprintf("add chain %s { type filter hook prerouting priority -150; }", prerouting),
+			fmt.Sprintf("flush chain %s", prerouting),
+			fmt.Sprintf("add rule %s iifname %q ct state new ct mark set ct mark and %s or %s", prerouting, mark.Interface, keep, value),
+			fmt.Sprintf("add chain %s { type route hook output priority -150; }", output),
+			fmt.Sprintf("flush chain %s", output),
+			fmt.Sprintf("add rule %s ct mark and %#x == %s meta mark set meta mark and %s or %s", output, constant.FwmarkMask, value, keep, value),
+		)
+	}
+	return commands
+}
+
+// fwmarkRules returns the rules of the marked replies: ip rule add fwmark <mark>/<mask> lookup <table>
+func fwmarkRules(marks []IngressMark, enableIpv4, enableIpv6 bool) []*netlink.Rule {
+	var rules []*netlink.Rule
+	for _, mark := range marks {
+		for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+			if (family == netlink.FAMILY_V4 && !enableIpv4) || (family == netlink.FAMILY_V6 && !enableIpv6) {
+				continue
+			}
+			rule := netlink.NewRule()
+			rule.Family = family
+			rule.Mark = FwmarkValue(mark.Table)
+			rule.Mask = constant.FwmarkMask
+			rule.Table = mark.Table
+			rule.Priority = constant.FwmarkRulePriority
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// MarkByIngress makes the replies of the connections leave through the interface which they arrive on, regardless
+// of their destinations, such as the ones in the hijacked subnets:
+//  1. the new connections arriving on the interface are marked with the mark of its table by nftables in pod
+//  2. the replies of the marked connections get the same fwmark, and lookup the table by the rule of fwmark
+func MarkByIngress(ctx context.Context, logger *zap.Logger, netns ns.NetNS, podNl nl.Netlink, nftables Nftables, marks []IngressMark, enableIpv4, enableIpv6 bool) error {
+	if len(marks) == 0 {
+		return nil
+	}
+
+	script := strings.Join(fwmarkNftCommands(marks), "\n") + "\n"
+	err := netns.Do(func(_ ns.NetNS) error {
+		if err := nftables(ctx, script); err != nil {
+			return fmt.Errorf("failed to add the nftables rules of fwmark: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	for _, rule := range fwmarkRules(marks, enableIpv4, enableIpv6) {
+		if err = podNl.RuleAdd(rule); err != nil && !os.IsExist(err) {
+			logger.Error("failed to add the rule of fwmark", zap.String("rule", rule.String()), zap.Error(err))
+			return fmt.Errorf("failed to add the rule of fwmark %s: %w", rule.String(), err)
+		}
+	}
+	logger.Info("Succeeded to mark the connections by the ingress interface", zap.Any("marks", marks))
+	return nil
+}
+
+// FwmarkIngress returns the marks of the interfaces if the routes of defaultInterface are moved to ruleTable by
+// MigrateRoute: the replies of the connections arriving on defaultInterface lookup ruleTable, and the ones on
+// mainInterfaces lookup main
+func FwmarkIngress(value types.MigrateRoute, defaultInterface, chainedInterface string, ruleTable int, mainInterfaces ...string) []IngressMark {
+	if !Migrated(value, chainedInterface) {
+		return nil
+	}
+	marks := []IngressMark{{Interface: defaultInterface, Table: ruleTable}}
+	for _, name := range mainInterfaces {
+		if name != defaultInterface {
+			marks = append(marks, IngressMark{Interface: name, Table: unix.RT_TABLE_MAIN})
+		}
+	}
+	return marks
+}
@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spidernet-io/cni-plugins/pkg/nl"
+	"github.com/spidernet-io/cni-plugins/pkg/plan"
+	"github.com/spidernet-io/cni-plugins/pkg/types"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+var _ = Describe("Fwmark", func() {
+	marks := []IngressMark{{Interface: "eth0", Table: 100}, {Interface: "net1", Table: unix.RT_TABLE_MAIN}}
+
+	It("the mark of a table", func() {
+		Expect(FwmarkValue(100)).To(Equal(0x640000))
+		Expect(FwmarkValue(unix.RT_TABLE_MAIN)).To(Equal(0xfe0000))
+		Expect(FwmarkValue(356)).To(Equal(0x1640000))
+	})
+
+	It("FwmarkIngress returns nothing if the routes are not migrated", func() {
+		Expect(FwmarkIngress(types.MigrateNever, "eth0", "net1", 100, "net1")).To(BeEmpty())
+		Expect(FwmarkIngress(types.MigrateEnable, "eth0", "net1", 100, "net1")).To(Equal(marks))
+		Expect(FwmarkIngress(types.MigrateEnable, "net2", "net2", 101, "net2")).To(Equal([]IngressMark{{Interface: "net2", Table: 101}}))
+	})
+
+	Context("Test MarkByIngress", func() {
+		BeforeEach(func() {
+			if testNetNs == nil {
+				Skip("root is required to create the netns")
+			}
+			DeferCleanup(func(command string) { nftCommand = command }, nftCommand)
+		})
+
+		It("add the rules of fwmark after the nftables rules", func() {
+			nftCommand = "true"
+			pod := nl.NewFake()
+			Expect(MarkByIngress(context.Background(), logger, testNetNs, pod, RunNftables, marks, true, false)).To(Succeed())
+			Expect(MarkByIngress(context.Background(), logger, testNetNs, pod, RunNftables, marks, true, false)).To(Succeed())
+
+			rules, _ := pod.RuleList(netlink.FAMILY_V4)
+			Expect(rules).To(ContainElement(SatisfyAll(HaveField("Mark", 0x640000), HaveField("Table", 100))))
+			Expect(rules).To(ContainElement(SatisfyAll(HaveField("Mark", 0xfe0000), HaveField("Table", unix.RT_TABLE_MAIN))))
+		})
+
+		It("nftables fails", func() {
+			nftCommand = "false"
+			pod := nl.NewFake()
+			err := MarkByIngress(context.Background(), logger, testNetNs, pod, RunNftables, marks, true, false)
+			Expect(err).To(MatchError(ContainSubstring("failed to add the nftables rules of fwmark")))
+			rules, _ := pod.RuleList(netlink.FAMILY_V4)
+			Expect(rules).NotTo(ContainElement(HaveField("Mark", 0x640000)))
+		})
+
+		It("record the nftables rules and the rules of fwmark in dry-run mode", func() {
+			p := &plan.Plan{}
+			podNl := p.Netlink(plan.PodNetns, nl.NewFake())
+			Expect(MarkByIngress(context.Background(), logger, testNetNs, podNl, p.Nftables(plan.PodNetns), marks[:1], true, true)).To(Succeed())
+			Expect(p.Changes).To(Equal([]plan.Change{
+				{Netns: plan.PodNetns, Kind: plan.KindNftables, Command: "nft add table inet meta-plugins"},
+				{Netns: plan.PodNetns, Kind: plan.KindNftables, Command: `nft add chain inet meta-plugins "prerouting-eth0" { type filter hook prerouting priority -150; }`},
+				{Netns: plan.PodNetns, Kind: plan.KindNftables, Command: `nft flush chain inet meta-plugins "prerouting-eth0"`},
+				{Netns: plan.PodNetns, Kind: plan.KindNftables, Command: `nft add rule inet meta-plugins "prerouting-eth0" iifname "eth0" ct state new ct mark set ct mark and 0xffff or 0x640000`},
+				{Netns: plan.PodNetns, Kind: plan.KindNftables, Command: `nft add chain inet meta-plugins "output-eth0" { type route hook output priority -150; }`},
+				{Netns: plan.PodNetns, Kind: plan.KindNftables, Command: `nft flush chain inet meta-plugins "output-eth0"`},
+				{Netns: plan.PodNetns, Kind: plan.KindNftables, Command: `nft add rule inet meta-plugins "output-eth0" ct mark and 0xffff0000 == 0x640000 meta mark set meta mark and 0xffff or 0x640000`},
+				{Netns: plan.PodNetns, Kind: plan.KindRule, Command: "ip rule add priority 95 from all fwmark 0x640000/0xffff0000 lookup 100"},
+				{Netns: plan.PodNetns, Kind: plan.KindRule, Command: "ip rule add priority 95 from all fwmark 0x640000/0xffff0000 lookup 100"},
+			}))
+
+			p = &plan.Plan{}
+			Expect(MarkByIngress(context.Background(), logger, testNetNs, p.Netlink(plan.PodNetns, nl.NewFake()), p.Nftables(plan.PodNetns), nil, true, true)).To(Succeed())
+			Expect(p.Changes).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,160 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/spidernet-io/cni-plugins/pkg/constant"
+	"github.com/spidernet-io/cni-plugins/pkg/networking"
+	"github.com/spidernet-io/cni-plugins/pkg/nl"
+	"github.com/spidernet-io/cni-plugins/pkg/types"
+	"github.com/vishvananda/netlink"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+// HijackPath is where the hijack routes via overlay or underlay go: the interface, the table of its routes, and
+// the gateways and the sources which are used if the route doesn't give one
+type HijackPath struct {
+	Interface string
+	Table     int
+	V4Gw      net.IP
+	V6Gw      net.IP
+	SrcIPs    []netlink.Addr
+}
+
+// AddSrcHints gives the addresses of the interfaces to the paths without SrcIPs, so the hijack routes prefer the
+// source of the interface which they go through. The interfaces given by via are added as the paths of main table
+func AddSrcHints(podNl nl.Netlink, paths map[string]HijackPath, specs []types.HijackRoute) error {
+	for _, spec := range specs {
+		if _, ok := paths[spec.Via]; !ok && spec.Via != constant.HijackViaOverlay && spec.Via != constant.HijackViaUnderlay {
+			paths[spec.Via] = HijackPath{Interface: spec.Via, Table: unix.RT_TABLE_MAIN}
+		}
+	}
+	for via, path := range paths {
+		if len(path.SrcIPs) != 0 {
+			continue
+		}
+		addrs, err := networking.IPAddressByName(podNl, path.Interface, netlink.FAMILY_ALL)
+		if err != nil {
+			return fmt.Errorf("failed to get the addresses of %s: %w", path.Interface, err)
+		}
+		path.SrcIPs = addrs
+		paths[via] = path
+	}
+	return nil
+}
+
+// DefaultGateways returns the gateways of the default routes through the interface in main table
+func DefaultGateways(podNl nl.Netlink, iface string) (v4Gw, v6Gw net.IP, err error) {
+	link, err := podNl.LinkByName(iface)
+	if err != nil {
+		return nil, nil, err
+	}
+	routes, err := podNl.RouteList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list the routes of %s: %w", iface, err)
+	}
+	for _, route := range routes {
+		if route.Gw == nil || (route.Dst != nil && !route.Dst.IP.IsUnspecified()) {
+			continue
+		}
+		if route.Gw.To4() != nil && v4Gw == nil {
+			v4Gw = route.Gw
+		}
+		if route.Gw.To4() == nil && v6Gw == nil {
+			v6Gw = route.Gw
+		}
+	}
+	return v4Gw, v6Gw, nil
+}
+
+// hijackRouteAndRule returns the route of the hijack route and the interface of it, and the rule which makes the
+// destination lookup the table if it's not main. the route is nil if its family is not enabled
+func hijackRouteAndRule(spec types.HijackRoute, paths map[string]HijackPath, enableIpv4, enableIpv6 bool) (*netlink.Route, *netlink.Rule, string, error) {
+	_, dst, err := net.ParseCIDR(spec.Dst)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	isV4 := dst.IP.To4() != nil
+	if (isV4 && !enableIpv4) || (!isV4 && !enableIpv6) {
+		return nil, nil, "", nil
+	}
+
+	path, ok := paths[spec.Via]
+	if !ok {
+		if spec.Via == constant.HijackViaOverlay || spec.Via == constant.HijackViaUnderlay {
+			return nil, nil, "", fmt.Errorf("the pod has no %s interface for the hijack route %s", spec.Via, spec.Dst)
+		}
+		path = HijackPath{Interface: spec.Via, Table: unix.RT_TABLE_MAIN}
+	}
+
+	route := &netlink.Route{Dst: dst, Table: path.Table, Gw: path.V4Gw, Scope: netlink.SCOPE_UNIVERSE}
+	if !isV4 {
+		route.Gw = path.V6Gw
+	}
+	if spec.Gateway != "" {
+		route.Gw = net.ParseIP(spec.Gateway)
+	}
+	if route.Gw == nil {
+		route.Scope = netlink.SCOPE_LINK
+	}
+	route.Src = networking.SrcHint(path.SrcIPs, dst.IP)
+	if spec.Src != "" {
+		route.Src = net.ParseIP(spec.Src)
+	}
+	if spec.Metric != nil {
+		route.Priority = *spec.Metric
+	}
+	if spec.Table != nil {
+		route.Table = *spec.Table
+	}
+
+	var rule *netlink.Rule
+	if route.Table != unix.RT_TABLE_MAIN {
+		rule = netlink.NewRule()
+		rule.Family = netlink.FAMILY_V6
+		if isV4 {
+			rule.Family = netlink.FAMILY_V4
+		}
+		rule.Dst = dst
+		rule.Table = route.Table
+	}
+	return route, rule, path.Interface, nil
+}
+
+// AddHijackRoutes adds the hijack routes through the given paths or interfaces in pod:
+// ip route add <dst> via <gateway> dev <interface> src <src> metric <metric> table <table>,
+// and ip rule add to <dst> lookup <table> if the table is not main
+func AddHijackRoutes(logger *zap.Logger, podNl nl.Netlink, specs []types.HijackRoute, paths map[string]HijackPath, enableIpv4, enableIpv6 bool) error {
+	for _, spec := range specs {
+		route, rule, iface, err := hijackRouteAndRule(spec, paths, enableIpv4, enableIpv6)
+		if err != nil {
+			logger.Error(err.Error())
+			return err
+		}
+		if route == nil {
+			logger.Debug("Ignore the hijack route of the disabled family", zap.String("dst", spec.Dst))
+			continue
+		}
+
+		link, err := podNl.LinkByName(iface)
+		if err != nil {
+			logger.Error("failed to find the interface of hijack route", zap.String("dst", spec.Dst), zap.String("interface", iface), zap.Error(err))
+			return fmt.Errorf("failed to find the interface %s of hijack route %s: %w", iface, spec.Dst, err)
+		}
+		route.LinkIndex = link.Attrs().Index
+		if err = podNl.RouteAdd(route); err != nil && !os.IsExist(err) {
+			logger.Error("failed to add the hijack route", zap.String("route", route.String()), zap.Error(err))
+			return fmt.Errorf("failed to add the hijack route %s: %w", route.String(), err)
+		}
+		if rule != nil {
+			if err = hijackRuleAdd(logger, podNl, []*netlink.Rule{rule}); err != nil {
+				return err
+			}
+		}
+		logger.Debug("Added the hijack route", zap.String("route", route.String()), zap.String("interface", iface))
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package utils
+
+import (
+	"context"
+
+	"github.com/spidernet-io/cni-plugins/pkg/constant"
+	"github.com/spidernet-io/cni-plugins/pkg/lock"
+	"go.uber.org/zap"
+)
+
+var hostLockFile = constant.HostLockFile
+
+// WithHostLock runs f under the node-wide lock of host network, the lock is released once f returns, so f should only
+// change the rules, routes and neighbors of host, which are shared by all pods on the node. f runs without the lock in
+// dry-run mode, as nothing is changed then.
+func WithHostLock(ctx context.Context, logger *zap.Logger, dryRun bool, f func() error) error {
+	if dryRun {
+		return f()
+	}
+
+	hostLock, wait, err := lock.Lock(ctx, hostLockFile)
+	if err != nil {
+		logger.Error("failed to acquire the node-wide lock of host network", zap.Error(err))
+		return err
+	}
+	defer hostLock.Unlock()
+	logger.Info("Acquired the node-wide lock of host network", zap.Duration("wait", wait))
+
+	return f()
+}
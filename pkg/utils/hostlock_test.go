@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spidernet-io/cni-plugins/pkg/lock"
+)
+
+var _ = Describe("HostLock", func() {
+	BeforeEach(func() {
+		DeferCleanup(func(file string) { hostLockFile = file }, hostLockFile)
+		hostLockFile = filepath.Join(GinkgoT().TempDir(), "host.lock")
+	})
+
+	// the lock is held if another invocation can't acquire it in time
+	locked := func() bool {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		l, _, err := lock.Lock(ctx, hostLockFile)
+		if err != nil {
+			return true
+		}
+		Expect(l.Unlock()).To(Succeed())
+		return false
+	}
+
+	It("hold the lock only while f runs", func() {
+		err := WithHostLock(context.Background(), logger, false, func() error {
+			Expect(locked()).To(BeTrue())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(locked()).To(BeFalse())
+	})
+
+	It("don't lock in dry-run mode", func() {
+		err := WithHostLock(context.Background(), logger, true, func() error {
+			Expect(locked()).To(BeFalse())
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
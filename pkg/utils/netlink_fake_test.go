@@ -0,0 +1,355 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spidernet-io/cni-plugins/pkg/constant"
+	"github.com/spidernet-io/cni-plugins/pkg/nl"
+	"github.com/spidernet-io/cni-plugins/pkg/plan"
+	"github.com/spidernet-io/cni-plugins/pkg/types"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	"k8s.io/utils/pointer"
+)
+
+// these tests use the fake netlink, so they don't need root
+var _ = Describe("Utils with fake netlink", func() {
+	var pod, host *nl.Fake
+	var eth0, net1, cali netlink.Link
+	var eth0Addrs []netlink.Addr
+	gw := net.ParseIP("169.254.1.1")
+
+	mustParseCIDR := func(s string) *net.IPNet {
+		_, ipNet, err := net.ParseCIDR(s)
+		Expect(err).NotTo(HaveOccurred())
+		return ipNet
+	}
+
+	BeforeEach(func() {
+		host = nl.NewFake()
+		cali = host.AddVeth(netlink.LinkAttrs{Name: "cali12345", HardwareAddr: net.HardwareAddr{0xee, 0xee, 0xee, 0xee, 0xee, 0xee}})
+
+		// the pod created by calico, and net1 created by macvlan
+		pod = nl.NewFake()
+		eth0 = pod.AddVeth(netlink.LinkAttrs{Name: "eth0", ParentIndex: cali.Attrs().Index, HardwareAddr: net.HardwareAddr{0x0a, 0x1b, 0x0a, 0xf4, 0x01, 0x0a}})
+		net1 = pod.AddLink(netlink.LinkAttrs{Name: "net1"})
+		Expect(pod.AddAddr("eth0", "10.244.1.10/32")).To(Succeed())
+		Expect(pod.AddAddr("net1", "10.6.1.10/16")).To(Succeed())
+		Expect(pod.RouteAdd(&netlink.Route{LinkIndex: eth0.Attrs().Index, Dst: mustParseCIDR("169.254.1.1/32"), Scope: netlink.SCOPE_LINK})).To(Succeed())
+		Expect(pod.RouteAdd(&netlink.Route{LinkIndex: eth0.Attrs().Index, Gw: gw})).To(Succeed())
+
+		eth0Addrs, _ = pod.AddrList(eth0, netlink.FAMILY_V4)
+	})
+
+	Context("Test MigrateRoute", func() {
+		It("moves the default route of overlay interface to the rule table", func() {
+			err := MigrateRoute(logger, pod, "eth0", "net1", eth0Addrs, types.MigrateEnable, 100, true, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			rules, _ := pod.RuleList(netlink.FAMILY_V4)
+			Expect(rules).To(ContainElement(SatisfyAll(
+				HaveField("Src.String()", Equal("10.244.1.10/32")),
+				HaveField("Table", Equal(100)),
+			)))
+
+			main, _ := pod.RouteList(nil, netlink.FAMILY_V4)
+			for _, route := range main {
+				Expect(route.Dst).NotTo(BeNil(), "the default route must be removed from main table")
+			}
+
+			table := pod.RoutesInTable(100)
+			Expect(table).To(HaveLen(3))
+			Expect(table).To(ContainElement(SatisfyAll(HaveField("Dst", BeNil()), HaveField("Gw", Equal(gw)))))
+		})
+
+		It("is idempotent", func() {
+			Expect(MigrateRoute(logger, pod, "eth0", "net1", eth0Addrs, types.MigrateEnable, 100, true, false)).To(Succeed())
+			Expect(MigrateRoute(logger, pod, "eth0", "net1", eth0Addrs, types.MigrateEnable, 100, true, false)).To(Succeed())
+			Expect(pod.RoutesInTable(100)).To(HaveLen(3))
+		})
+
+		It("never migrate", func() {
+			Expect(MigrateRoute(logger, pod, "eth0", "net1", eth0Addrs, types.MigrateNever, 100, true, false)).To(Succeed())
+			Expect(pod.RoutesInTable(100)).To(BeEmpty())
+		})
+
+		It("moves the ipv6 multipath default route", func() {
+			net2 := pod.AddLink(netlink.LinkAttrs{Name: "net2"})
+			gw1, gw2 := net.ParseIP("fd00::1"), net.ParseIP("fd00::2")
+			Expect(pod.RouteAdd(&netlink.Route{Family: netlink.FAMILY_V6, MultiPath: []*netlink.NexthopInfo{
+				{LinkIndex: eth0.Attrs().Index, Gw: gw1},
+				{LinkIndex: net2.Attrs().Index, Gw: gw2},
+			}})).To(Succeed())
+			Expect(pod.RouteAdd(&netlink.Route{LinkIndex: eth0.Attrs().Index, Gw: gw1, Table: 100})).To(Succeed())
+
+			Expect(moveRouteTable(logger, pod, "eth0", 100, netlink.FAMILY_V6)).NotTo(HaveOccurred(),
+				"adding the existing route to the rule table is ignored")
+
+			main, _ := pod.RouteList(nil, netlink.FAMILY_V6)
+			Expect(main).To(HaveLen(1))
+			Expect(main[0].MultiPath).To(HaveLen(1), "only the nexthop via eth0 is removed")
+			Expect(main[0].MultiPath[0].Gw).To(Equal(gw2))
+		})
+
+		It("unknown interface return err", func() {
+			err := MigrateRoute(logger, pod, "eth1", "net1", eth0Addrs, types.MigrateEnable, 100, true, false)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Test HijackCustomSubnet", func() {
+		It("hijack overlay and service subnets for the first interface", func() {
+			err := HijackCustomSubnet(logger, pod, serviceSubnet, overlaySubnet, []string{"10.7.0.0/16"}, eth0Addrs, 100, true, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			rules, _ := pod.RuleList(netlink.FAMILY_V4)
+			var dsts []string
+			for _, rule := range rules {
+				if rule.Table == 100 {
+					dsts = append(dsts, rule.Dst.String())
+				}
+			}
+			Expect(dsts).To(ConsistOf("10.244.0.0/16", "10.96.0.0/16", "10.7.0.0/16"))
+
+			rules, _ = pod.RuleList(netlink.FAMILY_V6)
+			Expect(rules).To(HaveLen(3), "only the default rules")
+		})
+
+		It("hijack the ips of previous interface for the other interfaces", func() {
+			err := HijackCustomSubnet(logger, pod, serviceSubnet, overlaySubnet, nil, eth0Addrs, 101, true, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			rules, _ := pod.RuleList(netlink.FAMILY_V4)
+			Expect(rules).To(ContainElement(SatisfyAll(
+				HaveField("Dst.String()", Equal("10.244.1.10/32")),
+				HaveField("Table", Equal(101)),
+			)))
+		})
+
+		It("existing rules are ignored", func() {
+			Expect(HijackCustomSubnet(logger, pod, serviceSubnet, overlaySubnet, nil, eth0Addrs, 100, true, true)).To(Succeed())
+			Expect(HijackCustomSubnet(logger, pod, serviceSubnet, overlaySubnet, nil, eth0Addrs, 100, true, true)).To(Succeed())
+		})
+	})
+
+	Context("Test IsolateByVRF", func() {
+		var nodeGw = net.ParseIP("10.6.0.1")
+
+		BeforeEach(func() {
+			Expect(pod.AddAddr("net1", "fd00:10:6::10/64")).To(Succeed())
+			Expect(pod.RouteAdd(&netlink.Route{LinkIndex: net1.Attrs().Index, Gw: nodeGw, Priority: 100})).To(Succeed())
+		})
+
+		It("enslaves the chained interface to the vrf device with its routes", func() {
+			err := IsolateByVRF(logger, pod, "net1", types.MigrateEnable, 100, true, true)
+			Expect(err).NotTo(HaveOccurred())
+
+			link, err := pod.LinkByName("vrf-net1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(link).To(BeAssignableToTypeOf(&netlink.Vrf{}))
+			Expect(link.(*netlink.Vrf).Table).To(BeEquivalentTo(100))
+			Expect(link.Attrs().Flags & net.FlagUp).NotTo(BeZero())
+			net1, _ = pod.LinkByName("net1")
+			Expect(net1.Attrs().MasterIndex).To(Equal(link.Attrs().Index))
+
+			table := pod.RoutesInTable(100)
+			Expect(table).To(ContainElement(SatisfyAll(HaveField("Dst", BeNil()), HaveField("Gw", Equal(nodeGw)))))
+			Expect(table).To(ContainElement(HaveField("Dst.String()", Equal("10.6.0.0/16"))))
+			Expect(table).To(ContainElement(HaveField("Dst.String()", Equal("fd00:10:6::/64"))))
+			for _, route := range table {
+				Expect(route.LinkIndex).To(Equal(net1.Attrs().Index))
+			}
+
+			// the overlay stays in the default vrf, which reaches net1 through the vrf device
+			main := pod.RoutesInTable(unix.RT_TABLE_MAIN)
+			Expect(main).To(ContainElement(SatisfyAll(HaveField("Dst", BeNil()), HaveField("Gw", Equal(gw)))))
+			Expect(main).To(ContainElement(SatisfyAll(HaveField("Dst.String()", Equal("10.6.1.10/32")), HaveField("LinkIndex", Equal(link.Attrs().Index)))))
+			Expect(main).To(ContainElement(SatisfyAll(HaveField("Dst.String()", Equal("fd00:10:6::10/128")), HaveField("LinkIndex", Equal(link.Attrs().Index)))))
+			for _, route := range main {
+				Expect(route.LinkIndex).NotTo(Equal(net1.Attrs().Index))
+			}
+
+			addrs, _ := pod.AddrList(net1, netlink.FAMILY_V6)
+			Expect(addrs).To(ContainElement(SatisfyAll(HaveField("IPNet.String()", Equal("fd00:10:6::10/64")), HaveField("Flags", Equal(unix.IFA_F_NODAD)))))
+
+			// no policy rules in vrf mode
+			rules, _ := pod.RuleList(netlink.FAMILY_ALL)
+			for _, rule := range rules {
+				Expect(rule.Table).NotTo(Equal(100))
+			}
+		})
+
+		It("is idempotent", func() {
+			Expect(IsolateByVRF(logger, pod, "net1", types.MigrateEnable, 100, true, false)).To(Succeed())
+			table := pod.RoutesInTable(100)
+			Expect(IsolateByVRF(logger, pod, "net1", types.MigrateEnable, 100, true, false)).To(Succeed())
+			Expect(pod.RoutesInTable(100)).To(HaveLen(len(table)))
+		})
+
+		It("does nothing if never migrate", func() {
+			Expect(IsolateByVRF(logger, pod, "net1", types.MigrateNever, 100, true, true)).To(Succeed())
+			_, err := pod.LinkByName("vrf-net1")
+			Expect(err).To(HaveOccurred())
+			Expect(pod.RoutesInTable(100)).To(BeEmpty())
+		})
+
+		It("the link with the name of vrf device is not a vrf", func() {
+			pod.AddLink(netlink.LinkAttrs{Name: "vrf-net1"})
+			err := IsolateByVRF(logger, pod, "net1", types.MigrateEnable, 100, true, false)
+			Expect(err).To(MatchError("vrf-net1 is not the vrf device of table 100"))
+		})
+
+		It("record the vrf device and the routes in dry-run mode", func() {
+			p := &plan.Plan{}
+			err := IsolateByVRF(logger, p.Netlink(plan.PodNetns, pod), "net1", types.MigrateEnable, 100, true, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(p.Changes).To(Equal([]plan.Change{
+				{Netns: plan.PodNetns, Kind: plan.KindLink, Command: "ip link add vrf-net1 type vrf table 100"},
+				{Netns: plan.PodNetns, Kind: plan.KindLink, Command: "ip link set vrf-net1 up"},
+				{Netns: plan.PodNetns, Kind: plan.KindLink, Command: "ip link set net1 master vrf-net1"},
+				{Netns: plan.PodNetns, Kind: plan.KindAddr, Command: "ip addr add fd00:10:6::10/64 dev net1 nodad"},
+				{Netns: plan.PodNetns, Kind: plan.KindRoute, Command: "ip route add 10.6.0.0/16 dev net1 src 10.6.1.10 scope link table 100 proto 2"},
+				{Netns: plan.PodNetns, Kind: plan.KindRoute, Command: "ip route del default via 10.6.0.1 dev net1 metric 100"},
+				{Netns: plan.PodNetns, Kind: plan.KindRoute, Command: "ip route add default via 10.6.0.1 dev net1 metric 100 table 100"},
+				{Netns: plan.PodNetns, Kind: plan.KindRoute, Command: "ip route add fd00:10:6::/64 dev net1 src fd00:10:6::10 scope link table 100 proto 2"},
+				{Netns: plan.PodNetns, Kind: plan.KindRoute, Command: "ip route add 10.6.1.10/32 dev vrf-net1 scope link"},
+				{Netns: plan.PodNetns, Kind: plan.KindRoute, Command: "ip route add fd00:10:6::10/128 dev vrf-net1 scope link"},
+			}))
+		})
+	})
+
+	Context("Test AddHijackRoutes", func() {
+		var paths map[string]HijackPath
+		var specs []types.HijackRoute
+		nodeGw := net.ParseIP("10.6.0.1")
+
+		BeforeEach(func() {
+			Expect(pod.RouteAdd(&netlink.Route{LinkIndex: net1.Attrs().Index, Gw: nodeGw, Priority: 100})).To(Succeed())
+			paths = map[string]HijackPath{
+				constant.HijackViaOverlay:  {Interface: "eth0", Table: 100, V4Gw: gw},
+				constant.HijackViaUnderlay: {Interface: "net1", Table: unix.RT_TABLE_MAIN, V4Gw: nodeGw},
+			}
+			specs = []types.HijackRoute{
+				{Dst: "10.7.0.0/16", Via: constant.HijackViaOverlay},
+				{Dst: "172.16.0.0/16", Via: constant.HijackViaUnderlay, Metric: pointer.Int(10), Src: "10.6.1.10"},
+				{Dst: "192.168.0.0/24", Via: "net1", Table: pointer.Int(200)},
+				{Dst: "fd00:10:7::/64", Via: constant.HijackViaOverlay},
+			}
+		})
+
+		It("the gateways of the default routes", func() {
+			v4Gw, v6Gw, err := DefaultGateways(pod, "net1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(v4Gw).To(Equal(nodeGw))
+			Expect(v6Gw).To(BeNil())
+
+			_, _, err = DefaultGateways(pod, "net9")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("add the routes through the paths and the rules of the tables", func() {
+			Expect(AddHijackRoutes(logger, pod, specs, paths, true, false)).To(Succeed())
+			Expect(AddHijackRoutes(logger, pod, specs, paths, true, false)).To(Succeed())
+
+			Expect(pod.RoutesInTable(100)).To(ConsistOf(SatisfyAll(
+				HaveField("Dst.String()", Equal("10.7.0.0/16")),
+				HaveField("Gw", Equal(gw)),
+				HaveField("LinkIndex", Equal(eth0.Attrs().Index)),
+			)))
+			Expect(pod.RoutesInTable(unix.RT_TABLE_MAIN)).To(ContainElement(SatisfyAll(
+				HaveField("Dst.String()", Equal("172.16.0.0/16")),
+				HaveField("Gw", Equal(nodeGw)),
+				HaveField("Priority", Equal(10)),
+				HaveField("Src", Equal(net.ParseIP("10.6.1.10"))),
+			)))
+			Expect(pod.RoutesInTable(200)).To(ConsistOf(SatisfyAll(
+				HaveField("Dst.String()", Equal("192.168.0.0/24")),
+				HaveField("Gw", BeNil()),
+				HaveField("Scope", Equal(netlink.SCOPE_LINK)),
+				HaveField("LinkIndex", Equal(net1.Attrs().Index)),
+			)))
+
+			rules, _ := pod.RuleList(netlink.FAMILY_V4)
+			var dsts []string
+			for _, rule := range rules {
+				if rule.Dst != nil {
+					dsts = append(dsts, fmt.Sprintf("%s %d", rule.Dst, rule.Table))
+				}
+			}
+			Expect(dsts).To(ConsistOf("10.7.0.0/16 100", "192.168.0.0/24 200"))
+		})
+
+		It("the path or the interface is not found", func() {
+			err := AddHijackRoutes(logger, pod, []types.HijackRoute{{Dst: "10.7.0.0/16", Via: "net9"}}, paths, true, false)
+			Expect(err).To(MatchError(ContainSubstring("failed to find the interface net9")))
+			err = AddHijackRoutes(logger, pod, specs[:1], nil, true, false)
+			Expect(err).To(MatchError("the pod has no overlay interface for the hijack route 10.7.0.0/16"))
+		})
+
+		It("record the routes and the rules in dry-run mode", func() {
+			p := &plan.Plan{}
+			Expect(AddHijackRoutes(logger, p.Netlink(plan.PodNetns, pod), specs, paths, true, false)).To(Succeed())
+			Expect(p.Changes).To(Equal([]plan.Change{
+				{Netns: plan.PodNetns, Kind: plan.KindRoute, Command: "ip route add 10.7.0.0/16 via 169.254.1.1 dev eth0 table 100"},
+				{Netns: plan.PodNetns, Kind: plan.KindRule, Command: "ip rule add from all to 10.7.0.0/16 lookup 100"},
+				{Netns: plan.PodNetns, Kind: plan.KindRoute, Command: "ip route add 172.16.0.0/16 via 10.6.0.1 dev net1 src 10.6.1.10 metric 10"},
+				{Netns: plan.PodNetns, Kind: plan.KindRoute, Command: "ip route add 192.168.0.0/24 dev net1 scope link table 200"},
+				{Netns: plan.PodNetns, Kind: plan.KindRule, Command: "ip rule add from all to 192.168.0.0/24 lookup 200"},
+			}))
+		})
+
+		It("the routes prefer the source of the interface which they go through", func() {
+			Expect(AddSrcHints(pod, paths, specs)).To(Succeed())
+			Expect(paths).To(HaveKey("net1"))
+
+			p := &plan.Plan{}
+			Expect(AddHijackRoutes(logger, p.Netlink(plan.PodNetns, pod), specs, paths, true, false)).To(Succeed())
+			Expect(p.Changes).To(Equal([]plan.Change{
+				{Netns: plan.PodNetns, Kind: plan.KindRoute, Command: "ip route add 10.7.0.0/16 via 169.254.1.1 dev eth0 src 10.244.1.10 table 100"},
+				{Netns: plan.PodNetns, Kind: plan.KindRule, Command: "ip rule add from all to 10.7.0.0/16 lookup 100"},
+				{Netns: plan.PodNetns, Kind: plan.KindRoute, Command: "ip route add 172.16.0.0/16 via 10.6.0.1 dev net1 src 10.6.1.10 metric 10"},
+				{Netns: plan.PodNetns, Kind: plan.KindRoute, Command: "ip route add 192.168.0.0/24 dev net1 src 10.6.1.10 scope link table 200"},
+				{Netns: plan.PodNetns, Kind: plan.KindRule, Command: "ip rule add from all to 192.168.0.0/24 lookup 200"},
+			}))
+
+			Expect(AddSrcHints(pod, map[string]HijackPath{}, []types.HijackRoute{{Dst: "10.7.0.0/16", Via: "net9"}})).NotTo(Succeed())
+		})
+	})
+
+	Context("Test AddStaticNeighTable", func() {
+		It("add the neighbors in pod and host", func() {
+			chainedIPs, _ := pod.AddrList(net1, netlink.FAMILY_V4)
+			err := AddHostStaticNeighTable(logger, pod, host, false, "eth0", chainedIPs)
+			Expect(err).NotTo(HaveOccurred())
+			err = AddStaticNeighTable(logger, pod, host, false, "eth0", []net.IP{net.ParseIP("10.6.0.1")})
+			Expect(err).NotTo(HaveOccurred())
+
+			podNeighs, _ := pod.NeighList(eth0.Attrs().Index, netlink.FAMILY_ALL)
+			Expect(podNeighs).To(HaveLen(1))
+			Expect(podNeighs[0].IP.String()).To(Equal("10.6.0.1"))
+			Expect(podNeighs[0].HardwareAddr).To(Equal(cali.Attrs().HardwareAddr))
+
+			hostNeighs, _ := host.NeighList(cali.Attrs().Index, netlink.FAMILY_ALL)
+			Expect(hostNeighs).To(HaveLen(1))
+			Expect(hostNeighs[0].IP.String()).To(Equal("10.6.1.10"))
+			Expect(hostNeighs[0].HardwareAddr).To(Equal(eth0.Attrs().HardwareAddr))
+		})
+
+		It("the overlay interface without veth peer return err", func() {
+			err := AddStaticNeighTable(logger, pod, host, false, "net1", []net.IP{net.ParseIP("10.6.0.1")})
+			Expect(err).To(MatchError(ContainSubstring("net1 in pod has no veth peer on the node")))
+			err = AddHostStaticNeighTable(logger, pod, host, false, "net1", nil)
+			Expect(err).To(MatchError(ContainSubstring("net1 in pod has no veth peer on the node")))
+			Expect(pod.Neighs).To(BeEmpty())
+		})
+
+		It("the veth peer not found on host return err", func() {
+			err := AddStaticNeighTable(logger, pod, nl.NewFake(), false, "eth0", []net.IP{net.ParseIP("10.6.0.1")})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+})
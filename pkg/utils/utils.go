@@ -2,12 +2,14 @@ package utils
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
 	"fmt"
 	"github.com/containernetworking/plugins/pkg/ip"
 	"github.com/containernetworking/plugins/pkg/ns"
-	"github.com/containernetworking/plugins/pkg/utils/sysctl"
 	"github.com/spidernet-io/cni-plugins/pkg/constant"
+	"github.com/spidernet-io/cni-plugins/pkg/networking"
+	"github.com/spidernet-io/cni-plugins/pkg/nl"
 	"github.com/spidernet-io/cni-plugins/pkg/types"
 	"github.com/vishvananda/netlink"
 	"go.uber.org/zap"
@@ -97,19 +99,19 @@ func GetChainedInterfaceIps(netns ns.NetNS, interfacenName string, enableIPv4, e
 	err = netns.Do(func(_ ns.NetNS) error {
 		netInterfaces, err := net.Interfaces()
 		if err != nil {
-			return fmt.Errorf("failed to list container interfaces: %v", err)
+			return fmt.Errorf("failed to list container interfaces: %w", err)
 		}
 
 		for _, netInterface := range netInterfaces {
 			if netInterface.Name == interfacenName {
 				addrs, err := netInterface.Addrs()
 				if err != nil {
-					return fmt.Errorf("failed to list all address for interface %s: %v", netInterface.Name, err)
+					return fmt.Errorf("failed to list all address for interface %s: %w", netInterface.Name, err)
 				}
 				for _, addr := range addrs {
 					netIP, _, err := net.ParseCIDR(addr.String())
 					if err != nil {
-						return fmt.Errorf("failed to parse cidr %s: %v", addr.String(), err)
+						return fmt.Errorf("failed to parse cidr %s: %w", addr.String(), err)
 					}
 					if netIP.IsMulticast() || netIP.IsLinkLocalUnicast() {
 						continue
@@ -186,20 +188,20 @@ func RouteAdd(logger *zap.Logger, ruleTable int, iface string, ips []string, ena
 	return dst4, dst6, nil
 }
 
-// SysctlRPFilter set rp_filter value
-func SysctlRPFilter(logger *zap.Logger, netns ns.NetNS, rp *types.RPFilter) error {
+// SysctlRPFilter set rp_filter value, hostSysctl and podSysctl are the sysctl of the node and pod
+func SysctlRPFilter(ctx context.Context, logger *zap.Logger, netns ns.NetNS, rp *types.RPFilter, hostSysctl, podSysctl networking.Sysctl) error {
 	var err error
 	if rp.Enable != nil && *rp.Enable {
-		if err = setRPFilter(logger, rp.Value); err != nil {
+		if err = setRPFilter(ctx, logger, rp.Value, hostSysctl); err != nil {
 			logger.Error(fmt.Sprintf("failed to set rp_filter for host : %v", err))
-			return fmt.Errorf("failed to set rp_filter for host : %v", err)
+			return fmt.Errorf("failed to set rp_filter for host : %w", err)
 		}
 	}
 	// set pod rp_filter
 	err = netns.Do(func(_ ns.NetNS) error {
-		if err := setRPFilter(logger, rp.Value); err != nil {
+		if err := setRPFilter(ctx, logger, rp.Value, podSysctl); err != nil {
 			logger.Error(fmt.Sprintf("failed to set rp_filter for pod : %v", err))
-			return fmt.Errorf("failed to set rp_filter for pod : %v", err)
+			return fmt.Errorf("failed to set rp_filter for pod : %w", err)
 		}
 		return nil
 	})
@@ -210,7 +212,7 @@ func SysctlRPFilter(logger *zap.Logger, netns ns.NetNS, rp *types.RPFilter) erro
 }
 
 // setRPFilter set rp_filter parameters
-func setRPFilter(logger *zap.Logger, v *int32) error {
+func setRPFilter(ctx context.Context, logger *zap.Logger, v *int32, sysctl networking.Sysctl) error {
 	if v == nil {
 		v = pointer.Int32(0)
 	}
@@ -221,8 +223,11 @@ func setRPFilter(logger *zap.Logger, v *int32) error {
 		return err
 	}
 	for _, dir := range dirs {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
 		name := fmt.Sprintf("/net/ipv4/conf/%s/rp_filter", dir.Name())
-		value, err := sysctl.Sysctl(name)
+		value, err := sysctl(name)
 		if err != nil {
 			logger.Warn("failed to get rp_filter value", zap.String("name", name), zap.Error(err))
 			continue
@@ -230,7 +235,7 @@ func setRPFilter(logger *zap.Logger, v *int32) error {
 		if value == fmt.Sprintf("%d", *v) {
 			continue
 		}
-		if _, e := sysctl.Sysctl(name, fmt.Sprintf("%d", *v)); e != nil {
+		if _, e := sysctl(name, fmt.Sprintf("%d", *v)); e != nil {
 			logger.Error("failed to set rp_filter", zap.String("name", name), zap.Error(err))
 			return e
 		}
@@ -239,12 +244,8 @@ func setRPFilter(logger *zap.Logger, v *int32) error {
 }
 
 // CheckInterfaceMiss returns true by checking if the veth0  exists in the container
-func CheckInterfaceMiss(netns ns.NetNS, intefaceName string) (bool, error) {
-	e := netns.Do(func(_ ns.NetNS) error {
-		_, err := netlink.LinkByName(intefaceName)
-		return err
-	})
-
+func CheckInterfaceMiss(podNl nl.Netlink, intefaceName string) (bool, error) {
+	_, e := podNl.LinkByName(intefaceName)
 	if e == nil {
 		return false, nil
 	}
@@ -255,7 +256,8 @@ func CheckInterfaceMiss(netns ns.NetNS, intefaceName string) (bool, error) {
 	}
 }
 
-func EnableIpv6Sysctl(logger *zap.Logger, netns ns.NetNS) error {
+// EnableIpv6Sysctl sets disable_ipv6 of all interfaces in pod to 0 by podSysctl
+func EnableIpv6Sysctl(ctx context.Context, logger *zap.Logger, netns ns.NetNS, podSysctl networking.Sysctl) error {
 	logger.Debug("Setting all interface sysctl 'disable_ipv6' to 0 ", zap.String("NetNs Path", netns.Path()))
 	err := netns.Do(func(_ ns.NetNS) error {
 		dirs, err := os.ReadDir(sysctlConfPathIPv6)
@@ -265,18 +267,21 @@ func EnableIpv6Sysctl(logger *zap.Logger, netns ns.NetNS) error {
 		}
 
 		for _, dir := range dirs {
+			if err = ctx.Err(); err != nil {
+				return err
+			}
 			// Read current sysctl value
 			name := fmt.Sprintf("/net/ipv6/conf/%s/disable_ipv6", dir.Name())
-			value, err := sysctl.Sysctl(name)
+			value, err := podSysctl(name)
 			if err != nil {
 				logger.Error("failed to read current sysctl value", zap.String("name", name), zap.Error(err))
-				return fmt.Errorf("failed to read current sysctl %+v value: %v", name, err)
+				return fmt.Errorf("failed to read current sysctl %+v value: %w", name, err)
 			}
 			// make sure value=0
 			if value != "0" {
-				if _, err = sysctl.Sysctl(name, "0"); err != nil {
+				if _, err = podSysctl(name, "0"); err != nil {
 					logger.Error("failed to set sysctl value to 0 ", zap.String("name", name), zap.Error(err))
-					return fmt.Errorf("failed to read current sysctl %+v value: %v ", name, err)
+					return fmt.Errorf("failed to read current sysctl %+v value: %w ", name, err)
 				}
 			}
 		}
@@ -288,69 +293,49 @@ func EnableIpv6Sysctl(logger *zap.Logger, netns ns.NetNS) error {
 // HijackCustomSubnet set ip rule : to Subnet table $routeTable
 // if first macvlan interface, move service/pod subnet route to table 100: ip rule add from all to service/pod look table 100
 // else only move custom route to table <ruleTable>: ip rule add from all to <custom_subnet> look table <ruletable>
-func HijackCustomSubnet(logger *zap.Logger, netns ns.NetNS, serviceSubnet, overlaySubnet, additionalSubnet []string, defaultInterfaceIPs []netlink.Addr, routeTable int, enableIpv4, enableIpv6 bool) error {
-	logger.Debug(fmt.Sprintf("Hijack Custom Subnet to %v ", routeTable), zap.String("Netns Path", netns.Path()),
+func HijackCustomSubnet(logger *zap.Logger, podNl nl.Netlink, serviceSubnet, overlaySubnet, additionalSubnet []string, defaultInterfaceIPs []netlink.Addr, routeTable int, enableIpv4, enableIpv6 bool) error {
+	logger.Debug(fmt.Sprintf("Hijack Custom Subnet to %v ", routeTable),
 		zap.Bool("enableIpv4", enableIpv4),
 		zap.Bool("enableIpv6", enableIpv6))
-	e := netns.Do(func(_ ns.NetNS) error {
-		var err error
-		// only first macvlan interface, we add rule table for it.
-		// eq: ip rule add from <overlay/service subnet> lookup <ruleTable>
-		if routeTable == overlayRouteTable {
-			allSubnets := append(overlaySubnet, serviceSubnet...)
-			if err = ruleAdd(logger, allSubnets, routeTable, enableIpv4, enableIpv6); err != nil {
-				return err
-			}
-
-		} else {
-			// As for more than two macvlan interface, we need to add something like below shown:
-			// eq: ip rule add to <defaultInterfaceIPs > lookup table <ruleTable>
-			// net2: ip rule add to <net1 subnet> lookup table <ruleTable>
-			if err = toRuleAdd(logger, defaultInterfaceIPs, routeTable, enableIpv4, enableIpv6); err != nil {
-				return err
-			}
+	var err error
+	// only first macvlan interface, we add rule table for it.
+	// eq: ip rule add from <overlay/service subnet> lookup <ruleTable>
+	if routeTable == overlayRouteTable {
+		allSubnets := append(overlaySubnet, serviceSubnet...)
+		if err = ruleAdd(logger, podNl, allSubnets, routeTable, enableIpv4, enableIpv6); err != nil {
+			return err
 		}
 
-		// last we hijack additionalSubnet to lookup table <routeTable>
-		if err = ruleAdd(logger, additionalSubnet, routeTable, enableIpv4, enableIpv6); err != nil {
+	} else {
+		// As for more than two macvlan interface, we need to add something like below shown:
+		// eq: ip rule add to <defaultInterfaceIPs > lookup table <ruleTable>
+		// net2: ip rule add to <net1 subnet> lookup table <ruleTable>
+		if err = toRuleAdd(logger, podNl, defaultInterfaceIPs, routeTable, enableIpv4, enableIpv6); err != nil {
 			return err
 		}
+	}
 
-		return nil
-	})
-	return e
+	// last we hijack additionalSubnet to lookup table <routeTable>
+	return ruleAdd(logger, podNl, additionalSubnet, routeTable, enableIpv4, enableIpv6)
 }
 
-// ruleAdd
-func ruleAdd(logger *zap.Logger, routes []string, routeTable int, enableIpv4, enableIpv6 bool) error {
-	for _, route := range routes {
-		_, ipNet, err := net.ParseCIDR(route)
-		if err != nil {
-			logger.Error(err.Error())
-			return err
-		}
-		var family int
-		match := false
-		if ipNet.IP.To4() != nil && enableIpv4 {
-			family = netlink.FAMILY_V4
-			match = true
-		}
-		if ipNet.IP.To4() == nil && enableIpv6 {
-			family = netlink.FAMILY_V6
-			match = true
-		}
+// ruleAdd adds the rules: ip rule add to <route> lookup <routeTable>
+func ruleAdd(logger *zap.Logger, h nl.Netlink, routes []string, routeTable int, enableIpv4, enableIpv6 bool) error {
+	rules, err := subnetRules(logger, routes, routeTable, enableIpv4, enableIpv6)
+	if err != nil {
+		return err
+	}
+	return hijackRuleAdd(logger, h, rules)
+}
 
-		if !match {
-			logger.Warn("the route given does not match the ipVersion of the pod, ignore the creation of this route", zap.String("route", ipNet.String()))
-			continue
-		}
+func toRuleAdd(logger *zap.Logger, h nl.Netlink, routes []netlink.Addr, routeTable int, enableIpv4, enableIpv6 bool) error {
+	return hijackRuleAdd(logger, h, addrRules(logger, routes, routeTable, enableIpv4, enableIpv6))
+}
 
-		rule := netlink.NewRule()
-		rule.Dst = ipNet
-		rule.Family = family
-		rule.Table = routeTable
-		logger.Debug("HijackCustomSubnet Add Rule table", zap.Int("ipfamily", family), zap.String("dst", rule.Dst.String()))
-		if err := netlink.RuleAdd(rule); err != nil && !strings.EqualFold(err.Error(), constant.ErrRouteFileExist) {
+func hijackRuleAdd(logger *zap.Logger, h nl.Netlink, rules []*netlink.Rule) error {
+	for _, rule := range rules {
+		logger.Debug("HijackCustomSubnet Add Rule table", zap.Int("ipfamily", rule.Family), zap.String("dst", rule.Dst.String()))
+		if err := h.RuleAdd(rule); err != nil && !strings.EqualFold(err.Error(), constant.ErrRouteFileExist) {
 			logger.Error(err.Error())
 			return fmt.Errorf("failed to set ip rule(%+v rule.Dst %v): %+v", rule, rule.Dst, err)
 		}
@@ -358,7 +343,23 @@ func ruleAdd(logger *zap.Logger, routes []string, routeTable int, enableIpv4, en
 	return nil
 }
 
-func toRuleAdd(logger *zap.Logger, routes []netlink.Addr, routeTable int, enableIpv4, enableIpv6 bool) error {
+// subnetRules returns the rules to the given subnets, the subnets which don't match the ipVersion of the pod are ignored
+func subnetRules(logger *zap.Logger, routes []string, routeTable int, enableIpv4, enableIpv6 bool) ([]*netlink.Rule, error) {
+	addrs := make([]netlink.Addr, 0, len(routes))
+	for _, route := range routes {
+		_, ipNet, err := net.ParseCIDR(route)
+		if err != nil {
+			logger.Error(err.Error())
+			return nil, err
+		}
+		addrs = append(addrs, netlink.Addr{IPNet: ipNet})
+	}
+	return addrRules(logger, addrs, routeTable, enableIpv4, enableIpv6), nil
+}
+
+// addrRules returns the rules to the given addresses, the addresses which don't match the ipVersion of the pod are ignored
+func addrRules(logger *zap.Logger, routes []netlink.Addr, routeTable int, enableIpv4, enableIpv6 bool) []*netlink.Rule {
+	rules := make([]*netlink.Rule, 0, len(routes))
 	for _, route := range routes {
 		var family int
 		match := false
@@ -380,17 +381,13 @@ func toRuleAdd(logger *zap.Logger, routes []netlink.Addr, routeTable int, enable
 		rule.Dst = route.IPNet
 		rule.Family = family
 		rule.Table = routeTable
-		logger.Debug("HijackCustomSubnet Add Rule table", zap.Int("ipfamily", family), zap.String("dst", rule.Dst.String()))
-		if err := netlink.RuleAdd(rule); err != nil && !strings.EqualFold(err.Error(), constant.ErrRouteFileExist) {
-			logger.Error(err.Error())
-			return fmt.Errorf("failed to set ip rule(%+v rule.Dst %v): %+v", rule, rule.Dst, err)
-		}
+		rules = append(rules, rule)
 	}
-	return nil
+	return rules
 }
 
 // MigrateRoute make sure that the reply packets accessing the overlay interface are still sent from the overlay interface.
-func MigrateRoute(logger *zap.Logger, netns ns.NetNS, defaultInterface, chainedInterface string, defaultInterfaceIPs []netlink.Addr, value types.MigrateRoute, ruleTable int, enableIpv4, enableIpv6 bool) error {
+func MigrateRoute(logger *zap.Logger, podNl nl.Netlink, defaultInterface, chainedInterface string, defaultInterfaceIPs []netlink.Addr, value types.MigrateRoute, ruleTable int, enableIpv4, enableIpv6 bool) error {
 	/*
 		1. if migrateValue = -1, auto migrate route by interface name, if current_interface > last_interface by directory order, do migrate else nothing to do
 		2. if migrateValue = 1, do migrate directly
@@ -420,30 +417,20 @@ func MigrateRoute(logger *zap.Logger, netns ns.NetNS, defaultInterface, chainedI
 	// add route rule: source overlayIP for new rule
 	// eq: ip rule add from <defaultRoute interface> lookup <ruleTable>
 	logger.Debug("Add Rule Table in Pod Netns", zap.Int("ruleTable", ruleTable), zap.Any("chainedIPs", defaultInterfaceIPs))
-	err = netns.Do(func(_ ns.NetNS) error {
-		if err = AddFromRuleTable(logger, defaultInterfaceIPs, ruleTable, enableIpv4, enableIpv6); err != nil {
-			logger.Error(fmt.Sprintf("failed to add route table %d: %v ", overlayRouteTable, err))
-			return err
-		}
-
-		return nil
-	})
+	if err = AddFromRuleTable(logger, podNl, defaultInterfaceIPs, ruleTable, enableIpv4, enableIpv6); err != nil {
+		logger.Error(fmt.Sprintf("failed to add route table %d: %v ", overlayRouteTable, err))
+		return err
+	}
 
 	// move overlay default route to table <ruleTable>
 	if enableIpv4 {
-		err := netns.Do(func(_ ns.NetNS) error {
-			return moveRouteTable(logger, defaultInterface, ruleTable, netlink.FAMILY_V4)
-		})
-		if err != nil {
+		if err = moveRouteTable(logger, podNl, defaultInterface, ruleTable, netlink.FAMILY_V4); err != nil {
 			logger.Error(err.Error())
 			return err
 		}
 	}
 	if enableIpv6 {
-		err := netns.Do(func(_ ns.NetNS) error {
-			return moveRouteTable(logger, defaultInterface, ruleTable, netlink.FAMILY_V6)
-		})
-		if err != nil {
+		if err = moveRouteTable(logger, podNl, defaultInterface, ruleTable, netlink.FAMILY_V6); err != nil {
 			logger.Error(err.Error())
 			return err
 		}
@@ -453,8 +440,24 @@ func MigrateRoute(logger *zap.Logger, netns ns.NetNS, defaultInterface, chainedI
 
 // AddFromRuleTable add route rule for calico/cilium cidr(ipv4 and ipv6)
 // Equivalent to: `ip rule add from <cidr> `
-func AddFromRuleTable(logger *zap.Logger, chainedIPs []netlink.Addr, ruleTable int, enableIpv4, enableIpv6 bool) error {
+func AddFromRuleTable(logger *zap.Logger, h nl.Netlink, chainedIPs []netlink.Addr, ruleTable int, enableIpv4, enableIpv6 bool) error {
 	logger.Debug("Add FromRule Table in Pod Netns")
+	for _, rule := range fromRules(chainedIPs, ruleTable, enableIpv4, enableIpv6) {
+		logger.Debug("Netlink RuleAdd", zap.String("Rule", rule.String()))
+		if err := h.RuleAdd(rule); err != nil && !os.IsExist(err) {
+			logger.Error(err.Error())
+			return err
+		}
+	}
+	// we should add rule route table, just like `ip route add default via 169.254.1.1 table 100`
+	// but we don't know what's the default route If it has been deleted.
+	// so we should add this route rule table before removing the default route
+	return nil
+}
+
+// fromRules returns the rules from the given addresses: ip rule add from <ip> lookup <ruleTable>
+func fromRules(chainedIPs []netlink.Addr, ruleTable int, enableIpv4, enableIpv6 bool) []*netlink.Rule {
+	rules := make([]*netlink.Rule, 0, len(chainedIPs))
 	for _, chainedIP := range chainedIPs {
 		mask := net.IPMask{}
 		if chainedIP.IP.To4() != nil && enableIpv4 {
@@ -470,16 +473,9 @@ func AddFromRuleTable(logger *zap.Logger, chainedIPs []netlink.Addr, ruleTable i
 			IP:   chainedIP.IP,
 			Mask: mask,
 		}
-		logger.Debug("Netlink RuleAdd", zap.String("Rule", rule.String()))
-		if err := netlink.RuleAdd(rule); err != nil {
-			logger.Error(err.Error())
-			return err
-		}
+		rules = append(rules, rule)
 	}
-	// we should add rule route table, just like `ip route add default via 169.254.1.1 table 100`
-	// but we don't know what's the default route If it has been deleted.
-	// so we should add this route rule table before removing the default route
-	return nil
+	return rules
 }
 
 func AddrListByName(iface string, family int) ([]netlink.Addr, error) {
@@ -500,7 +496,7 @@ func AddToRuleTable(logger *zap.Logger, chainedIPs []string, ruleTable int, enab
 	for _, chainedIP := range chainedIPs {
 		netIP, ipNet, err := net.ParseCIDR(chainedIP)
 		if err != nil {
-			return fmt.Errorf("failed to parse cidr %s: %v", chainedIP, err)
+			return fmt.Errorf("failed to parse cidr %s: %w", chainedIP, err)
 		}
 		if netIP.IsMulticast() || netIP.IsLinkLocalUnicast() {
 			continue
@@ -523,22 +519,49 @@ func AddToRuleTable(logger *zap.Logger, chainedIPs []string, ruleTable int, enab
 
 // moveRouteTable del default route and add default rule route in pod netns
 // Equivalent: `ip route del <default route>` and `ip r route add <default route> table 100`
-func moveRouteTable(logger *zap.Logger, iface string, ruleTable, ipfamily int) error {
+func moveRouteTable(logger *zap.Logger, h nl.Netlink, iface string, ruleTable, ipfamily int) error {
 	logger.Debug(fmt.Sprintf("Moving overlay route table from main table to %d by given interface", ruleTable),
 		zap.String("interface", iface),
 		zap.Int("ipfamily", ipfamily))
-	link, err := netlink.LinkByName(iface)
+	link, err := h.LinkByName(iface)
 	if err != nil {
 		logger.Error(err.Error())
 		return err
 	}
 
-	routes, err := netlink.RouteList(nil, ipfamily)
+	routes, err := h.RouteList(nil, ipfamily)
 	if err != nil {
 		logger.Error(err.Error())
 		return err
 	}
 
+	for _, move := range routeTableMoves(logger, routes, link.Attrs().Index, ruleTable) {
+		if move.del {
+			if err = h.RouteDel(move.route); err != nil {
+				logger.Error("failed to delete default route in main table ", zap.String("route", move.route.String()), zap.Error(err))
+				return fmt.Errorf("failed to delete default route (%+v) in main table: %+v", move.route, err)
+			}
+			logger.Debug("Succeed to del the default route", zap.String("Default Route", move.route.String()))
+			continue
+		}
+		if err = h.RouteAdd(move.route); err != nil && err.Error() != constant.ErrRouteFileExist {
+			logger.Error("failed to add default route to new table ", zap.String("route", move.route.String()), zap.Error(err))
+			return fmt.Errorf("failed to add route (%+v) to new table: %+v", move.route, err)
+		}
+		logger.Debug("Succeed to move default route table from main to new table", zap.String("Route", move.route.String()))
+	}
+	return nil
+}
+
+// routeMove is a step of moving the routes of main table to the rule table, the route is deleted if del is true, or else added
+type routeMove struct {
+	del   bool
+	route *netlink.Route
+}
+
+// routeTableMoves returns the steps of moving the routes via the given link from main table to the rule table
+func routeTableMoves(logger *zap.Logger, routes []netlink.Route, linkIndex, ruleTable int) []routeMove {
+	var moves []routeMove
 	for _, route := range routes {
 
 		// only handle route tables from table main
@@ -553,60 +576,40 @@ func moveRouteTable(logger *zap.Logger, iface string, ruleTable, ipfamily int) e
 
 		logger.Debug("Found Route", zap.String("Route", route.String()))
 
-		if route.LinkIndex == link.Attrs().Index {
+		if route.LinkIndex == linkIndex {
 			if route.Dst == nil || route.Dst.IP.Equal(net.IPv4zero) {
-				if err = netlink.RouteDel(&route); err != nil {
-					logger.Error("failed to delete default route  in main table ", zap.String("route", route.String()), zap.Error(err))
-					return fmt.Errorf("failed to delete default route (%+v) in main table: %+v", route, err)
-				}
-				logger.Debug("Succeed to del the default route", zap.String("Default Route", route.String()))
-			}
-			route.Table = ruleTable
-			if err = netlink.RouteAdd(&route); err != nil && err.Error() != constant.ErrRouteFileExist {
-				logger.Error("failed to add default route to new table ", zap.String("route", route.String()), zap.Error(err))
-				return fmt.Errorf("failed to add route (%+v) to new table: %+v", route, err)
+				deletedRoute := route
+				moves = append(moves, routeMove{del: true, route: &deletedRoute})
 			}
-			logger.Debug("Succeed to move default route table from main to new table", zap.String("Route", route.String()))
+			generatedRoute := route
+			generatedRoute.Table = ruleTable
+			moves = append(moves, routeMove{route: &generatedRoute})
 		} else {
 			// especially more than two default ipv6 gateway
-			var generatedRoute, deletedRoute *netlink.Route
 			if len(route.MultiPath) == 0 {
 				continue
 			}
 
 			// get generated default Route for new table
 			for _, v := range route.MultiPath {
-				if v.LinkIndex == link.Attrs().Index {
-					generatedRoute = &netlink.Route{
+				if v.LinkIndex == linkIndex {
+					// add default route to new table, and delete it in main table
+					moves = append(moves, routeMove{route: &netlink.Route{
 						LinkIndex: v.LinkIndex,
 						Gw:        v.Gw,
 						Table:     ruleTable,
 						MTU:       route.MTU,
-					}
-					deletedRoute = &netlink.Route{
+					}}, routeMove{del: true, route: &netlink.Route{
 						LinkIndex: v.LinkIndex,
 						Gw:        v.Gw,
 						Table:     unix.RT_TABLE_MAIN,
-					}
+					}})
 					break
 				}
 			}
-			if generatedRoute == nil {
-				continue
-			}
-			// add default route to new table
-			if err = netlink.RouteAdd(generatedRoute); err != nil && err.Error() != constant.ErrRouteFileExist {
-				logger.Error("failed to add overlay route to new table", zap.String("generatedRoute", generatedRoute.String()), zap.Error(err))
-				return fmt.Errorf("failed to move overlay route (%+v) to new table: %+v", generatedRoute, err)
-			}
-			// delete default route in main table
-			if err := netlink.RouteDel(deletedRoute); err != nil {
-				logger.Error("failed to del overlay route from main table", zap.String("deletedRoute", deletedRoute.String()), zap.Error(err))
-				return fmt.Errorf("failed to delete default route (%+v) in main table: %+v", deletedRoute, err)
-			}
 		}
 	}
-	return nil
+	return moves
 }
 
 // GetRuleNumber return the number of rule table corresponding to the previous interface from the given interface.
@@ -663,12 +666,12 @@ func GetNextHopIPs(logger *zap.Logger, ips []string) ([]net.IP, error) {
 	for _, nip := range ips {
 		netIP, _, err := net.ParseCIDR(nip)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse cidr %s: %v", nip, err)
+			return nil, fmt.Errorf("failed to parse cidr %s: %w", nip, err)
 		}
 		logger.Debug("destination IP", zap.Any("dst", netIP))
 		routes, err := netlink.RouteGet(netIP)
 		if err != nil {
-			return nil, fmt.Errorf("failed to ip route get %s: %v", nip, err)
+			return nil, fmt.Errorf("failed to ip route get %s: %w", nip, err)
 		}
 
 		for _, route := range routes {
@@ -703,73 +706,51 @@ func RuleDel(logger *zap.Logger, ruleTable int, ips []netlink.Addr) error {
 		rule.Dst = &dst
 		if err := netlink.RuleDel(rule); err != nil && !os.IsNotExist(err) {
 			logger.Error("failed to del rule table", zap.Error(err))
-			return fmt.Errorf("failed to del rule table %d: %v ", ruleTable, err)
+			return fmt.Errorf("failed to del rule table %d: %w ", ruleTable, err)
 		}
 	}
 
 	return nil
 }
 
-// AddStaticNeighTable fix the problem of communication failure between pods and hosts by adding neigh table on pod and host
-func AddStaticNeighTable(logger *zap.Logger, netns ns.NetNS, iSriov bool, defaultOverlayInterface string, hostIPs []net.IP, chainedInterfaceIps []netlink.Addr) error {
-	if iSriov {
-		logger.Info("Main-cni is sriov, don't need set chained route")
+// AddStaticNeighTable fix the problem of communication failure between pods and hosts by adding neigh table on pod,
+// the one on host is added by AddHostStaticNeighTable
+func AddStaticNeighTable(logger *zap.Logger, podNl, hostNl nl.Netlink, noHostAccess bool, defaultOverlayInterface string, hostIPs []net.IP) error {
+	if noHostAccess {
+		logger.Info("The pod has no host access interface, don't need set neigh table")
 		return nil
 	}
 
-	parentIndex := -1
-	defaultOverlayMac := ""
-	err := netns.Do(func(_ ns.NetNS) error {
-		link, err := netlink.LinkByName(defaultOverlayInterface)
-		if err != nil {
-			logger.Error("", zap.Error(err))
-			return err
-		}
-		// get link index of host veth-peer and pod veth-peer mac-address
-		parentIndex = link.Attrs().ParentIndex
-		defaultOverlayMac = link.Attrs().HardwareAddr.String()
-		return nil
-	})
-	if err != nil {
-		logger.Error(err.Error())
-		return err
-	}
-
-	if parentIndex < 0 {
-		logger.Debug("defaultOverlay veth-peer linkIndex no found, ignore add neigh table")
-		return nil
-	}
-
-	if defaultOverlayMac == "" {
-		logger.Debug("defaultOverlayInterface Mac-address still empty, ignore add neigh table")
-		return nil
-	}
-
-	hostLink, err := netlink.LinkByIndex(parentIndex)
-	if err != nil {
-		logger.Error("", zap.Error(err))
+	_, hostLink, err := hostAccessPeer(logger, podNl, hostNl, defaultOverlayInterface)
+	if err != nil || hostLink == nil {
 		return err
 	}
 
 	// add neigh table in pod
 	// eq: ip n add <host IP> dev eth0 lladdr <host veth-peer mac> nud permanent
-	err = netns.Do(func(_ ns.NetNS) error {
-		for _, hostIP := range hostIPs {
-			if err := NeighborAdd(logger, defaultOverlayInterface, hostLink.Attrs().HardwareAddr.String(), hostIP); err != nil {
-				return err
-			}
+	for _, hostIP := range hostIPs {
+		if err = NeighborAdd(logger, podNl, defaultOverlayInterface, hostLink.Attrs().HardwareAddr.String(), hostIP); err != nil {
+			logger.Error(err.Error())
+			return err
 		}
+	}
+	return nil
+}
+
+// AddHostStaticNeighTable adds the neigh table of the chained interface ips on host, see AddStaticNeighTable
+func AddHostStaticNeighTable(logger *zap.Logger, podNl, hostNl nl.Netlink, noHostAccess bool, defaultOverlayInterface string, chainedInterfaceIps []netlink.Addr) error {
+	if noHostAccess {
 		return nil
-	})
+	}
 
-	if err != nil {
-		logger.Error(err.Error())
+	defaultOverlayMac, hostLink, err := hostAccessPeer(logger, podNl, hostNl, defaultOverlayInterface)
+	if err != nil || hostLink == nil {
 		return err
 	}
 
 	// eq: ip n add <chained interface IP> dev <host veth-peer > lladdr < defaultInterface Mac> nud permanent (only for ipv6)
 	for _, chainedInterfaceIP := range chainedInterfaceIps {
-		if err = NeighborAdd(logger, hostLink.Attrs().Name, defaultOverlayMac, chainedInterfaceIP.IP); err != nil {
+		if err = NeighborAdd(logger, hostNl, hostLink.Attrs().Name, defaultOverlayMac, chainedInterfaceIP.IP); err != nil {
 			logger.Error(err.Error())
 			return err
 		}
@@ -777,11 +758,36 @@ func AddStaticNeighTable(logger *zap.Logger, netns ns.NetNS, iSriov bool, defaul
 	return nil
 }
 
+// hostAccessPeer returns the mac-address of the host access interface in pod and its veth peer on host, the peer is
+// nil if the mac-address is still empty
+func hostAccessPeer(logger *zap.Logger, podNl, hostNl nl.Netlink, defaultOverlayInterface string) (string, netlink.Link, error) {
+	link, err := podNl.LinkByName(defaultOverlayInterface)
+	if err != nil {
+		logger.Error(err.Error())
+		return "", nil, err
+	}
+	// get host veth-peer and pod veth-peer mac-address
+	defaultOverlayMac := link.Attrs().HardwareAddr.String()
+	hostLink, err := nl.VethPeer(podNl, hostNl, defaultOverlayInterface)
+	if err != nil {
+		err = fmt.Errorf("%s in pod has no veth peer on the node: %v. the node can't reach the chained interface through it, "+
+			"check overlay_interface, or enable sriov if the pod has no overlay interface", defaultOverlayInterface, err)
+		logger.Error(err.Error())
+		return "", nil, err
+	}
+
+	if defaultOverlayMac == "" {
+		logger.Debug("defaultOverlayInterface Mac-address still empty, ignore add neigh table")
+		return "", nil, nil
+	}
+	return defaultOverlayMac, hostLink, nil
+}
+
 // NeighborAdd add static neighborhood tales
-func NeighborAdd(logger *zap.Logger, iface, mac string, netIP net.IP) error {
-	link, err := netlink.LinkByName(iface)
+func NeighborAdd(logger *zap.Logger, h nl.Netlink, iface, mac string, netIP net.IP) error {
+	link, err := h.LinkByName(iface)
 	if err != nil {
-		return fmt.Errorf("failed to get link: %v", err)
+		return fmt.Errorf("failed to get link: %w", err)
 	}
 
 	neigh := &netlink.Neigh{
@@ -792,9 +798,9 @@ func NeighborAdd(logger *zap.Logger, iface, mac string, netIP net.IP) error {
 		HardwareAddr: parseMac(mac),
 	}
 
-	if err := netlink.NeighAdd(neigh); err != nil && !os.IsExist(err) {
+	if err := h.NeighAdd(neigh); err != nil && !os.IsExist(err) {
 		logger.Error("failed to add neigh table", zap.String("interface", iface), zap.String("neigh", neigh.String()), zap.Error(err))
-		return fmt.Errorf("failed to add neigh table(%+v): %v ", neigh, err)
+		return fmt.Errorf("failed to add neigh table(%+v): %w ", neigh, err)
 	}
 
 	return nil
@@ -810,7 +816,7 @@ func parseMac(s string) net.HardwareAddr {
 }
 
 // OverwriteMacAddress overwrite mac-address
-func OverwriteMacAddress(logger *zap.Logger, netns ns.NetNS, macPrefix, iface string) (string, error) {
+func OverwriteMacAddress(logger *zap.Logger, netns ns.NetNS, podNl nl.Netlink, macPrefix, iface string) (string, error) {
 	// which nic need to overwrite?
 	logger.Debug("Get OverwriteMacAddress parameters", zap.String("macPrefix", macPrefix), zap.String("iface", iface))
 	ips, err := GetChainedInterfaceIps(netns, iface, true, true)
@@ -834,14 +840,10 @@ func OverwriteMacAddress(logger *zap.Logger, netns ns.NetNS, macPrefix, iface st
 
 	// newmac = xx:xx + xx:xx:xx:xx
 	newMac := macPrefix + ":" + suffix
-	err = netns.Do(func(netNS ns.NetNS) error {
-		link, err := netlink.LinkByName(iface)
-		if err != nil {
-			logger.Error(err.Error())
-			return err
-		}
-		return netlink.LinkSetHardwareAddr(link, parseMac(newMac))
-	})
+	link, err := podNl.LinkByName(iface)
+	if err == nil {
+		err = podNl.LinkSetHardwareAddr(link, parseMac(newMac))
+	}
 
 	if err != nil {
 		logger.Error("failed to overwrite mac address", zap.String("newMac", newMac), zap.Error(err))
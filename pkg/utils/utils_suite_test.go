@@ -1,10 +1,12 @@
 package utils
 
 import (
+	"context"
 	"fmt"
 	"github.com/containernetworking/plugins/pkg/ip"
 	"github.com/containernetworking/plugins/pkg/ns"
 	"github.com/containernetworking/plugins/pkg/testutils"
+	"github.com/containernetworking/plugins/pkg/utils/sysctl"
 	"github.com/spidernet-io/cni-plugins/pkg/logging"
 	"github.com/spidernet-io/cni-plugins/pkg/types"
 	"github.com/spidernet-io/e2eframework/tools"
@@ -114,6 +116,11 @@ var _ = BeforeSuite(func() {
 	}
 	logger = logging.LoggerFile.Named("unit-test")
 
+	// the tests with the fake netlink don't need the netns
+	if os.Geteuid() != 0 {
+		return
+	}
+
 	// create net ns
 	testNetNs, err = testutils.NewNS()
 	Expect(err).NotTo(HaveOccurred())
@@ -129,7 +136,7 @@ var _ = BeforeSuite(func() {
 		err = netlink.LinkSetUp(link)
 		Expect(err).NotTo(HaveOccurred())
 
-		err = EnableIpv6Sysctl(logger, testNetNs)
+		err = EnableIpv6Sysctl(context.Background(), logger, testNetNs, sysctl.Sysctl)
 		Expect(err).NotTo(HaveOccurred())
 
 		for _, ipnet := range ipnets {
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/agiledragon/gomonkey/v2"
@@ -9,6 +10,8 @@ import (
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"github.com/spidernet-io/cni-plugins/pkg/logging"
+	"github.com/spidernet-io/cni-plugins/pkg/nl"
+	"github.com/spidernet-io/cni-plugins/pkg/plan"
 	"github.com/spidernet-io/cni-plugins/pkg/types"
 	"github.com/vishvananda/netlink"
 	"golang.org/x/sys/unix"
@@ -19,6 +22,11 @@ import (
 )
 
 var _ = Describe("Utils", func() {
+	BeforeEach(func() {
+		if testNetNs == nil {
+			Skip("root is required to create the netns")
+		}
+	})
 
 	Context("Test GetRuleNumber", Label("get-rule-number"), func() {
 
@@ -84,7 +92,7 @@ var _ = Describe("Utils", func() {
 
 	Context("test EnableIpv6Sysctl", Label("disable_ipv6"), func() {
 		It("test set disable_ipv6 to 0", func() {
-			err := EnableIpv6Sysctl(logging.LoggerFile, testNetNs)
+			err := EnableIpv6Sysctl(context.Background(), logging.LoggerFile, testNetNs, sysctl.Sysctl)
 			Expect(err).NotTo(HaveOccurred())
 
 			// check disable_ipv6 = 0
@@ -109,7 +117,7 @@ var _ = Describe("Utils", func() {
 			patches := gomonkey.NewPatches()
 			defer patches.Reset()
 			patches.ApplyFuncReturn(os.ReadDir, nil, errors.New("os err"))
-			err := EnableIpv6Sysctl(logging.LoggerFile, testNetNs)
+			err := EnableIpv6Sysctl(context.Background(), logging.LoggerFile, testNetNs, sysctl.Sysctl)
 			Expect(err).To(HaveOccurred())
 		})
 
@@ -117,7 +125,7 @@ var _ = Describe("Utils", func() {
 			patches := gomonkey.NewPatches()
 			defer patches.Reset()
 			patches.ApplyFuncReturn(sysctl.Sysctl, nil, errors.New("sysctl err"))
-			err := EnableIpv6Sysctl(logging.LoggerFile, testNetNs)
+			err := EnableIpv6Sysctl(context.Background(), logging.LoggerFile, testNetNs, sysctl.Sysctl)
 			Expect(err).To(HaveOccurred())
 		})
 
@@ -125,7 +133,7 @@ var _ = Describe("Utils", func() {
 			patches := gomonkey.NewPatches()
 			defer patches.Reset()
 			patches.ApplyFuncReturn(sysctl.Sysctl, "1", nil)
-			err := EnableIpv6Sysctl(logging.LoggerFile, testNetNs)
+			err := EnableIpv6Sysctl(context.Background(), logging.LoggerFile, testNetNs, sysctl.Sysctl)
 			Expect(err).NotTo(HaveOccurred())
 		})
 
@@ -136,7 +144,7 @@ var _ = Describe("Utils", func() {
 				{Values: gomonkey.Params{"1", nil}},
 				{Values: gomonkey.Params{"0", errors.New("sysctl err")}},
 			})
-			err := EnableIpv6Sysctl(logging.LoggerFile, testNetNs)
+			err := EnableIpv6Sysctl(context.Background(), logging.LoggerFile, testNetNs, sysctl.Sysctl)
 			Expect(err).To(HaveOccurred())
 		})
 	})
@@ -162,7 +170,7 @@ var _ = Describe("Utils", func() {
 			}
 
 			err = testNetNs.Do(func(netNS ns.NetNS) error {
-				return ruleAdd(logger, routes, table, true, false)
+				return ruleAdd(logger, nl.New(), routes, table, true, false)
 			})
 			Expect(err).NotTo(HaveOccurred())
 
@@ -205,7 +213,7 @@ var _ = Describe("Utils", func() {
 			}
 
 			err = testNetNs.Do(func(netNS ns.NetNS) error {
-				return ruleAdd(logger, routes, table, false, true)
+				return ruleAdd(logger, nl.New(), routes, table, false, true)
 			})
 			Expect(err).NotTo(HaveOccurred())
 
@@ -262,7 +270,7 @@ var _ = Describe("Utils", func() {
 			}
 
 			err = testNetNs.Do(func(netNS ns.NetNS) error {
-				return ruleAdd(logger, routes, table, true, true)
+				return ruleAdd(logger, nl.New(), routes, table, true, true)
 			})
 			Expect(err).NotTo(HaveOccurred())
 
@@ -296,7 +304,7 @@ var _ = Describe("Utils", func() {
 			}
 
 			err := testNetNs.Do(func(netNS ns.NetNS) error {
-				return ruleAdd(logger, routes, table, true, true)
+				return ruleAdd(logger, nl.New(), routes, table, true, true)
 			})
 			Expect(err).To(HaveOccurred())
 		})
@@ -308,7 +316,7 @@ var _ = Describe("Utils", func() {
 			}
 
 			err := testNetNs.Do(func(netNS ns.NetNS) error {
-				return ruleAdd(logger, routes, table, false, true)
+				return ruleAdd(logger, nl.New(), routes, table, false, true)
 			})
 			Expect(err).NotTo(HaveOccurred())
 
@@ -331,7 +339,7 @@ var _ = Describe("Utils", func() {
 				patches := gomonkey.NewPatches()
 				defer patches.Reset()
 				patches.ApplyFuncReturn(netlink.RuleAdd, errors.New("rule add err"))
-				return ruleAdd(logger, routes, table, true, false)
+				return ruleAdd(logger, nl.New(), routes, table, true, false)
 			})
 			Expect(err).To(HaveOccurred())
 		})
@@ -351,7 +359,7 @@ var _ = Describe("Utils", func() {
 			}
 
 			err := testNetNs.Do(func(netNS ns.NetNS) error {
-				return AddFromRuleTable(logger, chainedIPs, table, true, false)
+				return AddFromRuleTable(logger, nl.New(), chainedIPs, table, true, false)
 			})
 			Expect(err).NotTo(HaveOccurred())
 
@@ -383,7 +391,7 @@ var _ = Describe("Utils", func() {
 			}
 
 			err = testNetNs.Do(func(netNS ns.NetNS) error {
-				return AddFromRuleTable(logger, chainedIPs, table, false, true)
+				return AddFromRuleTable(logger, nl.New(), chainedIPs, table, false, true)
 			})
 			Expect(err).NotTo(HaveOccurred())
 
@@ -418,7 +426,7 @@ var _ = Describe("Utils", func() {
 			}
 
 			err = testNetNs.Do(func(netNS ns.NetNS) error {
-				return AddFromRuleTable(logger, chainedIPs, table, true, true)
+				return AddFromRuleTable(logger, nl.New(), chainedIPs, table, true, true)
 			})
 			Expect(err).NotTo(HaveOccurred())
 
@@ -567,7 +575,7 @@ var _ = Describe("Utils", func() {
 		It("test add ipv4 neighbor table", func() {
 			// add a neiborhood table in given netns
 			testNetNs.Do(func(netNS ns.NetNS) error {
-				err = NeighborAdd(logger, conVethName, hostInterface.HardwareAddr.String(), v4IP)
+				err = NeighborAdd(logger, nl.New(), conVethName, hostInterface.HardwareAddr.String(), v4IP)
 				Expect(err).NotTo(HaveOccurred())
 
 				// check neighborhood table
@@ -602,7 +610,7 @@ var _ = Describe("Utils", func() {
 		It("wrong input interface name", func() {
 			// add a neiborhood table in given netns
 			testNetNs.Do(func(netNS ns.NetNS) error {
-				err = NeighborAdd(logger, "tmp", hostInterface.HardwareAddr.String(), v4IP)
+				err = NeighborAdd(logger, nl.New(), "tmp", hostInterface.HardwareAddr.String(), v4IP)
 				Expect(err).To(HaveOccurred())
 				return nil
 			})
@@ -611,7 +619,7 @@ var _ = Describe("Utils", func() {
 		It("wrong input interface cidr", func() {
 			// add a neiborhood table in given netns
 			testNetNs.Do(func(netNS ns.NetNS) error {
-				err = NeighborAdd(logger, conVethName, hostInterface.HardwareAddr.String(), net.IP{})
+				err = NeighborAdd(logger, nl.New(), conVethName, hostInterface.HardwareAddr.String(), net.IP{})
 				Expect(err).To(HaveOccurred())
 				return nil
 			})
@@ -623,7 +631,7 @@ var _ = Describe("Utils", func() {
 				patches := gomonkey.NewPatches()
 				defer patches.Reset()
 				patches.ApplyFuncReturn(netlink.NeighAdd, errors.New("NeighAdd failed"))
-				err = NeighborAdd(logger, conVethName, hostInterface.HardwareAddr.String(), v4IP)
+				err = NeighborAdd(logger, nl.New(), conVethName, hostInterface.HardwareAddr.String(), v4IP)
 				Expect(err).To(HaveOccurred())
 				return nil
 			})
@@ -674,13 +682,13 @@ var _ = Describe("Utils", func() {
 
 	Context("test CheckInterfaceMiss", Label("check"), func() {
 		It("return false if given interface exist", func() {
-			exist, err := CheckInterfaceMiss(testNetNs, conVethName)
+			exist, err := CheckInterfaceMiss(nl.NewAt(testNetNs), conVethName)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(exist).NotTo(BeTrue())
 		})
 
 		It("return true if given interface don't exist", func() {
-			exist, err := CheckInterfaceMiss(testNetNs, "tmp-name")
+			exist, err := CheckInterfaceMiss(nl.NewAt(testNetNs), "tmp-name")
 			Expect(err).NotTo(HaveOccurred())
 			Expect(exist).To(BeTrue())
 		})
@@ -726,7 +734,7 @@ var _ = Describe("Utils", func() {
 					Enable: &enable,
 					Value:  &value0,
 				}
-				err := SysctlRPFilter(logger, testNetNs, rpFilter)
+				err := SysctlRPFilter(context.Background(), logger, testNetNs, rpFilter, sysctl.Sysctl, sysctl.Sysctl)
 				Expect(err).NotTo(HaveOccurred())
 			}
 		})
@@ -742,7 +750,7 @@ var _ = Describe("Utils", func() {
 				patches := gomonkey.NewPatches()
 				defer patches.Reset()
 				patches.ApplyFuncReturn(setRPFilter, errors.New("setRPFilter err"))
-				err := SysctlRPFilter(logger, testNetNs, rpFilter)
+				err := SysctlRPFilter(context.Background(), logger, testNetNs, rpFilter, sysctl.Sysctl, sysctl.Sysctl)
 				Expect(err).To(HaveOccurred())
 			}
 		})
@@ -758,7 +766,7 @@ var _ = Describe("Utils", func() {
 				patches := gomonkey.NewPatches()
 				defer patches.Reset()
 				patches.ApplyFuncReturn(setRPFilter, errors.New("setRPFilter err"))
-				err := SysctlRPFilter(logger, testNetNs, rpFilter)
+				err := SysctlRPFilter(context.Background(), logger, testNetNs, rpFilter, sysctl.Sysctl, sysctl.Sysctl)
 				Expect(err).To(HaveOccurred())
 			}
 		})
@@ -766,11 +774,11 @@ var _ = Describe("Utils", func() {
 
 	Context("test HijackCustomSubnet", func() {
 		It("overlay", func() {
-			err := HijackCustomSubnet(logger, testNetNs, serviceSubnet, overlaySubnet, []string{}, defaultInterfaceAddrs, 100, true, true)
+			err := HijackCustomSubnet(logger, nl.NewAt(testNetNs), serviceSubnet, overlaySubnet, []string{}, defaultInterfaceAddrs, 100, true, true)
 			Expect(err).NotTo(HaveOccurred())
 		})
 		It("underlay", func() {
-			err := HijackCustomSubnet(logger, testNetNs, serviceSubnet, overlaySubnet, []string{}, defaultInterfaceAddrs, 101, true, true)
+			err := HijackCustomSubnet(logger, nl.NewAt(testNetNs), serviceSubnet, overlaySubnet, []string{}, defaultInterfaceAddrs, 101, true, true)
 			Expect(err).NotTo(HaveOccurred())
 		})
 
@@ -781,7 +789,7 @@ var _ = Describe("Utils", func() {
 				{Values: gomonkey.Params{errors.New("rule add err")}},
 				{Values: gomonkey.Params{nil}},
 			})
-			err := HijackCustomSubnet(logger, testNetNs, serviceSubnet, overlaySubnet, []string{}, defaultInterfaceAddrs, 100, true, true)
+			err := HijackCustomSubnet(logger, nl.NewAt(testNetNs), serviceSubnet, overlaySubnet, []string{}, defaultInterfaceAddrs, 100, true, true)
 			Expect(err).To(HaveOccurred())
 		})
 
@@ -792,7 +800,7 @@ var _ = Describe("Utils", func() {
 				{Values: gomonkey.Params{nil}},
 				{Values: gomonkey.Params{errors.New("rule add err")}},
 			})
-			err := HijackCustomSubnet(logger, testNetNs, serviceSubnet, overlaySubnet, []string{}, defaultInterfaceAddrs, 100, true, true)
+			err := HijackCustomSubnet(logger, nl.NewAt(testNetNs), serviceSubnet, overlaySubnet, []string{}, defaultInterfaceAddrs, 100, true, true)
 			Expect(err).To(HaveOccurred())
 		})
 
@@ -803,19 +811,19 @@ var _ = Describe("Utils", func() {
 				{Values: gomonkey.Params{errors.New("rule add err")}},
 				{Values: gomonkey.Params{nil}},
 			})
-			err := HijackCustomSubnet(logger, testNetNs, serviceSubnet, overlaySubnet, []string{}, defaultInterfaceAddrs, 101, true, true)
+			err := HijackCustomSubnet(logger, nl.NewAt(testNetNs), serviceSubnet, overlaySubnet, []string{}, defaultInterfaceAddrs, 101, true, true)
 			Expect(err).To(HaveOccurred())
 		})
 	})
 
 	Context("test MigrateRoute", func() {
 		It("success MigrateRoute -1", func() {
-			err := MigrateRoute(logger, testNetNs, conVethName, conVethName, defaultInterfaceAddrs, types.MigrateRoute(-1), 100, true, true)
+			err := MigrateRoute(logger, nl.NewAt(testNetNs), conVethName, conVethName, defaultInterfaceAddrs, types.MigrateRoute(-1), 100, true, true)
 			Expect(err).NotTo(HaveOccurred())
 		})
 
 		It("success MigrateRoute 0", func() {
-			err := MigrateRoute(logger, testNetNs, conVethName, conVethName, defaultInterfaceAddrs, types.MigrateRoute(0), 100, true, true)
+			err := MigrateRoute(logger, nl.NewAt(testNetNs), conVethName, conVethName, defaultInterfaceAddrs, types.MigrateRoute(0), 100, true, true)
 			Expect(err).NotTo(HaveOccurred())
 		})
 
@@ -823,10 +831,54 @@ var _ = Describe("Utils", func() {
 			patches := gomonkey.NewPatches()
 			defer patches.Reset()
 			patches.ApplyFuncReturn(compareInterfaceName, false)
-			err := MigrateRoute(logger, testNetNs, conVethName, conVethName, defaultInterfaceAddrs, types.MigrateRoute(-1), 100, true, true)
+			err := MigrateRoute(logger, nl.NewAt(testNetNs), conVethName, conVethName, defaultInterfaceAddrs, types.MigrateRoute(-1), 100, true, true)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+	})
+
+	Context("test dry run", func() {
+		It("HijackCustomSubnet records the rules to overlay and service subnets", func() {
+			p := &plan.Plan{}
+			err := HijackCustomSubnet(logger, p.Netlink(plan.PodNetns, nl.NewFake()), serviceSubnet, overlaySubnet, []string{"10.7.0.0/16"}, defaultInterfaceAddrs, 100, true, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(p.Changes).To(Equal([]plan.Change{
+				{Netns: plan.PodNetns, Kind: plan.KindRule, Command: "ip rule add from all to 10.244.0.0/16 lookup 100"},
+				{Netns: plan.PodNetns, Kind: plan.KindRule, Command: "ip rule add from all to 10.96.0.0/16 lookup 100"},
+				{Netns: plan.PodNetns, Kind: plan.KindRule, Command: "ip rule add from all to 10.7.0.0/16 lookup 100"},
+			}))
+		})
+
+		It("HijackCustomSubnet invalid subnet in dry run", func() {
+			p := &plan.Plan{}
+			err := HijackCustomSubnet(logger, p.Netlink(plan.PodNetns, nl.NewFake()), nil, []string{"10.244.0.0"}, nil, nil, 100, true, false)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("MigrateRoute records nothing if never migrate", func() {
+			p := &plan.Plan{}
+			err := MigrateRoute(logger, p.Netlink(plan.PodNetns, nl.NewFake()), conVethName, conVethName, defaultInterfaceAddrs, types.MigrateNever, 100, true, true)
 			Expect(err).NotTo(HaveOccurred())
+			Expect(p.Changes).To(BeEmpty())
 		})
 
+		It("routeTableMoves moves the default route and copies the others", func() {
+			_, dst, _ := net.ParseCIDR("10.244.0.0/16")
+			routes := []netlink.Route{
+				{LinkIndex: 2, Gw: net.ParseIP("169.254.1.1"), Table: unix.RT_TABLE_MAIN},
+				{LinkIndex: 2, Dst: dst, Table: unix.RT_TABLE_MAIN},
+				{LinkIndex: 3, Dst: dst, Table: unix.RT_TABLE_MAIN},
+				{LinkIndex: 2, Dst: dst, Table: 200},
+			}
+			moves := routeTableMoves(logger, routes, 2, 100)
+			Expect(moves).To(HaveLen(3))
+			Expect(moves[0].del).To(BeTrue())
+			Expect(moves[0].route.Table).To(Equal(unix.RT_TABLE_MAIN))
+			Expect(moves[1].del).To(BeFalse())
+			Expect(moves[1].route.Table).To(Equal(100))
+			Expect(moves[2].route.Dst).To(Equal(dst))
+			Expect(moves[2].route.Table).To(Equal(100))
+		})
 	})
 	Context("test AddToRuleTable", func() {
 		It("overlay", func() {
@@ -895,11 +947,11 @@ var _ = Describe("Utils", func() {
 	})
 	Context("test AddStaticNeighTable", func() {
 		It("success", func() {
-			err := AddStaticNeighTable(logger, testNetNs, false, conVethName, hostIPs, defaultInterfaceAddrs)
+			err := AddStaticNeighTable(logger, nl.NewAt(testNetNs), nl.New(), false, conVethName, hostIPs)
 			Expect(err).NotTo(HaveOccurred())
 		})
 		It("skip", func() {
-			err := AddStaticNeighTable(logger, testNetNs, true, conVethName, hostIPs, defaultInterfaceAddrs)
+			err := AddStaticNeighTable(logger, nl.NewAt(testNetNs), nl.New(), true, conVethName, hostIPs)
 			Expect(err).NotTo(HaveOccurred())
 		})
 	})
@@ -907,7 +959,7 @@ var _ = Describe("Utils", func() {
 	Context("Test moveRouteTable", func() {
 		It("success", func() {
 			testNetNs.Do(func(netNS ns.NetNS) error {
-				err := moveRouteTable(logger, conVethName, 100, 4)
+				err := moveRouteTable(logger, nl.New(), conVethName, 100, 4)
 				Expect(err).NotTo(HaveOccurred())
 				return nil
 			})
@@ -918,7 +970,7 @@ var _ = Describe("Utils", func() {
 	Context("Test setRPFilter", func() {
 		It("success", func() {
 			var v *int32
-			err := setRPFilter(logger, v)
+			err := setRPFilter(context.Background(), logger, v, sysctl.Sysctl)
 			Expect(err).NotTo(HaveOccurred())
 		})
 
@@ -927,7 +979,7 @@ var _ = Describe("Utils", func() {
 			patches := gomonkey.NewPatches()
 			defer patches.Reset()
 			patches.ApplyFuncReturn(os.ReadDir, nil, errors.New("os err"))
-			err := setRPFilter(logger, v)
+			err := setRPFilter(context.Background(), logger, v, sysctl.Sysctl)
 			Expect(err).To(HaveOccurred())
 		})
 
@@ -936,7 +988,7 @@ var _ = Describe("Utils", func() {
 			patches := gomonkey.NewPatches()
 			defer patches.Reset()
 			patches.ApplyFuncReturn(sysctl.Sysctl, nil, errors.New("sysctl err"))
-			err := setRPFilter(logger, v)
+			err := setRPFilter(context.Background(), logger, v, sysctl.Sysctl)
 			Expect(err).NotTo(HaveOccurred())
 		})
 
@@ -948,7 +1000,7 @@ var _ = Describe("Utils", func() {
 				{Values: gomonkey.Params{nil, nil}},
 				{Values: gomonkey.Params{nil, errors.New("sysctl err")}},
 			})
-			err := setRPFilter(logger, v)
+			err := setRPFilter(context.Background(), logger, v, sysctl.Sysctl)
 			Expect(err).To(HaveOccurred())
 		})
 	})
@@ -988,7 +1040,7 @@ var _ = Describe("Utils", func() {
 	Context("Test OverwriteMacAddress", func() {
 
 		It("a right config and pass", func() {
-			newmac, err := OverwriteMacAddress(logger, testNetNs, "0a:1b", conVethName)
+			newmac, err := OverwriteMacAddress(logger, testNetNs, nl.NewAt(testNetNs), "0a:1b", conVethName)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(newmac).NotTo(BeEmpty(), newmac)
 		})
@@ -0,0 +1,163 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spidernet-io/cni-plugins/pkg/constant"
+	"github.com/spidernet-io/cni-plugins/pkg/nl"
+	"github.com/spidernet-io/cni-plugins/pkg/types"
+	spiderpool "github.com/spidernet-io/spiderpool/pkg/networking/networking"
+	"github.com/vishvananda/netlink"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+// VRFName returns the name of the vrf device which the chained interface is enslaved to, such as vrf-net1
+func VRFName(chainedInterface string) string {
+	return constant.VRFPrefix + chainedInterface
+}
+
+// vrfChanges is what IsolateByVRF does besides creating the vrf device and enslaving the chained interface
+type vrfChanges struct {
+	// the ipv6 addresses flushed by enslaving, they're added back
+	addrs []netlink.Addr
+	// the routes of the chained interface in main, they're moved to the table of vrf
+	moves []routeMove
+	// the routes to the ips of the chained interface through the vrf device in main, without the link
+	leaks []*netlink.Route
+}
+
+// IsolateByVRF is the vrf mode of MigrateRoute, the chained interface is enslaved to a vrf device of ruleTable
+// rather than adding the rules from its ips, while the other interfaces stay in the default vrf:
+//  1. ip link add vrf-<chainedInterface> type vrf table <ruleTable>
+//  2. ip link set <chainedInterface> master vrf-<chainedInterface>
+//  3. the routes of chainedInterface in main are moved to <ruleTable>, and the ipv6 addresses are added back
+//     because enslaving cycles the interface.
+//  4. ip route add <ip of chainedInterface> dev vrf-<chainedInterface>, so the default vrf still reaches it
+func IsolateByVRF(logger *zap.Logger, podNl nl.Netlink, chainedInterface string, value types.MigrateRoute, ruleTable int, enableIpv4, enableIpv6 bool) error {
+	if !Migrated(value, chainedInterface) {
+		logger.Info("Ignore isolating the interface by vrf", zap.String("interface", chainedInterface), zap.Int32("migrate_route", int32(value)))
+		return nil
+	}
+
+	link, err := podNl.LinkByName(chainedInterface)
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+	changes, err := listVRFChanges(logger, podNl, link, ruleTable, enableIpv4, enableIpv6)
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	name := VRFName(chainedInterface)
+	vrf, err := podNl.LinkByName(name)
+	if err != nil {
+		if err = podNl.LinkAdd(&netlink.Vrf{LinkAttrs: netlink.LinkAttrs{Name: name}, Table: uint32(ruleTable)}); err != nil && !os.IsExist(err) {
+			logger.Error("failed to add vrf device", zap.String("vrf", name), zap.Error(err))
+			return fmt.Errorf("failed to add vrf device %s of table %d: %w", name, ruleTable, err)
+		}
+		if vrf, err = podNl.LinkByName(name); err != nil {
+			logger.Error(err.Error())
+			return err
+		}
+	}
+	if v, ok := vrf.(*netlink.Vrf); !ok || v.Table != uint32(ruleTable) {
+		err = fmt.Errorf("%s is not the vrf device of table %d", name, ruleTable)
+		logger.Error(err.Error())
+		return err
+	}
+	if err = podNl.LinkSetUp(vrf); err != nil {
+		logger.Error("failed to set vrf device up", zap.String("vrf", name), zap.Error(err))
+		return fmt.Errorf("failed to set %s up: %w", name, err)
+	}
+
+	if link.Attrs().MasterIndex != vrf.Attrs().Index {
+		if err = podNl.LinkSetMasterByIndex(link, vrf.Attrs().Index); err != nil {
+			logger.Error("failed to enslave the interface to vrf", zap.String("interface", chainedInterface), zap.String("vrf", name), zap.Error(err))
+			return fmt.Errorf("failed to enslave %s to %s: %w", chainedInterface, name, err)
+		}
+		logger.Info("Enslaved the interface to vrf", zap.String("interface", chainedInterface), zap.String("vrf", name), zap.Int("table", ruleTable))
+	}
+
+	for idx := range changes.addrs {
+		if err = podNl.AddrAdd(link, &changes.addrs[idx]); err != nil && !os.IsExist(err) {
+			logger.Error("failed to add back the address", zap.String("address", changes.addrs[idx].IPNet.String()), zap.Error(err))
+			return fmt.Errorf("failed to add back the address %s of %s: %w", changes.addrs[idx].IPNet, chainedInterface, err)
+		}
+	}
+
+	// the routes via the interface are flushed by enslaving, only the ones of multipath may be left
+	for _, move := range changes.moves {
+		if move.del {
+			if err = podNl.RouteDel(move.route); err != nil && !errors.Is(err, unix.ESRCH) {
+				logger.Error("failed to delete route in main table", zap.String("route", move.route.String()), zap.Error(err))
+				return fmt.Errorf("failed to delete route (%+v) in main table: %w", move.route, err)
+			}
+			continue
+		}
+		if err = podNl.RouteAdd(move.route); err != nil && !os.IsExist(err) {
+			logger.Error("failed to add route to the table of vrf", zap.String("route", move.route.String()), zap.Error(err))
+			return fmt.Errorf("failed to add route (%+v) to the table of vrf: %w", move.route, err)
+		}
+	}
+
+	for _, leak := range changes.leaks {
+		leak.LinkIndex = vrf.Attrs().Index
+		if err = podNl.RouteAdd(leak); err != nil && !os.IsExist(err) {
+			logger.Error("failed to add the route to vrf", zap.String("route", leak.String()), zap.Error(err))
+			return fmt.Errorf("failed to add route (%+v) to %s: %w", leak, name, err)
+		}
+	}
+	return nil
+}
+
+// listVRFChanges lists the changes of IsolateByVRF before the interface is enslaved
+func listVRFChanges(logger *zap.Logger, podNl nl.Netlink, link netlink.Link, ruleTable int, enableIpv4, enableIpv6 bool) (*vrfChanges, error) {
+	changes := &vrfChanges{}
+	var families []int
+	if enableIpv4 {
+		families = append(families, netlink.FAMILY_V4)
+	}
+	if enableIpv6 {
+		families = append(families, netlink.FAMILY_V6)
+	}
+	for _, family := range families {
+		routes, err := podNl.RouteList(nil, family)
+		if err != nil {
+			return nil, err
+		}
+		changes.moves = append(changes.moves, routeTableMoves(logger, routes, link.Attrs().Index, ruleTable)...)
+
+		addrs, err := podNl.AddrList(link, family)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			if addr.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			if family == netlink.FAMILY_V6 {
+				// the address passed the duplicate address detection before
+				changes.addrs = append(changes.addrs, netlink.Addr{IPNet: addr.IPNet, Flags: unix.IFA_F_NODAD})
+			}
+			changes.leaks = append(changes.leaks, &netlink.Route{
+				Dst:   spiderpool.ConvertMaxMaskIPNet(addr.IP),
+				Scope: netlink.SCOPE_LINK,
+				Table: unix.RT_TABLE_MAIN,
+			})
+		}
+	}
+	return changes, nil
+}
+
+// Migrated tells whether the routes of the chained interface are isolated from the other interfaces, see MigrateRoute
+func Migrated(value types.MigrateRoute, chainedInterface string) bool {
+	if value == types.MigrateNever {
+		return false
+	}
+	return value != types.MigrateAuto || compareInterfaceName(chainedInterface, defaultInterfaceName)
+}
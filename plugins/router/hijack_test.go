@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spidernet-io/cni-plugins/pkg/constant"
+	"github.com/spidernet-io/cni-plugins/pkg/nl"
+	ty "github.com/spidernet-io/cni-plugins/pkg/types"
+	"github.com/spidernet-io/cni-plugins/pkg/utils"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+var _ = Describe("Hijack routes", func() {
+	var pod *nl.Fake
+	var conf *PluginConf
+	calicoGw, nodeGw := net.ParseIP("169.254.1.1"), net.ParseIP("10.6.0.1")
+
+	BeforeEach(func() {
+		pod = nl.NewFake()
+		eth0 := pod.AddVeth(netlink.LinkAttrs{Name: "eth0"})
+		net1 := pod.AddLink(netlink.LinkAttrs{Name: "net1"})
+		Expect(pod.RouteAdd(&netlink.Route{LinkIndex: eth0.Attrs().Index, Gw: calicoGw})).To(Succeed())
+		Expect(pod.RouteAdd(&netlink.Route{LinkIndex: net1.Attrs().Index, Gw: nodeGw, Priority: 100})).To(Succeed())
+
+		migrate, srcHint := ty.MigrateEnable, false
+		conf = &PluginConf{}
+		conf.MigrateRoute = &migrate
+		conf.RouteSrcHint = &srcHint
+		conf.DefaultOverlayInterface = "eth0"
+		conf.Isolation = constant.IsolationRule
+		conf.HijackRoutes = []ty.HijackRoute{{Dst: "10.8.0.0/16", Via: constant.HijackViaUnderlay}}
+	})
+
+	It("the overlay is in the table of net1 and the underlay is in main", func() {
+		paths, err := hijackPaths(pod, conf, "net1", 100)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(paths[constant.HijackViaOverlay]).To(Equal(utils.HijackPath{Interface: "eth0", Table: overlayRouteTable, V4Gw: calicoGw}))
+		Expect(paths[constant.HijackViaUnderlay]).To(Equal(utils.HijackPath{Interface: "net1", Table: unix.RT_TABLE_MAIN, V4Gw: nodeGw}))
+	})
+
+	It("the underlay is in the table of vrf with vrf isolation", func() {
+		conf.Isolation = constant.IsolationVRF
+		paths, err := hijackPaths(pod, conf, "net1", 100)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(paths[constant.HijackViaOverlay].Table).To(Equal(unix.RT_TABLE_MAIN))
+		Expect(paths[constant.HijackViaUnderlay].Table).To(Equal(100))
+	})
+
+	It("the paths prefer the ips of their interfaces", func() {
+		Expect(pod.AddAddr("eth0", "10.244.1.10/32")).To(Succeed())
+		Expect(pod.AddAddr("net1", "10.6.1.10/16")).To(Succeed())
+		srcHint := true
+		conf.RouteSrcHint = &srcHint
+		paths, err := hijackPaths(pod, conf, "net1", 100)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(paths[constant.HijackViaOverlay].SrcIPs).To(ConsistOf(HaveField("IPNet.String()", Equal("10.244.1.10/32"))))
+		Expect(paths[constant.HijackViaUnderlay].SrcIPs).To(ConsistOf(HaveField("IPNet.String()", Equal("10.6.1.10/16"))))
+	})
+
+	It("nothing without hijack routes", func() {
+		conf.HijackRoutes = nil
+		paths, err := hijackPaths(pod, conf, "net1", 100)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(paths).To(BeNil())
+	})
+})
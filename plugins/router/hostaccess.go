@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/cilium/cilium/pkg/mac"
+	"github.com/spidernet-io/cni-plugins/pkg/constant"
+	"github.com/spidernet-io/cni-plugins/pkg/networking"
+	"github.com/spidernet-io/cni-plugins/pkg/nl"
+	"github.com/spidernet-io/cni-plugins/pkg/utils"
+	"github.com/vishvananda/netlink"
+	"go.uber.org/zap"
+)
+
+// the dedicated veth pair of host-access mode veth, the traffic between the node and the chained interface
+// goes through it instead of the overlay interface
+var (
+	hostAccessVeth    = "veth0"
+	hostAccessVethMtu = 1500
+)
+
+// the prefix of the veth devices which cilium creates on the node for pods
+const ciliumVethPrefix = "lxc"
+
+// hostAccessInterface returns the interface in pod through which the node and the chained interface reach each other.
+// in auto mode, the dedicated veth pair is used if the overlay CNI is cilium, because cilium drops the packets from
+// the underlay ip on its lxc device.
+func hostAccessInterface(logger *zap.Logger, conf *PluginConf, overlay *overlayInterface) string {
+	switch conf.HostAccess {
+	case constant.HostAccessVeth:
+		return hostAccessVeth
+	case constant.HostAccessOverlay:
+		return overlay.name
+	}
+
+	if overlay.flavor == constant.OverlayFlavorCilium {
+		logger.Info("The overlay interface is a cilium device, the node accesses the pod through a dedicated veth pair",
+			zap.String("Parent Device", overlay.peer.Attrs().Name))
+		return hostAccessVeth
+	}
+	return overlay.name
+}
+
+// hostAccessSrcIPs returns the preferred sources of the routes to the node via the host access interface, they're
+// its own ips, or the ips of the chained interface for the dedicated veth pair which has none, as the node replies
+// to them through the veth pair as well
+func hostAccessSrcIPs(podNl nl.Netlink, hostAccess string, ipfamily int, chainedInterfaceIps []netlink.Addr) ([]netlink.Addr, error) {
+	if hostAccess == hostAccessVeth {
+		return chainedInterfaceIps, nil
+	}
+	return networking.IPAddressByName(podNl, hostAccess, ipfamily)
+}
+
+// setupHostAccessVeth creates the dedicated veth pair between the pod and the node if it doesn't exist,
+// it's shared by all chained interfaces of the pod.
+func setupHostAccessVeth(logger *zap.Logger, podNl, hostNl nl.Netlink, hostSysctl networking.Sysctl, containerID string) error {
+	hostVethName := networking.HostVethName(containerID)
+	missing, err := utils.CheckInterfaceMiss(podNl, hostAccessVeth)
+	if err != nil {
+		return fmt.Errorf("failed to setup the veth pair of host access: %w", err)
+	}
+	if !missing {
+		logger.Debug("The veth pair of host access has already setup", zap.String("HostVeth", hostVethName))
+		return nil
+	}
+
+	// systemd may change the mac-address of a virtual device asynchronously if it's not set explicitly,
+	// which breaks the static neighbors, so the mac-addresses of both ends are set, see the veth plugin.
+	podVethMac, err := mac.GenerateRandMAC()
+	if err != nil {
+		return fmt.Errorf("unable to generate podVeth mac addr: %s", err)
+	}
+	hostVeth, contVeth, err := networking.SetupVeth(podNl, hostNl, hostSysctl, hostAccessVeth, hostVethName, hostAccessVethMtu, net.HardwareAddr(podVethMac))
+	if err != nil {
+		return fmt.Errorf("failed to setup the veth pair of host access: failed to set veth peer: %w", err)
+	}
+	if err = podNl.LinkSetUp(contVeth); err != nil {
+		return fmt.Errorf("failed to setup the veth pair of host access: %w", err)
+	}
+
+	hostVethMac, err := mac.GenerateRandMAC()
+	if err != nil {
+		return fmt.Errorf("unable to generate hostVeth mac addr: %s", err)
+	}
+	if err = hostNl.LinkSetHardwareAddr(hostVeth, net.HardwareAddr(hostVethMac)); err != nil {
+		return fmt.Errorf("failed to set host veth mac: %w", err)
+	}
+	logger.Info("Succeeded to setup the veth pair of host access", zap.String("HostVeth", hostVethName), zap.String("PodVeth", hostAccessVeth))
+	return nil
+}
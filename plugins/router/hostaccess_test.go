@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spidernet-io/cni-plugins/pkg/config"
+	"github.com/spidernet-io/cni-plugins/pkg/constant"
+	"github.com/spidernet-io/cni-plugins/pkg/nl"
+	"github.com/spidernet-io/cni-plugins/pkg/plan"
+	"github.com/vishvananda/netlink"
+	"go.uber.org/zap"
+)
+
+var _ = Describe("HostAccess", func() {
+	var pod *nl.Fake
+	var conf *PluginConf
+
+	BeforeEach(func() {
+		pod = nl.NewFake()
+		conf = &PluginConf{PluginConf: config.PluginConf{DefaultOverlayInterface: "eth0"}, HostAccess: constant.HostAccessAuto}
+	})
+
+	It("use the dedicated veth for cilium in auto mode", func() {
+		overlay := &overlayInterface{name: "eth0", flavor: constant.OverlayFlavorCilium, peer: &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "lxc1234567890"}}}
+		Expect(hostAccessInterface(zap.NewNop(), conf, overlay)).To(Equal(hostAccessVeth))
+	})
+
+	It("use the overlay interface for others in auto mode", func() {
+		overlay := &overlayInterface{name: "eth0", flavor: constant.OverlayFlavorCalico, peer: &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "cali1234567890"}}}
+		Expect(hostAccessInterface(zap.NewNop(), conf, overlay)).To(Equal("eth0"))
+
+		conf.HostAccess = constant.HostAccessVeth
+		Expect(hostAccessInterface(zap.NewNop(), conf, overlay)).To(Equal(hostAccessVeth))
+	})
+
+	It("the mode given by config is used", func() {
+		overlay := &overlayInterface{name: "eth0", flavor: constant.OverlayFlavorCilium, peer: &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "lxc1234567890"}}}
+		conf.HostAccess = constant.HostAccessOverlay
+		Expect(hostAccessInterface(zap.NewNop(), conf, overlay)).To(Equal("eth0"))
+
+		conf.HostAccess = constant.HostAccessAuto
+		conf.Sriov = true
+		Expect(hostAccessInterface(zap.NewNop(), conf, &overlayInterface{name: "eth0"})).To(Equal("eth0"))
+	})
+
+	It("the routes to the node prefer the ips of the host access interface", func() {
+		pod.AddVeth(netlink.LinkAttrs{Name: "eth0"})
+		Expect(pod.AddAddr("eth0", "10.244.1.10/32")).To(Succeed())
+		srcIPs, err := hostAccessSrcIPs(pod, "eth0", netlink.FAMILY_ALL, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(srcIPs).To(ConsistOf(HaveField("IPNet.String()", Equal("10.244.1.10/32"))))
+
+		chainedIPs := []netlink.Addr{{IPNet: &net.IPNet{IP: net.ParseIP("10.6.1.10"), Mask: net.CIDRMask(16, 32)}}}
+		Expect(hostAccessSrcIPs(pod, hostAccessVeth, netlink.FAMILY_ALL, chainedIPs)).To(Equal(chainedIPs), "veth0 has no ip")
+
+		_, err = hostAccessSrcIPs(pod, "eth1", netlink.FAMILY_ALL, nil)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("setup the veth pair only if it doesn't exist", func() {
+		p := &plan.Plan{}
+		podNl, hostNl := p.Netlink(plan.PodNetns, pod), p.Netlink(plan.HostNetns, nl.NewFake())
+		Expect(setupHostAccessVeth(zap.NewNop(), podNl, hostNl, p.Sysctl(plan.HostNetns), "testtesttesttest")).To(Succeed())
+		Expect(p.Changes).To(HaveLen(5))
+		Expect(p.Changes[0].Command).To(MatchRegexp(`^ip link add veth0 mtu 1500 address \S+ type veth peer name vethtesttesttes netns host$`))
+		Expect(p.Changes).To(ContainElement(plan.Change{Netns: plan.PodNetns, Kind: plan.KindLink, Command: "ip link set veth0 up"}))
+		peer, err := nl.VethPeer(podNl, hostNl, hostAccessVeth)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(peer.Attrs().Name).To(Equal("vethtesttesttes"))
+
+		Expect(setupHostAccessVeth(zap.NewNop(), podNl, hostNl, p.Sysctl(plan.HostNetns), "testtesttesttest")).To(Succeed())
+		Expect(p.Changes).To(HaveLen(5), "the veth pair already exists")
+	})
+})
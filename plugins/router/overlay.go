@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spidernet-io/cni-plugins/pkg/constant"
+	"github.com/spidernet-io/cni-plugins/pkg/nl"
+	"github.com/vishvananda/netlink"
+	"go.uber.org/zap"
+)
+
+// the prefixes of the veth devices which the overlay CNIs create on the node for pods
+var overlayPeerPrefixes = []struct {
+	prefix string
+	flavor string
+}{
+	{"cali", constant.OverlayFlavorCalico},
+	{ciliumVethPrefix, constant.OverlayFlavorCilium},
+	{"veth", constant.OverlayFlavorVeth},
+}
+
+// overlayInterface is the interface of overlay CNI in pod and its veth peer on the node
+type overlayInterface struct {
+	name string
+	// empty if the peer is not created by a known overlay CNI
+	flavor string
+	// nil if a SR-IOV pod has no overlay interface
+	peer netlink.Link
+}
+
+// the suffix of the veth devices which Kube-OVN creates on the node, Antrea names them by the pod
+const kubeOVNVethSuffix = "_h"
+
+// overlayFlavor returns the flavor of overlay CNI by the veth device on the node
+func overlayFlavor(hostNl nl.Netlink, peer netlink.Link) string {
+	for _, p := range overlayPeerPrefixes {
+		if strings.HasPrefix(peer.Attrs().Name, p.prefix) {
+			return p.flavor
+		}
+	}
+	// Kube-OVN and Antrea attach the veth devices to the bridge of Open vSwitch
+	if peer.Attrs().MasterIndex > 0 {
+		master, err := hostNl.LinkByIndex(peer.Attrs().MasterIndex)
+		if err == nil && master.Type() == "openvswitch" {
+			if strings.HasSuffix(peer.Attrs().Name, kubeOVNVethSuffix) {
+				return constant.OverlayFlavorKubeOVN
+			}
+			return constant.OverlayFlavorAntrea
+		}
+	}
+	return ""
+}
+
+// detectOverlayInterface finds the interface of overlay CNI in pod, router requires it to be a veth device whose peer
+// is on the node. if overlay_interface is auto, the candidates are the interface of the first attachment, the interfaces
+// of the default routes, and all other veth devices in pod, the first one whose peer looks like cali*, lxc* or veth*,
+// or is attached to Open vSwitch, wins.
+// for sriov, the pod may have no overlay interface, then the node reaches the VF through the PF rather than the overlay.
+func detectOverlayInterface(logger *zap.Logger, podNl, hostNl nl.Netlink, conf *PluginConf) (*overlayInterface, error) {
+	overlay, err := findOverlayInterface(logger, podNl, hostNl, conf.DefaultOverlayInterface)
+	if err == nil || !conf.Sriov {
+		return overlay, err
+	}
+
+	name := conf.DefaultOverlayInterface
+	if name == constant.AutoOverlayInterface {
+		name = constant.DefaultInterfaceName
+	}
+	logger.Warn("The SR-IOV pod has no overlay interface, the node reaches the chained interface through the PF",
+		zap.String("interface", name), zap.Error(err))
+	return &overlayInterface{name: name}, nil
+}
+
+// findOverlayInterface finds the overlay interface given by overlay_interface, or detects it if it's auto
+func findOverlayInterface(logger *zap.Logger, podNl, hostNl nl.Netlink, configured string) (*overlayInterface, error) {
+	if configured != constant.AutoOverlayInterface {
+		peer, err := nl.VethPeer(podNl, hostNl, configured)
+		if err != nil {
+			return nil, fmt.Errorf("overlay_interface %s is not usable: %v. router requires the overlay CNI to create a veth pair "+
+				"for the pod, set overlay_interface to the interface of the overlay CNI, or auto to detect it", configured, err)
+		}
+		return &overlayInterface{name: configured, flavor: overlayFlavor(hostNl, peer), peer: peer}, nil
+	}
+
+	candidates, err := overlayCandidates(podNl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the overlay interface candidates in pod: %w", err)
+	}
+	for _, name := range candidates {
+		peer, err := nl.VethPeer(podNl, hostNl, name)
+		if err != nil {
+			logger.Debug("The candidate is not the overlay interface", zap.String("interface", name), zap.Error(err))
+			continue
+		}
+		if flavor := overlayFlavor(hostNl, peer); flavor != "" {
+			return &overlayInterface{name: name, flavor: flavor, peer: peer}, nil
+		}
+		logger.Debug("The veth peer of candidate is not created by a known overlay CNI",
+			zap.String("interface", name), zap.String("peer", peer.Attrs().Name))
+	}
+
+	var prefixes []string
+	for _, p := range overlayPeerPrefixes {
+		prefixes = append(prefixes, p.prefix+"*")
+	}
+	return nil, fmt.Errorf("failed to detect the overlay interface: none of %v in pod is a veth device whose peer on the node "+
+		"is %s or attached to Open vSwitch. router requires the overlay CNI to create a veth pair for the pod, set "+
+		"overlay_interface to the interface of the overlay CNI, or enable sriov if the pod has no overlay interface", candidates, strings.Join(prefixes, ", "))
+}
+
+// overlayCandidates returns the interfaces in pod which may be the overlay interface, in the order of preference
+func overlayCandidates(podNl nl.Netlink) ([]string, error) {
+	links, err := podNl.LinkList()
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[int]string, len(links))
+	for _, link := range links {
+		names[link.Attrs().Index] = link.Attrs().Name
+	}
+
+	var candidates []string
+	seen := map[string]bool{}
+	add := func(name string) {
+		// the dedicated veth pair of host access is created by router itself
+		if name == "" || name == hostAccessVeth || seen[name] {
+			return
+		}
+		seen[name] = true
+		candidates = append(candidates, name)
+	}
+
+	// the first attachment is the default network of pod, which is the overlay CNI
+	add(constant.DefaultInterfaceName)
+
+	routes, err := podNl.RouteList(nil, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, err
+	}
+	for _, route := range routes {
+		if route.Dst == nil {
+			add(names[route.LinkIndex])
+		} else if ones, _ := route.Dst.Mask.Size(); ones == 0 {
+			add(names[route.LinkIndex])
+		}
+	}
+
+	for _, link := range links {
+		if link.Type() == "veth" {
+			add(link.Attrs().Name)
+		}
+	}
+	return candidates, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spidernet-io/cni-plugins/pkg/config"
+	"github.com/spidernet-io/cni-plugins/pkg/constant"
+	"github.com/spidernet-io/cni-plugins/pkg/nl"
+	"github.com/vishvananda/netlink"
+	"go.uber.org/zap"
+)
+
+var _ = Describe("Overlay", func() {
+	var pod, host *nl.Fake
+	var conf *PluginConf
+
+	// the interface of pod is the peer of the given device on host
+	vethPair := func(name, peerName string) netlink.Link {
+		peer := host.AddVeth(netlink.LinkAttrs{Name: peerName})
+		return pod.AddVeth(netlink.LinkAttrs{Name: name, ParentIndex: peer.Attrs().Index})
+	}
+
+	BeforeEach(func() {
+		pod, host = nl.NewFake(), nl.NewFake()
+		conf = &PluginConf{PluginConf: config.PluginConf{DefaultOverlayInterface: constant.AutoOverlayInterface}}
+	})
+
+	It("tell the flavor by the name of peer", func() {
+		for name, flavor := range map[string]string{
+			"cali12345": constant.OverlayFlavorCalico,
+			"lxc12345":  constant.OverlayFlavorCilium,
+			"veth12345": constant.OverlayFlavorVeth,
+			"tap12345":  "",
+		} {
+			Expect(overlayFlavor(host, host.AddVeth(netlink.LinkAttrs{Name: name}))).To(Equal(flavor))
+		}
+	})
+
+	It("tell the flavor by the bridge of Open vSwitch", func() {
+		ovs := &netlink.GenericLink{LinkAttrs: netlink.LinkAttrs{Name: "ovs-system", Index: 100}, LinkType: "openvswitch"}
+		host.Links = append(host.Links, ovs)
+		Expect(overlayFlavor(host, host.AddVeth(netlink.LinkAttrs{Name: "1a2b3c4d5e6f_h", MasterIndex: 100}))).To(Equal(constant.OverlayFlavorKubeOVN))
+		Expect(overlayFlavor(host, host.AddVeth(netlink.LinkAttrs{Name: "coredns--3f1b8a", MasterIndex: 100}))).To(Equal(constant.OverlayFlavorAntrea))
+
+		vethPair("eth0", "nginx-7f-a1b2c3")
+		host.Links[len(host.Links)-1].Attrs().MasterIndex = 100
+		overlay, err := detectOverlayInterface(zap.NewNop(), pod, host, conf)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(overlay.name).To(Equal("eth0"))
+		Expect(overlay.flavor).To(Equal(constant.OverlayFlavorAntrea))
+	})
+
+	It("ignore the veth peer in another network namespace", func() {
+		link := vethPair("eth0", "cali12345")
+		// the peer is in a nested namespace, the link of node with the same index is not the peer
+		link.Attrs().NetNsID = 1
+		_, err := detectOverlayInterface(zap.NewNop(), pod, host, conf)
+		Expect(err).To(MatchError(ContainSubstring("failed to detect the overlay interface")))
+	})
+
+	It("detect the interface of the first attachment", func() {
+		vethPair("eth0", "cali12345")
+		overlay, err := detectOverlayInterface(zap.NewNop(), pod, host, conf)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(overlay.name).To(Equal("eth0"))
+		Expect(overlay.flavor).To(Equal(constant.OverlayFlavorCalico))
+		Expect(overlay.peer.Attrs().Name).To(Equal("cali12345"))
+	})
+
+	It("detect the interface of default route", func() {
+		pod.AddLink(netlink.LinkAttrs{Name: "eth0"})
+		link := vethPair("eth1", "lxc12345")
+		Expect(pod.RouteAdd(&netlink.Route{LinkIndex: link.Attrs().Index, Gw: net.ParseIP("169.254.1.1")})).To(Succeed())
+
+		overlay, err := detectOverlayInterface(zap.NewNop(), pod, host, conf)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(overlay.name).To(Equal("eth1"))
+		Expect(overlay.flavor).To(Equal(constant.OverlayFlavorCilium))
+	})
+
+	It("ignore the veth pair of host access and unknown peers", func() {
+		vethPair(hostAccessVeth, "veth12345")
+		vethPair("eth1", "tap12345")
+		_, err := detectOverlayInterface(zap.NewNop(), pod, host, conf)
+		Expect(err).To(MatchError(ContainSubstring("failed to detect the overlay interface")))
+		Expect(err).To(MatchError(ContainSubstring("set overlay_interface")))
+
+		vethPair("eth2", "veth67890")
+		overlay, err := detectOverlayInterface(zap.NewNop(), pod, host, conf)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(overlay.name).To(Equal("eth2"))
+		Expect(overlay.flavor).To(Equal(constant.OverlayFlavorVeth))
+	})
+
+	It("the interface given by config must have a veth peer", func() {
+		conf.DefaultOverlayInterface = "eth0"
+		pod.AddLink(netlink.LinkAttrs{Name: "eth0"})
+		_, err := detectOverlayInterface(zap.NewNop(), pod, host, conf)
+		Expect(err).To(MatchError(ContainSubstring("overlay_interface eth0 is not usable")))
+
+		conf.DefaultOverlayInterface = "eth1"
+		vethPair("eth1", "tap12345")
+		overlay, err := detectOverlayInterface(zap.NewNop(), pod, host, conf)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(overlay.name).To(Equal("eth1"))
+		Expect(overlay.flavor).To(BeEmpty())
+	})
+
+	It("sriov pod without overlay interface", func() {
+		conf.Sriov = true
+		overlay, err := detectOverlayInterface(zap.NewNop(), pod, host, conf)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(overlay.name).To(Equal(constant.DefaultInterfaceName))
+		Expect(overlay.peer).To(BeNil())
+	})
+
+	It("sriov pod with overlay interface", func() {
+		conf.Sriov = true
+		vethPair("eth0", "cali12345")
+		overlay, err := detectOverlayInterface(zap.NewNop(), pod, host, conf)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(overlay.name).To(Equal("eth0"))
+		Expect(overlay.peer.Attrs().Name).To(Equal("cali12345"))
+	})
+})
@@ -1,7 +1,7 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/containernetworking/cni/pkg/skel"
 	"github.com/containernetworking/cni/pkg/types"
@@ -9,69 +9,74 @@ import (
 	"github.com/containernetworking/cni/pkg/version"
 	"github.com/containernetworking/plugins/pkg/ns"
 	bv "github.com/containernetworking/plugins/pkg/utils/buildversion"
+	"github.com/containernetworking/plugins/pkg/utils/sysctl"
 	"github.com/spidernet-io/cni-plugins/pkg/config"
 	"github.com/spidernet-io/cni-plugins/pkg/constant"
+	"github.com/spidernet-io/cni-plugins/pkg/k8s"
+	"github.com/spidernet-io/cni-plugins/pkg/lock"
 	"github.com/spidernet-io/cni-plugins/pkg/logging"
 	"github.com/spidernet-io/cni-plugins/pkg/networking"
+	"github.com/spidernet-io/cni-plugins/pkg/nl"
+	"github.com/spidernet-io/cni-plugins/pkg/plan"
 	ty "github.com/spidernet-io/cni-plugins/pkg/types"
 	"github.com/spidernet-io/cni-plugins/pkg/utils"
 	spiderpool "github.com/spidernet-io/spiderpool/pkg/networking/networking"
 	"github.com/vishvananda/netlink"
 	"go.uber.org/zap"
 	"golang.org/x/sys/unix"
-	"k8s.io/utils/pointer"
 	"net"
 	"os"
 	"path/filepath"
 	"time"
 )
 
-type PluginConf struct {
-	types.NetConf
-	// should include: overlay Subnet , clusterIP subnet
-	// should include: overlay Subnet , clusterip subnet
-	OverlayHijackSubnet     []string         `json:"overlay_hijack_subnet,omitempty"`
-	ServiceHijackSubnet     []string         `json:"service_hijack_subnet,omitempty"`
-	AdditionalHijackSubnet  []string         `json:"additional_hijack_subnet,omitempty"`
-	MigrateRoute            *ty.MigrateRoute `json:"migrate_route,omitempty"`
-	DefaultOverlayInterface string           `json:"overlay_interface,omitempty"`
-	HostRuleTable           *int             `json:"host_rule_table,omitempty"`
-	// RpFilter
-	RPFilter   *ty.RPFilter   `json:"rp_filter,omitempty"`
-	Skipped    bool           `json:"skip_call,omitempty"`
-	Sriov      bool           `json:"sriov,omitempty"`
-	OnlyOpMac  bool           `json:"only_op_mac,omitempty"`
-	LogOptions *ty.LogOptions `json:"log_options,omitempty"`
-	IPConflict *ty.IPConflict `json:"ip_conflict,omitempty"`
-	MacPrefix  string         `json:"mac_prefix,omitempty"`
-}
+type PluginConf = config.RouterConf
 
 var binName = filepath.Base(os.Args[0])
 
 var overlayRouteTable = 100
 
 func main() {
+	// print the network config read from stdin migrated to the current config_version, such as: router migrate-config < conf.json
+	if len(os.Args) > 1 && os.Args[1] == "migrate-config" {
+		if err := config.PrintMigratedConfig("router", os.Stdin, os.Stdout, os.Stderr); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	skel.PluginMain(cmdAdd, cmdCheck, cmdDel, version.All, bv.BuildString(binName))
 }
 
-func cmdAdd(args *skel.CmdArgs) error {
+func cmdAdd(args *skel.CmdArgs) (err error) {
 	startTime := time.Now()
 
 	var logger *zap.Logger
 
-	conf, err := parseConfig(args.StdinData)
+	stdin, dropIns, err := config.MergeDropInConfig("router", args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	conf, err := parseConfig(stdin)
 	if err != nil {
 		return err
 	}
 
 	if err := logging.SetLogOptions(conf.LogOptions); err != nil {
-		return fmt.Errorf("faild to init logger: %v ", err)
+		return fmt.Errorf("faild to init logger: %w ", err)
 	}
 
 	logger = logging.LoggerFile.Named(binName)
 
+	k8sCNIArgs, overrideArgs, err := config.SplitCNIArgs(args.Args)
+	if err != nil {
+		return fmt.Errorf("failed to parse CNI_ARGS: %w", err)
+	}
+
 	k8sArgs := ty.K8sArgs{}
-	if err = types.LoadArgs(args.Args, &k8sArgs); nil != err {
+	if err = types.LoadArgs(k8sCNIArgs, &k8sArgs); nil != err {
 		logger.Error(err.Error())
 		return fmt.Errorf("failed to get pod information, error=%+v \n", err)
 	}
@@ -84,9 +89,49 @@ func cmdAdd(args *skel.CmdArgs) error {
 		zap.String("PodNamespace", string(k8sArgs.K8S_POD_NAMESPACE)),
 		zap.String("IfName", args.IfName))
 
+	// the deadline of this invocation, the failure caused by it is reported with the code ErrTryAgainLater
+	ctx, cancel, err := utils.InvocationContext(startTime, conf.Timeout)
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+	defer cancel()
+	defer func() {
+		if utils.IsTimeout(err) {
+			logger.Error("The invocation is timed out", zap.String("timeout", conf.Timeout), zap.Error(err))
+			err = utils.TimeoutError(conf.Timeout, err)
+		}
+	}()
+
+	if len(dropIns) != 0 {
+		logger.Info("Merged the node-local drop-in config", zap.Strings("DropIns", dropIns), zap.String("EffectiveConfig", string(stdin)))
+	}
+	for _, warning := range conf.Warnings {
+		logger.Warn("Coerced the value of network config", zap.String("warning", warning))
+	}
+
 	logger.Info("stdin", zap.String("stdin", string(args.StdinData)))
 	logger.Debug("Succeed to parse cni config", zap.Any("Config", *conf))
 
+	// the kubernetes client is shared by the lookups of this invocation
+	kc := k8s.NewInvocationClient(conf.Kubernetes)
+	annotations, err := k8s.LookupPodOverrides(ctx, logger, kc, string(k8sArgs.K8S_POD_NAMESPACE), string(k8sArgs.K8S_POD_NAME))
+	if err != nil {
+		logger.Error("failed to look up the annotations of pod", zap.Error(err))
+		return fmt.Errorf("failed to look up the annotations of pod: %w", err)
+	}
+
+	overrides, err := config.ParseOverrides(conf.AllowedOverrides, annotations, conf.Args, overrideArgs)
+	if err != nil {
+		logger.Error("failed to parse the overrides of pod", zap.Error(err))
+		return fmt.Errorf("failed to parse the overrides of pod: %w", err)
+	}
+	if err = conf.ApplyOverrides(overrides); err != nil {
+		logger.Error("failed to apply the overrides of pod", zap.Error(err))
+		return err
+	}
+	logger.Debug("Succeed to apply the overrides of pod", zap.Any("Overrides", *overrides))
+
 	// skip plugin
 	if conf.Skipped {
 		logger.Info("Ignore this plugin call, Return directly ")
@@ -97,11 +142,16 @@ func cmdAdd(args *skel.CmdArgs) error {
 		return fmt.Errorf("failed to find PrevResult, must be called as chained plugin")
 	}
 
+	if err = k8s.DiscoverHijackSubnets(ctx, logger, kc, &conf.PluginConf); err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
 	// ------------------- parse prevResult
 	prevResult, err := current.GetResult(conf.PrevResult)
 	if err != nil {
 		logger.Error(err.Error())
-		return fmt.Errorf("failed to convert prevResult: %v", err)
+		return fmt.Errorf("failed to convert prevResult: %w", err)
 	}
 
 	if len(prevResult.Interfaces) == 0 {
@@ -120,13 +170,42 @@ func cmdAdd(args *skel.CmdArgs) error {
 	netns, err := ns.GetNS(args.Netns)
 	if err != nil {
 		logger.Error(err.Error())
-		return fmt.Errorf("failed to open netns %q: %v", args.Netns, err)
+		return fmt.Errorf("failed to open netns %q: %w", args.Netns, err)
 	}
 	defer netns.Close()
 
+	// the netlink operations in pod and host use the sockets bound to the namespaces,
+	// so the pod netns is not entered for every one of them
+	podHandle, err := nl.NewHandleAt(ctx, netns)
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+	defer podHandle.Close()
+	hostHandle, err := nl.NewHandle(ctx)
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+	defer hostHandle.Close()
+	var podNl, hostNl nl.Netlink = nl.WithContext(ctx, podHandle), nl.WithContext(ctx, hostHandle)
+	var podSysctl, hostSysctl networking.Sysctl = sysctl.Sysctl, sysctl.Sysctl
+	var nftables utils.Nftables = utils.RunNftables
+	// in dry-run mode, the same code runs with the netlink, sysctl and nftables which record the changes to the plan
+	// rather than making them
+	dryRunPlan := &plan.Plan{}
+	if conf.DryRun {
+		podNl, hostNl = dryRunPlan.Netlink(plan.PodNetns, podNl), dryRunPlan.Netlink(plan.HostNetns, hostNl)
+		podSysctl, hostSysctl = dryRunPlan.Sysctl(plan.PodNetns), dryRunPlan.Sysctl(plan.HostNetns)
+		nftables = dryRunPlan.Nftables(plan.PodNetns)
+	}
+	// the routes and rules created by the plugin are tagged with the protocol, to tell them from the ones of others
+	podNl = nl.WithProtocol(podNl, *conf.RouteProtocol)
+	hostNl = nl.WithProtocol(hostNl, *conf.RouteProtocol)
+
 	// we do check if ip is conflict firstly
 	if conf.IPConflict != nil && conf.IPConflict.Enabled {
-		err = networking.DoIPConflictChecking(logger, netns, args.IfName, prevResult.IPs, conf.IPConflict)
+		err = networking.DoIPConflictChecking(ctx, logger, netns, args.IfName, prevResult.IPs, conf.IPConflict)
 		if err != nil {
 			logger.Error(err.Error())
 			return err
@@ -134,13 +213,16 @@ func cmdAdd(args *skel.CmdArgs) error {
 	}
 
 	if len(conf.MacPrefix) != 0 {
-		newMac, err := utils.OverwriteMacAddress(logger, netns, conf.MacPrefix, args.IfName)
+		newMac, err := utils.OverwriteMacAddress(logger, netns, podNl, conf.MacPrefix, args.IfName)
 		if err != nil {
 			return fmt.Errorf("failed to update mac address, maybe mac_prefix is invalid: %v", conf.MacPrefix)
 		}
 		logger.Info("Update mac address successfully", zap.String("interface", constant.DefaultInterfaceName), zap.String("new mac", newMac))
 		if conf.OnlyOpMac {
 			logger.Debug("only update mac address, exiting now...")
+			if conf.DryRun {
+				dryRunPlan.Log(logger)
+			}
 			return types.PrintResult(conf.PrevResult, conf.CNIVersion)
 		}
 	}
@@ -161,172 +243,344 @@ func cmdAdd(args *skel.CmdArgs) error {
 		ipfamily = netlink.FAMILY_ALL
 	}
 
-	// get all ip of pod
-	var allPodIp []netlink.Addr
-	err = netns.Do(func(netNS ns.NetNS) error {
-		allPodIp, err = spiderpool.GetAllIPAddress(ipfamily, []string{`^lo$`})
-		if err != nil {
-			logger.Error("failed to GetAllIPAddress in pod", zap.Error(err))
-			return fmt.Errorf("failed to GetAllIPAddress in pod: %v", err)
+	// the chained interface is a SR-IOV VF if it's allocated by the device plugin
+	if !conf.Sriov {
+		if pciID := networking.SriovDevice(conf.DeviceID, conf.RawPrevResult, args.IfName); pciID != "" {
+			conf.Sriov = true
+			logger.Info("Detected the SR-IOV VF of the chained interface", zap.String("pciID", pciID))
 		}
-		return nil
-	})
+	}
+
+	// the interface of overlay CNI in pod, the node reaches the pod through its veth peer
+	overlay, err := detectOverlayInterface(logger, podNl, hostNl, conf)
 	if err != nil {
-		logger.Error("failed to all ip of pod", zap.Error(err))
+		logger.Error(err.Error())
 		return err
 	}
+	conf.DefaultOverlayInterface = overlay.name
+	logger.Info("Detected the overlay interface", zap.String("interface", overlay.name), zap.String("flavor", overlay.flavor))
+
+	// infer the overlay subnets before the routes of overlay interface are migrated
+	conf.OverlayHijackSubnet, err = networking.ResolveAutoOverlaySubnets(logger, podNl, hostNl, conf.DefaultOverlayInterface, ipfamily, conf.OverlayHijackSubnet)
+	if err != nil {
+		logger.Error("failed to infer overlay hijack subnets", zap.Error(err))
+		return fmt.Errorf("failed to infer overlay hijack subnets: %w", err)
+	}
+
+	// get all ip of pod
+	allPodIp, err := networking.GetAllIPAddress(podNl, ipfamily, []string{`^lo$`})
+	if err != nil {
+		logger.Error("failed to GetAllIPAddress in pod", zap.Error(err))
+		return fmt.Errorf("failed to GetAllIPAddress in pod: %w", err)
+	}
 
 	// get ip addresses of the node
-	hostIPs, err := networking.GetAllHostIPRouteForPod(ipfamily, allPodIp)
+	hostIPs, err := networking.GetAllHostIPRouteForPod(ipfamily, allPodIp, conf.HostInterfacesToExclude)
 	if err != nil {
 		logger.Error("failed to get IPAddressOnNode", zap.Error(err))
-		return fmt.Errorf("failed to get IPAddressOnNode: %v", err)
+		return fmt.Errorf("failed to get IPAddressOnNode: %w", err)
 	}
 	logger.Debug("success get host IP for route to Pod", zap.Any("hostIPs", hostIPs))
 
-	chainedInterfaceIps, err := spiderpool.IPAddressByName(netns, args.IfName, ipfamily)
+	chainedInterfaceIps, err := networking.IPAddressByName(podNl, args.IfName, ipfamily)
 	if err != nil {
 		logger.Error(err.Error())
-		return fmt.Errorf("failed to IPAddressByName for pod %s : %v", args.IfName, err)
+		return fmt.Errorf("failed to IPAddressByName for pod %s : %w", args.IfName, err)
+	}
+
+	ruleTable := utils.GetRuleNumber(preInterfaceName)
+	if ruleTable < 0 {
+		logger.Error("failed to get the number of rule table for interface", zap.String("interface", preInterfaceName))
+		return fmt.Errorf("failed to get the number of rule table for interface %s", preInterfaceName)
+	}
+
+	// the interface in pod through which the node and the chained interface reach each other
+	hostAccess := hostAccessInterface(logger, conf, overlay)
+	// only a SR-IOV pod without overlay interface has none, the node reaches the VF through the PF
+	noHostAccess := hostAccess != hostAccessVeth && overlay.peer == nil
+
+	// the VF may be in the same subnet as the node, the pod reaches the node ips with the ips of the overlay interface,
+	// so the node replies through the overlay interface as well
+	var srcIPs []netlink.Addr
+	switch {
+	case conf.Sriov && overlay.peer != nil:
+		if srcIPs, err = networking.IPAddressByName(podNl, overlay.name, ipfamily); err != nil {
+			logger.Error(err.Error())
+			return fmt.Errorf("failed to IPAddressByName for pod %s : %w", overlay.name, err)
+		}
+	case *conf.RouteSrcHint && !noHostAccess:
+		if srcIPs, err = hostAccessSrcIPs(podNl, hostAccess, ipfamily, chainedInterfaceIps); err != nil {
+			logger.Error(err.Error())
+			return fmt.Errorf("failed to get the source of the routes to node via %s: %w", hostAccess, err)
+		}
 	}
 
 	if enableIpv6 {
-		if err = utils.EnableIpv6Sysctl(logger, netns); err != nil {
+		if err = utils.EnableIpv6Sysctl(ctx, logger, netns, podSysctl); err != nil {
 			logger.Error(err.Error())
 			return err
 		}
 	}
 
-	ruleTable := utils.GetRuleNumber(preInterfaceName)
-	if ruleTable < 0 {
-		logger.Error("failed to get the number of rule table for interface", zap.String("interface", preInterfaceName))
-		return fmt.Errorf("failed to get the number of rule table for interface %s", preInterfaceName)
+	if hostAccess == hostAccessVeth {
+		if err = setupHostAccessVeth(logger, podNl, hostNl, hostSysctl, args.ContainerID); err != nil {
+			logger.Error(err.Error())
+			return err
+		}
 	}
 
-	// setup neighborhood to fix pod and host communication issue
-	if err = utils.AddStaticNeighTable(logger, netns, conf.Sriov, conf.DefaultOverlayInterface, hostIPs, chainedInterfaceIps); err != nil {
+	// the ip may be reused from a deleted pod, whose conntrack entries break the connections of this pod
+	if *conf.FlushConntrack && !conf.DryRun {
+		networking.CleanReusedConntrack(ctx, logger, prevResult.IPs)
+	}
+
+	// ----------------- Add neigh table and route table in host ns
+	// they're shared by all pods on the node, so the changes of them are serialized, and the lock is released before
+	// the setup in pod
+	err = utils.WithHostLock(ctx, logger, conf.DryRun, func() error {
+		if err := utils.AddHostStaticNeighTable(logger, podNl, hostNl, noHostAccess, hostAccess, chainedInterfaceIps); err != nil {
+			return err
+		}
+		return addChainedIPRoute(logger, podNl, hostNl, noHostAccess, *conf.HostRuleTable, *conf.HostRulePriority, hostAccess, hostIPs, chainedInterfaceIps)
+	})
+	if err != nil {
 		logger.Error(err.Error())
 		return err
 	}
 
-	// ----------------- Add route table in host ns
-	if err = addChainedIPRoute(logger, netns, conf.Sriov, *conf.HostRuleTable, conf.DefaultOverlayInterface, hostIPs, chainedInterfaceIps); err != nil {
+	// setup neighborhood to fix pod and host communication issue
+	if err = utils.AddStaticNeighTable(logger, podNl, hostNl, noHostAccess, hostAccess, hostIPs); err != nil {
 		logger.Error(err.Error())
 		return err
 	}
 
 	// -----------------  Add route table in pod ns
-	// add route in pod: hostIP via DefaultOverlayInterface
-	if err = addHostIPRoute(logger, netns, ruleTable, ipfamily, conf.DefaultOverlayInterface, hostIPs, conf.Sriov, enableIpv4, enableIpv6); err != nil {
+	// add route in pod: hostIP via DefaultOverlayInterface or the dedicated veth
+	if err = addHostIPRoute(logger, podNl, hostIPRouteTable(conf, ruleTable), hostAccess, hostIPs, srcIPs, noHostAccess, enableIpv4, enableIpv6); err != nil {
 		logger.Error("failed to add host ip route in container", zap.Error(err))
-		return fmt.Errorf("failed to add route: %v", err)
+		return fmt.Errorf("failed to add route: %w", err)
 	}
 
-	// hijack overlay response packet to overlay interface
-	// we move default route into table <ruleTable>.
-	defaultInterfaceIPs, err := spiderpool.IPAddressByName(netns, utils.GetDefaultRouteInterface(preInterfaceName), ipfamily)
+	// the gateways of the hijack routes are looked up before the routes are moved
+	paths, err := hijackPaths(podNl, conf, preInterfaceName, ruleTable)
 	if err != nil {
-		logger.Error(err.Error())
-		return fmt.Errorf("failed to IPAddressByName for pod %s : %v", args.IfName, err)
+		logger.Error("failed to resolve the paths of hijack routes", zap.Error(err))
+		return err
 	}
 
-	// add route in pod: custom subnet via DefaultOverlayInterface:  overlay subnet / clusterip subnet ...custom route
-	if err = utils.HijackCustomSubnet(logger, netns, conf.ServiceHijackSubnet, conf.OverlayHijackSubnet, conf.AdditionalHijackSubnet, defaultInterfaceIPs, ruleTable, enableIpv4, enableIpv6); err != nil {
-		logger.Error(err.Error())
-		return err
+	if conf.Isolation == constant.IsolationVRF {
+		// the overlay interface stays in the default vrf with its routes, so the overlay and service subnets
+		// need no rules, and the chained interface is isolated in the vrf of <ruleTable>
+		if err = utils.IsolateByVRF(logger, podNl, preInterfaceName, *conf.MigrateRoute, ruleTable, enableIpv4, enableIpv6); err != nil {
+			logger.Error(err.Error())
+			return err
+		}
+		if err = addVRFHostIPRoute(logger, podNl, ruleTable, hostAccess, hostIPs, noHostAccess); err != nil {
+			logger.Error("failed to add host ip route to vrf in container", zap.Error(err))
+			return fmt.Errorf("failed to add route: %w", err)
+		}
+	} else {
+		// hijack overlay response packet to overlay interface
+		// we move default route into table <ruleTable>.
+		var defaultInterfaceIPs []netlink.Addr
+		defaultInterfaceIPs, err = networking.IPAddressByName(podNl, utils.GetDefaultRouteInterface(preInterfaceName), ipfamily)
+		if err != nil {
+			logger.Error(err.Error())
+			return fmt.Errorf("failed to IPAddressByName for pod %s : %w", args.IfName, err)
+		}
+
+		// add route in pod: custom subnet via DefaultOverlayInterface:  overlay subnet / clusterip subnet ...custom route
+		if err = utils.HijackCustomSubnet(logger, podNl, conf.ServiceHijackSubnet, conf.OverlayHijackSubnet, conf.AdditionalHijackSubnet, defaultInterfaceIPs, ruleTable, enableIpv4, enableIpv6); err != nil {
+			logger.Error(err.Error())
+			return err
+		}
+
+		if err = utils.MigrateRoute(logger, podNl, utils.GetDefaultRouteInterface(preInterfaceName), preInterfaceName, defaultInterfaceIPs, *conf.MigrateRoute, ruleTable, enableIpv4, enableIpv6); err != nil {
+			logger.Error(err.Error())
+			return err
+		}
+
+		// the replies of the connections arriving on the chained interface leave through it, even if they're
+		// to the hijacked subnets
+		if conf.Isolation == constant.IsolationFwmark {
+			if err = utils.MarkByIngress(ctx, logger, netns, podNl, nftables, fwmarkIngress(conf, preInterfaceName, ruleTable), enableIpv4, enableIpv6); err != nil {
+				logger.Error(err.Error())
+				return err
+			}
+		}
 	}
 
-	if err = utils.MigrateRoute(logger, netns, utils.GetDefaultRouteInterface(preInterfaceName), preInterfaceName, defaultInterfaceIPs, *conf.MigrateRoute, ruleTable, enableIpv4, enableIpv6); err != nil {
+	// add route in pod: the hijack routes through overlay, underlay or the given interface
+	if err = utils.AddHijackRoutes(logger, podNl, conf.HijackRoutes, paths, enableIpv4, enableIpv6); err != nil {
 		logger.Error(err.Error())
 		return err
 	}
 
 	// setup sysctl rp_filter
-	if err = utils.SysctlRPFilter(logger, netns, conf.RPFilter); err != nil {
+	if err = utils.SysctlRPFilter(ctx, logger, netns, conf.RPFilter, hostSysctl, podSysctl); err != nil {
 		logger.Error(err.Error())
 		return err
 	}
 
+	if conf.DryRun {
+		dryRunPlan.Log(logger)
+		return types.PrintResult(conf.PrevResult, conf.CNIVersion)
+	}
+
 	logger.Info("Succeeded to set for chained interface for overlay interface",
 		zap.String("interface", preInterfaceName), zap.Int64("Time Cost", time.Since(startTime).Microseconds()))
 
 	return types.PrintResult(conf.PrevResult, conf.CNIVersion)
 }
 
-func cmdDel(args *skel.CmdArgs) error {
-	return nil
-}
-
-func cmdCheck(args *skel.CmdArgs) error {
-	// TODO
-	return fmt.Errorf("not implement it")
-}
-
-// parseConfig parses the supplied configuration (and prevResult) from stdin.
-func parseConfig(stdin []byte) (*PluginConf, error) {
-	var err error
-	conf := PluginConf{}
+func cmdDel(args *skel.CmdArgs) (err error) {
+	startTime := time.Now()
 
-	if err := json.Unmarshal(stdin, &conf); err != nil {
-		return nil, fmt.Errorf("[router] failed to parse network configuration: %v", err)
+	// DEL is best-effort, the pod must be torn down even if its config is invalid, such as an old config with
+	// unknown fields, so the cleanups still run with the config decoded leniently then
+	conf, confErr := parseDelConfig(args.StdinData)
+	logOptions := config.DefaultLogOptions(constant.RouterLogDefaultFilePath)
+	if confErr == nil {
+		logOptions = conf.LogOptions
 	}
-
-	// Parse previous result. This will parse, validate, and place the
-	// previous result object into conf.PrevResult. If you need to modify
-	// or inspect the PrevResult you will need to convert it to a concrete
-	// versioned Result struct.
-	if err := version.ParsePrevResult(&conf.NetConf); err != nil {
-		return nil, fmt.Errorf("[router] could not parse prevResult: %v", err)
+	if err := logging.SetLogOptions(logOptions); err != nil {
+		return fmt.Errorf("faild to init logger: %v ", err)
 	}
-	// End previous result parsing
-	if err = config.ValidateOverwriteMacAddress(conf.MacPrefix); err != nil {
-		return nil, err
+
+	logger := logging.LoggerFile.Named(binName).With(zap.String("Action", "Del"),
+		zap.String("ContainerID", args.ContainerID),
+		zap.String("IfName", args.IfName))
+	if confErr != nil {
+		logger.Warn("The network config is invalid, clean up router with the defaults", zap.Error(confErr))
 	}
 
-	conf.LogOptions = logging.InitLogOptions(conf.LogOptions)
-	if conf.LogOptions.LogFilePath == "" {
-		conf.LogOptions.LogFilePath = constant.RouterLogDefaultFilePath
+	// the deadline of this invocation, the failure caused by it is reported with the code ErrTryAgainLater
+	ctx, cancel, err := utils.InvocationContext(startTime, conf.Timeout)
+	if err != nil {
+		logger.Error(err.Error())
+		return err
 	}
+	defer cancel()
+	defer func() {
+		if utils.IsTimeout(err) {
+			logger.Error("The invocation is timed out", zap.String("timeout", conf.Timeout), zap.Error(err))
+			err = utils.TimeoutError(conf.Timeout, err)
+		}
+	}()
 
-	if conf.OnlyOpMac {
-		return &conf, nil
+	// the ip may be assigned to another pod, which shouldn't see the connections of this pod
+	if *conf.FlushConntrack && conf.PrevResult != nil {
+		if prevResult, err := current.GetResult(conf.PrevResult); err == nil {
+			networking.CleanReleasedConntrack(ctx, logger, prevResult.IPs)
+		}
 	}
 
-	conf.ServiceHijackSubnet, conf.OverlayHijackSubnet, err = config.ValidateRoutes(conf.ServiceHijackSubnet, conf.OverlayHijackSubnet)
+	// the host rules, routes and veth devices are shared by all pods on the node, clean them up under the same lock
+	// as ADD
+	hostLock, wait, err := lock.Lock(ctx, constant.HostLockFile)
 	if err != nil {
-		return nil, err
+		logger.Error("failed to acquire the node-wide lock of host network", zap.Error(err))
+		return err
 	}
+	defer hostLock.Unlock()
+	logger.Debug("Acquired the node-wide lock of host network", zap.Duration("wait", wait))
 
-	if conf.IPConflict != nil {
-		conf.IPConflict = config.ValidateIPConflict(conf.IPConflict)
-		_, err = time.ParseDuration(conf.IPConflict.Interval)
+	// the veth pair of host access is removed with the netns of pod, but the netns may be still held by others
+	if conf.HostAccess != constant.HostAccessOverlay {
+		hostHandle, err := nl.NewHandle(ctx)
 		if err != nil {
-			return nil, fmt.Errorf("invalid interval %s: %v, input like: 1s,1m", conf.IPConflict.Interval, err)
+			logger.Error(err.Error())
+			return err
+		}
+		defer hostHandle.Close()
+		hostNl := nl.WithContext(ctx, hostHandle)
+
+		hostVeth := networking.HostVethName(args.ContainerID)
+		vethLink, err := hostNl.LinkByName(hostVeth)
+		if err == nil {
+			if err = hostNl.LinkDel(vethLink); err != nil {
+				logger.Error("failed to del hostVeth", zap.Error(err))
+				return fmt.Errorf("failed to del hostVeth %s: %w", hostVeth, err)
+			}
+		} else if _, ok := err.(netlink.LinkNotFoundError); !ok {
+			return fmt.Errorf("failed to get host veth device %s: %w", hostVeth, err)
 		}
 	}
 
-	conf.MigrateRoute = config.ValidateMigrateRouteConfig(conf.MigrateRoute)
+	logger.Debug("Success to call router cmdDel")
+	return nil
+}
+
+func cmdCheck(args *skel.CmdArgs) error {
+	// TODO
+	return fmt.Errorf("not implement it")
+}
 
-	if conf.DefaultOverlayInterface == "" {
-		conf.DefaultOverlayInterface = "eth0"
+// parseConfig parses the supplied configuration (and prevResult) from stdin.
+func parseConfig(stdin []byte) (*PluginConf, error) {
+	return config.ParseRouterConfig(stdin)
+}
+
+// parseDelConfig merges the drop-in config with the config of DEL and parses it leniently,
+// the returned config is never nil
+func parseDelConfig(stdin []byte) (*PluginConf, error) {
+	merged, _, mergeErr := config.MergeDropInConfig("router", stdin)
+	if mergeErr != nil {
+		merged = stdin
 	}
+	conf, err := config.ParseRouterDelConfig(merged)
+	return conf, errors.Join(mergeErr, err)
+}
 
-	if conf.HostRuleTable == nil {
-		conf.HostRuleTable = pointer.Int(500)
+// hostIPRouteTable returns the table of the routes of node ips through the host access interface, they're in main
+// with the overlay interface in vrf mode
+func hostIPRouteTable(conf *PluginConf, ruleTable int) int {
+	if conf.Isolation == constant.IsolationVRF {
+		return unix.RT_TABLE_MAIN
 	}
+	return ruleTable
+}
 
-	// value must be 0/1/2
-	// If not, giving default value: RPFilter_Loose(2) to it
-	conf.RPFilter = config.ValidateRPFilterConfig(conf.RPFilter)
+// hijackPaths returns the paths of the hijack routes via overlay and underlay. The routes of the overlay interface
+// are moved to the table of net1, and the ones of the chained interface stay in main, or they're in main and the
+// table of vrf with vrf isolation. The gateways are the ones of their default routes in main, so they're looked up
+// before the routes are moved
+func hijackPaths(podNl nl.Netlink, conf *PluginConf, preInterfaceName string, ruleTable int) (map[string]utils.HijackPath, error) {
+	if len(conf.HijackRoutes) == 0 {
+		return nil, nil
+	}
 
-	return &conf, nil
+	overlay := utils.HijackPath{Interface: conf.DefaultOverlayInterface, Table: overlayRouteTable}
+	underlay := utils.HijackPath{Interface: preInterfaceName, Table: unix.RT_TABLE_MAIN}
+	if conf.Isolation == constant.IsolationVRF && utils.Migrated(*conf.MigrateRoute, preInterfaceName) {
+		overlay.Table, underlay.Table = unix.RT_TABLE_MAIN, ruleTable
+	}
+	var err error
+	for _, path := range []*utils.HijackPath{&overlay, &underlay} {
+		if path.V4Gw, path.V6Gw, err = utils.DefaultGateways(podNl, path.Interface); err != nil {
+			return nil, err
+		}
+	}
+	paths := map[string]utils.HijackPath{constant.HijackViaOverlay: overlay, constant.HijackViaUnderlay: underlay}
+	if *conf.RouteSrcHint {
+		if err = utils.AddSrcHints(podNl, paths, conf.HijackRoutes); err != nil {
+			return nil, err
+		}
+	}
+	return paths, nil
+}
+
+// fwmarkIngress returns the marks of fwmark isolation, the connections arriving on the overlay interface lookup the
+// table which its routes are moved to, and the ones on the chained interface lookup main
+func fwmarkIngress(conf *PluginConf, preInterfaceName string, ruleTable int) []utils.IngressMark {
+	return utils.FwmarkIngress(*conf.MigrateRoute, utils.GetDefaultRouteInterface(preInterfaceName), preInterfaceName, ruleTable, preInterfaceName)
 }
 
 // addHostIPRoute add all routes to the node in pod netns, the nexthop is the ip of the host
 // only add to main!
-func addHostIPRoute(logger *zap.Logger, netns ns.NetNS, ruleTable, ipfamily int, defaultInterface string, hostIPs []net.IP, iSriov, enableIpv4 bool, enableIpv6 bool) error {
-	if iSriov {
-		logger.Info("Main-cni is sriov, don't need to set chained route")
+func addHostIPRoute(logger *zap.Logger, podNl nl.Netlink, ruleTable int, defaultInterface string, hostIPs []net.IP, srcIPs []netlink.Addr, noHostAccess, enableIpv4 bool, enableIpv6 bool) error {
+	if noHostAccess {
+		logger.Info("The pod has no host access interface, don't need to set the routes of node ips")
 		return nil
 	}
 
@@ -334,62 +588,127 @@ func addHostIPRoute(logger *zap.Logger, netns ns.NetNS, ruleTable, ipfamily int,
 		zap.Int("RuleTable", ruleTable),
 		zap.Bool("enableIpv4", enableIpv4),
 		zap.Bool("enableIpv6", enableIpv6))
-	err := netns.Do(func(_ ns.NetNS) error {
-		if ruleTable == 100 {
-			ruleTable = unix.RT_TABLE_MAIN
-		}
-		for _, hostIP := range hostIPs {
-			if err := spiderpool.AddRoute(logger, ruleTable, ipfamily, netlink.SCOPE_LINK, defaultInterface, spiderpool.ConvertMaxMaskIPNet(hostIP), nil, nil); err != nil {
-				logger.Error(err.Error())
-				return err
-			}
-		}
+	if err := addRoutesVia(logger, podNl, defaultInterface, hostIPRoutes(ruleTable, hostIPs, srcIPs)); err != nil {
+		return err
+	}
 
-		logger.Debug("addHostIPRoute add hostIP route dev eth0 to table main")
+	logger.Debug("addHostIPRoute add hostIP route dev eth0 to table main")
+	return nil
+}
+
+// addVRFHostIPRoute adds the routes of the node ips through the host access interface to the table of vrf,
+// so the chained interface in the vrf reaches the node as well
+func addVRFHostIPRoute(logger *zap.Logger, podNl nl.Netlink, ruleTable int, defaultInterface string, hostIPs []net.IP, noHostAccess bool) error {
+	if noHostAccess {
 		return nil
-	})
-	return err
+	}
+	return addRoutesVia(logger, podNl, defaultInterface, vrfHostIPRoutes(ruleTable, hostIPs))
+}
+
+// vrfHostIPRoutes returns the routes of the node ips in the table of vrf, they leak the traffic of the vrf to
+// the host access interface in the default vrf
+func vrfHostIPRoutes(ruleTable int, hostIPs []net.IP) []*netlink.Route {
+	routes := hostIPRoutes(unix.RT_TABLE_MAIN, hostIPs, nil)
+	for _, route := range routes {
+		route.Table = ruleTable
+	}
+	return routes
+}
+
+// addRoutesVia adds the routes through the given interface in pod
+func addRoutesVia(logger *zap.Logger, podNl nl.Netlink, iface string, routes []*netlink.Route) error {
+	link, err := podNl.LinkByName(iface)
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+	for _, route := range routes {
+		route.LinkIndex = link.Attrs().Index
+		if err = podNl.RouteAdd(route); err != nil && !os.IsExist(err) {
+			logger.Error("failed to RouteAdd", zap.String("route", route.String()), zap.Error(err))
+			return fmt.Errorf("failed to add route table(%v): %w", route.String(), err)
+		}
+	}
+	return nil
+}
+
+// hostIPRoutes returns the routes of the node ips in pod, they're added to main instead of the table of the first
+// chained interface. the source of a route is the ip of the same family in srcIPs, see networking.SrcHint.
+func hostIPRoutes(ruleTable int, hostIPs []net.IP, srcIPs []netlink.Addr) []*netlink.Route {
+	if ruleTable == overlayRouteTable {
+		ruleTable = unix.RT_TABLE_MAIN
+	}
+	var routes []*netlink.Route
+	for _, hostIP := range hostIPs {
+		route := &netlink.Route{
+			Dst:   spiderpool.ConvertMaxMaskIPNet(hostIP),
+			Scope: netlink.SCOPE_LINK,
+			Table: ruleTable,
+		}
+		route.Src = networking.SrcHint(srcIPs, hostIP)
+		routes = append(routes, route)
+	}
+	return routes
 }
 
 // addChainedIPRoute to solve macvlan master/slave interface can't communications directly, we add a route fix it.
 // something like: ip r add <macvlan_ip> dev <overlay_veth_device> on host
-func addChainedIPRoute(logger *zap.Logger, netNS ns.NetNS, iSriov bool, hostRuleTable int, defaultOverlayInterface string, hostIPs []net.IP, chainedIPs []netlink.Addr) error {
-	if iSriov {
-		logger.Debug("main-cni is sriov, don't need set chained route")
+func addChainedIPRoute(logger *zap.Logger, podNl, hostNl nl.Netlink, noHostAccess bool, hostRuleTable, hostRulePriority int, defaultOverlayInterface string, hostIPs []net.IP, chainedIPs []netlink.Addr) error {
+	if noHostAccess {
+		logger.Debug("The pod has no host access interface, don't need set chained route")
 		return nil
 	}
 	// 1. get defaultOverlayInterface IP
 	logger.Debug("Add underlay interface route in host ",
 		zap.String("default overlay interface", defaultOverlayInterface))
-	var err error
-	// index of cali* or lxc* on host
-	parentIndex := -1
-	err = netNS.Do(func(_ ns.NetNS) error {
-		link, err := netlink.LinkByName(defaultOverlayInterface)
-		if err != nil {
-			logger.Error(err.Error())
-			return err
-		}
-		parentIndex = link.Attrs().ParentIndex
-		return nil
-	})
+	// the veth device of overlay cni on host, such as cali* or lxc*
+	link, err := nl.VethPeer(podNl, hostNl, defaultOverlayInterface)
 	if err != nil {
 		logger.Error(err.Error())
-		return fmt.Errorf("failed to get parentIndex of %s in pod: %v", defaultOverlayInterface, err)
+		return fmt.Errorf("failed to found the veth peer of %s on host: %w", defaultOverlayInterface, err)
 	}
+	logger.Debug("found veth device of default-overlay cni on host", zap.String("Parent Device", link.Attrs().Name))
+
+	rules, routes := chainedIPRoutes(link.Attrs().Index, hostRuleTable, hostRulePriority, hostIPs, chainedIPs)
+	for i := range rules {
+		if err = addHostRule(hostNl, rules[i]); err != nil {
+			logger.Error("Netlink RuleAdd Failed", zap.String("Rule", rules[i].String()), zap.Error(err))
+			return fmt.Errorf("failed to add rule table for underlay interface: %w", err)
+		}
 
-	if parentIndex < 0 {
-		return fmt.Errorf("parentIndex on found")
+		if err = hostNl.RouteAdd(routes[i]); err != nil && !os.IsExist(err) {
+			logger.Error(err.Error())
+			return fmt.Errorf("failed to add route for underlay interface: %w", err)
+		}
+		logger.Debug("Succeed to add default overlay route on host", zap.Int("LinkIndex", link.Attrs().Index), zap.String("Dst", routes[i].Dst.String()))
 	}
+	return nil
+}
 
-	// debug: get overlay veth interface(cali* or lxc*)
-	link, err := netlink.LinkByIndex(parentIndex)
+// addHostRule adds the rule of host_rule_table on host. the rule added by the old versions is untagged, and the kernel
+// tells the rules by their protocols, so it's adopted rather than being added again with route_protocol
+func addHostRule(hostNl nl.Netlink, rule *netlink.Rule) error {
+	rules, err := hostNl.RuleList(rule.Family)
 	if err != nil {
-		logger.Error(err.Error())
-		return fmt.Errorf("failed to found default overlay veth interface: %v", err)
+		return fmt.Errorf("failed to list the rules on host: %w", err)
+	}
+	for _, r := range rules {
+		if r.Protocol == unix.RTPROT_UNSPEC && r.Table == rule.Table && r.Priority == rule.Priority && r.Src == nil && r.Dst == nil {
+			return nil
+		}
 	}
-	logger.Debug("found veth device of default-overlay cni on host", zap.String("Parent Device", link.Attrs().Name))
 
+	if err = hostNl.RuleAdd(rule); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return nil
+}
+
+// chainedIPRoutes returns the rules and routes on host for the chained ips which are in the same subnet as the node,
+// the rules[i] and routes[i] are for the same chained ip.
+func chainedIPRoutes(parentIndex, hostRuleTable, hostRulePriority int, hostIPs []net.IP, chainedIPs []netlink.Addr) ([]*netlink.Rule, []*netlink.Route) {
+	var rules []*netlink.Rule
+	var routes []*netlink.Route
 	for _, chainedIP := range chainedIPs {
 		for _, hostIP := range hostIPs {
 			if chainedIP.Contains(hostIP) {
@@ -408,25 +727,18 @@ func addChainedIPRoute(logger *zap.Logger, netNS ns.NetNS, iSriov bool, hostRule
 				rule := netlink.NewRule()
 				rule.Table = hostRuleTable
 				rule.Family = family
-				rule.Priority = 1000
-				if err = netlink.RuleAdd(rule); err != nil && !os.IsExist(err) {
-					logger.Error("Netlink RuleAdd Failed", zap.String("Rule", rule.String()), zap.Error(err))
-					return fmt.Errorf("failed to add rule table for underlay interface: %v", err)
-				}
+				rule.Priority = hostRulePriority
+				rules = append(rules, rule)
 
-				if err = netlink.RouteAdd(&netlink.Route{
+				routes = append(routes, &netlink.Route{
 					LinkIndex: parentIndex,
 					Dst:       dst,
 					Scope:     netlink.SCOPE_LINK,
 					Table:     hostRuleTable,
-				}); err != nil && !os.IsExist(err) {
-					logger.Error(err.Error())
-					return fmt.Errorf("failed to add route for underlay interface: %v", err)
-				}
-				logger.Debug("Succeed to add default overlay route on host", zap.Int("LinkIndex", parentIndex), zap.String("Dst", dst.String()))
+				})
 				break
 			}
 		}
 	}
-	return nil
+	return rules, routes
 }
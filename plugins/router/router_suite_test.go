@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/containernetworking/plugins/pkg/ip"
 	"github.com/containernetworking/plugins/pkg/ns"
 	"github.com/containernetworking/plugins/pkg/testutils"
+	"github.com/containernetworking/plugins/pkg/utils/sysctl"
 	"github.com/spidernet-io/cni-plugins/pkg/logging"
 	"github.com/spidernet-io/cni-plugins/pkg/types"
 	"github.com/spidernet-io/cni-plugins/pkg/utils"
@@ -115,7 +117,7 @@ var _ = BeforeSuite(func() {
 		err = netlink.LinkSetUp(overlaylink)
 		Expect(err).NotTo(HaveOccurred())
 
-		err = utils.EnableIpv6Sysctl(logger, testNetNs)
+		err = utils.EnableIpv6Sysctl(context.Background(), logger, testNetNs, sysctl.Sysctl)
 		Expect(err).NotTo(HaveOccurred())
 
 		for _, ipnet := range ipnets {
@@ -10,6 +10,8 @@ import (
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"github.com/spidernet-io/cni-plugins/pkg/logging"
+	"github.com/spidernet-io/cni-plugins/pkg/networking"
+	"github.com/spidernet-io/cni-plugins/pkg/nl"
 	"github.com/spidernet-io/cni-plugins/pkg/utils"
 	"github.com/vishvananda/netlink"
 )
@@ -185,11 +187,11 @@ var _ = Describe("Router", func() {
 
 	Context("Test addHostIPRoute", func() {
 		It("success", func() {
-			err := addHostIPRoute(logger, testNetNs, 101, netlink.FAMILY_ALL, secondifName, hostIPs, false, true, true)
+			err := addHostIPRoute(logger, nl.NewAt(testNetNs), 101, secondifName, hostIPs, nil, false, true, true)
 			Expect(err).NotTo(HaveOccurred())
 		})
 		It("when main cni is sroiv, don't need to add route", func() {
-			err := addHostIPRoute(logger, testNetNs, 100, netlink.FAMILY_ALL, secondifName, hostIPs, true, true, true)
+			err := addHostIPRoute(logger, nl.NewAt(testNetNs), 100, secondifName, hostIPs, nil, true, true, true)
 			Expect(err).NotTo(HaveOccurred())
 		})
 	})
@@ -197,7 +199,7 @@ var _ = Describe("Router", func() {
 	Context("Test addChainedIPRoute", func() {
 
 		It("success", func() {
-			err := addChainedIPRoute(logger, testNetNs, false, 100, overlayifName, hostIPs, defaultInterfaceIPs)
+			err := addChainedIPRoute(logger, nl.NewAt(testNetNs), nl.New(), false, 100, 1000, overlayifName, hostIPs, defaultInterfaceIPs)
 			Expect(err).NotTo(HaveOccurred())
 		})
 
@@ -205,12 +207,12 @@ var _ = Describe("Router", func() {
 			patches := gomonkey.NewPatches()
 			patches.ApplyFuncReturn(netlink.LinkByName, nil, errors.New("link no found"))
 			defer patches.Reset()
-			err := addChainedIPRoute(logger, testNetNs, false, 100, secondifName, hostIPs, defaultInterfaceIPs)
+			err := addChainedIPRoute(logger, nl.NewAt(testNetNs), nl.New(), false, 100, 1000, secondifName, hostIPs, defaultInterfaceIPs)
 			Expect(err).To(HaveOccurred())
 		})
 
 		It("skip call addChainedIPRoute", func() {
-			err := addChainedIPRoute(logger, testNetNs, true, 100, secondifName, hostIPs, defaultInterfaceIPs)
+			err := addChainedIPRoute(logger, nl.NewAt(testNetNs), nl.New(), true, 100, 1000, secondifName, hostIPs, defaultInterfaceIPs)
 			Expect(err).NotTo(HaveOccurred())
 		})
 
@@ -218,7 +220,7 @@ var _ = Describe("Router", func() {
 			patches := gomonkey.NewPatches()
 			defer patches.Reset()
 			patches.ApplyFuncReturn(netlink.LinkByIndex, nil, errors.New("netlink.LinkByIndex err"))
-			err := addChainedIPRoute(logger, testNetNs, false, 100, secondifName, hostIPs, defaultInterfaceIPs)
+			err := addChainedIPRoute(logger, nl.NewAt(testNetNs), nl.New(), false, 100, 1000, secondifName, hostIPs, defaultInterfaceIPs)
 			Expect(err).To(HaveOccurred())
 		})
 
@@ -226,11 +228,39 @@ var _ = Describe("Router", func() {
 			patches := gomonkey.NewPatches()
 			defer patches.Reset()
 			patches.ApplyFuncReturn(netlink.RuleAdd, errors.New("netlink.RuleAdd err"))
-			err := addChainedIPRoute(logger, testNetNs, false, 100, secondifName, hostIPs, defaultInterfaceIPs)
+			err := addChainedIPRoute(logger, nl.NewAt(testNetNs), nl.New(), false, 100, 1000, secondifName, hostIPs, defaultInterfaceIPs)
 			Expect(err).To(HaveOccurred())
 		})
 	})
 
+	Context("Test addHostRule", func() {
+		newRule := func() *netlink.Rule {
+			rule := netlink.NewRule()
+			rule.Table = 500
+			rule.Family = netlink.FAMILY_V4
+			rule.Priority = 1000
+			return rule
+		}
+
+		It("add the rule with route_protocol once", func() {
+			fake := nl.NewFake()
+			hostNl := nl.WithProtocol(fake, 201)
+			Expect(addHostRule(hostNl, newRule())).To(Succeed())
+			Expect(addHostRule(hostNl, newRule())).To(Succeed())
+			Expect(fake.Rules).To(ContainElement(And(HaveField("Table", 500), HaveField("Protocol", uint8(201)))))
+			Expect(fake.Rules).To(HaveLen(len(nl.NewFake().Rules) + 1))
+		})
+
+		It("adopt the untagged rule of the old versions", func() {
+			fake := nl.NewFake()
+			Expect(fake.RuleAdd(newRule())).To(Succeed())
+			rules := append([]netlink.Rule{}, fake.Rules...)
+
+			Expect(addHostRule(nl.WithProtocol(fake, 201), newRule())).To(Succeed())
+			Expect(fake.Rules).To(Equal(rules), "the tagged copy isn't added besides it")
+		})
+	})
+
 	Context("Test cmdAdd", func() {
 
 		It("parse config failed", func() {
@@ -1105,14 +1135,62 @@ var _ = Describe("Router", func() {
 	Context("Test cmdDel", func() {
 
 		It("success", func() {
+			var stdin = []byte(`{
+		"cniVersion": "0.3.1",
+		"name": "router",
+		"type": "router",
+		"service_hijack_subnet": ["10.244.64.0/18"],
+		"overlay_hijack_subnet": ["10.244.0.0/18"],
+		"log_options": {
+			"log_level": "debug"
+		},
+		"prevResult": {
+			"interfaces": [
+				{"name": "net1"}
+			],
+			"ips": [
+				{
+					"version": "4",
+					"address": "10.0.0.1/24",
+					"gateway": "10.0.0.1",
+					"interface": 0
+				}
+			]
+		}
+	}`)
 			args := &skel.CmdArgs{
 				Netns:       testNetNs.Path(),
 				ContainerID: containerID,
+				StdinData:   stdin,
 			}
 			err := cmdDel(args)
 			Expect(err).NotTo(HaveOccurred())
 		})
 
+		It("the host veth is cleaned with an invalid or legacy config", func() {
+			id := "deltestdeltest"
+			hostVeth := networking.HostVethName(id)
+			for _, stdin := range []string{
+				"",
+				`{"cniVersion": "0.3.1", "name": "router", "type": "router", "foo": 1}`,
+				`{"cniVersion": "0.3.1", "name": "router", "type": "router"}`,
+			} {
+				la := netlink.NewLinkAttrs()
+				la.Name = hostVeth
+				Expect(netlink.LinkAdd(&netlink.Veth{LinkAttrs: la, PeerName: "deltestpeer"})).To(Succeed())
+
+				args := &skel.CmdArgs{
+					Netns:       testNetNs.Path(),
+					ContainerID: id,
+					StdinData:   []byte(stdin),
+				}
+				Expect(cmdDel(args)).To(Succeed(), stdin)
+
+				_, err := netlink.LinkByName(hostVeth)
+				Expect(err).To(BeAssignableToTypeOf(netlink.LinkNotFoundError{}), stdin)
+			}
+		})
+
 	})
 
 	Context("Test cmdCheck", func() {
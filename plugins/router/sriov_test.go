@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spidernet-io/cni-plugins/pkg/plan"
+	"github.com/vishvananda/netlink"
+)
+
+var _ = Describe("SR-IOV", func() {
+	Context("Test hostIPRoutes", func() {
+		hostIPs := []net.IP{net.ParseIP("10.6.0.1"), net.ParseIP("fd00:10:6::1")}
+
+		It("the source is the ip of the same family", func() {
+			srcIPs := []netlink.Addr{
+				{IPNet: &net.IPNet{IP: net.ParseIP("fd00:10:244::10"), Mask: net.CIDRMask(128, 128)}},
+				{IPNet: &net.IPNet{IP: net.ParseIP("10.244.1.10"), Mask: net.CIDRMask(32, 32)}},
+			}
+			routes := hostIPRoutes(overlayRouteTable, hostIPs, srcIPs)
+			Expect(routes).To(HaveLen(2))
+			Expect(plan.RouteString(routes[0], "eth0")).To(Equal("10.6.0.1/32 dev eth0 src 10.244.1.10 scope link"))
+			Expect(plan.RouteString(routes[1], "eth0")).To(Equal("fd00:10:6::1/128 dev eth0 src fd00:10:244::10 scope link"))
+		})
+
+		It("no source without ips", func() {
+			routes := hostIPRoutes(101, hostIPs[:1], nil)
+			Expect(plan.RouteString(routes[0], "eth0")).To(Equal("10.6.0.1/32 dev eth0 scope link table 101"))
+		})
+	})
+})
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spidernet-io/cni-plugins/pkg/constant"
+	"github.com/spidernet-io/cni-plugins/pkg/plan"
+	"golang.org/x/sys/unix"
+)
+
+var _ = Describe("VRF", func() {
+	It("the routes to the node are in main table with vrf isolation", func() {
+		conf := &PluginConf{}
+		conf.Isolation = constant.IsolationRule
+		Expect(hostIPRouteTable(conf, 101)).To(Equal(101))
+		conf.Isolation = constant.IsolationVRF
+		Expect(hostIPRouteTable(conf, 101)).To(Equal(unix.RT_TABLE_MAIN))
+	})
+
+	It("the routes to the node in the table of vrf have no source", func() {
+		routes := vrfHostIPRoutes(overlayRouteTable, []net.IP{net.ParseIP("10.6.0.1"), net.ParseIP("fd00:10:6::1")})
+		Expect(routes).To(HaveLen(2))
+		Expect(plan.RouteString(routes[0], "eth0")).To(Equal("10.6.0.1/32 dev eth0 scope link table 100"))
+		Expect(plan.RouteString(routes[1], "eth0")).To(Equal("fd00:10:6::1/128 dev eth0 scope link table 100"))
+	})
+})
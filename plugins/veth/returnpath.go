@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/spidernet-io/cni-plugins/pkg/networking"
+	"github.com/spidernet-io/cni-plugins/pkg/nl"
+	ty "github.com/spidernet-io/cni-plugins/pkg/types"
+	spiderpool "github.com/spidernet-io/spiderpool/pkg/networking/networking"
+	"github.com/vishvananda/netlink"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+// the comment of the iptables rules of return path, to tell them from the ones of others
+const returnPathComment = "meta-plugins: return path of veth0"
+
+// returnPathIptablesRule is a rule of the mangle table in pod
+type returnPathIptablesRule struct {
+	chain string
+	spec  []string
+}
+
+// returnPathIptablesRules returns the rules which mark the connections from veth0, such as the NodePort traffic
+// forwarded by the node, and mark the replies of them so that they are routed by the return path table.
+func returnPathIptablesRules(mark int) []returnPathIptablesRule {
+	markMask := fmt.Sprintf("%#x/%#x", mark, mark)
+	return []returnPathIptablesRule{
+		{
+			chain: "PREROUTING",
+			spec: []string{"-i", defaultConVeth, "-m", "conntrack", "--ctstate", "NEW",
+				"-m", "comment", "--comment", returnPathComment, "-j", "CONNMARK", "--set-xmark", markMask},
+		},
+		{
+			chain: "OUTPUT",
+			spec: []string{"-m", "connmark", "--mark", markMask,
+				"-m", "comment", "--comment", returnPathComment, "-j", "MARK", "--set-xmark", markMask},
+		},
+	}
+}
+
+// returnPathRules returns the rules of return path:
+// the marked replies lookup the return path table in pod: ip rule add fwmark <mark>/<mark> lookup <table>,
+// and the replies from veth0 lookup the main table on the node: ip rule add from <podIP> iif <hostVeth> lookup main,
+// where the SNAT/DNAT state of the node is, even if other rules on the node match the pod ip
+func returnPathRules(rp *ty.ReturnPath, hostVeth string, conIPs []netlink.Addr, enableIpv4, enableIpv6 bool) (podRules, hostRules []*netlink.Rule) {
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		if (family == netlink.FAMILY_V4 && !enableIpv4) || (family == netlink.FAMILY_V6 && !enableIpv6) {
+			continue
+		}
+		rule := netlink.NewRule()
+		rule.Family = family
+		rule.Mark = *rp.Mark
+		rule.Mask = *rp.Mark
+		rule.Table = *rp.Table
+		rule.Priority = *rp.RulePriority
+		podRules = append(podRules, rule)
+	}
+
+	for _, conIP := range conIPs {
+		rule := netlink.NewRule()
+		rule.Family = netlink.FAMILY_V6
+		if conIP.IP.To4() != nil {
+			rule.Family = netlink.FAMILY_V4
+		}
+		rule.Src = spiderpool.ConvertMaxMaskIPNet(conIP.IP)
+		rule.IifName = hostVeth
+		rule.Table = unix.RT_TABLE_MAIN
+		rule.Priority = *rp.RulePriority
+		hostRules = append(hostRules, rule)
+	}
+	return podRules, hostRules
+}
+
+// returnPathRoutes returns the routes of return path table in pod, the replies are sent to the node through veth0:
+// ip route add <hostIP> dev veth0 scope link table <table> and ip route add default via <gateway> dev veth0 table <table>
+func returnPathRoutes(table int, hostIPs []net.IP, v4Gw, v6Gw net.IP) []*netlink.Route {
+	var routes []*netlink.Route
+	for _, hostIP := range hostIPs {
+		routes = append(routes, &netlink.Route{
+			Dst:   spiderpool.ConvertMaxMaskIPNet(hostIP),
+			Scope: netlink.SCOPE_LINK,
+			Table: table,
+		})
+	}
+	if v4Gw != nil {
+		routes = append(routes, &netlink.Route{
+			Dst:   &net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)},
+			Gw:    v4Gw,
+			Table: table,
+		})
+	}
+	if v6Gw != nil {
+		routes = append(routes, &netlink.Route{
+			Dst:   &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)},
+			Gw:    v6Gw,
+			Table: table,
+		})
+	}
+	return routes
+}
+
+// iptablesAppender appends the rules of iptables, it's iptables.IPTables or the recorder of dry-run mode
+type iptablesAppender interface {
+	AppendUnique(table, chain string, rulespec ...string) error
+}
+
+// newIptables returns the iptables of the protocol in the current network namespace
+func newIptables(protocol iptables.Protocol) (iptablesAppender, error) {
+	return iptables.NewWithProtocol(protocol)
+}
+
+func iptablesProtocols(enableIpv4, enableIpv6 bool) []iptables.Protocol {
+	var protocols []iptables.Protocol
+	if enableIpv4 {
+		protocols = append(protocols, iptables.ProtocolIPv4)
+	}
+	if enableIpv6 {
+		protocols = append(protocols, iptables.ProtocolIPv6)
+	}
+	return protocols
+}
+
+// setupReturnPath makes the replies of the connections from veth0 go back through veth0. without it, the replies of the
+// NodePort traffic forwarded by the node are sent by the chained interface, and the node drops the connections
+// because it never sees the replies, see the known issue #142.
+func setupReturnPath(logger *zap.Logger, netns ns.NetNS, podNl nl.Netlink, newIptables func(iptables.Protocol) (iptablesAppender, error), hostVeth string, hostIPs []net.IP, conIPs []netlink.Addr, enableIpv4, enableIpv6 bool, rp *ty.ReturnPath) error {
+	err := netns.Do(func(_ ns.NetNS) error {
+		for _, protocol := range iptablesProtocols(enableIpv4, enableIpv6) {
+			ipt, err := newIptables(protocol)
+			if err != nil {
+				return fmt.Errorf("failed to init iptables: %w", err)
+			}
+			for _, rule := range returnPathIptablesRules(*rp.Mark) {
+				if err = ipt.AppendUnique("mangle", rule.chain, rule.spec...); err != nil {
+					return fmt.Errorf("failed to add the iptables rule to %s: %w", rule.chain, err)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Error("failed to mark the connections from veth0 in pod", zap.Error(err))
+		return err
+	}
+
+	v4Gw, v6Gw, err := spiderpool.GetGatewayIP(conIPs)
+	if err != nil {
+		logger.Error("failed to GetGatewayIP", zap.Error(err))
+		return err
+	}
+	for _, route := range returnPathRoutes(*rp.Table, hostIPs, v4Gw, v6Gw) {
+		ipfamily := netlink.FAMILY_V6
+		if route.Dst.IP.To4() != nil {
+			ipfamily = netlink.FAMILY_V4
+		}
+		if err = networking.AddRoute(logger, podNl, route.Table, ipfamily, route.Scope, defaultConVeth, route.Dst, route.Gw, route.Gw, nil); err != nil {
+			return fmt.Errorf("failed to add the route of return path: %w", err)
+		}
+	}
+
+	podRules, _ := returnPathRules(rp, hostVeth, conIPs, enableIpv4, enableIpv6)
+	for _, rule := range podRules {
+		if err = podNl.RuleAdd(rule); err != nil && !os.IsExist(err) {
+			logger.Error("failed to add the rule of return path in pod", zap.String("rule", rule.String()), zap.Error(err))
+			return fmt.Errorf("failed to add the rule of return path in pod: %w", err)
+		}
+	}
+
+	logger.Info("Succeeded to setup the return path of veth0", zap.Int("mark", *rp.Mark), zap.Int("table", *rp.Table))
+	return nil
+}
+
+// setupHostReturnPath adds the rules of return path on the node, see setupReturnPath
+func setupHostReturnPath(logger *zap.Logger, hostNl nl.Netlink, hostVeth string, conIPs []netlink.Addr, enableIpv4, enableIpv6 bool, rp *ty.ReturnPath) error {
+	_, hostRules := returnPathRules(rp, hostVeth, conIPs, enableIpv4, enableIpv6)
+	for _, rule := range hostRules {
+		if err := hostNl.RuleAdd(rule); err != nil && !os.IsExist(err) {
+			logger.Error("failed to add the rule of return path on host", zap.String("rule", rule.String()), zap.Error(err))
+			return fmt.Errorf("failed to add the rule of return path on host: %w", err)
+		}
+	}
+	return nil
+}
+
+// cleanReturnPath deletes the rules of return path on the node, the ones in pod are gone with the netns
+func cleanReturnPath(logger *zap.Logger, hostNl nl.Netlink, hostVeth string) error {
+	rules, err := hostNl.RuleList(netlink.FAMILY_ALL)
+	if err != nil {
+		return fmt.Errorf("failed to list the rules on host: %w", err)
+	}
+	for idx := range rules {
+		if rules[idx].IifName != hostVeth {
+			continue
+		}
+		if err = hostNl.RuleDel(&rules[idx]); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete the rule of return path %s: %w", rules[idx].String(), err)
+		}
+		logger.Debug("Deleted the rule of return path on host", zap.String("rule", rules[idx].String()))
+	}
+	return nil
+}
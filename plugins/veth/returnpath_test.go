@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net"
+
+	"github.com/coreos/go-iptables/iptables"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spidernet-io/cni-plugins/pkg/plan"
+	ty "github.com/spidernet-io/cni-plugins/pkg/types"
+	"github.com/vishvananda/netlink"
+	"k8s.io/utils/pointer"
+)
+
+var _ = Describe("Veth return path", func() {
+	defer GinkgoRecover()
+	rp := &ty.ReturnPath{Enabled: true, Mark: pointer.Int(0x2000), Table: pointer.Int(1002), RulePriority: pointer.Int(90)}
+	conIPs := []netlink.Addr{
+		{IPNet: &net.IPNet{IP: net.ParseIP("10.6.1.10"), Mask: net.CIDRMask(24, 32)}},
+		{IPNet: &net.IPNet{IP: net.ParseIP("fd00:10:6::10"), Mask: net.CIDRMask(64, 128)}},
+	}
+
+	Context("Test returnPathIptablesRules", func() {
+		It("mark the connections from veth0 and their replies", func() {
+			p := &plan.Plan{}
+			ipt := p.Iptables(plan.PodNetns, iptables.ProtocolIPv4)
+			for _, rule := range returnPathIptablesRules(0x2000) {
+				Expect(ipt.AppendUnique("mangle", rule.chain, rule.spec...)).To(Succeed())
+			}
+			Expect(p.Changes).To(Equal([]plan.Change{
+				{Netns: plan.PodNetns, Kind: plan.KindIptables, Command: `iptables -t mangle -A PREROUTING -i veth0 -m conntrack --ctstate NEW -m comment --comment "meta-plugins: return path of veth0" -j CONNMARK --set-xmark 0x2000/0x2000`},
+				{Netns: plan.PodNetns, Kind: plan.KindIptables, Command: `iptables -t mangle -A OUTPUT -m connmark --mark 0x2000/0x2000 -m comment --comment "meta-plugins: return path of veth0" -j MARK --set-xmark 0x2000/0x2000`},
+			}))
+		})
+	})
+
+	Context("Test returnPathRules", func() {
+		It("rules of pod and host", func() {
+			podRules, hostRules := returnPathRules(rp, "vethtesttestte", conIPs, true, true)
+			Expect(podRules).To(HaveLen(2))
+			Expect(plan.RuleString(podRules[0])).To(Equal("priority 90 from all fwmark 0x2000/0x2000 lookup 1002"))
+			Expect(podRules[0].Family).To(Equal(netlink.FAMILY_V4))
+			Expect(podRules[1].Family).To(Equal(netlink.FAMILY_V6))
+
+			Expect(hostRules).To(HaveLen(2))
+			Expect(plan.RuleString(hostRules[0])).To(Equal("priority 90 from 10.6.1.10/32 iif vethtesttestte lookup 254"))
+			Expect(plan.RuleString(hostRules[1])).To(Equal("priority 90 from fd00:10:6::10/128 iif vethtesttestte lookup 254"))
+		})
+
+		It("only the enabled family in pod", func() {
+			podRules, _ := returnPathRules(rp, "vethtesttestte", conIPs[:1], true, false)
+			Expect(podRules).To(HaveLen(1))
+			Expect(podRules[0].Family).To(Equal(netlink.FAMILY_V4))
+		})
+	})
+
+	Context("Test returnPathRoutes", func() {
+		It("routes to host and default route", func() {
+			routes := returnPathRoutes(1002, []net.IP{net.ParseIP("10.6.1.1")}, net.ParseIP("10.6.1.1"), nil)
+			Expect(routes).To(HaveLen(2))
+			Expect(plan.RouteString(routes[0], "veth0")).To(Equal("10.6.1.1/32 dev veth0 scope link table 1002"))
+			Expect(plan.RouteString(routes[1], "veth0")).To(Equal("0.0.0.0/0 via 10.6.1.1 dev veth0 table 1002"))
+		})
+	})
+})
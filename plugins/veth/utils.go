@@ -4,34 +4,25 @@
 package main
 
 import (
-	"fmt"
+	"github.com/spidernet-io/cni-plugins/pkg/constant"
+	"github.com/spidernet-io/cni-plugins/pkg/nl"
+	ty "github.com/spidernet-io/cni-plugins/pkg/types"
+	"github.com/spidernet-io/cni-plugins/pkg/utils"
+	spiderpool "github.com/spidernet-io/spiderpool/pkg/networking/networking"
 	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 	"net"
 )
 
 var defaultMtu = 1500
 
-// setLinkup set the given interface to up.
-func setLinkup(iface string) error {
-	link, err := netlink.LinkByName(iface)
-	if err != nil {
-		return err
-	}
-
-	// set link up
-	if err := netlink.LinkSetUp(link); err != nil {
-		return fmt.Errorf("failed to set %q UP: %v", iface, err)
-	}
-	return nil
-}
-
 // setRPFilter set rp_filter parameters to 2
 /*
 var sysctlConfPath = "/proc/sys/net/ipv4/conf"
 func setRPFilter() error {
 	dirs, err := os.ReadDir(sysctlConfPath)
 	if err != nil {
-		return fmt.Errorf("[veth]failed to set rp_filter: %v", err)
+		return fmt.Errorf("[veth]failed to set rp_filter: %w", err)
 	}
 	for _, dir := range dirs {
 		name := fmt.Sprintf("/net/ipv4/conf/%s/rp_filter", dir.Name())
@@ -63,14 +54,50 @@ func filterIPs(netIP net.IP, ipv4, ipv6 bool, viaIps []string) (bool, bool, []st
 	return ipv4, ipv6, viaIps
 }
 
-// getHostVethName select the first 11 characters of the containerID for the host veth.
-func getHostVethName(containerID string) string {
-	return fmt.Sprintf("veth%s", containerID[:min(len(containerID))])
+// macChangeable tells whether the mac-address of the chained interface can be updated by mac_prefix,
+// the ipvlan device always has the mac-address of its parent.
+func macChangeable(chainedType string) bool {
+	return chainedType != constant.ChainedTypeIPvlanL2 && chainedType != constant.ChainedTypeIPvlanL3
+}
+
+// fwmarkIngress returns the marks of fwmark isolation, the connections arriving on the first chained interface
+// lookup main, and the ones on the others lookup the table which their routes are moved to
+func fwmarkIngress(isfirstInterface bool, chainedInterface string, ruleTable int, value ty.MigrateRoute) []utils.IngressMark {
+	if isfirstInterface {
+		return []utils.IngressMark{{Interface: chainedInterface, Table: unix.RT_TABLE_MAIN}}
+	}
+	return utils.FwmarkIngress(value, chainedInterface, chainedInterface, ruleTable)
 }
 
-func min(len int) int {
-	if len > 11 {
-		return 11
+// hijackPaths returns the paths of the hijack routes: overlay is veth0 whose routes are in ruleTable like the ones of
+// the hijacked subnets, and underlay is the chained interface whose routes are moved to ruleTable unless it's the
+// first one. The gateways of the chained interface are the ones of its default routes, which are looked up before
+// the routes are moved. With route_src_hint, the routes via veth0 prefer the addresses of the chained interface as
+// veth0 has none, and the others prefer the ones of their interfaces
+func hijackPaths(podNl nl.Netlink, conf *PluginConf, isfirstInterface bool, chainedInterface string, ruleTable int, conIPs []netlink.Addr) (map[string]utils.HijackPath, error) {
+	if len(conf.HijackRoutes) == 0 {
+		return nil, nil
+	}
+
+	overlay := utils.HijackPath{Interface: defaultConVeth, Table: ruleTable}
+	underlay := utils.HijackPath{Interface: chainedInterface, Table: unix.RT_TABLE_MAIN}
+	if !isfirstInterface && utils.Migrated(*conf.MigrateRoute, chainedInterface) {
+		underlay.Table = ruleTable
+	}
+	var err error
+	if overlay.V4Gw, overlay.V6Gw, err = spiderpool.GetGatewayIP(conIPs); err != nil {
+		return nil, err
+	}
+	if underlay.V4Gw, underlay.V6Gw, err = utils.DefaultGateways(podNl, chainedInterface); err != nil {
+		return nil, err
+	}
+	paths := map[string]utils.HijackPath{constant.HijackViaOverlay: overlay, constant.HijackViaUnderlay: underlay}
+	if *conf.RouteSrcHint {
+		overlay.SrcIPs = conIPs
+		paths[constant.HijackViaOverlay] = overlay
+		if err = utils.AddSrcHints(podNl, paths, conf.HijackRoutes); err != nil {
+			return nil, err
+		}
 	}
-	return len
+	return paths, nil
 }
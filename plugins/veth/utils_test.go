@@ -3,6 +3,13 @@ package main
 import (
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/spidernet-io/cni-plugins/pkg/config"
+	"github.com/spidernet-io/cni-plugins/pkg/constant"
+	"github.com/spidernet-io/cni-plugins/pkg/nl"
+	ty "github.com/spidernet-io/cni-plugins/pkg/types"
+	"github.com/spidernet-io/cni-plugins/pkg/utils"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 	"net"
 )
 
@@ -33,4 +40,60 @@ var _ = Describe("Veth utils", func() {
 		})
 
 	})
+
+	Context("Test macChangeable", func() {
+		It("the ipvlan device shares the mac-address of its parent", func() {
+			Expect(macChangeable(constant.ChainedTypeMacvlan)).To(BeTrue())
+			Expect(macChangeable(constant.ChainedTypeSriov)).To(BeTrue())
+			Expect(macChangeable(constant.ChainedTypeIPvlanL2)).To(BeFalse())
+			Expect(macChangeable(constant.ChainedTypeIPvlanL3)).To(BeFalse())
+		})
+	})
+
+	Context("Test fwmarkIngress", func() {
+		It("the first chained interface lookup main, the others lookup their tables", func() {
+			Expect(fwmarkIngress(true, "net1", 100, ty.MigrateEnable)).To(Equal([]utils.IngressMark{{Interface: "net1", Table: unix.RT_TABLE_MAIN}}))
+			Expect(fwmarkIngress(false, "net2", 101, ty.MigrateEnable)).To(Equal([]utils.IngressMark{{Interface: "net2", Table: 101}}))
+			Expect(fwmarkIngress(false, "net2", 101, ty.MigrateNever)).To(BeEmpty())
+		})
+	})
+
+	Context("Test hijackPaths", func() {
+		It("the underlay is moved to the rule table unless it's the first interface", func() {
+			pod := nl.NewFake()
+			net2 := pod.AddLink(netlink.LinkAttrs{Name: "net2"})
+			Expect(pod.RouteAdd(&netlink.Route{LinkIndex: net2.Attrs().Index, Gw: net.ParseIP("10.7.0.1")})).To(Succeed())
+
+			migrate, srcHint := ty.MigrateEnable, false
+			conf := &PluginConf{PluginConf: config.PluginConf{MigrateRoute: &migrate, RouteSrcHint: &srcHint}}
+			paths, err := hijackPaths(pod, conf, false, "net2", 101, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(paths).To(BeNil())
+
+			conf.HijackRoutes = []ty.HijackRoute{{Dst: "10.8.0.0/16"}}
+			paths, err = hijackPaths(pod, conf, false, "net2", 101, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(paths[constant.HijackViaOverlay]).To(Equal(utils.HijackPath{Interface: "veth0", Table: 101}))
+			Expect(paths[constant.HijackViaUnderlay]).To(Equal(utils.HijackPath{Interface: "net2", Table: 101, V4Gw: net.ParseIP("10.7.0.1")}))
+
+			paths, err = hijackPaths(pod, conf, true, "net2", unix.RT_TABLE_MAIN, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(paths[constant.HijackViaUnderlay].Table).To(Equal(unix.RT_TABLE_MAIN))
+		})
+
+		It("the routes via veth0 prefer the ips of the chained interface", func() {
+			pod := nl.NewFake()
+			net2 := pod.AddLink(netlink.LinkAttrs{Name: "net2"})
+			addr := netlink.Addr{IPNet: &net.IPNet{IP: net.ParseIP("10.7.1.10"), Mask: net.CIDRMask(16, 32)}}
+			Expect(pod.AddrAdd(net2, &addr)).To(Succeed())
+
+			migrate, srcHint := ty.MigrateEnable, true
+			conf := &PluginConf{PluginConf: config.PluginConf{MigrateRoute: &migrate, RouteSrcHint: &srcHint, HijackRoutes: []ty.HijackRoute{{Dst: "10.8.0.0/16", Via: constant.HijackViaUnderlay}}}}
+			conIPs := []netlink.Addr{addr}
+			paths, err := hijackPaths(pod, conf, false, "net2", 101, conIPs)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(paths[constant.HijackViaOverlay].SrcIPs).To(Equal(conIPs))
+			Expect(paths[constant.HijackViaUnderlay].SrcIPs).To(ConsistOf(HaveField("IPNet.IP", Equal(addr.IP))))
+		})
+	})
 })
@@ -4,7 +4,7 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"os"
@@ -14,8 +14,12 @@ import (
 
 	"github.com/spidernet-io/cni-plugins/pkg/config"
 	"github.com/spidernet-io/cni-plugins/pkg/constant"
+	"github.com/spidernet-io/cni-plugins/pkg/k8s"
+	"github.com/spidernet-io/cni-plugins/pkg/lock"
 	"github.com/spidernet-io/cni-plugins/pkg/logging"
 	"github.com/spidernet-io/cni-plugins/pkg/networking"
+	"github.com/spidernet-io/cni-plugins/pkg/nl"
+	"github.com/spidernet-io/cni-plugins/pkg/plan"
 	ty "github.com/spidernet-io/cni-plugins/pkg/types"
 	"github.com/spidernet-io/cni-plugins/pkg/utils"
 
@@ -24,30 +28,17 @@ import (
 	"github.com/containernetworking/cni/pkg/types"
 	current "github.com/containernetworking/cni/pkg/types/100"
 	"github.com/containernetworking/cni/pkg/version"
-	"github.com/containernetworking/plugins/pkg/ip"
 	"github.com/containernetworking/plugins/pkg/ns"
 	bv "github.com/containernetworking/plugins/pkg/utils/buildversion"
+	"github.com/containernetworking/plugins/pkg/utils/sysctl"
+	"github.com/coreos/go-iptables/iptables"
 	spiderpool "github.com/spidernet-io/spiderpool/pkg/networking/networking"
 	"github.com/vishvananda/netlink"
 	"go.uber.org/zap"
 	"golang.org/x/sys/unix"
 )
 
-type PluginConf struct {
-	types.NetConf
-	// should include: overlay Subnet , clusterip subnet
-	OverlayHijackSubnet    []string `json:"overlay_hijack_subnet,omitempty"`
-	ServiceHijackSubnet    []string `json:"service_hijack_subnet,omitempty"`
-	AdditionalHijackSubnet []string `json:"additional_hijack_subnet,omitempty"`
-	// RpFilter
-	RPFilter     *ty.RPFilter     `json:"rp_filter,omitempty" `
-	Skipped      bool             `json:"skip_call,omitempty"`
-	MigrateRoute *ty.MigrateRoute `json:"migrate_route,omitempty"`
-	LogOptions   *ty.LogOptions   `json:"log_options,omitempty"`
-	IPConflict   *ty.IPConflict   `json:"ip_conflict,omitempty"`
-	MacPrefix    string           `json:"mac_prefix,omitempty"`
-	OnlyOpMac    bool             `json:"only_op_mac,omitempty"`
-}
+type PluginConf = config.VethConf
 
 func init() {
 	// this ensures that main runs only on main thread (thread group leader).
@@ -62,24 +53,43 @@ var binName = filepath.Base(os.Args[0])
 var defaultConVeth = "veth0"
 
 func main() {
+	// print the network config read from stdin migrated to the current config_version, such as: veth migrate-config < conf.json
+	if len(os.Args) > 1 && os.Args[1] == "migrate-config" {
+		if err := config.PrintMigratedConfig("veth", os.Stdin, os.Stdout, os.Stderr); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	skel.PluginMain(cmdAdd, cmdCheck, cmdDel, version.All, bv.BuildString(binName))
 }
 
-func cmdAdd(args *skel.CmdArgs) error {
+func cmdAdd(args *skel.CmdArgs) (err error) {
 	startTime := time.Now()
 
 	var logger *zap.Logger
-	conf, err := parseConfig(args.StdinData)
+	stdin, dropIns, err := config.MergeDropInConfig("veth", args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	conf, err := parseConfig(stdin)
 	if err != nil {
 		return err
 	}
 
 	if err := logging.SetLogOptions(conf.LogOptions); err != nil {
-		return fmt.Errorf("faild to init logger: %v ", err)
+		return fmt.Errorf("faild to init logger: %w ", err)
+	}
+
+	k8sCNIArgs, overrideArgs, err := config.SplitCNIArgs(args.Args)
+	if err != nil {
+		return fmt.Errorf("failed to parse CNI_ARGS: %w", err)
 	}
 
 	k8sArgs := ty.K8sArgs{}
-	if err = types.LoadArgs(args.Args, &k8sArgs); nil != err {
+	if err = types.LoadArgs(k8sCNIArgs, &k8sArgs); nil != err {
 		return fmt.Errorf("failed to get pod information, error=%+v \n", err)
 	}
 
@@ -93,6 +103,46 @@ func cmdAdd(args *skel.CmdArgs) error {
 		zap.String("PodNamespace", string(k8sArgs.K8S_POD_NAMESPACE)),
 		zap.String("IfName", args.IfName))
 
+	// the deadline of this invocation, the failure caused by it is reported with the code ErrTryAgainLater
+	ctx, cancel, err := utils.InvocationContext(startTime, conf.Timeout)
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+	defer cancel()
+	defer func() {
+		if utils.IsTimeout(err) {
+			logger.Error("The invocation is timed out", zap.String("timeout", conf.Timeout), zap.Error(err))
+			err = utils.TimeoutError(conf.Timeout, err)
+		}
+	}()
+
+	if len(dropIns) != 0 {
+		logger.Info("Merged the node-local drop-in config", zap.Strings("DropIns", dropIns), zap.String("EffectiveConfig", string(stdin)))
+	}
+	for _, warning := range conf.Warnings {
+		logger.Warn("Coerced the value of network config", zap.String("warning", warning))
+	}
+
+	// the kubernetes client is shared by the lookups of this invocation
+	kc := k8s.NewInvocationClient(conf.Kubernetes)
+	annotations, err := k8s.LookupPodOverrides(ctx, logger, kc, string(k8sArgs.K8S_POD_NAMESPACE), string(k8sArgs.K8S_POD_NAME))
+	if err != nil {
+		logger.Error("failed to look up the annotations of pod", zap.Error(err))
+		return fmt.Errorf("failed to look up the annotations of pod: %w", err)
+	}
+
+	overrides, err := config.ParseOverrides(conf.AllowedOverrides, annotations, conf.Args, overrideArgs)
+	if err != nil {
+		logger.Error("failed to parse the overrides of pod", zap.Error(err))
+		return fmt.Errorf("failed to parse the overrides of pod: %w", err)
+	}
+	if err = conf.ApplyOverrides(overrides); err != nil {
+		logger.Error("failed to apply the overrides of pod", zap.Error(err))
+		return err
+	}
+	logger.Debug("Succeed to apply the overrides of pod", zap.Any("Overrides", *overrides))
+
 	// skip veth plugin
 	if conf.Skipped {
 		logger.Info("Ignore this plugin call, Return directly ")
@@ -103,10 +153,15 @@ func cmdAdd(args *skel.CmdArgs) error {
 		return fmt.Errorf("failed to find PrevResult, must be called as chained plugin")
 	}
 
+	if err = k8s.DiscoverHijackSubnets(ctx, logger, kc, &conf.PluginConf); err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
 	prevResult, err := current.GetResult(conf.PrevResult)
 	if err != nil {
 		logger.Error(err.Error())
-		return fmt.Errorf("failed to convert prevResult: %v", err)
+		return fmt.Errorf("failed to convert prevResult: %w", err)
 	}
 
 	logger.Debug("Start call veth", zap.Any("config", conf))
@@ -114,29 +169,105 @@ func cmdAdd(args *skel.CmdArgs) error {
 	netns, err := ns.GetNS(args.Netns)
 	if err != nil {
 		logger.Error(err.Error())
-		return fmt.Errorf("failed to open netns %q: %v", args.Netns, err)
+		return fmt.Errorf("failed to open netns %q: %w", args.Netns, err)
 	}
 	defer netns.Close()
 
+	// the netlink operations in pod and host use the sockets bound to the namespaces,
+	// so the pod netns is not entered for every one of them
+	podHandle, err := nl.NewHandleAt(ctx, netns)
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+	defer podHandle.Close()
+	hostHandle, err := nl.NewHandle(ctx)
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+	defer hostHandle.Close()
+	var podNl, hostNl nl.Netlink = nl.WithContext(ctx, podHandle), nl.WithContext(ctx, hostHandle)
+	var podSysctl, hostSysctl networking.Sysctl = sysctl.Sysctl, sysctl.Sysctl
+	var nftables utils.Nftables = utils.RunNftables
+	newIptables := newIptables
+	// in dry-run mode, the same code runs with the netlink, sysctl, nftables and iptables which record the changes
+	// to the plan rather than making them
+	dryRunPlan := &plan.Plan{}
+	if conf.DryRun {
+		podNl, hostNl = dryRunPlan.Netlink(plan.PodNetns, podNl), dryRunPlan.Netlink(plan.HostNetns, hostNl)
+		podSysctl, hostSysctl = dryRunPlan.Sysctl(plan.PodNetns), dryRunPlan.Sysctl(plan.HostNetns)
+		nftables = dryRunPlan.Nftables(plan.PodNetns)
+		newIptables = func(protocol iptables.Protocol) (iptablesAppender, error) {
+			return dryRunPlan.Iptables(plan.PodNetns, protocol), nil
+		}
+	}
+	// the routes and rules created by the plugin are tagged with the protocol, to tell them from the ones of others
+	podNl = nl.WithProtocol(podNl, *conf.RouteProtocol)
+	hostNl = nl.WithProtocol(hostNl, *conf.RouteProtocol)
+
 	logger.Debug("Get prevResult", zap.Any("prevResult", prevResult))
 
+	if len(prevResult.Interfaces) == 0 {
+		err = fmt.Errorf("failed to find interface from prevResult")
+		logger.Error(err.Error())
+		return err
+	}
+	chainedInterface := prevResult.Interfaces[0].Name
+	if len(chainedInterface) == 0 {
+		err = fmt.Errorf("failed to find interface name from prevResult")
+		logger.Error(err.Error())
+		return err
+	}
+
+	// the chained interface is a SR-IOV VF if it's allocated by the device plugin
+	pciID := networking.SriovDevice(conf.DeviceID, conf.RawPrevResult, args.IfName)
+	if !conf.Sriov && pciID != "" {
+		conf.Sriov = true
+		logger.Info("Detected the SR-IOV VF of the chained interface", zap.String("pciID", pciID))
+	}
+	chainedType, err := networking.ChainedInterfaceType(podNl, args.IfName, conf.Sriov)
+	if err != nil {
+		logger.Error("failed to detect the type of chained interface", zap.Error(err))
+		return fmt.Errorf("failed to detect the type of chained interface %s: %w", args.IfName, err)
+	}
+	logger.Debug("Detected the type of chained interface", zap.String("type", chainedType))
+
+	// the node learns the neighbors of the VF on its PF, see setupNeighborhood
+	sriovPF := ""
+	if chainedType == constant.ChainedTypeSriov {
+		if sriovPF, err = networking.SriovPF(pciID); err != nil {
+			logger.Warn("failed to find the PF of the chained interface, ignore cleaning the neighbors of pod on it", zap.String("pciID", pciID), zap.Error(err))
+		}
+	}
+
 	// we do check if ip is conflict firstly
 	if conf.IPConflict != nil && conf.IPConflict.Enabled {
-		err = networking.DoIPConflictChecking(logger, netns, args.IfName, prevResult.IPs, conf.IPConflict)
-		if err != nil {
+		if chainedType == constant.ChainedTypeIPvlanL3 {
+			// ipvlan in l3 mode never sends or answers ARP and NDP, so the conflict is undetectable
+			logger.Warn("Skip checking the ip conflict, the chained interface is NOARP", zap.String("type", chainedType))
+		} else if err = networking.DoIPConflictChecking(ctx, logger, netns, args.IfName, prevResult.IPs, conf.IPConflict); err != nil {
 			logger.Error(err.Error())
 			return err
 		}
 	}
 
 	if len(conf.MacPrefix) != 0 {
-		newMac, err := utils.OverwriteMacAddress(logger, netns, conf.MacPrefix, args.IfName)
-		if err != nil {
-			return fmt.Errorf("failed to update mac address, maybe mac_prefix is invalid: %v", conf.MacPrefix)
+		if !macChangeable(chainedType) {
+			// the ipvlan device shares the mac-address of its parent, which can't be changed in pod
+			logger.Warn("Skip updating the mac address, it's shared with the parent of the chained interface", zap.String("type", chainedType))
+		} else {
+			newMac, err := utils.OverwriteMacAddress(logger, netns, podNl, conf.MacPrefix, args.IfName)
+			if err != nil {
+				return fmt.Errorf("failed to update mac address, maybe mac_prefix is invalid: %v", conf.MacPrefix)
+			}
+			logger.Info("Update mac address successfully", zap.String("interface", constant.DefaultInterfaceName), zap.String("new mac", newMac))
 		}
-		logger.Info("Update mac address successfully", zap.String("interface", constant.DefaultInterfaceName), zap.String("new mac", newMac))
 		if conf.OnlyOpMac {
 			logger.Debug("only update mac address, exiting now...")
+			if conf.DryRun {
+				dryRunPlan.Log(logger)
+			}
 			return types.PrintResult(conf.PrevResult, conf.CNIVersion)
 		}
 	}
@@ -157,22 +288,22 @@ func cmdAdd(args *skel.CmdArgs) error {
 		ipfamily = netlink.FAMILY_ALL
 	}
 
-	if len(prevResult.Interfaces) == 0 {
-		err = fmt.Errorf("failed to find interface from prevResult")
-		logger.Error(err.Error())
-		return err
+	// infer the overlay subnets before the routes of overlay interface are migrated.
+	// if the chained interface is the overlay interface, there is no overlay interface in pod, only infer from the node.
+	overlayInterface := ""
+	if chainedInterface != conf.DefaultOverlayInterface {
+		overlayInterface = conf.DefaultOverlayInterface
 	}
-	chainedInterface := prevResult.Interfaces[0].Name
-	if len(chainedInterface) == 0 {
-		err = fmt.Errorf("failed to find interface name from prevResult")
-		logger.Error(err.Error())
-		return err
+	conf.OverlayHijackSubnet, err = networking.ResolveAutoOverlaySubnets(logger, podNl, hostNl, overlayInterface, ipfamily, conf.OverlayHijackSubnet)
+	if err != nil {
+		logger.Error("failed to infer overlay hijack subnets", zap.Error(err))
+		return fmt.Errorf("failed to infer overlay hijack subnets: %w", err)
 	}
 
 	// Pass the prevResult through this plugin to the next one
 	// result := prevResult
 
-	isfirstInterface, e := utils.CheckInterfaceMiss(netns, defaultConVeth)
+	isfirstInterface, e := utils.CheckInterfaceMiss(podNl, defaultConVeth)
 	if e != nil {
 		logger.Error("failed to check first veth interface", zap.Error(e))
 		return fmt.Errorf("failed to check first veth interface: %v", e)
@@ -187,7 +318,7 @@ func cmdAdd(args *skel.CmdArgs) error {
 	// 1. setup veth pair
 	var hostInterface *current.Interface
 	var conInterface *current.Interface
-	hostInterface, conInterface, err = setupVeth(logger, netns, isfirstInterface, args.ContainerID, prevResult)
+	hostInterface, conInterface, err = setupVeth(logger, netns, podNl, hostNl, hostSysctl, isfirstInterface, args.ContainerID, prevResult)
 	if err != nil {
 		logger.Error(err.Error())
 		return err
@@ -195,43 +326,69 @@ func cmdAdd(args *skel.CmdArgs) error {
 	logger.Info("Succeeded to set veth interface", zap.Any("interfaces", prevResult.Interfaces), zap.Any("ips", prevResult.IPs), zap.Any("routes", prevResult.Routes))
 
 	// get all ip of pod
-	var allPodIp []netlink.Addr
-	err = netns.Do(func(netNS ns.NetNS) error {
-		allPodIp, err = spiderpool.GetAllIPAddress(ipfamily, []string{`^lo$`})
-		if err != nil {
-			logger.Error("failed to GetAllIPAddress in pod", zap.Error(err))
-			return fmt.Errorf("failed to GetAllIPAddress in pod: %v", err)
-		}
-		return nil
-	})
+	allPodIp, err := networking.GetAllIPAddress(podNl, ipfamily, []string{`^lo$`})
 	if err != nil {
-		logger.Error("failed to all ip of pod", zap.Error(err))
-		return err
+		logger.Error("failed to GetAllIPAddress in pod", zap.Error(err))
+		return fmt.Errorf("failed to GetAllIPAddress in pod: %w", err)
 	}
 	logger.Info("Succeed to get ips from given interface inside container", zap.String("interface", chainedInterface), zap.Any("container ips", allPodIp))
 
 	// get ip addresses of the node
-	hostIPs, err := networking.GetAllHostIPRouteForPod(ipfamily, allPodIp)
+	hostIPs, err := networking.GetAllHostIPRouteForPod(ipfamily, allPodIp, conf.HostInterfacesToExclude)
 	if err != nil {
 		logger.Error("failed to get IPAddressOnNode", zap.Error(err))
-		return fmt.Errorf("failed to get IPAddressOnNode: %v", err)
+		return fmt.Errorf("failed to get IPAddressOnNode: %w", err)
 	}
 	logger.Debug("success get host IP for route to Pod", zap.Any("hostIPs", hostIPs))
 
 	if enableIpv6 {
-		if err := utils.EnableIpv6Sysctl(logger, netns); err != nil {
+		if err := utils.EnableIpv6Sysctl(ctx, logger, netns, podSysctl); err != nil {
 			return err
 		}
 	}
 
-	currentIPs, err := spiderpool.IPAddressByName(netns, args.IfName, ipfamily)
+	currentIPs, err := networking.IPAddressByName(podNl, args.IfName, ipfamily)
+	if err != nil {
+		logger.Error(err.Error())
+		return fmt.Errorf("failed to IPAddressByName for pod %s : %w", args.IfName, err)
+	}
+
+	// the stale neighbors of the pod ips may be left on the host peer of the overlay interface too
+	overlayPeer := 0
+	if overlayInterface != "" {
+		if peer, err := nl.VethPeer(podNl, hostNl, overlayInterface); err == nil {
+			overlayPeer = peer.Attrs().Index
+		} else {
+			logger.Debug("The overlay interface has no veth peer on host, ignore cleaning the neighbors on it", zap.String("interface", overlayInterface), zap.Error(err))
+		}
+	}
+
+	// the ip may be reused from a deleted pod, whose conntrack entries break the connections of this pod
+	if *conf.FlushConntrack && !conf.DryRun {
+		networking.CleanReusedConntrack(ctx, logger, prevResult.IPs)
+	}
+
+	// 2. setup the neighbors, routes and rules of pod on host. they're shared by all pods on the node, so the changes
+	// of them are serialized, and the lock is released before the setup in pod
+	err = utils.WithHostLock(ctx, logger, conf.DryRun, func() error {
+		if err := setupHostNeighborhood(logger, hostNl, sriovPF, overlayPeer, hostInterface, conInterface, currentIPs); err != nil {
+			return err
+		}
+		if err := setupHostRoutes(logger, hostNl, ipfamily, hostInterface, currentIPs); err != nil {
+			return err
+		}
+		if conf.ReturnPath != nil && conf.ReturnPath.Enabled {
+			return setupHostReturnPath(logger, hostNl, hostInterface.Name, currentIPs, enableIpv4, enableIpv6, conf.ReturnPath)
+		}
+		return nil
+	})
 	if err != nil {
 		logger.Error(err.Error())
-		return fmt.Errorf("failed to IPAddressByName for pod %s : %v", args.IfName, err)
+		return err
 	}
 
-	// 2. setup neighborhood
-	if err = setupNeighborhood(logger, isfirstInterface, netns, chainedInterface, hostInterface, conInterface, hostIPs, currentIPs, args.ContainerID); err != nil {
+	// 3. setup neighborhood
+	if err = setupNeighborhood(logger, isfirstInterface, podNl, chainedInterface, hostInterface, hostIPs, currentIPs); err != nil {
 		logger.Error(err.Error())
 		return err
 	}
@@ -245,43 +402,101 @@ func cmdAdd(args *skel.CmdArgs) error {
 		}
 	}
 
-	// 3. setup routes
-	if err = setupRoutes(logger, netns, ruleTable, ipfamily, hostInterface, conInterface, hostIPs, currentIPs, conf); err != nil {
+	// 4. setup routes
+	if err = setupRoutes(logger, podNl, ruleTable, ipfamily, conInterface, hostIPs, currentIPs, conf); err != nil {
 		logger.Error(err.Error())
 		return err
 	}
 
-	//4. migrate default route
+	// the replies of the NodePort traffic forwarded by the node go back through veth0
+	if conf.ReturnPath != nil && conf.ReturnPath.Enabled {
+		if err = setupReturnPath(logger, netns, podNl, newIptables, hostInterface.Name, hostIPs, currentIPs, enableIpv4, enableIpv6, conf.ReturnPath); err != nil {
+			logger.Error(err.Error())
+			return err
+		}
+	}
+
+	// the gateways of the hijack routes are looked up before the routes are moved
+	paths, err := hijackPaths(podNl, conf, isfirstInterface, chainedInterface, ruleTable, currentIPs)
+	if err != nil {
+		logger.Error("failed to resolve the paths of hijack routes", zap.Error(err))
+		return err
+	}
+
+	// 5. migrate default route, or isolate the chained interface in the vrf of ruleTable
 	if !isfirstInterface {
-		if err = utils.MigrateRoute(logger, netns, chainedInterface, chainedInterface, currentIPs, *conf.MigrateRoute, ruleTable, enableIpv4, enableIpv6); err != nil {
+		if conf.Isolation == constant.IsolationVRF {
+			err = utils.IsolateByVRF(logger, podNl, chainedInterface, *conf.MigrateRoute, ruleTable, enableIpv4, enableIpv6)
+		} else {
+			err = utils.MigrateRoute(logger, podNl, chainedInterface, chainedInterface, currentIPs, *conf.MigrateRoute, ruleTable, enableIpv4, enableIpv6)
+		}
+		if err != nil {
+			logger.Error(err.Error())
+			return err
+		}
+	}
+
+	// the replies of the connections arriving on the chained interface leave through it, even if they're to the
+	// hijacked subnets
+	if conf.Isolation == constant.IsolationFwmark {
+		if err = utils.MarkByIngress(ctx, logger, netns, podNl, nftables, fwmarkIngress(isfirstInterface, chainedInterface, ruleTable, *conf.MigrateRoute), enableIpv4, enableIpv6); err != nil {
 			logger.Error(err.Error())
 			return err
 		}
 	}
 
-	// 5. setup sysctl rp_filter
-	if err = utils.SysctlRPFilter(logger, netns, conf.RPFilter); err != nil {
+	// the hijack routes through veth0, the chained interface or the given interface
+	if err = utils.AddHijackRoutes(logger, podNl, conf.HijackRoutes, paths, enableIpv4, enableIpv6); err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	// 6. setup sysctl rp_filter
+	if err = utils.SysctlRPFilter(ctx, logger, netns, conf.RPFilter, hostSysctl, podSysctl); err != nil {
 		logger.Error(err.Error())
 		return err
 	}
 
+	if conf.DryRun {
+		dryRunPlan.Log(logger)
+		return types.PrintResult(conf.PrevResult, conf.CNIVersion)
+	}
+
 	logger.Info("succeeded to call veth-plugin", zap.Int64("Time Cost", time.Since(startTime).Microseconds()))
 	return types.PrintResult(conf.PrevResult, conf.CNIVersion)
 }
 
-func cmdDel(args *skel.CmdArgs) error {
+func cmdDel(args *skel.CmdArgs) (err error) {
+	startTime := time.Now()
+
 	var logger *zap.Logger
-	conf, err := parseConfig(args.StdinData)
-	if err != nil {
-		return err
+	// DEL is best-effort, the pod must be torn down even if its config is invalid, such as an old config with
+	// unknown fields, so the cleanups still run with the config decoded leniently then
+	stdin, dropIns, mergeErr := config.MergeDropInConfig("veth", args.StdinData)
+	if mergeErr != nil {
+		stdin = args.StdinData
+	}
+	conf, confErr := parseDelConfig(stdin)
+	confErr = errors.Join(mergeErr, confErr)
+	logOptions := config.DefaultLogOptions(constant.VethLogDefaultFilePath)
+	if confErr == nil {
+		logOptions = conf.LogOptions
+	}
+	if err := logging.SetLogOptions(logOptions); err != nil {
+		return fmt.Errorf("faild to init logger: %w ", err)
+	}
+	if confErr != nil {
+		logging.LoggerFile.Named(binName).Warn("The network config is invalid, clean up veth with the defaults",
+			zap.String("ContainerID", args.ContainerID), zap.Error(confErr))
 	}
 
-	if err := logging.SetLogOptions(conf.LogOptions); err != nil {
-		return fmt.Errorf("faild to init logger: %v ", err)
+	k8sCNIArgs, _, err := config.SplitCNIArgs(args.Args)
+	if err != nil {
+		return fmt.Errorf("failed to parse CNI_ARGS: %w", err)
 	}
 
 	k8sArgs := ty.K8sArgs{}
-	if err = types.LoadArgs(args.Args, &k8sArgs); nil != err {
+	if err = types.LoadArgs(k8sCNIArgs, &k8sArgs); nil != err {
 		return fmt.Errorf("failed to get pod information, error=%+v \n", err)
 	}
 
@@ -295,10 +510,63 @@ func cmdDel(args *skel.CmdArgs) error {
 		zap.String("PodNamespace", string(k8sArgs.K8S_POD_NAMESPACE)),
 		zap.String("IfName", args.IfName))
 
+	if len(dropIns) != 0 {
+		logger.Info("Merged the node-local drop-in config", zap.Strings("DropIns", dropIns), zap.String("EffectiveConfig", string(stdin)))
+	}
+	for _, warning := range conf.Warnings {
+		logger.Warn("Coerced the value of network config", zap.String("warning", warning))
+	}
+
+	// the deadline of this invocation, the failure caused by it is reported with the code ErrTryAgainLater
+	ctx, cancel, err := utils.InvocationContext(startTime, conf.Timeout)
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+	defer cancel()
+	defer func() {
+		if utils.IsTimeout(err) {
+			logger.Error("The invocation is timed out", zap.String("timeout", conf.Timeout), zap.Error(err))
+			err = utils.TimeoutError(conf.Timeout, err)
+		}
+	}()
+
 	logger.Debug("Start call veth cmdDel", zap.Any("config", conf))
 
-	hostVeth := getHostVethName(args.ContainerID)
-	vethLink, err := netlink.LinkByName(hostVeth)
+	// the ip may be assigned to another pod, which shouldn't see the connections of this pod
+	if *conf.FlushConntrack && conf.PrevResult != nil {
+		if prevResult, err := current.GetResult(conf.PrevResult); err == nil {
+			networking.CleanReleasedConntrack(ctx, logger, prevResult.IPs)
+		}
+	}
+
+	// the host rules, routes and veth devices are shared by all pods on the node, clean them up under the same lock
+	// as ADD
+	hostLock, wait, err := lock.Lock(ctx, constant.HostLockFile)
+	if err != nil {
+		logger.Error("failed to acquire the node-wide lock of host network", zap.Error(err))
+		return err
+	}
+	defer hostLock.Unlock()
+	logger.Debug("Acquired the node-wide lock of host network", zap.Duration("wait", wait))
+
+	hostHandle, err := nl.NewHandle(ctx)
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+	defer hostHandle.Close()
+	hostNl := nl.WithContext(ctx, hostHandle)
+
+	hostVeth := networking.HostVethName(args.ContainerID)
+	if conf.ReturnPath != nil && conf.ReturnPath.Enabled {
+		if err = cleanReturnPath(logger, hostNl, hostVeth); err != nil {
+			logger.Error(err.Error())
+			return err
+		}
+	}
+
+	vethLink, err := hostNl.LinkByName(hostVeth)
 	if err != nil {
 		if _, ok := err.(netlink.LinkNotFoundError); ok {
 			logger.Debug("Host veth has gone, nothing to do", zap.String("HostVeth", hostVeth))
@@ -307,7 +575,7 @@ func cmdDel(args *skel.CmdArgs) error {
 		return fmt.Errorf("failed to get host veth device %s: %w", hostVeth, err)
 	}
 
-	if err = netlink.LinkDel(vethLink); err != nil {
+	if err = hostNl.LinkDel(vethLink); err != nil {
 		logger.Error("failed to del hostVeth", zap.Error(err))
 		return fmt.Errorf("failed to del hostVeth %s: %w", hostVeth, err)
 	}
@@ -323,137 +591,81 @@ func cmdCheck(args *skel.CmdArgs) error {
 
 // parseConfig parses the supplied configuration (and prevResult) from stdin.
 func parseConfig(stdin []byte) (*PluginConf, error) {
-	var err error
-	conf := PluginConf{}
-
-	if err := json.Unmarshal(stdin, &conf); err != nil {
-		return nil, fmt.Errorf("[veth] failed to parse network configuration: %v", err)
-	}
-
-	// Parse previous result. This will parse, validate, and place the
-	// previous result object into conf.PrevResult. If you need to modify
-	// or inspect the PrevResult you will need to convert it to a concrete
-	// versioned Result struct.
-	if err := version.ParsePrevResult(&conf.NetConf); err != nil {
-		return nil, fmt.Errorf("[veth] could not parse prevResult: %v", err)
-	}
-	// End previous result parsing
-	if err = config.ValidateOverwriteMacAddress(conf.MacPrefix); err != nil {
-		return nil, err
-	}
-
-	if conf.IPConflict != nil {
-		conf.IPConflict = config.ValidateIPConflict(conf.IPConflict)
-		_, err = time.ParseDuration(conf.IPConflict.Interval)
-		if err != nil {
-			return nil, fmt.Errorf("invalid interval %s: %v, input like: 1s or 1m", conf.IPConflict.Interval, err)
-		}
-	}
-
-	conf.LogOptions = logging.InitLogOptions(conf.LogOptions)
-	if conf.LogOptions.LogFilePath == "" {
-		conf.LogOptions.LogFilePath = constant.VethLogDefaultFilePath
-	}
-
-	if conf.OnlyOpMac {
-		return &conf, nil
-	}
-
-	conf.ServiceHijackSubnet, conf.OverlayHijackSubnet, err = config.ValidateRoutes(conf.ServiceHijackSubnet, conf.OverlayHijackSubnet)
-	if err != nil {
-		return nil, err
-	}
-
-	// value must be 0/1/2
-	// If not, giving default value: RPFilter_Loose(2) to it
-	conf.RPFilter = config.ValidateRPFilterConfig(conf.RPFilter)
-
-	conf.MigrateRoute = config.ValidateMigrateRouteConfig(conf.MigrateRoute)
+	return config.ParseVethConfig(stdin)
+}
 
-	return &conf, nil
+// parseDelConfig parses the configuration of DEL leniently, the returned config is never nil
+func parseDelConfig(stdin []byte) (*PluginConf, error) {
+	return config.ParseVethDelConfig(stdin)
 }
 
 // setupVeth sets up a pair of virtual ethernet devices. It will create both veth
 // devices and move the host-side veth into the provided hostNS namespace.
-func setupVeth(logger *zap.Logger, netns ns.NetNS, isfirstInterface bool, containerID string, pr *current.Result) (*current.Interface, *current.Interface, error) {
-	hostInterface := &current.Interface{Name: getHostVethName(containerID)}
+func setupVeth(logger *zap.Logger, netns ns.NetNS, podNl, hostNl nl.Netlink, hostSysctl networking.Sysctl, isfirstInterface bool, containerID string, pr *current.Result) (*current.Interface, *current.Interface, error) {
+	hostInterface := &current.Interface{Name: networking.HostVethName(containerID)}
 	containerInterface := &current.Interface{}
 
-	err := netns.Do(func(hostNS ns.NetNS) error {
-		if !isfirstInterface {
-			link, err := netlink.LinkByName(defaultConVeth)
-			if err != nil {
-				return err
-			}
-			containerInterface.Mac = link.Attrs().HardwareAddr.String()
-			containerInterface.Name = defaultConVeth
-			logger.Info("Veth-peer has already setup, skip setupVeth ")
-			return nil
-		}
-
-		// systemd 242+ tries to set a "persistent" MAC addr for any virtual device
-		// by default (controlled by MACAddressPolicy). As setting happens
-		// asynchronously after a device has been created, ep.Mac and ep.HostMac
-		// can become stale which has a serious consequence - the kernel will drop
-		// any packet sent to/from the endpoint. However, we can trick systemd by
-		// explicitly setting MAC addrs for both veth ends. This sets
-		// addr_assign_type for NET_ADDR_SET which prevents systemd from changing
-		// the addrs.
-		podVethMac, err := mac.GenerateRandMAC()
-		if err != nil {
-			return fmt.Errorf("unable to generate podVeth mac addr: %s", err)
-		}
-
-		hostVeth, contVeth0, err := ip.SetupVethWithName(defaultConVeth, hostInterface.Name, defaultMtu, podVethMac.String(), hostNS)
+	if !isfirstInterface {
+		link, err := podNl.LinkByName(defaultConVeth)
 		if err != nil {
-			return fmt.Errorf("[veth] failed to set veth peer: %v", err)
+			return nil, nil, err
 		}
+		containerInterface.Mac = link.Attrs().HardwareAddr.String()
+		containerInterface.Name = defaultConVeth
+		logger.Info("Veth-peer has already setup, skip setupVeth ")
+		return hostInterface, containerInterface, nil
+	}
+
+	// systemd 242+ tries to set a "persistent" MAC addr for any virtual device
+	// by default (controlled by MACAddressPolicy). As setting happens
+	// asynchronously after a device has been created, ep.Mac and ep.HostMac
+	// can become stale which has a serious consequence - the kernel will drop
+	// any packet sent to/from the endpoint. However, we can trick systemd by
+	// explicitly setting MAC addrs for both veth ends. This sets
+	// addr_assign_type for NET_ADDR_SET which prevents systemd from changing
+	// the addrs.
+	podVethMac, err := mac.GenerateRandMAC()
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to generate podVeth mac addr: %s", err)
+	}
 
-		hostInterface.Name = hostVeth.Name
-		containerInterface.Name = contVeth0.Name
-		containerInterface.Mac = contVeth0.HardwareAddr.String()
-		containerInterface.Sandbox = netns.Path()
-
-		pr.Interfaces = append(pr.Interfaces, hostInterface, containerInterface)
-
-		if err = setLinkup(contVeth0.Name); err != nil {
-			return fmt.Errorf("[veth] failed to set %s up: %v", contVeth0.Name, err)
-		}
-		return nil
-	})
+	hostVeth, contVeth0, err := networking.SetupVeth(podNl, hostNl, hostSysctl, defaultConVeth, hostInterface.Name, defaultMtu, net.HardwareAddr(podVethMac))
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, fmt.Errorf("[veth] failed to set veth peer: %w", err)
 	}
 
-	if isfirstInterface {
-		hostVethMac, err := mac.GenerateRandMAC()
-		if err != nil {
-			return nil, nil, fmt.Errorf("unable to generate hostVeth mac addr: %s", err)
-		}
+	hostInterface.Name = hostVeth.Attrs().Name
+	containerInterface.Name = contVeth0.Attrs().Name
+	containerInterface.Mac = contVeth0.Attrs().HardwareAddr.String()
+	containerInterface.Sandbox = netns.Path()
 
-		hostVethLink, err := netlink.LinkByName(hostInterface.Name)
-		if err != nil {
-			return nil, nil, err
-		}
+	pr.Interfaces = append(pr.Interfaces, hostInterface, containerInterface)
 
-		if err = netlink.LinkSetHardwareAddr(hostVethLink, net.HardwareAddr(hostVethMac)); err != nil {
-			return nil, nil, fmt.Errorf("failed to set host veth mac: %v", err)
-		}
-		hostInterface.Mac = hostVethMac.String()
-		logger.Debug("Successfully to set veth mac", zap.String("podVethMac", containerInterface.Mac), zap.String("hostVethMac", hostInterface.Mac))
+	if err = podNl.LinkSetUp(contVeth0); err != nil {
+		return nil, nil, fmt.Errorf("[veth] failed to set %s up: %w", contVeth0.Attrs().Name, err)
+	}
+
+	hostVethMac, err := mac.GenerateRandMAC()
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to generate hostVeth mac addr: %s", err)
 	}
+	if err = hostNl.LinkSetHardwareAddr(hostVeth, net.HardwareAddr(hostVethMac)); err != nil {
+		return nil, nil, fmt.Errorf("failed to set host veth mac: %w", err)
+	}
+	hostInterface.Mac = hostVethMac.String()
+	logger.Debug("Successfully to set veth mac", zap.String("podVethMac", containerInterface.Mac), zap.String("hostVethMac", hostInterface.Mac))
 
 	return hostInterface, containerInterface, nil
 }
 
-// setupNeighborhood setup neighborhood tables for pod and host.
+// setupHostNeighborhood setup the neighborhood table of pod ips on host, and cleans the stale ones.
 // equivalent to: `ip neigh add ....`
-func setupNeighborhood(logger *zap.Logger, isfirstInterface bool, netns ns.NetNS, chainInterface string, hostInterface, chainedInterface *current.Interface, hostIPs []net.IP, conIPs []netlink.Addr, containerId string) error {
+func setupHostNeighborhood(logger *zap.Logger, hostNl nl.Netlink, sriovPF string, overlayPeer int, hostInterface, chainedInterface *current.Interface, conIPs []netlink.Addr) error {
 	var err error
-	hostVethLink, err := netlink.LinkByName(hostInterface.Name)
+	hostVethLink, err := hostNl.LinkByName(hostInterface.Name)
 	if err != nil {
-		logger.Error(fmt.Sprintf("setupNeighborhood: %v", err))
-		return fmt.Errorf("setupNeighborhood: %v", err)
+		logger.Error(fmt.Sprintf("setupHostNeighborhood: %v", err))
+		return fmt.Errorf("setupHostNeighborhood: %w", err)
 	}
 	hostInterface.Mac = hostVethLink.Attrs().HardwareAddr.String()
 
@@ -463,22 +675,34 @@ func setupNeighborhood(logger *zap.Logger, isfirstInterface bool, netns ns.NetNS
 		zap.String("containerInterface Mac", chainedInterface.Mac),
 		zap.String("hostInterface Mac", hostInterface.Mac))
 
-	// do any cleans?
-	nList, err := netlink.NeighList(0, netlink.FAMILY_ALL)
+	// clean the dirty neigh table of pod ips left on the devices of plugins
+	nList, err := networking.StaleNeighbors(hostNl, overlayPeer, conIPs)
 	if err != nil {
-		logger.Warn("failed to get NeighList, ignore clean dirty neigh table")
+		logger.Warn("failed to get NeighList, ignore clean dirty neigh table", zap.Error(err))
 	}
 
 	for idx := range nList {
-		for _, ipAddr := range conIPs {
-			if nList[idx].IP.Equal(ipAddr.IP) {
-				if err = netlink.NeighDel(&nList[idx]); err != nil && !os.IsNotExist(err) {
-					logger.Warn("failed to clean dirty neigh table, it may cause the pod can't communicate with the node, please clean it up manually",
-						zap.String("dirty neigh table", nList[idx].String()))
-				} else {
-					logger.Debug("successfully cleaned up the dirty neigh table", zap.String("dirty neigh table", nList[idx].String()))
-				}
-				break
+		if err = hostNl.NeighDel(&nList[idx]); err != nil && !os.IsNotExist(err) {
+			logger.Warn("failed to clean dirty neigh table, it may cause the pod can't communicate with the node, please clean it up manually",
+				zap.String("dirty neigh table", nList[idx].String()))
+		} else {
+			logger.Debug("successfully cleaned up the dirty neigh table", zap.String("dirty neigh table", nList[idx].String()))
+		}
+	}
+
+	// the VF reaches the PF through the hairpin of the NIC, so the node learns the pod ips on the PF with the
+	// mac-address of the VF, such as from the ARP of the pod to the node. the pod is reached through the veth pair,
+	// and the mac-address of the VF may be changed by mac_prefix, so clean them
+	if len(sriovPF) != 0 {
+		learned, err := networking.LearnedNeighbors(hostNl, sriovPF, conIPs)
+		if err != nil {
+			logger.Warn("failed to get the neighbors of the PF, ignore cleaning them", zap.String("pf", sriovPF), zap.Error(err))
+		}
+		for idx := range learned {
+			if err = hostNl.NeighDel(&learned[idx]); err != nil && !os.IsNotExist(err) {
+				logger.Warn("failed to clean the neighbor of pod on the PF", zap.String("pf", sriovPF), zap.String("neigh", learned[idx].String()), zap.Error(err))
+			} else {
+				logger.Debug("successfully cleaned up the neighbor of pod on the PF", zap.String("pf", sriovPF), zap.String("neigh", learned[idx].String()))
 			}
 		}
 	}
@@ -486,105 +710,117 @@ func setupNeighborhood(logger *zap.Logger, isfirstInterface bool, netns ns.NetNS
 	for _, conIP := range conIPs {
 		hw, err := net.ParseMAC(chainedInterface.Mac)
 		if err != nil {
-			return fmt.Errorf("veth's mac is invalid: %v", err)
+			return fmt.Errorf("veth's mac is invalid: %w", err)
 		}
 
-		if err = spiderpool.AddStaticNeighborTable(hostVethLink.Attrs().Index, conIP.IP, hw); err != nil {
+		if err = networking.AddStaticNeighborTable(hostNl, hostVethLink.Attrs().Index, conIP.IP, hw); err != nil {
 			logger.Error(err.Error())
 			return err
 		}
 	}
+	return nil
+}
 
+// setupNeighborhood setup neighborhood tables of host ips for pod, the mac-address of host veth is set by
+// setupHostNeighborhood.
+// equivalent to: `ip neigh add ....`
+func setupNeighborhood(logger *zap.Logger, isfirstInterface bool, podNl nl.Netlink, chainInterface string, hostInterface *current.Interface, hostIPs []net.IP, conIPs []netlink.Addr) error {
 	if !isfirstInterface {
 		logger.Debug("Succeed to add neighbor table for interface", zap.String("chainInterface", chainInterface), zap.Any("Container interface ips", conIPs))
 		return nil
 	}
 
-	err = netns.Do(func(_ ns.NetNS) error {
-		podVethLink, err := netlink.LinkByName(defaultConVeth)
-		if err != nil {
-			logger.Error(fmt.Sprintf("setupNeighborhood: %v", err))
-			return fmt.Errorf("setupNeighborhood: %v", err)
-		}
+	podVethLink, err := podNl.LinkByName(defaultConVeth)
+	if err != nil {
+		logger.Error(fmt.Sprintf("setupNeighborhood: %v", err))
+		return fmt.Errorf("setupNeighborhood: %w", err)
+	}
+	hostVethMac, err := net.ParseMAC(hostInterface.Mac)
+	if err != nil {
+		return fmt.Errorf("host veth's mac is invalid: %w", err)
+	}
 
-		logger.Debug("Add HostpIPs Neighborhood Table In Pod Side",
-			zap.String("defaultConVeth", defaultConVeth),
-			zap.String("hostInterface veth Mac", hostInterface.Mac),
-			zap.String("podInterface Mac", podVethLink.Attrs().HardwareAddr.String()))
+	logger.Debug("Add HostpIPs Neighborhood Table In Pod Side",
+		zap.String("defaultConVeth", defaultConVeth),
+		zap.String("hostInterface veth Mac", hostInterface.Mac),
+		zap.String("podInterface Mac", podVethLink.Attrs().HardwareAddr.String()))
 
-		for _, hostIP := range hostIPs {
-			if err = spiderpool.AddStaticNeighborTable(podVethLink.Attrs().Index, hostIP, hostVethLink.Attrs().HardwareAddr); err != nil {
-				logger.Error(err.Error())
-				return err
-			}
+	for _, hostIP := range hostIPs {
+		if err = networking.AddStaticNeighborTable(podNl, podVethLink.Attrs().Index, hostIP, hostVethMac); err != nil {
+			logger.Error(err.Error())
+			return err
 		}
-		return nil
-	})
-
-	return err
+	}
+	return nil
 }
 
-// setupRoutes setup routes for pod and host
+// setupRoutes setup routes for pod
 // equivalent to: `ip route add $route`
-func setupRoutes(logger *zap.Logger, netns ns.NetNS, ruleTable, ipfamily int, hostInterface, chainedInterface *current.Interface, hostIPs []net.IP, conIPs []netlink.Addr, conf *PluginConf) error {
+func setupRoutes(logger *zap.Logger, podNl nl.Netlink, ruleTable, ipfamily int, chainedInterface *current.Interface, hostIPs []net.IP, conIPs []netlink.Addr, conf *PluginConf) error {
 	v4Gw, v6Gw, err := spiderpool.GetGatewayIP(conIPs)
 	if err != nil {
 		logger.Error("failed to GetGatewayIP", zap.Error(err))
 		return err
 	}
+	// veth0 has no address, the routes through it prefer the ones of the chained interface
+	var srcIPs []netlink.Addr
+	if *conf.RouteSrcHint {
+		srcIPs = conIPs
+	}
 
 	// set routes for pod
-	err = netns.Do(func(_ ns.NetNS) error {
-		// add host ip route
-		// equiva to "ip r add hostIP dev veth0 table <ruleTable> "
-		for _, hostAddress := range hostIPs {
-			ipNet := spiderpool.ConvertMaxMaskIPNet(hostAddress)
-			if err = spiderpool.AddRoute(logger, ruleTable, ipfamily, netlink.SCOPE_LINK, defaultConVeth, ipNet, nil, nil); err != nil {
-				logger.Error("failed to AddRoute for ipAddressOnNode", zap.Error(err))
-				return fmt.Errorf("failed to AddRouteTable for ipAddressOnNode: %v", err)
-			}
+	// add host ip route
+	// equiva to "ip r add hostIP dev veth0 table <ruleTable> "
+	for _, hostAddress := range hostIPs {
+		ipNet := spiderpool.ConvertMaxMaskIPNet(hostAddress)
+		if err = networking.AddRoute(logger, podNl, ruleTable, ipfamily, netlink.SCOPE_LINK, defaultConVeth, ipNet, nil, nil, srcIPs); err != nil {
+			logger.Error("failed to AddRoute for ipAddressOnNode", zap.Error(err))
+			return fmt.Errorf("failed to AddRouteTable for ipAddressOnNode: %w", err)
 		}
+	}
 
-		allSubnets := append(conf.ServiceHijackSubnet, conf.OverlayHijackSubnet...)
-		allSubnets = append(allSubnets, conf.AdditionalHijackSubnet...)
-		for _, hijack := range allSubnets {
-			nip, ipNet, err := net.ParseCIDR(hijack)
-			if err != nil {
-				logger.Error("Invalid Hijack Cidr", zap.String("Cidr", hijack), zap.Error(err))
-				return err
-			}
-
-			if nip.To4() != nil && v4Gw == nil {
-				logger.Warn("ignore adding hijack routing table(ipv4), due to ipv4 gateway is nil", zap.String("IPv4 Hijack cidr", hijack))
-				continue
-			}
+	allSubnets := append(conf.ServiceHijackSubnet, conf.OverlayHijackSubnet...)
+	allSubnets = append(allSubnets, conf.AdditionalHijackSubnet...)
+	for _, hijack := range allSubnets {
+		nip, ipNet, err := net.ParseCIDR(hijack)
+		if err != nil {
+			logger.Error("Invalid Hijack Cidr", zap.String("Cidr", hijack), zap.Error(err))
+			return err
+		}
 
-			if nip.To4() == nil && v6Gw == nil {
-				logger.Warn("ignore adding hijack routing table(ipv6), due to ipv6 gateway is nil", zap.String("IPv6 Hijack cidr", hijack))
-				continue
-			}
+		if nip.To4() != nil && v4Gw == nil {
+			logger.Warn("ignore adding hijack routing table(ipv4), due to ipv4 gateway is nil", zap.String("IPv4 Hijack cidr", hijack))
+			continue
+		}
 
-			if err := spiderpool.AddRoute(logger, ruleTable, ipfamily, netlink.SCOPE_UNIVERSE, defaultConVeth, ipNet, v4Gw, v6Gw); err != nil {
-				logger.Error("failed to AddRoute for hijackCIDR", zap.String("Dst", ipNet.String()), zap.Error(err))
-				return fmt.Errorf("failed to AddRoute for hijackCIDR: %v", err)
-			}
+		if nip.To4() == nil && v6Gw == nil {
+			logger.Warn("ignore adding hijack routing table(ipv6), due to ipv6 gateway is nil", zap.String("IPv6 Hijack cidr", hijack))
+			continue
+		}
 
+		if err := networking.AddRoute(logger, podNl, ruleTable, ipfamily, netlink.SCOPE_UNIVERSE, defaultConVeth, ipNet, v4Gw, v6Gw, srcIPs); err != nil {
+			logger.Error("failed to AddRoute for hijackCIDR", zap.String("Dst", ipNet.String()), zap.Error(err))
+			return fmt.Errorf("failed to AddRoute for hijackCIDR: %w", err)
 		}
-		logger.Debug("AddRouteTable for localCIDRs successfully", zap.Strings("localCIDRs", allSubnets))
-		return nil
-	})
 
+	}
+	logger.Debug("AddRouteTable for localCIDRs successfully", zap.Strings("localCIDRs", allSubnets))
+	return nil
+}
+
+// setupHostRoutes setup routes of pod ips on host
+// equivalent to: `ip route add $route`
+func setupHostRoutes(logger *zap.Logger, hostNl nl.Netlink, ipfamily int, hostInterface *current.Interface, conIPs []netlink.Addr) error {
 	for idx := range conIPs {
 		ipNet := spiderpool.ConvertMaxMaskIPNet(conIPs[idx].IP)
 
 		// set routes for host
 		// equivalent: ip add  <chainedIPs> dev <hostVethName> table  on host
-		if err = spiderpool.AddRoute(logger, unix.RT_TABLE_MAIN, ipfamily, netlink.SCOPE_LINK, hostInterface.Name, ipNet, nil, nil); err != nil {
+		if err := networking.AddRoute(logger, hostNl, unix.RT_TABLE_MAIN, ipfamily, netlink.SCOPE_LINK, hostInterface.Name, ipNet, nil, nil, nil); err != nil {
 			logger.Error("failed to AddRouteTable for preInterface IPAddress", zap.Error(err))
-			return fmt.Errorf("failed to AddRouteTable for preInterface %s's IPAddress: %v", hostInterface.Name, err)
+			return fmt.Errorf("failed to AddRouteTable for preInterface %s's IPAddress: %w", hostInterface.Name, err)
 		}
 		logger.Info("add route for to pod in host", zap.String("Dst", ipNet.String()))
 	}
-
-	return err
+	return nil
 }
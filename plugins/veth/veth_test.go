@@ -1,20 +1,30 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/agiledragon/gomonkey/v2"
 	"github.com/containernetworking/cni/pkg/skel"
 	"github.com/containernetworking/cni/pkg/types"
 	current "github.com/containernetworking/cni/pkg/types/100"
 	"github.com/containernetworking/cni/pkg/version"
-	"github.com/containernetworking/plugins/pkg/ip"
 	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+	"github.com/containernetworking/plugins/pkg/utils/sysctl"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/spidernet-io/cni-plugins/pkg/constant"
+	"github.com/spidernet-io/cni-plugins/pkg/lock"
 	"github.com/spidernet-io/cni-plugins/pkg/logging"
+	"github.com/spidernet-io/cni-plugins/pkg/networking"
+	"github.com/spidernet-io/cni-plugins/pkg/nl"
+	"github.com/spidernet-io/cni-plugins/pkg/plan"
+	ty "github.com/spidernet-io/cni-plugins/pkg/types"
 	"github.com/spidernet-io/cni-plugins/pkg/utils"
 	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 	"net"
 )
 
@@ -25,6 +35,55 @@ var _ = Describe("Veth", func() {
 			cmdDel(&skel.CmdArgs{})
 			//Expect(err).NotTo(HaveOccurred())
 		})
+
+		It("the host veth and the rules of return path are cleaned with an invalid or legacy config", func() {
+			id := "deltestdeltest"
+			hostVeth := networking.HostVethName(id)
+			for _, stdin := range []string{
+				`{"cniVersion": "0.3.1", "name": "veth", "type": "veth", "foo": 1, "return_path": {"enabled": true}}`,
+				`{"cniVersion": "0.3.1", "name": "veth", "type": "veth", "return_path": {"enabled": true}}`,
+			} {
+				la := netlink.NewLinkAttrs()
+				la.Name = hostVeth
+				Expect(netlink.LinkAdd(&netlink.Veth{LinkAttrs: la, PeerName: "deltestpeer"})).To(Succeed())
+				rule := netlink.NewRule()
+				rule.Src = &net.IPNet{IP: net.ParseIP("10.6.212.200"), Mask: net.CIDRMask(32, 32)}
+				rule.IifName = hostVeth
+				rule.Table = unix.RT_TABLE_MAIN
+				rule.Priority = 90
+				Expect(netlink.RuleAdd(rule)).To(Succeed())
+
+				Expect(cmdDel(&skel.CmdArgs{ContainerID: id, StdinData: []byte(stdin)})).To(Succeed(), stdin)
+
+				_, err := netlink.LinkByName(hostVeth)
+				Expect(err).To(BeAssignableToTypeOf(netlink.LinkNotFoundError{}), stdin)
+				rules, err := netlink.RuleList(netlink.FAMILY_V4)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(rules).NotTo(ContainElement(HaveField("IifName", hostVeth)), stdin)
+			}
+		})
+		It("the host veth is cleaned under the node-wide lock", func() {
+			id := "deltestlocked"
+			hostVeth := networking.HostVethName(id)
+			la := netlink.NewLinkAttrs()
+			la.Name = hostVeth
+			Expect(netlink.LinkAdd(&netlink.Veth{LinkAttrs: la, PeerName: "deltestpeer"})).To(Succeed())
+
+			hostLock, _, err := lock.Lock(context.TODO(), constant.HostLockFile)
+			Expect(err).NotTo(HaveOccurred())
+			stdin := []byte(`{"cniVersion": "0.3.1", "name": "veth", "type": "veth", "timeout": "100ms"}`)
+			err = cmdDel(&skel.CmdArgs{ContainerID: id, StdinData: stdin})
+			var cniErr *types.Error
+			Expect(errors.As(err, &cniErr)).To(BeTrue())
+			Expect(cniErr.Code).To(Equal(uint(types.ErrTryAgainLater)))
+			_, err = netlink.LinkByName(hostVeth)
+			Expect(err).NotTo(HaveOccurred(), "nothing is cleaned without the lock")
+
+			Expect(hostLock.Unlock()).To(Succeed())
+			Expect(cmdDel(&skel.CmdArgs{ContainerID: id, StdinData: stdin})).To(Succeed())
+			_, err = netlink.LinkByName(hostVeth)
+			Expect(err).To(BeAssignableToTypeOf(netlink.LinkNotFoundError{}))
+		})
 	})
 
 	Context("Test cmdCheck", func() {
@@ -141,7 +200,76 @@ var _ = Describe("Veth", func() {
 				}
 			}`)
 			_, err := parseConfig(stdin)
-			Expect(err).To(Equal(errors.New("the subnet of service clusterip must be given")))
+			Expect(err).To(MatchError(ContainSubstring("overlay_hijack_subnet: the subnet of overlay cni(such as calico or cilium) must be given")))
+		})
+
+		It("report all unknown and invalid fields", func() {
+			var stdin = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "veth",
+				"type": "veth",
+				"service_hijack_subnet": ["10.244.64.0/18"],
+				"overlay_hijack_subnet": ["10.244.0.0/18"],
+				"overlay_subnets": ["10.244.0.0/18"],
+				"rp_filter": {
+					"enabled": true,
+					"value": 0
+				},
+				"mac_prefix": "wrong mac"
+			}`)
+			_, err := parseConfig(stdin)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("overlay_subnets: unknown field"))
+			Expect(err.Error()).To(ContainSubstring("rp_filter.enabled: unknown field"))
+			Expect(err.Error()).To(ContainSubstring("mac_prefix: "))
+		})
+
+		It("warn the coerced values", func() {
+			var stdin = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "veth",
+				"type": "veth",
+				"service_hijack_subnet": [" 10.244.64.0/18"],
+				"overlay_hijack_subnet": ["10.244.0.0/18"],
+				"migrate_route": 10
+			}`)
+			conf, err := parseConfig(stdin)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conf.ServiceHijackSubnet).To(Equal([]string{"10.244.64.0/18"}))
+			Expect(*conf.MigrateRoute).To(Equal(ty.MigrateAuto))
+			Expect(conf.Warnings).To(HaveLen(2))
+		})
+
+		It("subnets can be empty when auto_discover is enabled", func() {
+			var stdin = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "veth",
+				"type": "veth",
+				"service_hijack_subnet": ["10.244.64.0/18"],
+				"kubernetes": {
+					"kubeconfig": "/etc/cni/net.d/meta-plugins.kubeconfig"
+				},
+				"auto_discover": {
+					"enabled": true
+				}
+			}`)
+			conf, err := parseConfig(stdin)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conf.OverlayHijackSubnet).To(BeEmpty())
+			Expect(conf.AutoDiscovery.CacheTTL).To(Equal("10m"))
+		})
+
+		It("auto_discover requires kubeconfig", func() {
+			var stdin = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "veth",
+				"type": "veth",
+				"auto_discover": {
+					"enabled": true
+				}
+			}`)
+			_, err := parseConfig(stdin)
+			Expect(err).To(HaveOccurred())
 		})
 
 		It("json unmarshal err", func() {
@@ -226,46 +354,59 @@ var _ = Describe("Veth", func() {
 	Context("Test setupVeth", func() {
 
 		It("not first interface", func() {
-			patches := gomonkey.NewPatches()
-			defer patches.Reset()
+			pod := nl.NewFake()
+			pod.AddVeth(netlink.LinkAttrs{Name: defaultConVeth, HardwareAddr: net.HardwareAddr{0x0a, 0x1b, 0x0a, 0x06, 0x01, 0x0a}})
 			pr := &current.Result{}
-			patches.ApplyFuncReturn(ip.SetupVethWithName, hostInterface, conInterface, nil)
-			_, _, err := setupVeth(logger, testNetNs, true, containerID, pr)
+			hostInterface, conInterface, err := setupVeth(logger, testNetNs, pod, nl.NewFake(), sysctl.Sysctl, false, containerID, pr)
 			Expect(err).NotTo(HaveOccurred())
+			Expect(hostInterface.Name).To(Equal(networking.HostVethName(containerID)))
+			Expect(conInterface.Name).To(Equal(defaultConVeth))
+			Expect(conInterface.Mac).To(Equal("0a:1b:0a:06:01:0a"))
+			Expect(pr.Interfaces).To(BeEmpty())
 		})
+
+		It("not first interface without veth0", func() {
+			_, _, err := setupVeth(logger, testNetNs, nl.NewFake(), nl.NewFake(), sysctl.Sysctl, false, containerID, &current.Result{})
+			Expect(err).To(HaveOccurred())
+		})
+
 		It("first interface", func() {
+			p := &plan.Plan{}
+			podNl, hostNl := p.Netlink(plan.PodNetns, nl.NewFake()), p.Netlink(plan.HostNetns, nl.NewFake())
 			pr := &current.Result{}
-			patches := gomonkey.NewPatches()
-			defer patches.Reset()
-			patches.ApplyFuncReturn(netlink.LinkByName, &netlink.Dummy{netlink.LinkAttrs{HardwareAddr: net.HardwareAddr("test")}}, nil)
-			_, _, err := setupVeth(logger, testNetNs, false, containerID, pr)
+			hostInterface, conInterface, err := setupVeth(logger, testNetNs, podNl, hostNl, p.Sysctl(plan.HostNetns), true, containerID, pr)
 			Expect(err).NotTo(HaveOccurred())
-		})
+			Expect(pr.Interfaces).To(Equal([]*current.Interface{hostInterface, conInterface}))
+			Expect(conInterface.Sandbox).To(Equal(testNetNs.Path()))
 
-		It("first interface linkByName err", func() {
-			pr := &current.Result{}
-			patches := gomonkey.NewPatches()
-			defer patches.Reset()
-			patches.ApplyFuncReturn(netlink.LinkByName, &netlink.Dummy{netlink.LinkAttrs{HardwareAddr: net.HardwareAddr("test")}}, errors.New("linkByName err"))
-			_, _, err := setupVeth(logger, testNetNs, false, containerID, pr)
-			Expect(err).To(HaveOccurred())
+			hostVeth := networking.HostVethName(containerID)
+			Expect(p.Changes).To(HaveLen(5))
+			Expect(p.Changes[0].Command).To(Equal(fmt.Sprintf("ip link add veth0 mtu 1500 address %s type veth peer name %s netns host", conInterface.Mac, hostVeth)))
+			Expect(p.Changes[1:4]).To(Equal([]plan.Change{
+				{Netns: plan.HostNetns, Kind: plan.KindLink, Command: "ip link set " + hostVeth + " up"},
+				{Netns: plan.HostNetns, Kind: plan.KindSysctl, Command: "sysctl -w net.ipv6.conf." + hostVeth + ".accept_ra=0"},
+				{Netns: plan.PodNetns, Kind: plan.KindLink, Command: "ip link set veth0 up"},
+			}))
+			Expect(p.Changes[4]).To(Equal(plan.Change{Netns: plan.HostNetns, Kind: plan.KindMac, Command: fmt.Sprintf("ip link set %s address %s", hostVeth, hostInterface.Mac)}))
+
+			// the veth pair is seen by the later changes
+			peer, err := nl.VethPeer(podNl, hostNl, defaultConVeth)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(peer.Attrs().Name).To(Equal(hostVeth))
+			Expect(peer.Attrs().HardwareAddr.String()).To(Equal(hostInterface.Mac))
 		})
 
-		It(" SetupVethWithName err", func() {
-			pr := &current.Result{}
-			patches := gomonkey.NewPatches()
-			defer patches.Reset()
-			patches.ApplyFuncReturn(ip.SetupVethWithName, nil, nil, errors.New("SetupVethWithName err"))
-			_, _, err := setupVeth(logger, testNetNs, true, containerID, pr)
+		It("first interface with veth0 existing", func() {
+			p := &plan.Plan{}
+			pod := nl.NewFake()
+			pod.AddVeth(netlink.LinkAttrs{Name: defaultConVeth})
+			_, _, err := setupVeth(logger, testNetNs, p.Netlink(plan.PodNetns, pod), p.Netlink(plan.HostNetns, nl.NewFake()), p.Sysctl(plan.HostNetns), true, containerID, &current.Result{})
 			Expect(err).To(HaveOccurred())
 		})
 
-		It(" setLinkup err", func() {
-			pr := &current.Result{}
-			patches := gomonkey.NewPatches()
-			defer patches.Reset()
-			patches.ApplyFuncReturn(setLinkup, errors.New("setLinkup err"))
-			_, _, err := setupVeth(logger, testNetNs, true, containerID, pr)
+		It("first interface without the peer on host", func() {
+			p := &plan.Plan{}
+			_, _, err := setupVeth(logger, testNetNs, p.Netlink(plan.PodNetns, nl.NewFake()), nl.NewFake(), p.Sysctl(plan.HostNetns), true, containerID, &current.Result{})
 			Expect(err).To(HaveOccurred())
 		})
 	})
@@ -700,6 +841,40 @@ var _ = Describe("Veth", func() {
 			Expect(err).To(HaveOccurred())
 		})
 
+		It("timed out", func() {
+			var stdin = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "veth",
+				"type": "veth",
+				"service_hijack_subnet": ["10.244.64.0/18"],
+				"overlay_hijack_subnet": ["10.244.0.0/18"],
+				"timeout": "1ns",
+				"prevResult": {
+					"interfaces": [
+						{"name": "net1"},
+						{"name": "container", "sandbox":"netns"}
+					],
+					"ips": [
+						{
+							"version": "4",
+							"address": "10.0.0.1/24",
+							"gateway": "10.0.0.1",
+							"interface": 0
+						}
+					]
+				}
+			}`)
+			args := &skel.CmdArgs{
+				Netns:       testNetNs.Path(),
+				ContainerID: containerID,
+				StdinData:   stdin,
+			}
+			err := cmdAdd(args)
+			var cniErr *types.Error
+			Expect(errors.As(err, &cniErr)).To(BeTrue())
+			Expect(cniErr.Code).To(Equal(uint(types.ErrTryAgainLater)))
+		})
+
 		It("check interface miss failed", func() {
 			var stdin = []byte(`{
 				"cniVersion": "0.3.1",
@@ -1005,7 +1180,6 @@ var _ = Describe("Veth", func() {
 
 	Context("Test setupRoutes", func() {
 
-		var hInterface = &current.Interface{Name: hostVethName}
 		var cInterface = &current.Interface{Name: conVethName}
 		hostIP1 := net.ParseIP("10.244.0.1")
 		hostIP2 := net.ParseIP("10.244.0.2")
@@ -1058,11 +1232,189 @@ var _ = Describe("Veth", func() {
 			patches := gomonkey.NewPatches()
 			defer patches.Reset()
 			patches.ApplyFuncReturn(utils.RouteAdd, nil, nil, nil)
-			patches.ApplyFuncReturn(netlink.LinkByName, &netlink.Dummy{netlink.LinkAttrs{HardwareAddr: net.HardwareAddr("test")}}, nil)
-			err = setupRoutes(logger, testNetNs, 100, netlink.FAMILY_ALL, hInterface, cInterface, hostIPs, conIPs, conf)
+			patches.ApplyFuncReturn(netlink.LinkByName, &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{HardwareAddr: net.HardwareAddr("test")}}, nil)
+			err = setupRoutes(logger, nl.NewAt(testNetNs), 100, netlink.FAMILY_ALL, cInterface, hostIPs, conIPs, conf)
 			// Expect(err).NotTo(HaveOccurred())
 		})
 
 	})
 
+	Context("Test cmdAdd with the type of chained interface", func() {
+		var podNS ns.NetNS
+
+		BeforeEach(func() {
+			var err error
+			podNS, err = testutils.NewNS()
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(func() {
+				Expect(podNS.Close()).To(Succeed())
+				Expect(testutils.UnmountNS(podNS)).To(Succeed())
+			})
+			// the parent of the chained interface, which plays the nic of node
+			err = podNS.Do(func(_ ns.NetNS) error {
+				return netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "ens192"}, PeerName: "ens224"})
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		// addChained creates the chained interface net1 with 10.6.212.101/16 on ens192, and returns its mac-address
+		addChained := func(newLink func(attrs netlink.LinkAttrs) netlink.Link) string {
+			var hwAddr string
+			err := podNS.Do(func(_ ns.NetNS) error {
+				parent, err := netlink.LinkByName("ens192")
+				if err != nil {
+					return err
+				}
+				if err = netlink.LinkAdd(newLink(netlink.LinkAttrs{Name: "net1", ParentIndex: parent.Attrs().Index})); err != nil {
+					return err
+				}
+				link, err := netlink.LinkByName("net1")
+				if err != nil {
+					return err
+				}
+				hwAddr = link.Attrs().HardwareAddr.String()
+				addr, _ := netlink.ParseAddr("10.6.212.101/16")
+				return netlink.AddrAdd(link, addr)
+			})
+			if errors.Is(err, unix.EOPNOTSUPP) {
+				Skip("the kernel doesn't support the type of chained interface: " + err.Error())
+			}
+			Expect(err).NotTo(HaveOccurred())
+			return hwAddr
+		}
+
+		chainedMac := func() string {
+			var hwAddr string
+			err := podNS.Do(func(_ ns.NetNS) error {
+				link, err := netlink.LinkByName("net1")
+				if err != nil {
+					return err
+				}
+				hwAddr = link.Attrs().HardwareAddr.String()
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+			return hwAddr
+		}
+
+		onlyOpMac := func() error {
+			return cmdAdd(&skel.CmdArgs{
+				Netns:       podNS.Path(),
+				ContainerID: containerID,
+				IfName:      "net1",
+				StdinData: []byte(`{
+					"cniVersion": "0.3.1",
+					"name": "veth",
+					"type": "veth",
+					"service_hijack_subnet": ["10.244.64.0/18"],
+					"overlay_hijack_subnet": ["10.244.0.0/18"],
+					"mac_prefix": "0a:1b",
+					"only_op_mac": true,
+					"prevResult": {
+						"interfaces": [{"name": "net1", "sandbox": "netns"}],
+						"ips": [{"version": "4", "address": "10.6.212.101/16", "gateway": "10.6.0.1", "interface": 0}]
+					}
+				}`),
+			})
+		}
+
+		It("macvlan updates the mac-address by mac_prefix", func() {
+			addChained(func(attrs netlink.LinkAttrs) netlink.Link {
+				return &netlink.Macvlan{LinkAttrs: attrs, Mode: netlink.MACVLAN_MODE_BRIDGE}
+			})
+			Expect(onlyOpMac()).To(Succeed())
+			Expect(chainedMac()).To(HavePrefix("0a:1b:"))
+		})
+
+		DescribeTable("ipvlan keeps the mac-address of parent",
+			func(mode netlink.IPVlanMode) {
+				hwAddr := addChained(func(attrs netlink.LinkAttrs) netlink.Link {
+					return &netlink.IPVlan{LinkAttrs: attrs, Mode: mode}
+				})
+				Expect(onlyOpMac()).To(Succeed())
+				Expect(chainedMac()).To(Equal(hwAddr))
+			},
+			Entry("l2", netlink.IPVLAN_MODE_L2),
+			Entry("l3", netlink.IPVLAN_MODE_L3),
+		)
+	})
+
+	Context("Test setupHostNeighborhood with the type of chained interface", func() {
+		var hostNS, podNS ns.NetNS
+		podIP := netlink.Addr{IPNet: &net.IPNet{IP: net.ParseIP("10.6.212.101"), Mask: net.CIDRMask(16, 32)}}
+		otherIP := net.ParseIP("10.6.212.102")
+		vfMac, _ := net.ParseMAC("0a:1b:2c:3d:4e:5f")
+
+		BeforeEach(func() {
+			var err error
+			hostNS, err = testutils.NewNS()
+			Expect(err).NotTo(HaveOccurred())
+			podNS, err = testutils.NewNS()
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(func() {
+				for _, netns := range []ns.NetNS{hostNS, podNS} {
+					Expect(netns.Close()).To(Succeed())
+					Expect(testutils.UnmountNS(netns)).To(Succeed())
+				}
+			})
+
+			err = hostNS.Do(func(_ ns.NetNS) error {
+				// the veth pair of pod
+				if err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: hostVethName}, PeerName: defaultConVeth}); err != nil {
+					return err
+				}
+				conVeth, err := netlink.LinkByName(defaultConVeth)
+				if err != nil {
+					return err
+				}
+				if err = netlink.LinkSetNsFd(conVeth, int(podNS.Fd())); err != nil {
+					return err
+				}
+				// the PF, which has learned the pod ip from the VF and another ip
+				if err = netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "ens1f0"}, PeerName: "ens1f1"}); err != nil {
+					return err
+				}
+				pf, err := netlink.LinkByName("ens1f0")
+				if err != nil {
+					return err
+				}
+				for _, ip := range []net.IP{podIP.IP, otherIP} {
+					if err = netlink.NeighAdd(&netlink.Neigh{LinkIndex: pf.Attrs().Index, Family: netlink.FAMILY_V4, State: netlink.NUD_REACHABLE, IP: ip, HardwareAddr: vfMac}); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		pfNeighbors := func() []string {
+			var ips []string
+			err := hostNS.Do(func(_ ns.NetNS) error {
+				pf, err := netlink.LinkByName("ens1f0")
+				if err != nil {
+					return err
+				}
+				neighs, err := netlink.NeighList(pf.Attrs().Index, netlink.FAMILY_V4)
+				for _, neigh := range neighs {
+					ips = append(ips, neigh.IP.String())
+				}
+				return err
+			})
+			Expect(err).NotTo(HaveOccurred())
+			return ips
+		}
+
+		DescribeTable("the neighbors of pod learned on the PF",
+			func(sriovPF string, expected []string) {
+				hostInterface := &current.Interface{Name: hostVethName}
+				conInterface := &current.Interface{Name: defaultConVeth, Mac: vfMac.String()}
+				err := setupHostNeighborhood(logger, nl.NewAt(hostNS), sriovPF, 0, hostInterface, conInterface, []netlink.Addr{podIP})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(pfNeighbors()).To(ConsistOf(expected))
+			},
+			Entry("are cleaned for the SR-IOV VF", "ens1f0", []string{otherIP.String()}),
+			Entry("are kept for the others", "", []string{podIP.IP.String(), otherIP.String()}),
+		)
+	})
 })
@@ -18,6 +18,7 @@ var (
 	MacvlanStandaloneVlan100Name = "macvlan-standalone-vlan100"
 	MacvlanOverlayVlan0Name      = "macvlan-overlay-vlan0"
 	MacvlanOverlayVlan100Name    = "macvlan-overlay-vlan100"
+	MacvlanStandaloneReturnPath  = "macvlan-standalone-return-path"
 )
 
 // annotations
@@ -37,6 +38,8 @@ var (
 	KindNodeDefaultInterface   = "eth0"
 	CtxTimeout                 = 60 * time.Second
 	ENV_VLAN_GATEWAY_CONTAINER = "VLAN_GATEWAY_CONTAINER"
+	// the container outside the cluster, it's the client of NodePort
+	VlanGatewayContainer string
 )
 var (
 	IPV4       = true
@@ -53,6 +56,7 @@ var (
 func init() {
 	IPV4 = os.Getenv("E2E_IPV4_ENABLED") == "true"
 	IPV6 = os.Getenv("E2E_IPV6_ENABLED") == "true"
+	VlanGatewayContainer = os.Getenv(ENV_VLAN_GATEWAY_CONTAINER)
 	// https://github.com/spidernet-io/cni-plugins/issues/143
 	TestMultus = os.Getenv("DEFAULT_CNI") != "cilium"
 }
@@ -0,0 +1,65 @@
+package macvlan_standalone_return_path_test
+
+import (
+	"context"
+	"fmt"
+	multus_v1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spidernet-io/cni-plugins/pkg/schema"
+	"github.com/spidernet-io/cni-plugins/test/e2e/common"
+	e2e "github.com/spidernet-io/e2eframework/framework"
+	"github.com/spidernet-io/e2eframework/tools"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"testing"
+)
+
+func TestMacvlanStandaloneReturnPath(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "MacvlanStandaloneReturnPath Suite")
+}
+
+var frame *e2e.Framework
+var name, namespace string
+var labels = make(map[string]string)
+var annotations = make(map[string]string)
+var port = int32(80)
+
+var _ = BeforeSuite(func() {
+	defer GinkgoRecover()
+	var e error
+	frame, e = e2e.NewFramework(GinkgoT(), []func(*runtime.Scheme) error{multus_v1.AddToScheme, schema.SpiderPoolAddToScheme})
+	Expect(e).NotTo(HaveOccurred())
+	Expect(common.VlanGatewayContainer).NotTo(BeEmpty(), "the client outside the cluster must be given by %s", common.ENV_VLAN_GATEWAY_CONTAINER)
+
+	name = "return-path-" + tools.RandomName()
+	namespace = "ns-" + tools.RandomName()
+	labels["app"] = name
+
+	// the macvlan interface is the only interface of pod, veth0 is created by the veth plugin with return_path enabled
+	multusInstance, err := frame.GetMultusInstance(common.MacvlanStandaloneReturnPath, common.MultusNs)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(multusInstance).NotTo(BeNil())
+	annotations[common.MultusDefaultAnnotationKey] = fmt.Sprintf("%s/%s", common.MultusNs, common.MacvlanStandaloneReturnPath)
+
+	err = frame.CreateNamespaceUntilDefaultServiceAccountReady(namespace, common.CtxTimeout)
+	Expect(err).NotTo(HaveOccurred())
+
+	_, err = frame.CreateDeploymentUntilReady(common.GenerateDeploymentYaml(name, namespace, labels, annotations), 2*common.CtxTimeout)
+	Expect(err).NotTo(HaveOccurred())
+
+	// the pods are reached without SNAT only if the service routes the traffic to the local pods of node
+	service := common.GenerateServiceYaml(name, namespace, port, labels)
+	service.Spec.ExternalTrafficPolicy = corev1.ServiceExternalTrafficPolicyTypeLocal
+	err = frame.CreateService(service)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(common.WaitEndpointReady(5, name, namespace, frame)).NotTo(HaveOccurred())
+})
+
+var _ = AfterSuite(func() {
+	ctx, cancel := context.WithTimeout(context.Background(), common.CtxTimeout)
+	defer cancel()
+	err := frame.DeleteNamespaceUntilFinish(namespace, ctx)
+	Expect(err).NotTo(HaveOccurred(), "failed to delete namespace %v", namespace)
+})
@@ -0,0 +1,41 @@
+package macvlan_standalone_return_path_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spidernet-io/cni-plugins/test/e2e/common"
+)
+
+var _ = Describe("MacvlanStandaloneReturnPath", Label("standalone", "return-path"), func() {
+
+	It("clients outside the cluster access the nodePort of macvlan pods", Label("nodeport"), func() {
+		service, err := frame.GetService(name, namespace)
+		Expect(err).NotTo(HaveOccurred())
+		nodePorts := common.GetServiceNodePorts(service.Spec.Ports)
+		Expect(nodePorts).NotTo(BeEmpty())
+
+		podList, err := frame.GetPodListByLabel(labels)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(podList.Items).NotTo(BeEmpty())
+
+		ctx, cancel := context.WithTimeout(context.Background(), common.CtxTimeout)
+		defer cancel()
+		// the service only has local endpoints on the nodes of pods, so the replies are not SNATed by the node
+		for _, pod := range podList.Items {
+			// the name of kind node is the name of its container
+			nodeIPs, err := common.GetKindNodeIPs(ctx, frame, []string{pod.Spec.NodeName})
+			Expect(err).NotTo(HaveOccurred())
+			for _, nodeIP := range nodeIPs {
+				for _, nodePort := range nodePorts {
+					command := common.GetCurlCommandByIPFamily(nodeIP, nodePort) + " --connect-timeout 5 -s -o /dev/null -w '%{http_code}'"
+					GinkgoWriter.Printf("access nodePort from %s: %s\n", common.VlanGatewayContainer, command)
+					output, err := frame.DockerExecCommand(ctx, common.VlanGatewayContainer, command)
+					Expect(err).NotTo(HaveOccurred(), "failed to access %s:%d of pod %s: %s", nodeIP, nodePort, pod.Name, output)
+					Expect(string(output)).To(Equal("200"))
+				}
+			}
+		}
+	})
+})
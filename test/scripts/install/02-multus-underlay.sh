@@ -289,4 +289,45 @@ spec:
 EOF
 fi
 
+# the replies of the NodePort traffic go back through veth0, see the return_path of veth
+cat <<EOF | kubectl --kubeconfig ${E2E_KUBECONFIG} apply -f -
+apiVersion: k8s.cni.cncf.io/v1
+kind: NetworkAttachmentDefinition
+metadata:
+  name: macvlan-standalone-return-path
+  namespace: kube-system
+spec:
+  config: |-
+    {
+        "cniVersion": "0.3.1",
+        "name": "macvlan-standalone-return-path",
+        "plugins": [
+            {
+                "type": "macvlan",
+                "master": "eth0",
+                "mode": "bridge",
+                "ipam": {
+                    "type": "spiderpool",
+                    "log_level": "DEBUG"
+                }
+            },{
+                "type": "veth",
+                "service_hijack_subnet": ${SERVICE_HIJACK_SUBNET},
+                "overlay_hijack_subnet": ${OVERLAY_HIJACK_SUBNET},
+                "additional_hijack_subnet": [],
+                "migrate_route": -1,
+                "rp_filter": {
+                    "set_host": true,
+                    "value": 0
+                },
+                "overlay_interface": "eth0",
+                "skip_call": false,
+                "return_path": {
+                    "enabled": true
+                }
+            }
+        ]
+    }
+EOF
+
 echo -e "\033[35m Succeed to install Multus-underlay \033[0m"